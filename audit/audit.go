@@ -0,0 +1,204 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"strings"
+
+	"github.com/heypkg/store/internal/gormutil"
+	"github.com/heypkg/store/jsontype"
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+type Operation string
+
+const (
+	OperationCreate  Operation = "create"
+	OperationUpdate  Operation = "update"
+	OperationDelete  Operation = "delete"
+	OperationRestore Operation = "restore"
+	OperationRevert  Operation = "revert"
+)
+
+var (
+	ErrVersionNotFound = errors.New("version not found")
+	// ErrNoSnapshot is returned when reverting to a version without a
+	// snapshot, such as the version of a delete.
+	ErrNoSnapshot = errors.New("version has no snapshot")
+)
+
+type Change struct {
+	Field  string `json:"Field"`
+	Before any    `json:"Before"`
+	After  any    `json:"After"`
+}
+
+// Record is one entry of the change history of an object. Snapshot holds the
+// full object as it was after the change, so any version can be restored.
+type Record struct {
+	ID        uint                               `gorm:"primarykey" json:"ID"`
+	Schema    string                             `gorm:"index" json:"Schema"`
+	Time      jsontype.JSONTime                  `gorm:"index" json:"Time"`
+	Actor     string                             `gorm:"index" json:"Actor"`
+	Model     string                             `gorm:"uniqueIndex:idx_audit_records_version" json:"Model"`
+	ObjectKey string                             `gorm:"uniqueIndex:idx_audit_records_version" json:"ObjectKey"`
+	Operation Operation                          `json:"Operation"`
+	Version   int                                `gorm:"uniqueIndex:idx_audit_records_version" json:"Version"`
+	Changes   jsontype.JSONSlice[Change]         `json:"Changes"`
+	Snapshot  jsontype.JSONType[json.RawMessage] `json:"Snapshot"`
+}
+
+func (Record) TableName() string {
+	return "audit_records"
+}
+
+type actorContextKey struct{}
+
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorContextKey{}, actor)
+}
+
+func ActorFromContext(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	v, _ := ctx.Value(actorContextKey{}).(string)
+	return v
+}
+
+const (
+	settingSkip      = "audit:skip"
+	settingOperation = "audit:operation"
+	settingBefore    = "audit:before"
+	settingMatched   = "audit:matched"
+)
+
+// Skip disables auditing for the writes executed through the returned db.
+func Skip(db *gorm.DB) *gorm.DB {
+	return db.Set(settingSkip, true)
+}
+
+// WithOperation overrides the operation recorded for the writes executed
+// through the returned db.
+func WithOperation(db *gorm.DB, op Operation) *gorm.DB {
+	return db.Set(settingOperation, op)
+}
+
+// ObjectIdentity returns the table name and the primary key string used to
+// identify obj in the audit table.
+func ObjectIdentity(db *gorm.DB, obj any) (string, string, error) {
+	stmt := &gorm.Statement{DB: db, Context: context.Background()}
+	if err := stmt.Parse(obj); err != nil {
+		return "", "", errors.Wrap(err, "parse model")
+	}
+	key, ok := gormutil.PrimaryKeyString(stmt, reflectValue(obj))
+	if !ok {
+		return "", "", errors.New("object has no primary key")
+	}
+	return stmt.Table, key, nil
+}
+
+// History returns a query over the audit records of obj.
+func History(db *gorm.DB, obj any) (*gorm.DB, error) {
+	model, key, err := ObjectIdentity(db, obj)
+	if err != nil {
+		return nil, err
+	}
+	return db.Model(&Record{}).Where("model = ? AND object_key = ?", model, key), nil
+}
+
+// Revert restores obj to the state recorded by the given version and records
+// the result as a new version. Only the fields held by the snapshot are
+// restored, so fields left out of JSON keep their current values.
+func Revert[T any](db *gorm.DB, obj *T, version int) error {
+	model, key, err := ObjectIdentity(db, obj)
+	if err != nil {
+		return err
+	}
+	var record Record
+	result := db.Session(&gorm.Session{NewDB: true}).
+		Where("model = ? AND object_key = ? AND version = ?", model, key, version).
+		First(&record)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return errors.Wrapf(ErrVersionNotFound, "version %v", version)
+		}
+		return result.Error
+	}
+	if len(record.Snapshot.Data) == 0 || string(record.Snapshot.Data) == "null" {
+		return errors.Wrapf(ErrNoSnapshot, "version %v", version)
+	}
+	var restored T
+	if err := json.Unmarshal(record.Snapshot.Data, &restored); err != nil {
+		return errors.Wrap(err, "decode snapshot")
+	}
+	var keys map[string]json.RawMessage
+	if err := json.Unmarshal(record.Snapshot.Data, &keys); err != nil {
+		return errors.Wrap(err, "decode snapshot")
+	}
+
+	stmt := &gorm.Statement{DB: db, Context: context.Background()}
+	if err := stmt.Parse(obj); err != nil {
+		return errors.Wrap(err, "parse model")
+	}
+	var current T
+	err = db.Session(&gorm.Session{NewDB: true}).Unscoped().
+		Where(gormutil.PrimaryKeyCondition(stmt, reflectValue(obj))).
+		Take(&current).Error
+	found := err == nil
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+	if !found {
+		current = *obj
+	}
+	columns := []string{}
+	for _, field := range snapshotFields(stmt.Schema, keys) {
+		v := field.ReflectValueOf(stmt.Context, reflectValue(&restored)).Interface()
+		if err := field.Set(stmt.Context, reflectValue(&current), v); err != nil {
+			return errors.Wrap(err, field.Name)
+		}
+		if !field.PrimaryKey {
+			columns = append(columns, field.DBName)
+		}
+	}
+	db2 := WithOperation(db, OperationRevert).Unscoped()
+	if !found {
+		err = db2.Create(&current).Error
+	} else if len(columns) > 0 {
+		err = db2.Model(&current).Select(columns).Updates(&current).Error
+	}
+	if err != nil {
+		return err
+	}
+	*obj = current
+	return nil
+}
+
+// snapshotFields returns the fields of s whose JSON keys are in keys.
+func snapshotFields(s *schema.Schema, keys map[string]json.RawMessage) []*schema.Field {
+	fields := []*schema.Field{}
+	for _, field := range s.Fields {
+		if field.DBName == "" {
+			continue
+		}
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		if _, ok := keys[name]; ok {
+			fields = append(fields, field)
+		}
+	}
+	return fields
+}
+
+func reflectValue(obj any) reflect.Value {
+	return reflect.Indirect(reflect.ValueOf(obj))
+}
@@ -0,0 +1,43 @@
+package audit
+
+import (
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func TestRevert(t *testing.T) {
+	db := newDB(t)
+	d := &device{Name: "d1", Token: "t1"}
+	if err := db.Create(d).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Model(d).Updates(map[string]any{"name": "d2", "token": "t2"}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	// the token is not in the snapshots and keeps its current value
+	if err := Revert(db, d, 1); err != nil {
+		t.Fatal(err)
+	}
+	var got device
+	if err := db.First(&got, d.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if got.Name != "d1" || got.Token != "t2" || d.Name != "d1" || d.Token != "t2" {
+		t.Fatalf("reverted: %+v, stored: %+v", d, got)
+	}
+	if ops := operations(t, db, d); len(ops) != 3 || ops[2] != OperationRevert {
+		t.Fatalf("operations: %v", ops)
+	}
+
+	if err := Revert(db, d, 9); !errors.Is(err, ErrVersionNotFound) {
+		t.Fatalf("revert to a missing version: %v", err)
+	}
+	if err := db.Delete(d).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := Revert(db, d, 4); !errors.Is(err, ErrNoSnapshot) {
+		t.Fatalf("revert to a delete: %v", err)
+	}
+}
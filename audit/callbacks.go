@@ -0,0 +1,261 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"time"
+
+	"github.com/heypkg/store/internal/gormutil"
+	"github.com/heypkg/store/jsontype"
+	"github.com/heypkg/store/tenancy"
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+// Register migrates the audit table and installs the callbacks that record
+// every create, update and delete executed through db, including writes that
+// do not go through the store helpers.
+func Register(db *gorm.DB) error {
+	if err := db.AutoMigrate(&Record{}); err != nil {
+		return errors.Wrap(err, "migrate audit records")
+	}
+	cb := db.Callback()
+	if err := cb.Create().After("gorm:create").Register("audit:after_create", afterWrite(OperationCreate)); err != nil {
+		return err
+	}
+	if err := cb.Update().Before("gorm:update").Register("audit:before_update", beforeWrite); err != nil {
+		return err
+	}
+	if err := cb.Update().After("gorm:update").Register("audit:after_update", afterWrite(OperationUpdate)); err != nil {
+		return err
+	}
+	if err := cb.Delete().Before("gorm:delete").Register("audit:before_delete", beforeWrite); err != nil {
+		return err
+	}
+	if err := cb.Delete().After("gorm:delete").Register("audit:after_delete", afterWrite(OperationDelete)); err != nil {
+		return err
+	}
+	return nil
+}
+
+func enabled(db *gorm.DB) bool {
+	if db.Error != nil || db.Statement.Schema == nil {
+		return false
+	}
+	if db.Statement.Schema.Table == (Record{}).TableName() {
+		return false
+	}
+	if v, ok := db.Get(settingSkip); ok {
+		if skip, _ := v.(bool); skip {
+			return false
+		}
+	}
+	return true
+}
+
+// beforeWrite loads the objects of an update or delete as they were before
+// the write. Writes by conditions, whose value has no primary key, are
+// recorded for the rows matched by their conditions.
+func beforeWrite(db *gorm.DB) {
+	if !enabled(db) {
+		return
+	}
+	before := map[string]reflect.Value{}
+	keyed := false
+	gormutil.EachObject(db, func(rv reflect.Value) {
+		key, ok := gormutil.PrimaryKeyString(db.Statement, rv)
+		if !ok {
+			return
+		}
+		keyed = true
+		if obj, ok := gormutil.LoadObject(db, rv); ok {
+			before[key] = obj
+		}
+	})
+	if !keyed {
+		matched := loadMatched(db)
+		for _, obj := range matched {
+			if key, ok := gormutil.PrimaryKeyString(db.Statement, obj); ok {
+				before[key] = obj
+			}
+		}
+		db.InstanceSet(settingMatched, matched)
+	}
+	db.InstanceSet(settingBefore, before)
+}
+
+// loadMatched returns the rows matched by the conditions of the statement of
+// db. Statements without conditions match nothing, as gorm refuses them,
+// unless global updates are allowed.
+func loadMatched(db *gorm.DB) []reflect.Value {
+	stmt := db.Statement
+	where, ok := stmt.Clauses["WHERE"]
+	if !ok && !db.AllowGlobalUpdate {
+		return nil
+	}
+	rows := reflect.New(reflect.SliceOf(stmt.Schema.ModelType))
+	tx := db.Session(&gorm.Session{NewDB: true}).Unscoped().Table(stmt.Table)
+	if ok {
+		tx = tx.Clauses(where.Expression)
+	}
+	if err := tx.Find(rows.Interface()).Error; err != nil {
+		db.AddError(errors.Wrap(err, "load audited objects"))
+		return nil
+	}
+	matched := make([]reflect.Value, rows.Elem().Len())
+	for i := range matched {
+		matched[i] = rows.Elem().Index(i)
+	}
+	return matched
+}
+
+// eachWritten calls fn with the objects of the write: the rows matched by
+// writes by conditions, the values of the statement otherwise.
+func eachWritten(db *gorm.DB, fn func(rv reflect.Value)) {
+	if v, ok := db.InstanceGet(settingMatched); ok {
+		for _, rv := range v.([]reflect.Value) {
+			fn(rv)
+		}
+		return
+	}
+	gormutil.EachObject(db, fn)
+}
+
+func afterWrite(op Operation) func(db *gorm.DB) {
+	return func(db *gorm.DB) {
+		if !enabled(db) {
+			return
+		}
+		before := map[string]reflect.Value{}
+		if v, ok := db.InstanceGet(settingBefore); ok {
+			before, _ = v.(map[string]reflect.Value)
+		}
+		eachWritten(db, func(rv reflect.Value) {
+			key, ok := gormutil.PrimaryKeyString(db.Statement, rv)
+			if !ok {
+				return
+			}
+			old, hasOld := before[key]
+			obj, hasObj := gormutil.LoadObject(db, rv)
+			record := Record{
+				Time:      jsontype.JSONTime(time.Now()),
+				Actor:     actorOf(db),
+				Model:     db.Statement.Table,
+				ObjectKey: key,
+				Operation: operationOf(db, op, old, obj),
+			}
+			var changes []Change
+			switch {
+			case hasOld && hasObj:
+				changes = diffObjects(db.Statement.Schema, old, obj)
+			case hasObj:
+				changes = diffObjects(db.Statement.Schema, reflect.Value{}, obj)
+			case hasOld:
+				changes = diffObjects(db.Statement.Schema, old, reflect.Value{})
+			}
+			if hasOld && hasObj && len(changes) == 0 {
+				return
+			}
+			record.Changes = changes
+			if hasObj {
+				record.Schema = schemaOf(db.Statement.Schema, obj)
+				if data, err := json.Marshal(obj.Addr().Interface()); err == nil {
+					record.Snapshot = jsontype.NewJSONType(json.RawMessage(data))
+				}
+			} else if hasOld {
+				record.Schema = schemaOf(db.Statement.Schema, old)
+			}
+			if err := writeRecord(db, &record); err != nil {
+				db.AddError(errors.Wrap(err, "write audit record"))
+			}
+		})
+	}
+}
+
+// versionAttempts is the number of versions tried for a record whose
+// version is taken by a concurrent write of its object.
+const versionAttempts = 5
+
+// writeRecord adds record with the version following the latest one of its
+// object, unique per object.
+func writeRecord(db *gorm.DB, record *Record) error {
+	tx := db.Session(&gorm.Session{NewDB: true})
+	for attempt := 1; ; attempt++ {
+		version, err := latestVersion(tx, record)
+		if err != nil {
+			return err
+		}
+		record.ID = 0
+		record.Version = version + 1
+		// the savepoint keeps the transaction of the write usable after a
+		// conflict
+		err = tx.Transaction(func(tx *gorm.DB) error {
+			return tx.Create(record).Error
+		})
+		if err == nil || attempt == versionAttempts {
+			return err
+		}
+		if latest, err2 := latestVersion(tx, record); err2 != nil || latest < record.Version {
+			return err
+		}
+	}
+}
+
+// latestVersion returns the version of the latest record of the object of
+// record, locked for the databases that support it.
+func latestVersion(tx *gorm.DB, record *Record) (int, error) {
+	var versions []int
+	err := tx.Model(&Record{}).Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("model = ? AND object_key = ?", record.Model, record.ObjectKey).
+		Order("version DESC").
+		Limit(1).
+		Pluck("version", &versions).Error
+	if err != nil || len(versions) == 0 {
+		return 0, err
+	}
+	return versions[0], nil
+}
+
+func actorOf(db *gorm.DB) string {
+	return ActorFromContext(db.Statement.Context)
+}
+
+func operationOf(db *gorm.DB, op Operation, before reflect.Value, after reflect.Value) Operation {
+	if v, ok := db.Get(settingOperation); ok {
+		if op2, ok := v.(Operation); ok && op2 != "" {
+			return op2
+		}
+	}
+	if op != OperationUpdate || !before.IsValid() || !after.IsValid() {
+		return op
+	}
+	field := gormutil.DeletedAtField(db.Statement.Schema)
+	if field == nil {
+		return op
+	}
+	_, wasAlive := field.ValueOf(db.Statement.Context, before)
+	_, isAlive := field.ValueOf(db.Statement.Context, after)
+	if !wasAlive && isAlive {
+		return OperationRestore
+	}
+	if wasAlive && !isAlive {
+		return OperationDelete
+	}
+	return op
+}
+
+func schemaOf(s *schema.Schema, rv reflect.Value) string {
+	column := tenancy.DefaultStrategy().Column()
+	if column == "" {
+		return ""
+	}
+	if field := s.LookUpField(column); field != nil {
+		if v, ok := field.ReflectValueOf(context.Background(), rv).Interface().(string); ok {
+			return v
+		}
+	}
+	return ""
+}
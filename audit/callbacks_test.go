@@ -0,0 +1,115 @@
+package audit
+
+import (
+	"testing"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+type device struct {
+	ID    uint `gorm:"primarykey"`
+	Name  string
+	Kind  string
+	Token string `json:"-"`
+}
+
+// newDB returns an audited sqlite database of devices.
+func newDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(t.TempDir()+"/audit.db"), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&device{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := Register(db); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// operations returns the operations recorded for d, oldest first.
+func operations(t *testing.T, db *gorm.DB, d *device) []Operation {
+	t.Helper()
+	history, err := History(db, d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ops []Operation
+	if err := history.Order("version").Pluck("operation", &ops).Error; err != nil {
+		t.Fatal(err)
+	}
+	return ops
+}
+
+func TestVersions(t *testing.T) {
+	db := newDB(t)
+	// a concurrent write takes the next version once it is read
+	race := true
+	if err := db.Callback().Query().After("gorm:query").Register("test:race", func(db *gorm.DB) {
+		versions, ok := db.Statement.Dest.(*[]int)
+		if !ok || !race {
+			return
+		}
+		race = false
+		taken := Record{Model: db.Statement.Vars[0].(string), ObjectKey: db.Statement.Vars[1].(string), Version: 1}
+		if len(*versions) > 0 {
+			taken.Version = (*versions)[0] + 1
+		}
+		if err := db.Session(&gorm.Session{NewDB: true}).Create(&taken).Error; err != nil {
+			t.Error(err)
+		}
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	d := &device{Name: "d1"}
+	if err := db.Create(d).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Model(d).Update("name", "d2").Error; err != nil {
+		t.Fatal(err)
+	}
+	var versions []int
+	if err := db.Model(&Record{}).Order("version").Pluck("version", &versions).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 3 || versions[0] != 1 || versions[1] != 2 || versions[2] != 3 {
+		t.Fatalf("versions: %v", versions)
+	}
+
+	var duplicate Record
+	if err := db.Take(&duplicate).Error; err != nil {
+		t.Fatal(err)
+	}
+	duplicate.ID = 0
+	if err := Skip(db).Create(&duplicate).Error; err == nil {
+		t.Fatal("duplicate version added")
+	}
+}
+
+func TestBatchWrites(t *testing.T) {
+	db := newDB(t)
+	d1, d2, d3 := &device{Name: "d1", Kind: "a"}, &device{Name: "d2", Kind: "a"}, &device{Name: "d3", Kind: "b"}
+	if err := db.Create([]*device{d1, d2, d3}).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Model(&device{}).Where("kind = ?", "a").Update("name", "x").Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Where("kind = ?", "a").Delete(&device{}).Error; err != nil {
+		t.Fatal(err)
+	}
+	for _, d := range []*device{d1, d2} {
+		ops := operations(t, db, d)
+		if len(ops) != 3 || ops[1] != OperationUpdate || ops[2] != OperationDelete {
+			t.Errorf("operations of %v: %v", d.Name, ops)
+		}
+	}
+	if ops := operations(t, db, d3); len(ops) != 1 {
+		t.Errorf("operations of the unmatched object: %v", ops)
+	}
+}
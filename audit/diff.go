@@ -0,0 +1,89 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+
+	"gorm.io/gorm/schema"
+)
+
+// diffObjects compares two objects of the same model column by column. JSON
+// columns such as jsontype.Tags and jsontype.JSONType are compared key by key
+// and reported with dotted names, the same way they are addressed in search
+// strings (tags.location).
+func diffObjects(s *schema.Schema, before reflect.Value, after reflect.Value) []Change {
+	changes := []Change{}
+	for _, field := range s.Fields {
+		if field.DBName == "" {
+			continue
+		}
+		var a, b any
+		if before.IsValid() {
+			a = normalizeValue(field.ReflectValueOf(context.Background(), before).Interface())
+		}
+		if after.IsValid() {
+			b = normalizeValue(field.ReflectValueOf(context.Background(), after).Interface())
+		}
+		changes = diffValues(changes, field.DBName, a, b)
+	}
+	return changes
+}
+
+// Diff compares two arbitrary values after converting them to their JSON
+// representation.
+func Diff(before any, after any) []Change {
+	return diffValues([]Change{}, "", normalizeValue(before), normalizeValue(after))
+}
+
+func diffValues(changes []Change, path string, a any, b any) []Change {
+	ma, okA := a.(map[string]any)
+	mb, okB := b.(map[string]any)
+	if okA && okB {
+		keys := []string{}
+		for k := range ma {
+			keys = append(keys, k)
+		}
+		for k := range mb {
+			if _, ok := ma[k]; !ok {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			changes = diffValues(changes, joinPath(path, k), ma[k], mb[k])
+		}
+		return changes
+	}
+	if reflect.DeepEqual(a, b) {
+		return changes
+	}
+	return append(changes, Change{Field: path, Before: a, After: b})
+}
+
+func joinPath(path string, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+func normalizeValue(v any) any {
+	if v == nil {
+		return nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprintf("%v", v)
+	}
+	var out any
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&out); err != nil {
+		return string(data)
+	}
+	return out
+}
@@ -0,0 +1,90 @@
+package boltdb
+
+import (
+	"context"
+	"time"
+
+	"github.com/heypkg/store"
+	"github.com/pkg/errors"
+	"go.etcd.io/bbolt"
+)
+
+var ErrReadOnlyTx = errors.New("write in a read-only transaction")
+
+// DB is an embedded, file-backed database holding the objects of any number
+// of models as JSON.
+type DB struct {
+	bolt *bbolt.DB
+}
+
+func Open(path string) (*DB, error) {
+	db, err := bbolt.Open(path, 0600, &bbolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, errors.Wrap(err, "open bolt db")
+	}
+	return &DB{bolt: db}, nil
+}
+
+func (db *DB) Close() error {
+	return db.bolt.Close()
+}
+
+func (db *DB) Bolt() *bbolt.DB {
+	return db.bolt
+}
+
+type txContextKey struct{}
+
+type txState struct {
+	db *DB
+	tx *bbolt.Tx
+}
+
+// Update runs fn in a read-write transaction. Stores of db called with the
+// context passed to fn join the transaction, which commits when fn returns
+// nil and rolls back otherwise.
+func (db *DB) Update(ctx context.Context, fn func(ctx context.Context) error) error {
+	if state := db.txFromContext(ctx); state != nil {
+		if !state.tx.Writable() {
+			return ErrReadOnlyTx
+		}
+		return fn(ctx)
+	}
+	return db.bolt.Update(func(tx *bbolt.Tx) error {
+		return fn(context.WithValue(ctx, txContextKey{}, &txState{db: db, tx: tx}))
+	})
+}
+
+// View runs fn in a read-only transaction with a consistent view of db.
+func (db *DB) View(ctx context.Context, fn func(ctx context.Context) error) error {
+	if state := db.txFromContext(ctx); state != nil {
+		return fn(ctx)
+	}
+	return db.bolt.View(func(tx *bbolt.Tx) error {
+		return fn(context.WithValue(ctx, txContextKey{}, &txState{db: db, tx: tx}))
+	})
+}
+
+func (db *DB) txFromContext(ctx context.Context) *txState {
+	if state, ok := ctx.Value(txContextKey{}).(*txState); ok && state.db == db {
+		return state
+	}
+	return nil
+}
+
+func (db *DB) view(ctx context.Context, fn func(tx *bbolt.Tx) error) error {
+	if state := db.txFromContext(ctx); state != nil {
+		return fn(state.tx)
+	}
+	return db.bolt.View(fn)
+}
+
+func (db *DB) update(ctx context.Context, fn func(tx *bbolt.Tx) error) error {
+	if state := db.txFromContext(ctx); state != nil {
+		if !state.tx.Writable() {
+			return store.Internal(ErrReadOnlyTx)
+		}
+		return fn(state.tx)
+	}
+	return db.bolt.Update(fn)
+}
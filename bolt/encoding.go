@@ -0,0 +1,165 @@
+package boltdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"math"
+	"time"
+
+	"github.com/heypkg/store/search"
+	"github.com/spf13/cast"
+	"gorm.io/gorm/schema"
+)
+
+// Index keys are the encoded value followed by the encoded primary key. The
+// encoding keeps the order of values of the same kind, so ranges are scanned
+// with a cursor.
+const (
+	kindBool   byte = 'b'
+	kindNumber byte = 'n'
+	kindString byte = 's'
+	kindTime   byte = 't'
+)
+
+// encodeValue encodes a normalized column value. It reports false for NULL
+// and values that are not indexed, such as JSON.
+func encodeValue(v any) ([]byte, bool) {
+	switch vv := search.NormalizeValue(v).(type) {
+	case bool:
+		if vv {
+			return []byte{kindBool, 1}, true
+		}
+		return []byte{kindBool, 0}, true
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64:
+		return encodeNumber(cast.ToFloat64(vv)), true
+	case json.Number:
+		f, err := vv.Float64()
+		if err != nil {
+			return nil, false
+		}
+		return encodeNumber(f), true
+	case string:
+		return encodeString(vv), true
+	case time.Time:
+		return encodeTime(vv), true
+	}
+	return nil, false
+}
+
+func encodeNumber(f float64) []byte {
+	bits := math.Float64bits(f)
+	if f < 0 {
+		bits = ^bits
+	} else {
+		bits |= 1 << 63
+	}
+	out := make([]byte, 9)
+	out[0] = kindNumber
+	binary.BigEndian.PutUint64(out[1:], bits)
+	return out
+}
+
+func encodeTime(t time.Time) []byte {
+	out := make([]byte, 9)
+	out[0] = kindTime
+	binary.BigEndian.PutUint64(out[1:], uint64(t.UnixNano())^(1<<63))
+	return out
+}
+
+// encodeString escapes 0x00 as 0x00 0xff and ends with 0x00 0x01, so that
+// shorter strings sort first.
+func encodeString(s string) []byte {
+	out := make([]byte, 0, len(s)+3)
+	out = append(out, kindString)
+	for i := 0; i < len(s); i++ {
+		if s[i] == 0 {
+			out = append(out, 0, 0xff)
+		} else {
+			out = append(out, s[i])
+		}
+	}
+	return append(out, 0, 1)
+}
+
+// valueLength returns the length of the encoded value at the start of key.
+func valueLength(key []byte) int {
+	if len(key) == 0 {
+		return 0
+	}
+	switch key[0] {
+	case kindBool:
+		return 2
+	case kindNumber, kindTime:
+		return 9
+	case kindString:
+		for i := 1; i+1 < len(key); i++ {
+			if key[i] == 0 {
+				if key[i+1] == 1 {
+					return i + 2
+				}
+				i++
+			}
+		}
+	}
+	return len(key)
+}
+
+// encodeSearchValue encodes a search value for an index on field. It reports
+// false when the index cannot answer the comparison with the semantics of
+// search.Match, for example a text value against a number column.
+func encodeSearchValue(field *schema.Field, v any) ([]byte, bool) {
+	switch field.DataType {
+	case schema.Bool:
+		b, err := cast.ToBoolE(v)
+		if err != nil {
+			return nil, false
+		}
+		return encodeValue(b)
+	case schema.Int, schema.Uint, schema.Float:
+		if s, ok := v.(string); ok {
+			f, err := cast.ToFloat64E(s)
+			if err != nil {
+				return nil, false
+			}
+			return encodeNumber(f), true
+		}
+		f, err := cast.ToFloat64E(v)
+		if err != nil {
+			return nil, false
+		}
+		return encodeNumber(f), true
+	case schema.String:
+		if s, ok := v.(string); ok {
+			return encodeString(s), true
+		}
+	case schema.Time:
+		t, err := cast.ToTimeE(v)
+		if err != nil {
+			return nil, false
+		}
+		return encodeTime(t), true
+	}
+	return nil, false
+}
+
+// keyRange is a range of encoded values. A nil bound is open up to the end
+// of the values of the same kind.
+type keyRange struct {
+	kind      byte
+	lower     []byte
+	lowerOpen bool
+	upper     []byte
+	upperOpen bool
+}
+
+func (r keyRange) containsUpper(v []byte) bool {
+	if len(v) == 0 || v[0] != r.kind {
+		return false
+	}
+	if r.upper == nil {
+		return true
+	}
+	c := bytes.Compare(v, r.upper)
+	return c < 0 || (c == 0 && !r.upperOpen)
+}
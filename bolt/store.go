@@ -0,0 +1,724 @@
+package boltdb
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/heypkg/store"
+	"github.com/heypkg/store/internal/objectstore"
+	"github.com/heypkg/store/policy"
+	"github.com/heypkg/store/search"
+	"github.com/pkg/errors"
+	"github.com/spf13/cast"
+	"go.etcd.io/bbolt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+var (
+	objectsBucket   = []byte("objects")
+	indexPrefix     = "index:"
+	partitionPrefix = "p:"
+)
+
+// Store keeps the objects of model T in a DB as JSON, with the semantics of
+// the GORM store. Every tenant gets its own partition. Search terms on
+// indexed columns are answered from the index; the rest of the search is
+// evaluated on the candidate objects.
+//
+// Models must round-trip through encoding/json. Associations are stored
+// with their parent: includes are validated, not loaded.
+type Store[T any] struct {
+	db      *DB
+	model   *objectstore.Model[T]
+	table   []byte
+	indexes map[string]*schema.Field
+}
+
+var (
+	_ store.Store[struct{}]   = (*Store[struct{}])(nil)
+	_ store.Scanner[struct{}] = (*Store[struct{}])(nil)
+)
+
+// NewStore returns the store of model T, maintaining secondary indexes on
+// the given columns. Indexes missing from existing data are built.
+func NewStore[T any](db *DB, indexes ...string) (*Store[T], error) {
+	m, err := objectstore.ParseModel[T]()
+	if err != nil {
+		return nil, err
+	}
+	s := &Store[T]{db: db, model: m, table: []byte(m.Schema.Table), indexes: map[string]*schema.Field{}}
+	for _, name := range indexes {
+		field := m.Schema.LookUpField(name)
+		if field == nil || field.DBName == "" {
+			return nil, errors.Errorf("unknown index column %v", name)
+		}
+		s.indexes[field.DBName] = field
+	}
+	err = db.bolt.Update(func(tx *bbolt.Tx) error {
+		table, err := tx.CreateBucketIfNotExists(s.table)
+		if err != nil {
+			return err
+		}
+		return table.ForEach(func(k, v []byte) error {
+			if v != nil || !bytes.HasPrefix(k, []byte(partitionPrefix)) {
+				return nil
+			}
+			return s.buildIndexes(table.Bucket(k))
+		})
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "build indexes")
+	}
+	return s, nil
+}
+
+func (s *Store[T]) buildIndexes(part *bbolt.Bucket) error {
+	for name := range s.indexes {
+		if part.Bucket([]byte(indexPrefix+name)) != nil {
+			continue
+		}
+		if _, err := part.CreateBucket([]byte(indexPrefix + name)); err != nil {
+			return err
+		}
+		objects := part.Bucket(objectsBucket)
+		if objects == nil {
+			continue
+		}
+		err := objects.ForEach(func(k, v []byte) error {
+			obj, err := s.decode(v)
+			if err != nil {
+				return err
+			}
+			return s.index(part, k, nil, obj)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// partition returns the name of the partition of the tenant. Models without
+// the tenant column of a column strategy are shared by all tenants.
+func (s *Store[T]) partition(a objectstore.Access) []byte {
+	if s.model.TenantField(a) != nil {
+		return []byte(partitionPrefix + a.Tenancy.Tenant)
+	}
+	return []byte(partitionPrefix + a.Partition())
+}
+
+func (s *Store[T]) readPartition(tx *bbolt.Tx, a objectstore.Access) *bbolt.Bucket {
+	table := tx.Bucket(s.table)
+	if table == nil {
+		return nil
+	}
+	return table.Bucket(s.partition(a))
+}
+
+func (s *Store[T]) writePartition(tx *bbolt.Tx, a objectstore.Access) (*bbolt.Bucket, error) {
+	table, err := tx.CreateBucketIfNotExists(s.table)
+	if err != nil {
+		return nil, err
+	}
+	part, err := table.CreateBucketIfNotExists(s.partition(a))
+	if err != nil {
+		return nil, err
+	}
+	if _, err := part.CreateBucketIfNotExists(objectsBucket); err != nil {
+		return nil, err
+	}
+	for name := range s.indexes {
+		if _, err := part.CreateBucketIfNotExists([]byte(indexPrefix + name)); err != nil {
+			return nil, err
+		}
+	}
+	return part, nil
+}
+
+func (s *Store[T]) key(obj *T) []byte {
+	key := []byte{}
+	for _, field := range s.model.Schema.PrimaryFields {
+		v, ok := encodeValue(s.model.Value(field, obj))
+		if !ok {
+			v = encodeString(s.model.PrimaryKey(obj))
+		}
+		key = append(key, v...)
+	}
+	return key
+}
+
+func (s *Store[T]) decode(data []byte) (*T, error) {
+	var obj T
+	if err := json.Unmarshal(data, &obj); err != nil {
+		return nil, errors.Wrap(err, "decode object")
+	}
+	return &obj, nil
+}
+
+func (s *Store[T]) load(part *bbolt.Bucket, key []byte) (*T, error) {
+	if part == nil {
+		return nil, nil
+	}
+	objects := part.Bucket(objectsBucket)
+	if objects == nil {
+		return nil, nil
+	}
+	data := objects.Get(key)
+	if data == nil {
+		return nil, nil
+	}
+	return s.decode(data)
+}
+
+// index replaces the index entries of old with those of obj.
+func (s *Store[T]) index(part *bbolt.Bucket, key []byte, old *T, obj *T) error {
+	for name, field := range s.indexes {
+		bucket := part.Bucket([]byte(indexPrefix + name))
+		if old != nil {
+			if v, ok := encodeValue(s.model.Value(field, old)); ok {
+				if err := bucket.Delete(append(v, key...)); err != nil {
+					return err
+				}
+			}
+		}
+		if obj != nil {
+			if v, ok := encodeValue(s.model.Value(field, obj)); ok {
+				if err := bucket.Put(append(v, key...), nil); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func (s *Store[T]) put(part *bbolt.Bucket, old *T, obj *T) error {
+	data, err := json.Marshal(obj)
+	if err != nil {
+		return errors.Wrap(err, "encode object")
+	}
+	key := s.key(obj)
+	if err := part.Bucket(objectsBucket).Put(key, data); err != nil {
+		return err
+	}
+	return s.index(part, key, old, obj)
+}
+
+// candidates returns the keys of the objects that may match data, in key
+// order, using the index of one search term. It reports false when no term
+// can be answered from an index.
+func (s *Store[T]) candidates(part *bbolt.Bucket, data search.SearchData, handleFuncs search.SearchDataHandleFuncMap) ([][]byte, bool) {
+	for name, values := range data {
+		if _, ok := handleFuncs[name]; ok {
+			continue
+		}
+		field, ok := s.indexes[strings.ToLower(name)]
+		if !ok {
+			continue
+		}
+		ranges, ok := searchRanges(field, values)
+		if !ok {
+			continue
+		}
+		bucket := part.Bucket([]byte(indexPrefix + field.DBName))
+		if bucket == nil {
+			continue
+		}
+		seen := map[string]bool{}
+		keys := [][]byte{}
+		c := bucket.Cursor()
+		for _, r := range ranges {
+			var k []byte
+			if r.lower != nil {
+				k, _ = c.Seek(r.lower)
+			} else {
+				k, _ = c.Seek([]byte{r.kind})
+			}
+			for ; k != nil; k, _ = c.Next() {
+				n := valueLength(k)
+				v := k[:n]
+				if r.lower != nil && r.lowerOpen && bytes.Equal(v, r.lower) {
+					continue
+				}
+				if !r.containsUpper(v) {
+					break
+				}
+				if key := k[n:]; !seen[string(key)] {
+					seen[string(key)] = true
+					keys = append(keys, append([]byte{}, key...))
+				}
+			}
+		}
+		sort.Slice(keys, func(i, j int) bool { return bytes.Compare(keys[i], keys[j]) < 0 })
+		return keys, true
+	}
+	return nil, false
+}
+
+// searchRanges converts the values of a search term to index ranges. Terms
+// with != or without conditions are not answered from indexes.
+func searchRanges(field *schema.Field, values []search.SearchValue) ([]keyRange, bool) {
+	ranges := []keyRange{}
+	for _, v := range values {
+		switch v.Symbol {
+		case search.SearchSymbolNone:
+			continue
+		case search.SearchSymbolEq, search.SearchSymbolGt, search.SearchSymbolGte, search.SearchSymbolLt, search.SearchSymbolLte:
+			enc, ok := encodeSearchValue(field, v.Value)
+			if !ok {
+				return nil, false
+			}
+			r := keyRange{kind: enc[0]}
+			switch v.Symbol {
+			case search.SearchSymbolEq:
+				r.lower, r.upper = enc, enc
+			case search.SearchSymbolGt:
+				r.lower, r.lowerOpen = enc, true
+			case search.SearchSymbolGte:
+				r.lower = enc
+			case search.SearchSymbolLt:
+				r.upper, r.upperOpen = enc, true
+			case search.SearchSymbolLte:
+				r.upper = enc
+			}
+			ranges = append(ranges, r)
+		case search.SearchSymbolRange:
+			r := keyRange{}
+			if v.Value != nil {
+				enc, ok := encodeSearchValue(field, v.Value)
+				if !ok {
+					return nil, false
+				}
+				r.kind, r.lower = enc[0], enc
+			}
+			if v.Value2 != nil {
+				enc, ok := encodeSearchValue(field, v.Value2)
+				if !ok || (r.kind != 0 && r.kind != enc[0]) {
+					return nil, false
+				}
+				r.kind, r.upper = enc[0], enc
+			}
+			if r.kind == 0 {
+				return nil, false
+			}
+			ranges = append(ranges, r)
+		default:
+			return nil, false
+		}
+	}
+	return ranges, len(ranges) > 0
+}
+
+// match returns the objects of the tenant that match data and the policy
+// filter.
+func (s *Store[T]) match(ctx context.Context, tx *bbolt.Tx, a objectstore.Access, data search.SearchData, handleFuncs search.SearchDataHandleFuncMap, deleted bool) ([]*T, error) {
+	out := []*T{}
+	err := s.each(ctx, tx, a, data, handleFuncs, deleted, func(obj *T) error {
+		out = append(out, obj)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// each calls fn with the objects of the tenant that match data and the
+// policy filter, in key order unless an index answers the search. It stops
+// when ctx is done.
+func (s *Store[T]) each(ctx context.Context, tx *bbolt.Tx, a objectstore.Access, data search.SearchData, handleFuncs search.SearchDataHandleFuncMap, deleted bool, fn func(obj *T) error) error {
+	part := s.readPartition(tx, a)
+	if part == nil {
+		return nil
+	}
+	objects := part.Bucket(objectsBucket)
+	if objects == nil {
+		return nil
+	}
+	check := func(v []byte) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		obj, err := s.decode(v)
+		if err != nil {
+			return err
+		}
+		if !s.model.InTenant(a, obj) || s.model.IsDeleted(obj) != deleted {
+			return nil
+		}
+		ok, err := a.Match(obj, data, handleFuncs)
+		if err != nil {
+			return err
+		}
+		if ok {
+			return fn(obj)
+		}
+		return nil
+	}
+	if keys, ok := s.candidates(part, data, handleFuncs); ok {
+		for _, key := range keys {
+			if v := objects.Get(key); v != nil {
+				if err := check(v); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	return objects.ForEach(func(k, v []byte) error { return check(v) })
+}
+
+func internalError(ctx context.Context, err error) error {
+	if err == nil {
+		return nil
+	}
+	return store.ContextError(ctx, store.WrapError(err))
+}
+
+func (s *Store[T]) List(ctx context.Context, req store.ListRequest) ([]T, int64, error) {
+	ctx, cancel := store.WithTimeout(ctx, req.Timeout)
+	defer cancel()
+	a, err := objectstore.NewAccess[T](ctx, policy.ActionList)
+	if err != nil {
+		return nil, 0, err
+	}
+	data, err := objectstore.ParseSearch(req.Search, a)
+	if err != nil {
+		return nil, 0, err
+	}
+	if err := objectstore.CheckIncludes[T](req.Include); err != nil {
+		return nil, 0, err
+	}
+	var objects []*T
+	err = s.db.view(ctx, func(tx *bbolt.Tx) error {
+		var err error
+		objects, err = s.match(ctx, tx, a, data, req.HandleFuncs, req.Deleted)
+		return err
+	})
+	if err != nil {
+		return nil, 0, internalError(ctx, err)
+	}
+	return objectstore.Page(s.model, objects, req), int64(len(objects)), nil
+}
+
+// Scan calls fn with the matching objects inside a read transaction.
+// Without OrderBy objects are decoded one at a time; ordered scans sort the
+// matching objects in memory first.
+func (s *Store[T]) Scan(ctx context.Context, req store.ListRequest, fn func(obj *T) error) error {
+	if req.OrderBy != "" {
+		req.Page, req.PageSize = 0, 0
+		objects, _, err := s.List(ctx, req)
+		if err != nil {
+			return err
+		}
+		for i := range objects {
+			if err := fn(&objects[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	ctx, cancel := store.WithTimeout(ctx, req.Timeout)
+	defer cancel()
+	a, err := objectstore.NewAccess[T](ctx, policy.ActionList)
+	if err != nil {
+		return err
+	}
+	data, err := objectstore.ParseSearch(req.Search, a)
+	if err != nil {
+		return err
+	}
+	var fnErr error
+	err = s.db.view(ctx, func(tx *bbolt.Tx) error {
+		return s.each(ctx, tx, a, data, req.HandleFuncs, req.Deleted, func(obj *T) error {
+			if len(req.Select) > 0 {
+				s.model.SelectFields(obj, req.Select)
+			}
+			if fnErr = fn(obj); fnErr != nil {
+				return fnErr
+			}
+			return nil
+		})
+	})
+	if fnErr != nil {
+		return fnErr
+	}
+	return internalError(ctx, err)
+}
+
+func (s *Store[T]) Count(ctx context.Context, req store.ListRequest) (int64, error) {
+	req.Page, req.PageSize, req.OrderBy, req.Select = 0, 0, "", nil
+	_, total, err := s.List(ctx, req)
+	return total, err
+}
+
+// Get loads the object whose columns equal req.Conditions, directly by key
+// when the conditions are the primary key.
+func (s *Store[T]) Get(ctx context.Context, req store.GetRequest) (*T, error) {
+	ctx, cancel := store.WithTimeout(ctx, req.Timeout)
+	defer cancel()
+	a, err := objectstore.NewAccess[T](ctx, policy.ActionRead)
+	if err != nil {
+		return nil, err
+	}
+	if err := objectstore.CheckIncludes[T](req.Include); err != nil {
+		return nil, err
+	}
+	var found *T
+	hidden := false
+	err = s.db.view(ctx, func(tx *bbolt.Tx) error {
+		part := s.readPartition(tx, a)
+		if part == nil {
+			return nil
+		}
+		check := func(obj *T) (bool, error) {
+			if !s.model.InTenant(a, obj) || s.model.IsDeleted(obj) != req.Deleted {
+				return false, nil
+			}
+			ok, err := objectstore.MatchConditions(obj, req.Conditions)
+			if err != nil || !ok {
+				return false, err
+			}
+			ok, err = a.Visible(obj)
+			if err != nil {
+				return false, err
+			}
+			if !ok {
+				hidden = true
+				return false, nil
+			}
+			found = obj
+			return true, nil
+		}
+		if key, ok := s.conditionKey(req.Conditions); ok {
+			obj, err := s.load(part, key)
+			if err != nil || obj == nil {
+				return err
+			}
+			_, err = check(obj)
+			return err
+		}
+		objects := part.Bucket(objectsBucket)
+		if objects == nil {
+			return nil
+		}
+		c := objects.Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			obj, err := s.decode(v)
+			if err != nil {
+				return err
+			}
+			if ok, err := check(obj); err != nil || ok {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, internalError(ctx, err)
+	}
+	if found == nil {
+		if hidden && a.DeniedStatus() == http.StatusForbidden {
+			return nil, a.Denied(http.StatusForbidden)
+		}
+		return nil, store.NotFound("not found")
+	}
+	if err := a.Allow(found); err != nil {
+		return nil, err
+	}
+	return found, nil
+}
+
+// conditionKey returns the key of the object selected by conditions when
+// they name exactly the primary key columns.
+func (s *Store[T]) conditionKey(conditions map[string]any) ([]byte, bool) {
+	fields := s.model.Schema.PrimaryFields
+	if len(conditions) != len(fields) {
+		return nil, false
+	}
+	var obj T
+	for _, field := range fields {
+		v, ok := conditions[field.DBName]
+		if !ok {
+			return nil, false
+		}
+		if err := s.model.Set(field, &obj, v); err != nil {
+			return nil, false
+		}
+	}
+	return s.key(&obj), true
+}
+
+func (s *Store[T]) Create(ctx context.Context, obj *T) error {
+	a, err := objectstore.NewAccess[T](ctx, policy.ActionCreate)
+	if err != nil {
+		return err
+	}
+	if err := s.model.AssignTenant(a, obj, nil); err != nil {
+		return internalError(ctx, err)
+	}
+	if err := a.Authorize(obj, nil); err != nil {
+		return err
+	}
+	return internalError(ctx, s.db.update(ctx, func(tx *bbolt.Tx) error {
+		part, err := s.writePartition(tx, a)
+		if err != nil {
+			return err
+		}
+		if len(s.model.Schema.PrimaryFields) == 1 {
+			field := s.model.Schema.PrimaryFields[0]
+			table := tx.Bucket(s.table)
+			if s.model.IsZero(field, obj) && field.AutoIncrement {
+				seq, err := table.NextSequence()
+				if err != nil {
+					return err
+				}
+				if err := s.model.Set(field, obj, seq); err != nil {
+					return err
+				}
+			} else if id, err := cast.ToUint64E(s.model.Value(field, obj)); err == nil && id > table.Sequence() {
+				if err := table.SetSequence(id); err != nil {
+					return err
+				}
+			}
+		}
+		if old, err := s.load(part, s.key(obj)); err != nil || old != nil {
+			if err == nil {
+				err = gorm.ErrDuplicatedKey
+			}
+			return err
+		}
+		if err := s.model.Touch(obj, true); err != nil {
+			return err
+		}
+		return s.put(part, nil, obj)
+	}))
+}
+
+// Update saves all fields of obj, or only the given values when values is
+// not empty. The tenant of obj cannot be changed.
+func (s *Store[T]) Update(ctx context.Context, obj *T, values map[string]any) error {
+	a, err := objectstore.NewAccess[T](ctx, policy.ActionUpdate)
+	if err != nil {
+		return err
+	}
+	if err := s.model.AssignTenant(a, obj, values); err != nil {
+		return internalError(ctx, err)
+	}
+	return internalError(ctx, s.db.update(ctx, func(tx *bbolt.Tx) error {
+		part, err := s.writePartition(tx, a)
+		if err != nil {
+			return err
+		}
+		stored, err := s.load(part, s.key(obj))
+		if err != nil {
+			return err
+		}
+		if err := a.Authorize(obj, stored); err != nil {
+			return err
+		}
+		if stored == nil || s.model.IsDeleted(stored) {
+			return store.NotFound("not found")
+		}
+		updated := *obj
+		if len(values) > 0 {
+			updated = *stored
+			if err := s.model.ApplyValues(&updated, values); err != nil {
+				return err
+			}
+		}
+		if err := s.model.Touch(&updated, false); err != nil {
+			return err
+		}
+		if err := s.put(part, stored, &updated); err != nil {
+			return err
+		}
+		*obj = updated
+		return nil
+	}))
+}
+
+func (s *Store[T]) Delete(ctx context.Context, obj *T) error {
+	return s.setDeleted(ctx, policy.ActionDelete, obj, true)
+}
+
+func (s *Store[T]) Restore(ctx context.Context, obj *T) error {
+	return s.setDeleted(ctx, policy.ActionRestore, obj, false)
+}
+
+func (s *Store[T]) setDeleted(ctx context.Context, action policy.Action, obj *T, deleted bool) error {
+	a, err := objectstore.NewAccess[T](ctx, action)
+	if err != nil {
+		return err
+	}
+	return internalError(ctx, s.db.update(ctx, func(tx *bbolt.Tx) error {
+		part, err := s.writePartition(tx, a)
+		if err != nil {
+			return err
+		}
+		key := s.key(obj)
+		stored, err := s.load(part, key)
+		if err != nil {
+			return err
+		}
+		if err := a.Authorize(obj, stored); err != nil {
+			return err
+		}
+		if stored == nil || deleted && s.model.IsDeleted(stored) {
+			return store.NotFound("not found")
+		}
+		if s.model.IsDeleted(stored) == deleted {
+			return nil
+		}
+		updated := *stored
+		ok, err := s.model.SetDeleted(&updated, deleted)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			if !deleted {
+				return nil
+			}
+			if err := part.Bucket(objectsBucket).Delete(key); err != nil {
+				return err
+			}
+			return s.index(part, key, stored, nil)
+		}
+		if err := s.put(part, stored, &updated); err != nil {
+			return err
+		}
+		_, err = s.model.SetDeleted(obj, deleted)
+		return err
+	}))
+}
+
+func (s *Store[T]) Aggregate(ctx context.Context, req store.AggregateRequest) ([]map[string]any, error) {
+	ctx, cancel := store.WithTimeout(ctx, req.Timeout)
+	defer cancel()
+	if err := req.Validate(); err != nil {
+		return nil, store.NewError(store.ErrInvalidQuery, err.Error(), err)
+	}
+	a, err := objectstore.NewAccess[T](ctx, policy.ActionList)
+	if err != nil {
+		return nil, err
+	}
+	data, err := objectstore.ParseSearch(req.Search, a)
+	if err != nil {
+		return nil, err
+	}
+	var objects []*T
+	err = s.db.view(ctx, func(tx *bbolt.Tx) error {
+		var err error
+		objects, err = s.match(ctx, tx, a, data, req.HandleFuncs, false)
+		return err
+	})
+	if err != nil {
+		return nil, internalError(ctx, err)
+	}
+	return objectstore.Aggregate(objects, req)
+}
@@ -0,0 +1,36 @@
+package boltdb_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/heypkg/store"
+	boltdb "github.com/heypkg/store/bolt"
+	"github.com/heypkg/store/storetest"
+)
+
+func newStore(t *testing.T, indexes ...string) store.Store[storetest.Device] {
+	t.Helper()
+	db, err := boltdb.Open(filepath.Join(t.TempDir(), "store.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	s, err := boltdb.NewStore[storetest.Device](db, indexes...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestStore(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) store.Store[storetest.Device] {
+		return newStore(t)
+	})
+}
+
+func TestIndexedStore(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) store.Store[storetest.Device] {
+		return newStore(t, "kind", "value", "name")
+	})
+}
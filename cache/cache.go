@@ -0,0 +1,282 @@
+// Package cache caches the results of list and object lookups. Entries are
+// keyed on the model, the tenant, the policy subject and the normalized
+// request, and writes through the stores invalidate the entries of their
+// model and tenant.
+package cache
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/heypkg/store"
+	"github.com/heypkg/store/policy"
+	"github.com/heypkg/store/search"
+	"github.com/heypkg/store/tenancy"
+	"github.com/heypkg/store/utils"
+	"gorm.io/gorm"
+)
+
+// PluginName is the name of caches registered with gorm.
+const PluginName = "store:cache"
+
+// DefaultTTL is the TTL of the models of caches without one.
+const DefaultTTL = 5 * time.Second
+
+// Backend stores the entries of a cache. It is implemented by LRU, by Fake
+// and by adapters of Redis-like stores.
+type Backend interface {
+	// Get returns the value of key and whether it is found.
+	Get(ctx context.Context, key string) ([]byte, bool, error)
+	// Set stores value under key until ttl passes. Zero is no expiry.
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	Delete(ctx context.Context, key string) error
+}
+
+// ModelConfig configures the caching of a model.
+type ModelConfig struct {
+	// TTL is the lifetime of entries, the TTL of the cache when zero.
+	TTL time.Duration
+	// Disabled opts the model out of caching.
+	Disabled bool
+}
+
+// Cache caches results in a backend. Models are cached with the TTL of the
+// cache unless they are configured otherwise.
+type Cache struct {
+	Backend Backend
+	TTL     time.Duration
+	// Prefix prefixes the keys of the backend.
+	Prefix string
+
+	mu     sync.RWMutex
+	models map[string]ModelConfig
+}
+
+var _ gorm.Plugin = (*Cache)(nil)
+
+// New returns a cache in b whose entries live for ttl, DefaultTTL when it is
+// zero.
+func New(b Backend, ttl time.Duration) *Cache {
+	if ttl <= 0 {
+		ttl = DefaultTTL
+	}
+	return &Cache{Backend: b, TTL: ttl, Prefix: "store:", models: map[string]ModelConfig{}}
+}
+
+// Use registers c with db. Lists and lookups of the gorm stores of db are
+// then cached and their writes invalidate c.
+func Use(db *gorm.DB, c *Cache) error {
+	return db.Use(c)
+}
+
+// FromDB returns the cache registered with db, nil when there is none.
+func FromDB(db *gorm.DB) *Cache {
+	if p, ok := db.Config.Plugins[PluginName]; ok {
+		return p.(*Cache)
+	}
+	return nil
+}
+
+func (c *Cache) Name() string {
+	return PluginName
+}
+
+func (c *Cache) Initialize(db *gorm.DB) error {
+	return nil
+}
+
+// Model returns the model name of T in caches.
+func Model[T any]() string {
+	var obj T
+	return utils.GetRawTypeName(obj)
+}
+
+// Configure sets the config of model T in c.
+func Configure[T any](c *Cache, config ModelConfig) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.models[Model[T]()] = config
+}
+
+// ttl returns the TTL of model, zero when it is not cached.
+func (c *Cache) ttl(model string) time.Duration {
+	c.mu.RLock()
+	config, ok := c.models[model]
+	c.mu.RUnlock()
+	if !ok {
+		return c.TTL
+	}
+	if config.Disabled {
+		return 0
+	}
+	if config.TTL > 0 {
+		return config.TTL
+	}
+	return c.TTL
+}
+
+// Info tells how the result of a request was served.
+type Info struct {
+	// TTL is the lifetime of the entry, zero when the result is not cached.
+	TTL time.Duration
+	Hit bool
+	// Age is the time since the entry was stored.
+	Age time.Duration
+}
+
+type infoContextKey struct{}
+
+// WithInfo returns ctx in which the cache reports on the requests to info.
+func WithInfo(ctx context.Context) (context.Context, *Info) {
+	info := &Info{}
+	return context.WithValue(ctx, infoContextKey{}, info), info
+}
+
+// SetHeaders sets the Cache-Control and Age headers of cached results. The
+// entries are private to the tenant and the subject of the request.
+func (info *Info) SetHeaders(h http.Header) {
+	if info == nil || info.TTL <= 0 {
+		return
+	}
+	h.Set("Cache-Control", fmt.Sprintf("private, max-age=%d", int(info.TTL.Seconds())))
+	if info.Hit {
+		h.Set("Age", strconv.Itoa(int(info.Age.Seconds())))
+	}
+}
+
+type entry[V any] struct {
+	StoredAt time.Time `json:"stored_at"`
+	Value    V         `json:"value"`
+}
+
+// Load returns the cached value of key for model, or the value of fn which
+// it caches. fn is called directly when c is nil, when the model is not
+// cached, when ctx reads from the primary database or when the backend
+// fails. Values are stored as JSON, without the fields JSON leaves out.
+func Load[V any](ctx context.Context, c *Cache, model string, key string, fn func() (V, error)) (V, error) {
+	if c == nil || store.UsePrimary(ctx) {
+		return fn()
+	}
+	ttl := c.ttl(model)
+	if ttl <= 0 {
+		return fn()
+	}
+	full, err := c.key(ctx, model, key)
+	if err != nil {
+		return fn()
+	}
+	info, _ := ctx.Value(infoContextKey{}).(*Info)
+	if b, ok, err := c.Backend.Get(ctx, full); err == nil && ok {
+		var e entry[V]
+		if err := json.Unmarshal(b, &e); err == nil {
+			if info != nil {
+				*info = Info{TTL: ttl, Hit: true, Age: time.Since(e.StoredAt)}
+			}
+			return e.Value, nil
+		}
+	}
+	v, err := fn()
+	if err != nil {
+		return v, err
+	}
+	if b, err := json.Marshal(entry[V]{StoredAt: time.Now(), Value: v}); err == nil {
+		if err := c.Backend.Set(ctx, full, b, ttl); err == nil && info != nil {
+			*info = Info{TTL: ttl}
+		}
+	}
+	return v, nil
+}
+
+// Invalidate drops the entries of model in the tenant of ctx, or in every
+// tenant when ctx has none.
+func Invalidate(ctx context.Context, c *Cache, model string) error {
+	if c == nil {
+		return nil
+	}
+	tenant := tenancy.ScopeFromContext(ctx).Tenant
+	if tenant == "" {
+		return c.Backend.Delete(ctx, c.generationKey(model, ""))
+	}
+	return c.Backend.Delete(ctx, c.generationKey(model, "tenant:"+tenant))
+}
+
+// key returns the backend key of key for model in the tenant of ctx. It
+// holds the generations of the model and of the tenant, which writes drop.
+func (c *Cache) key(ctx context.Context, model string, key string) (string, error) {
+	tenant := tenancy.ScopeFromContext(ctx).Tenant
+	modelGen, err := c.generation(ctx, c.generationKey(model, ""))
+	if err != nil {
+		return "", err
+	}
+	tenantGen, err := c.generation(ctx, c.generationKey(model, "tenant:"+tenant))
+	if err != nil {
+		return "", err
+	}
+	subject := policy.SubjectFromContext(ctx)
+	groups := append([]string(nil), subject.Groups...)
+	sort.Strings(groups)
+	sum := sha256.Sum256([]byte(strings.Join([]string{tenant, subject.ID, strings.Join(groups, ","), key}, "\x00")))
+	return fmt.Sprintf("%sentry:%s:%s:%s:%s", c.Prefix, model, modelGen, tenantGen, hex.EncodeToString(sum[:])), nil
+}
+
+func (c *Cache) generationKey(model string, scope string) string {
+	return fmt.Sprintf("%sgen:%s:%s", c.Prefix, model, scope)
+}
+
+// generation returns the generation stored under key, starting a new one
+// when there is none. A new generation is unique, so that the entries of
+// dropped generations are never read again.
+func (c *Cache) generation(ctx context.Context, key string) (string, error) {
+	b, ok, err := c.Backend.Get(ctx, key)
+	if err != nil {
+		return "", err
+	}
+	if ok {
+		return string(b), nil
+	}
+	gen := strconv.FormatInt(time.Now().UnixNano(), 36)
+	if err := c.Backend.Set(ctx, key, []byte(gen), 0); err != nil {
+		return "", err
+	}
+	return gen, nil
+}
+
+// ListKey returns the key of req: its normalized search, order, page,
+// fields and includes. The search handlers of req are not part of the key,
+// so requests with handlers must not be cached.
+func ListKey(req store.ListRequest) string {
+	return strings.Join([]string{
+		"list",
+		search.NormalizeSearchString(req.Search),
+		req.OrderBy,
+		strconv.Itoa(req.Page),
+		strconv.Itoa(req.PageSize),
+		strings.Join(req.Select, ","),
+		req.Preload,
+		req.Include,
+		strconv.FormatBool(req.Deleted),
+	}, "\x00")
+}
+
+// GetKey returns the key of req: its conditions and includes.
+func GetKey(req store.GetRequest) string {
+	names := make([]string, 0, len(req.Conditions))
+	for name := range req.Conditions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	parts := []string{"get", req.Preload, req.Include, strconv.FormatBool(req.Deleted)}
+	for _, name := range names {
+		parts = append(parts, fmt.Sprintf("%s=%v", name, req.Conditions[name]))
+	}
+	return strings.Join(parts, "\x00")
+}
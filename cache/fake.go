@@ -0,0 +1,92 @@
+package cache
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Fake is an in-memory backend behaving like a Redis-like store: values are
+// copied, keys expire and nothing is evicted. It stands in for such stores in
+// tests.
+type Fake struct {
+	mu      sync.Mutex
+	values  map[string][]byte
+	expires map[string]time.Time
+	offset  time.Duration
+}
+
+var _ Backend = (*Fake)(nil)
+
+func NewFake() *Fake {
+	return &Fake{values: map[string][]byte{}, expires: map[string]time.Time{}}
+}
+
+func (f *Fake) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !f.live(key) {
+		return nil, false, nil
+	}
+	return append([]byte(nil), f.values[key]...), true, nil
+}
+
+func (f *Fake) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.values[key] = append([]byte(nil), value...)
+	if ttl > 0 {
+		f.expires[key] = f.now().Add(ttl)
+	} else {
+		delete(f.expires, key)
+	}
+	return nil
+}
+
+func (f *Fake) Delete(ctx context.Context, key string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.values, key)
+	delete(f.expires, key)
+	return nil
+}
+
+// Keys returns the sorted keys that have not expired.
+func (f *Fake) Keys() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	keys := []string{}
+	for key := range f.values {
+		if f.live(key) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Advance moves the clock of f by d, expiring keys as time would.
+func (f *Fake) Advance(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.offset += d
+}
+
+func (f *Fake) now() time.Time {
+	return time.Now().Add(f.offset)
+}
+
+// live reports whether key is set and has not expired, dropping it when it
+// has.
+func (f *Fake) live(key string) bool {
+	if _, ok := f.values[key]; !ok {
+		return false
+	}
+	if t, ok := f.expires[key]; ok && !f.now().Before(t) {
+		delete(f.values, key)
+		delete(f.expires, key)
+		return false
+	}
+	return true
+}
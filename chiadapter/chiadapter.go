@@ -0,0 +1,19 @@
+// Package chiadapter serves stores from chi routers. Path parameters are
+// read with chi.URLParam; everything else is the net/http adapter.
+package chiadapter
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/heypkg/store"
+	"github.com/heypkg/store/nethttp"
+)
+
+func URLParam(r *http.Request, name string) string {
+	return chi.URLParam(r, name)
+}
+
+func New[T any](s store.Store[T]) *nethttp.Handler[T] {
+	return nethttp.New[T](s, URLParam)
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// config holds the settings shared by the commands. Each setting is read
+// from its flag, then from its HEYSTORE_ environment variable, then from the
+// JSON config file, whose keys are the flag names.
+type config struct {
+	Driver       string `json:"driver"`
+	DSN          string `json:"dsn"`
+	Schema       string `json:"schema"`
+	TenantColumn string `json:"tenant-column"`
+	Format       string `json:"format"`
+	Migrations   string `json:"migrations"`
+}
+
+type setting struct {
+	name  string
+	usage string
+	field func(c *config) *string
+}
+
+var settings = []setting{
+	{"driver", "database driver: postgres or mysql", func(c *config) *string { return &c.Driver }},
+	{"dsn", "database connection string", func(c *config) *string { return &c.DSN }},
+	{"schema", "tenant of the rows, all tenants when empty", func(c *config) *string { return &c.Schema }},
+	{"tenant-column", "column holding the tenant of rows", func(c *config) *string { return &c.TenantColumn }},
+	{"format", "output format: table, csv or json", func(c *config) *string { return &c.Format }},
+	{"migrations", "directory of the SQL migrations", func(c *config) *string { return &c.Migrations }},
+}
+
+func defaultConfig() config {
+	return config{Driver: "postgres", TenantColumn: "schema", Format: "table", Migrations: "migrations"}
+}
+
+// addSettings registers the -config flag and the flags of the settings on
+// fs, recording the values given in set.
+func addSettings(fs *flag.FlagSet, set map[string]string) {
+	fs.Func("config", "JSON config file (env HEYSTORE_CONFIG)", func(v string) error {
+		set["config"] = v
+		return nil
+	})
+	for _, s := range settings {
+		name := s.name
+		fs.Func(name, s.usage+" (env "+envName(name)+")", func(v string) error {
+			set[name] = v
+			return nil
+		})
+	}
+}
+
+func envName(name string) string {
+	return "HEYSTORE_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// loadConfig resolves the settings from the flags in set, the environment
+// and the config file.
+func loadConfig(set map[string]string) (config, error) {
+	c := defaultConfig()
+	path, ok := set["config"]
+	if !ok {
+		path = os.Getenv(envName("config"))
+	}
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return c, errors.Wrap(err, "read config")
+		}
+		if err := json.Unmarshal(data, &c); err != nil {
+			return c, errors.Wrapf(err, "parse config %v", path)
+		}
+	}
+	for _, s := range settings {
+		if v, ok := os.LookupEnv(envName(s.name)); ok {
+			*s.field(&c) = v
+		}
+		if v, ok := set[s.name]; ok {
+			*s.field(&c) = v
+		}
+	}
+	switch c.Format {
+	case "table", "csv", "json":
+	default:
+		return c, errors.Errorf("unknown format %q", c.Format)
+	}
+	return c, nil
+}
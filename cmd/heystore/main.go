@@ -0,0 +1,182 @@
+// Command heystore administers the databases of the stores: it runs
+// migrations, manages TimescaleDB hypertables and views, lists and purges
+// soft-deleted rows, searches tables and runs time series queries.
+//
+// Usage:
+//
+//	heystore [settings] <command> [<subcommand>] [flags]
+//
+// Settings, such as -dsn, are accepted before and after the command.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+
+	"github.com/heypkg/store/telemetry"
+	"github.com/heypkg/store/tenancy"
+	"github.com/pkg/errors"
+	"gorm.io/driver/mysql"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+type command struct {
+	usage string
+	run   func(ctx context.Context, a *app, args []string) error
+}
+
+var commands = map[string]command{
+	"migrate":    {"migrate up|down|status|unlock: apply the SQL migrations", runMigrate},
+	"hypertable": {"hypertable create|retention|list: manage hypertables", runHyperTable},
+	"view":       {"view count|avg|drop|list: manage continuous aggregate views", runView},
+	"deleted":    {"deleted list|purge: list or purge soft-deleted rows", runDeleted},
+	"search":     {"search -table t -q q: list the rows of a table matching a search", runSearch},
+	"tsquery":    {"tsquery -file f: run a time series query command", runTSQuery},
+	"parse":      {"parse -q q: validate a search and dump its parse tree", runParse},
+}
+
+// app is the state of a run: the settings given as flags and the database.
+type app struct {
+	out io.Writer
+	set map[string]string
+	cfg config
+	db  *gorm.DB
+}
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	if err := run(ctx, os.Stdout, os.Args[1:]); err != nil {
+		if !errors.Is(err, flag.ErrHelp) {
+			fmt.Fprintln(os.Stderr, "heystore:", err)
+		}
+		os.Exit(1)
+	}
+}
+
+func run(ctx context.Context, out io.Writer, args []string) error {
+	a := &app{out: out, set: map[string]string{}}
+	fs := a.flagSet("heystore")
+	fs.Usage = usage(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return flag.ErrHelp
+	}
+	cmd, ok := commands[fs.Arg(0)]
+	if !ok {
+		fs.Usage()
+		return errors.Errorf("unknown command %q", fs.Arg(0))
+	}
+	defer a.close()
+	return cmd.run(ctx, a, fs.Args()[1:])
+}
+
+func usage(fs *flag.FlagSet) func() {
+	return func() {
+		w := fs.Output()
+		fmt.Fprintln(w, "usage: heystore [settings] <command> [flags]")
+		fmt.Fprintln(w, "\ncommands:")
+		names := []string{}
+		for name := range commands {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Fprintln(w, "  "+commands[name].usage)
+		}
+		fmt.Fprintln(w, "\nsettings:")
+		fs.PrintDefaults()
+	}
+}
+
+// flagSet returns the flags of a command, with the settings.
+func (a *app) flagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	addSettings(fs, a.set)
+	return fs
+}
+
+// parse parses the flags of a command and resolves the settings.
+func (a *app) parse(fs *flag.FlagSet, args []string) error {
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return errors.Errorf("unexpected arguments %v", strings.Join(fs.Args(), " "))
+	}
+	cfg, err := loadConfig(a.set)
+	if err != nil {
+		return err
+	}
+	a.cfg = cfg
+	return nil
+}
+
+// subcommand returns the subcommand of args and its flags.
+func subcommand(args []string, names ...string) (string, []string, error) {
+	if len(args) == 0 {
+		return "", nil, errors.Errorf("missing subcommand: %v", strings.Join(names, ", "))
+	}
+	for _, name := range names {
+		if args[0] == name {
+			return name, args[1:], nil
+		}
+	}
+	return "", nil, errors.Errorf("unknown subcommand %q: %v", args[0], strings.Join(names, ", "))
+}
+
+// open connects to the database of the settings.
+func (a *app) open() (*gorm.DB, error) {
+	if a.db != nil {
+		return a.db, nil
+	}
+	if a.cfg.DSN == "" {
+		return nil, errors.New("missing -dsn")
+	}
+	var dialector gorm.Dialector
+	switch a.cfg.Driver {
+	case "postgres":
+		dialector = postgres.Open(a.cfg.DSN)
+	case "mysql":
+		dialector = mysql.Open(a.cfg.DSN)
+	default:
+		return nil, errors.Errorf("unknown driver %q", a.cfg.Driver)
+	}
+	db, err := gorm.Open(dialector, &gorm.Config{
+		Logger: telemetry.NewLogger(slog.New(slog.NewTextHandler(os.Stderr, nil))),
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "open database")
+	}
+	a.db = db
+	return db, nil
+}
+
+func (a *app) close() {
+	if a.db == nil {
+		return
+	}
+	if sqlDB, err := a.db.DB(); err == nil {
+		sqlDB.Close()
+	}
+}
+
+// tenant returns the tenancy scope of the settings. Without a tenant, the
+// rows of all tenants are selected.
+func (a *app) tenant() tenancy.Scope {
+	if a.cfg.Schema == "" {
+		return tenancy.NewScope(tenancy.NewColumnStrategy("", ""), "")
+	}
+	return tenancy.NewScope(tenancy.NewColumnStrategy(a.cfg.TenantColumn, a.cfg.TenantColumn), a.cfg.Schema)
+}
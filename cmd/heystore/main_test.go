@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+type device struct {
+	ID      uint `gorm:"primarykey"`
+	Schema  string
+	Name    string
+	Kind    string
+	Deleted *time.Time
+}
+
+func newApp(t *testing.T) (*app, *bytes.Buffer) {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(t.TempDir()+"/heystore.db"), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&device{}); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-48 * time.Hour)
+	recent := time.Now().Add(-time.Minute)
+	devices := []device{
+		{Schema: "acme", Name: "alpha", Kind: "sensor"},
+		{Schema: "acme", Name: "beta", Kind: "sensor", Deleted: &old},
+		{Schema: "acme", Name: "gamma", Kind: "gateway", Deleted: &recent},
+		{Schema: "other", Name: "delta", Kind: "sensor", Deleted: &old},
+	}
+	if err := db.Create(&devices).Error; err != nil {
+		t.Fatal(err)
+	}
+	out := &bytes.Buffer{}
+	return &app{out: out, set: map[string]string{}, db: db}, out
+}
+
+func TestDeletedPurgeDryRun(t *testing.T) {
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"purge", "-table", "devices", "-dry-run"}, "3 rows would be purged from devices\n"},
+		{[]string{"purge", "-table", "devices", "-dry-run", "-older-than", "24h"}, "2 rows would be purged from devices\n"},
+		{[]string{"purge", "-table", "devices", "-dry-run", "-schema", "acme"}, "2 rows would be purged from devices\n"},
+	}
+	for _, tt := range tests {
+		a, out := newApp(t)
+		if err := runDeleted(context.Background(), a, tt.args); err != nil {
+			t.Fatalf("%v: %v", tt.args, err)
+		}
+		if out.String() != tt.want {
+			t.Errorf("%v = %q, want %q", tt.args, out.String(), tt.want)
+		}
+		var n int64
+		a.db.Table("devices").Count(&n)
+		if n != 4 {
+			t.Errorf("%v left %v rows, want 4", tt.args, n)
+		}
+	}
+
+	a, out := newApp(t)
+	if err := runDeleted(context.Background(), a, []string{"purge", "-table", "devices", "-older-than", "24h"}); err != nil {
+		t.Fatal(err)
+	}
+	if want := "2 rows purged from devices\n"; out.String() != want {
+		t.Errorf("purge = %q, want %q", out.String(), want)
+	}
+}
+
+func TestSearch(t *testing.T) {
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{
+			[]string{"-table", "devices", "-q", "kind:sensor", "-order", "name", "-format", "csv"},
+			"deleted,id,kind,name,schema\n" +
+				",1,sensor,alpha,acme\n" +
+				"2006-01-02T00:00:00Z,2,sensor,beta,acme\n" +
+				"2006-01-02T00:00:00Z,4,sensor,delta,other\n",
+		},
+		{
+			[]string{"-table", "devices", "-q", "kind:sensor", "-order", "name", "-schema", "acme", "-page-size", "1"},
+			"DELETED  ID  KIND    NAME   SCHEMA\n" +
+				"         1   sensor  alpha  acme\n" +
+				"1 of 2 rows\n",
+		},
+	}
+	for _, tt := range tests {
+		a, out := newApp(t)
+		// soft-deleted rows are listed too, give them a stable time
+		a.db.Table("devices").Where("deleted IS NOT NULL").Update("deleted", time.Date(2006, 1, 2, 0, 0, 0, 0, time.UTC))
+		if err := runSearch(context.Background(), a, tt.args); err != nil {
+			t.Fatalf("%v: %v", tt.args, err)
+		}
+		if out.String() != tt.want {
+			t.Errorf("%v =\n%s\nwant\n%s", tt.args, out.String(), tt.want)
+		}
+	}
+
+	a, _ := newApp(t)
+	if err := runSearch(context.Background(), a, []string{"-q", "kind:sensor"}); err == nil || !strings.Contains(err.Error(), "missing -table") {
+		t.Errorf("search without table = %v", err)
+	}
+}
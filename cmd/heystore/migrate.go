@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"os"
+	"strings"
+
+	"github.com/heypkg/store/migrate"
+)
+
+func runMigrate(ctx context.Context, a *app, args []string) error {
+	sub, args, err := subcommand(args, "up", "down", "status", "unlock")
+	if err != nil {
+		return err
+	}
+	fs := a.flagSet("migrate " + sub)
+	to := fs.Int64("to", 0, "apply the migrations up to this version (up)")
+	steps := fs.Int("steps", 1, "number of migrations to roll back (down)")
+	dryRun := fs.Bool("dry-run", false, "print the statements instead of executing them")
+	if err := a.parse(fs, args); err != nil {
+		return err
+	}
+	db, err := a.open()
+	if err != nil {
+		return err
+	}
+	migrations, err := migrate.LoadSQL(os.DirFS(a.cfg.Migrations), ".")
+	if err != nil {
+		return err
+	}
+	m, err := migrate.New(db, migrations...)
+	if err != nil {
+		return err
+	}
+	m.DryRun = *dryRun
+
+	var done []migrate.Step
+	switch sub {
+	case "status":
+		states, err := m.Status(ctx)
+		if err != nil {
+			return err
+		}
+		rows := []map[string]any{}
+		for _, s := range states {
+			row := map[string]any{"version": s.Version, "name": s.Name, "applied": s.Applied, "drifted": s.Drifted, "missing": s.Missing}
+			if s.Applied {
+				row["applied_at"] = s.AppliedAt
+			}
+			rows = append(rows, row)
+		}
+		return a.writeRows([]string{"version", "name", "applied", "applied_at", "drifted", "missing"}, rows)
+	case "unlock":
+		return m.Unlock(ctx)
+	case "up":
+		done, err = m.UpTo(ctx, *to)
+	case "down":
+		done, err = m.Down(ctx, *steps)
+	}
+	rows := []map[string]any{}
+	for _, s := range done {
+		direction := "up"
+		if !s.Up {
+			direction = "down"
+		}
+		rows = append(rows, map[string]any{
+			"version": s.Version, "name": s.Name, "direction": direction,
+			"duration": s.Duration, "sql": strings.Join(s.SQL, "\n"),
+		})
+	}
+	columns := []string{"version", "name", "direction", "duration"}
+	if *dryRun {
+		columns = []string{"version", "name", "direction", "sql"}
+	}
+	if werr := a.writeRows(columns, rows); err == nil {
+		err = werr
+	}
+	return err
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// writeRows writes rows in the format of the settings. The columns are in
+// the given order, or sorted from the rows when columns is nil.
+func (a *app) writeRows(columns []string, rows []map[string]any) error {
+	if columns == nil {
+		columns = columnsOf(rows)
+	}
+	switch a.cfg.Format {
+	case "json":
+		out := make([]map[string]any, len(rows))
+		for i, row := range rows {
+			out[i] = map[string]any{}
+			for column, v := range row {
+				if b, ok := v.([]byte); ok {
+					v = string(b)
+				}
+				out[i][column] = v
+			}
+		}
+		return a.writeJSON(out)
+	case "csv":
+		w := csv.NewWriter(a.out)
+		w.Write(columns)
+		for _, row := range rows {
+			record := make([]string, len(columns))
+			for i, column := range columns {
+				record[i] = text(row[column])
+			}
+			w.Write(record)
+		}
+		w.Flush()
+		return w.Error()
+	}
+	w := tabwriter.NewWriter(a.out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, strings.ToUpper(strings.Join(columns, "\t")))
+	for _, row := range rows {
+		values := make([]string, len(columns))
+		for i, column := range columns {
+			values[i] = strings.ReplaceAll(text(row[column]), "\n", " ")
+		}
+		fmt.Fprintln(w, strings.Join(values, "\t"))
+	}
+	return w.Flush()
+}
+
+func (a *app) writeJSON(v any) error {
+	enc := json.NewEncoder(a.out)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+func columnsOf(rows []map[string]any) []string {
+	seen := map[string]bool{}
+	columns := []string{}
+	for _, row := range rows {
+		for column := range row {
+			if !seen[column] {
+				seen[column] = true
+				columns = append(columns, column)
+			}
+		}
+	}
+	sort.Strings(columns)
+	return columns
+}
+
+func text(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case []byte:
+		return string(v)
+	case time.Time:
+		return v.Format(time.RFC3339)
+	case string:
+		return v
+	}
+	return fmt.Sprint(v)
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/heypkg/store/search"
+	"github.com/pkg/errors"
+)
+
+// node is a term of a parsed search: a column with the values it matches,
+// or with the terms of a nested search.
+type node struct {
+	Name   string      `json:"name"`
+	Values []valueNode `json:"values,omitempty"`
+	Terms  []node      `json:"terms,omitempty"`
+}
+
+type valueNode struct {
+	Symbol string `json:"symbol"`
+	Value  any    `json:"value"`
+	Value2 any    `json:"value2,omitempty"`
+}
+
+func runParse(ctx context.Context, a *app, args []string) error {
+	fs := a.flagSet("parse")
+	q := fs.String("q", "", "search string")
+	if err := a.parse(fs, args); err != nil {
+		return err
+	}
+	data, err := search.ParseSearchString(*q)
+	if err != nil {
+		return errors.Wrapf(err, "invalid search %q", *q)
+	}
+	tree := parseTree(data)
+	if a.cfg.Format == "json" {
+		return a.writeJSON(map[string]any{
+			"search":     *q,
+			"normalized": search.NormalizeSearchString(*q),
+			"terms":      tree,
+		})
+	}
+	fmt.Fprintf(a.out, "search: %s\n", search.NormalizeSearchString(*q))
+	printTree(a, tree, 0)
+	return nil
+}
+
+func parseTree(data search.SearchData) []node {
+	names := []string{}
+	for name := range data {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	nodes := []node{}
+	for _, name := range names {
+		n := node{Name: name}
+		for _, v := range data[name] {
+			if sub, ok := v.Value.(search.SearchData); ok && v.Symbol == search.SearchSymbolSearch {
+				n.Terms = append(n.Terms, parseTree(sub)...)
+				continue
+			}
+			symbol := string(v.Symbol)
+			if symbol == "" {
+				symbol = "="
+			}
+			n.Values = append(n.Values, valueNode{Symbol: symbol, Value: v.Value, Value2: v.Value2})
+		}
+		nodes = append(nodes, n)
+	}
+	return nodes
+}
+
+func printTree(a *app, nodes []node, depth int) {
+	indent := strings.Repeat("  ", depth)
+	for _, n := range nodes {
+		fmt.Fprintf(a.out, "%s%s\n", indent, n.Name)
+		for _, v := range n.Values {
+			if v.Symbol == string(search.SearchSymbolRange) {
+				fmt.Fprintf(a.out, "%s  %v .. %v\n", indent, v.Value, v.Value2)
+				continue
+			}
+			fmt.Fprintf(a.out, "%s  %s %v (%T)\n", indent, v.Symbol, v.Value, v.Value)
+		}
+		printTree(a, n.Terms, depth+1)
+	}
+}
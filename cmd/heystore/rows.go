@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/heypkg/store"
+	gormdb "github.com/heypkg/store/gorm"
+	"github.com/heypkg/store/tenancy"
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+)
+
+func runDeleted(ctx context.Context, a *app, args []string) error {
+	sub, args, err := subcommand(args, "list", "purge")
+	if err != nil {
+		return err
+	}
+	fs := a.flagSet("deleted " + sub)
+	table := fs.String("table", "", "table of the rows")
+	column := fs.String("column", "deleted", "soft delete column")
+	olderThan := fs.Duration("older-than", 0, "select the rows deleted at least this long ago")
+	limit := fs.Int("limit", 100, "maximum number of rows listed (list)")
+	dryRun := fs.Bool("dry-run", false, "count the rows instead of purging them (purge)")
+	if err := a.parse(fs, args); err != nil {
+		return err
+	}
+	if *table == "" {
+		return errors.New("missing -table")
+	}
+	db, err := a.open()
+	if err != nil {
+		return err
+	}
+	scope := a.tenant()
+	return scope.Run(db.WithContext(ctx), func(db *gorm.DB) error {
+		deleted := func() *gorm.DB {
+			tx := scope.Apply(db.Table(*table)).Where(db.Statement.Quote(*column) + " IS NOT NULL")
+			if *olderThan > 0 {
+				tx = tx.Where(db.Statement.Quote(*column)+" < ?", time.Now().Add(-*olderThan))
+			}
+			return tx
+		}
+		switch {
+		case sub == "list":
+			rows := []map[string]any{}
+			if err := deleted().Order(db.Statement.Quote(*column) + " DESC").Limit(*limit).Find(&rows).Error; err != nil {
+				return err
+			}
+			return a.writeRows(nil, rows)
+		case *dryRun:
+			var n int64
+			if err := deleted().Count(&n).Error; err != nil {
+				return err
+			}
+			fmt.Fprintf(a.out, "%v rows would be purged from %v\n", n, *table)
+			return nil
+		}
+		result := deleted().Delete(nil)
+		if result.Error != nil {
+			return result.Error
+		}
+		fmt.Fprintf(a.out, "%v rows purged from %v\n", result.RowsAffected, *table)
+		return nil
+	})
+}
+
+func runSearch(ctx context.Context, a *app, args []string) error {
+	fs := a.flagSet("search")
+	table := fs.String("table", "", "table of the rows")
+	q := fs.String("q", "", "search string")
+	order := fs.String("order", "", "comma-separated columns, suffixed with - for descending order")
+	page := fs.Int("page", 1, "page of the rows")
+	pageSize := fs.Int("page-size", 100, "rows per page")
+	timeout := fs.Duration("timeout", 0, "timeout of the query")
+	if err := a.parse(fs, args); err != nil {
+		return err
+	}
+	if *table == "" {
+		return errors.New("missing -table")
+	}
+	db, err := a.open()
+	if err != nil {
+		return err
+	}
+	ctx = tenancy.WithScope(ctx, a.tenant())
+	rows, total, err := gormdb.ListAny(db, ctx, *table, store.ListRequest{
+		Search:   *q,
+		OrderBy:  *order,
+		Page:     *page,
+		PageSize: *pageSize,
+		Timeout:  *timeout,
+	})
+	if err != nil {
+		return err
+	}
+	if err := a.writeRows(nil, rows); err != nil {
+		return err
+	}
+	if a.cfg.Format == "table" {
+		fmt.Fprintf(a.out, "%v of %v rows\n", len(rows), total)
+	}
+	return nil
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/heypkg/store/tenancy"
+	"github.com/heypkg/store/tsdb"
+	"github.com/pkg/errors"
+)
+
+func runHyperTable(ctx context.Context, a *app, args []string) error {
+	sub, args, err := subcommand(args, "create", "retention", "list")
+	if err != nil {
+		return err
+	}
+	fs := a.flagSet("hypertable " + sub)
+	table := fs.String("table", "", "table of the hypertable")
+	retention := fs.Duration("retention", 0, "retention period of the data, forever when zero")
+	if err := a.parse(fs, args); err != nil {
+		return err
+	}
+	db, err := a.open()
+	if err != nil {
+		return err
+	}
+	db = db.WithContext(ctx)
+	if sub == "list" {
+		return a.query(ctx, `SELECT hypertable_schema, hypertable_name, num_dimensions, num_chunks, compression_enabled
+ FROM timescaledb_information.hypertables ORDER BY hypertable_schema, hypertable_name`)
+	}
+	if *table == "" {
+		return errors.New("missing -table")
+	}
+	if sub == "create" {
+		return tsdb.CreateHyperTable(db, *table, *retention)
+	}
+	return tsdb.SetDataRetentionPolicyForHyperTalbe(db, *table, *retention)
+}
+
+func runView(ctx context.Context, a *app, args []string) error {
+	sub, args, err := subcommand(args, "count", "avg", "drop", "list")
+	if err != nil {
+		return err
+	}
+	fs := a.flagSet("view " + sub)
+	table := fs.String("table", "", "hypertable of the view")
+	view := fs.String("view", "", "name of the view")
+	bucket := fs.String("bucket", "1h", "time bucket of the view, such as 5m, 1h or 1d")
+	index := fs.String("index", "", "comma-separated columns grouping the rows")
+	nameColumn := fs.String("name-column", "name", "column of the value names (avg)")
+	valueColumn := fs.String("value-column", "value", "column of the values (avg)")
+	names := fs.String("names", "", "comma-separated value names to average (avg)")
+	where := fs.String("where", "", "condition of the averaged rows (avg)")
+	if err := a.parse(fs, args); err != nil {
+		return err
+	}
+	db, err := a.open()
+	if err != nil {
+		return err
+	}
+	db = db.WithContext(ctx)
+	switch sub {
+	case "list":
+		return a.query(ctx, `SELECT view_schema, view_name, hypertable_name, materialized_only
+ FROM timescaledb_information.continuous_aggregates ORDER BY view_schema, view_name`)
+	case "drop":
+		if *view == "" {
+			return errors.New("missing -view")
+		}
+		return tsdb.DropHyperTableView(db, *view)
+	}
+	if *table == "" || *view == "" || *index == "" {
+		return errors.New("missing -table, -view or -index")
+	}
+	if sub == "count" {
+		return tsdb.CreateHyperTableCountView(db, *table, *view, *bucket, split(*index))
+	}
+	if *names == "" {
+		return errors.New("missing -names")
+	}
+	return tsdb.CreateHyperTableAvgValuesView(db, *table, *view, *bucket, *nameColumn, *valueColumn, split(*index), split(*names), *where)
+}
+
+func runTSQuery(ctx context.Context, a *app, args []string) error {
+	fs := a.flagSet("tsquery")
+	file := fs.String("file", "", "JSON file of the query command, - for stdin")
+	timeout := fs.Duration("timeout", 0, "timeout of the query")
+	if err := a.parse(fs, args); err != nil {
+		return err
+	}
+	if *file == "" {
+		return errors.New("missing -file")
+	}
+	var data []byte
+	var err error
+	if *file == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(*file)
+	}
+	if err != nil {
+		return errors.Wrap(err, "read query")
+	}
+	var cmd tsdb.TSQueryCommand
+	if err := json.Unmarshal(data, &cmd); err != nil {
+		return errors.Wrap(err, "parse query")
+	}
+	// the tenant is the one of ctx, with the tenant column of the settings
+	cmd.Schema = ""
+	if *timeout > 0 {
+		cmd.Timeout = timeout.Seconds()
+	}
+	db, err := a.open()
+	if err != nil {
+		return err
+	}
+	ctx = tenancy.WithScope(ctx, a.tenant())
+	result, err := tsdb.HandleTSQueryCommandContext(ctx, db, cmd)
+	if err != nil {
+		return err
+	}
+	return a.writeJSON(result)
+}
+
+// query writes the rows of a raw query.
+func (a *app) query(ctx context.Context, q string, args ...any) error {
+	db, err := a.open()
+	if err != nil {
+		return err
+	}
+	rows := []map[string]any{}
+	if err := db.WithContext(ctx).Raw(q, args...).Scan(&rows).Error; err != nil {
+		return err
+	}
+	return a.writeRows(nil, rows)
+}
+
+func split(s string) []string {
+	parts := []string{}
+	for _, part := range strings.Split(s, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			parts = append(parts, part)
+		}
+	}
+	return parts
+}
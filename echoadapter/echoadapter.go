@@ -0,0 +1,71 @@
+// Package echoadapter serves stores from echo handlers. Request values such
+// as the tenant, "actor" and "preload" are read from the echo context, which
+// implements store.Values.
+package echoadapter
+
+import (
+	"context"
+
+	"github.com/heypkg/store"
+	"github.com/heypkg/store/policy"
+	"github.com/heypkg/store/tenancy"
+	"github.com/labstack/echo/v4"
+)
+
+// Middleware makes s the tenancy strategy of every request handled by next.
+func Middleware(s tenancy.Strategy) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			c.Set(tenancy.StrategyContextKey, s)
+			return next(c)
+		}
+	}
+}
+
+func Strategy(c echo.Context) tenancy.Strategy {
+	return tenancy.StrategyFromValues(c)
+}
+
+func Scope(c echo.Context) (tenancy.Scope, error) {
+	return tenancy.ScopeFromValues(c)
+}
+
+func Subject(c echo.Context) policy.Subject {
+	return policy.SubjectFromValues(c)
+}
+
+// Context returns the request context carrying the tenant, the policy
+// subject and the audit actor of c. See store.RequestContext for the reads
+// from the primary database.
+func Context(c echo.Context) (context.Context, error) {
+	return store.RequestContext(c.Request(), c)
+}
+
+func ListRequest(c echo.Context) store.ListRequest {
+	return store.ParseListRequest(c.QueryParams(), c)
+}
+
+// GetRequest selects the object of the id path parameter.
+func GetRequest(c echo.Context) store.GetRequest {
+	return store.ParseGetRequest(c.Param("id"), c.QueryParams(), c)
+}
+
+// DeletedGetRequest selects the soft-deleted object of the id path
+// parameter.
+func DeletedGetRequest(c echo.Context) store.GetRequest {
+	return store.ParseDeletedGetRequest(c.Param("id"))
+}
+
+// TSGetRequest selects the object of the ts path parameter, a time in
+// microseconds.
+func TSGetRequest(c echo.Context) store.GetRequest {
+	return store.ParseTSGetRequest(c.Param("ts"))
+}
+
+func AggregateRequest(c echo.Context) (store.AggregateRequest, error) {
+	return store.ParseAggregateRequest(c.QueryParams())
+}
+
+func SuggestRequest(c echo.Context) store.SuggestRequest {
+	return store.ParseSuggestRequest(c.QueryParams())
+}
@@ -0,0 +1,36 @@
+package echoadapter_test
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/heypkg/store/echoadapter"
+	"github.com/heypkg/store/tenancy"
+	"github.com/labstack/echo/v4"
+)
+
+func TestMiddleware(t *testing.T) {
+	s := tenancy.NewColumnStrategy("org", "org")
+	c := echo.New().NewContext(httptest.NewRequest("GET", "/?q=kind:a&page=2", nil), httptest.NewRecorder())
+	c.Set("org", "a")
+	c.Set("actor", "u1")
+	err := echoadapter.Middleware(s)(func(c echo.Context) error {
+		if scope, err := echoadapter.Scope(c); err != nil || scope.Strategy != s || scope.Tenant != "a" {
+			t.Errorf("scope: %+v, %v", scope, err)
+		}
+		if subject := echoadapter.Subject(c); subject.ID != "u1" {
+			t.Errorf("subject: %+v", subject)
+		}
+		ctx, err := echoadapter.Context(c)
+		if err != nil || tenancy.ScopeFromContext(ctx).Tenant != "a" {
+			t.Errorf("context: %v", err)
+		}
+		if req := echoadapter.ListRequest(c); req.Search != "kind:a" || req.Page != 2 {
+			t.Errorf("list request: %+v", req)
+		}
+		return nil
+	})(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
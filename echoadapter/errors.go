@@ -0,0 +1,69 @@
+package echoadapter
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/heypkg/store"
+	"github.com/labstack/echo/v4"
+)
+
+// Error returns the store error of err. Errors of echo, such as those of
+// its router and binder, get the kind of their status.
+func Error(err error) *store.Error {
+	var e *store.Error
+	if errors.As(err, &e) {
+		return store.ErrorOf(e)
+	}
+	var he *echo.HTTPError
+	if errors.As(err, &he) {
+		message := http.StatusText(he.Code)
+		switch m := he.Message.(type) {
+		case string:
+			message = m
+		case error:
+			if he.Code < http.StatusInternalServerError {
+				message = m.Error()
+			}
+		}
+		return store.NewError(store.ErrorOfStatus(he.Code), message, he)
+	}
+	return store.ErrorOf(err)
+}
+
+// HTTPError returns err as an echo.HTTPError served with the status and the
+// message of its store error, so that echo serves store errors with any
+// error handler. errors.Is and errors.As see the store error through it.
+func HTTPError(err error) error {
+	if err == nil {
+		return nil
+	}
+	var he *echo.HTTPError
+	if errors.As(err, &he) {
+		return he
+	}
+	e := store.ErrorOf(err)
+	return &echo.HTTPError{Code: e.Status, Message: e.Message, Internal: e}
+}
+
+// ProblemErrorHandler is an echo.HTTPErrorHandler serving errors as
+// application/problem+json. Server errors are logged with their cause.
+func ProblemErrorHandler(err error, c echo.Context) {
+	if c.Response().Committed {
+		return
+	}
+	p := store.NewProblem(Error(err))
+	p.Instance = c.Request().URL.Path
+	if p.Status >= http.StatusInternalServerError {
+		c.Logger().Error(err)
+	}
+	c.Response().Header().Set(echo.HeaderContentType, store.MIMEProblemJSON)
+	if c.Request().Method == http.MethodHead {
+		err = c.NoContent(p.Status)
+	} else {
+		err = c.JSON(p.Status, p)
+	}
+	if err != nil {
+		c.Logger().Error(err)
+	}
+}
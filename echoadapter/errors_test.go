@@ -0,0 +1,57 @@
+package echoadapter_test
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/heypkg/store"
+	"github.com/heypkg/store/echoadapter"
+	"github.com/labstack/echo/v4"
+)
+
+func TestHTTPError(t *testing.T) {
+	if echoadapter.HTTPError(nil) != nil {
+		t.Error("error of nil")
+	}
+	err := echoadapter.HTTPError(store.NotFound("device not found"))
+	var he *echo.HTTPError
+	if !errors.As(err, &he) || he.Code != http.StatusNotFound || he.Message != "device not found" {
+		t.Fatalf("http error: %v", err)
+	}
+	if !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("kind of %v", err)
+	}
+	if e := echoadapter.Error(echo.ErrMethodNotAllowed); e.Status != http.StatusMethodNotAllowed || e.Code != "method_not_allowed" {
+		t.Errorf("error of echo: %+v", e)
+	}
+}
+
+func TestProblemErrorHandler(t *testing.T) {
+	e := echo.New()
+	e.HTTPErrorHandler = echoadapter.ProblemErrorHandler
+	e.GET("/devices/:id", func(c echo.Context) error {
+		return store.NotFound("device not found")
+	})
+	tests := []struct {
+		path   string
+		status int
+		code   string
+	}{
+		{"/devices/1", http.StatusNotFound, "not_found"},
+		{"/other", http.StatusNotFound, "not_found"},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest("GET", tt.path, nil))
+		var p store.Problem
+		if err := json.Unmarshal(rec.Body.Bytes(), &p); err != nil {
+			t.Fatal(err)
+		}
+		if rec.Code != tt.status || p.Code != tt.code || p.Instance != tt.path || rec.Header().Get("Content-Type") != store.MIMEProblemJSON {
+			t.Errorf("%v: %v %+v", tt.path, rec.Code, p)
+		}
+	}
+}
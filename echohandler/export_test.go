@@ -0,0 +1,5 @@
+package echohandler
+
+func HandlerOf[T any](db any) (*Handler[T], error) {
+	return handlerOf[T](db)
+}
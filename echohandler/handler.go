@@ -1,28 +1,284 @@
 package echohandler
 
 import (
+	"context"
+	"reflect"
+	"sync"
+
+	"github.com/heypkg/store"
+	"github.com/heypkg/store/audit"
+	boltdb "github.com/heypkg/store/bolt"
+	"github.com/heypkg/store/cache"
+	"github.com/heypkg/store/echoadapter"
 	gormdb "github.com/heypkg/store/gorm"
 	"github.com/heypkg/store/search"
 	"github.com/heypkg/store/utils"
 	"github.com/labstack/echo/v4"
 	"github.com/pkg/errors"
+	"github.com/spf13/cast"
 	"gorm.io/gorm"
 )
 
-func ListObjects[T any](db any, c echo.Context, selectNames []string, handleFuncs map[string]search.SearchDataHandleFunc) ([]T, int64, error) {
+var ErrUnsupportedBackend = errors.New("unsupported store backend")
+
+// NewStore returns the store of model T for a backend: a store.Store[T], a
+// *gorm.DB or a *boltdb.DB without secondary indexes.
+func NewStore[T any](db any) (store.Store[T], error) {
 	switch db2 := db.(type) {
+	case store.Store[T]:
+		return db2, nil
 	case *gorm.DB:
-		return gormdb.ListObjects[T](db2, c, selectNames, handleFuncs)
+		return gormdb.NewStore[T](db2), nil
+	case *boltdb.DB:
+		return boltdb.NewStore[T](db2)
+	}
+	return nil, errors.Wrapf(ErrUnsupportedBackend, "%T", db)
+}
+
+// Handler serves the objects of model T from a store.
+type Handler[T any] struct {
+	Store store.Store[T]
+}
+
+// New returns the handler of model T. It fails for unsupported backends.
+func New[T any](db any) (*Handler[T], error) {
+	s, err := NewStore[T](db)
+	if err != nil {
+		return nil, err
+	}
+	return &Handler[T]{Store: s}, nil
+}
+
+func (h *Handler[T]) ListObjects(c echo.Context, selectNames []string, handleFuncs map[string]search.SearchDataHandleFunc) ([]T, int64, error) {
+	ctx, err := echoadapter.Context(c)
+	if err != nil {
+		return nil, 0, echoadapter.HTTPError(err)
+	}
+	req := echoadapter.ListRequest(c)
+	req.Select = selectNames
+	req.HandleFuncs = handleFuncs
+	data, total, err := h.list(c, ctx, req)
+	return data, total, echoadapter.HTTPError(err)
+}
+
+func (h *Handler[T]) ListDeletedObjects(c echo.Context, selectNames []string, handleFuncs map[string]search.SearchDataHandleFunc) ([]T, int64, error) {
+	ctx, err := echoadapter.Context(c)
+	if err != nil {
+		return nil, 0, echoadapter.HTTPError(err)
+	}
+	req := echoadapter.ListRequest(c)
+	req.Select = selectNames
+	req.HandleFuncs = handleFuncs
+	req.Deleted = true
+	data, total, err := h.list(c, ctx, req)
+	return data, total, echoadapter.HTTPError(err)
+}
+
+// list lists the objects of req and sets the cache headers of c.
+func (h *Handler[T]) list(c echo.Context, ctx context.Context, req store.ListRequest) ([]T, int64, error) {
+	ctx, info := cache.WithInfo(ctx)
+	data, total, err := h.Store.List(ctx, req)
+	info.SetHeaders(c.Response().Header())
+	return data, total, err
+}
+
+func (h *Handler[T]) AggregateObjects(c echo.Context, handleFuncs map[string]search.SearchDataHandleFunc) ([]map[string]any, error) {
+	ctx, err := echoadapter.Context(c)
+	if err != nil {
+		return nil, echoadapter.HTTPError(err)
+	}
+	req, err := echoadapter.AggregateRequest(c)
+	if err != nil {
+		return nil, echoadapter.HTTPError(err)
+	}
+	req.HandleFuncs = handleFuncs
+	data, err := h.Store.Aggregate(ctx, req)
+	return data, echoadapter.HTTPError(err)
+}
+
+// SuggestObjects completes the q query parameter at the cursor parameter,
+// for stores implementing store.Suggester.
+func (h *Handler[T]) SuggestObjects(c echo.Context, handleFuncs map[string]search.SearchDataHandleFunc) (store.Suggestions, error) {
+	suggester, ok := h.Store.(store.Suggester)
+	if !ok {
+		return store.Suggestions{}, echoadapter.HTTPError(store.NewError(store.ErrNotImplemented, "suggestions not supported", nil))
+	}
+	ctx, err := echoadapter.Context(c)
+	if err != nil {
+		return store.Suggestions{}, echoadapter.HTTPError(err)
+	}
+	req := echoadapter.SuggestRequest(c)
+	req.HandleFuncs = handleFuncs
+	suggestions, err := suggester.Suggest(ctx, req)
+	return suggestions, echoadapter.HTTPError(err)
+}
+
+func (h *Handler[T]) objectHandler(getRequest func(c echo.Context) store.GetRequest) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			var obj T
+			key := utils.GetRawTypeName(obj)
+			ctx, err := echoadapter.Context(c)
+			if err != nil {
+				return echoadapter.HTTPError(err)
+			}
+			ctx, info := cache.WithInfo(ctx)
+			out, err := h.Store.Get(ctx, getRequest(c))
+			if err != nil {
+				return echoadapter.HTTPError(err)
+			}
+			info.SetHeaders(c.Response().Header())
+			c.Set(key, out)
+			return next(c)
+		}
+	}
+}
+
+func (h *Handler[T]) ObjectHandler() echo.MiddlewareFunc {
+	return h.objectHandler(echoadapter.GetRequest)
+}
+
+func (h *Handler[T]) DeletedObjectHandler() echo.MiddlewareFunc {
+	return h.objectHandler(echoadapter.DeletedGetRequest)
+}
+
+func (h *Handler[T]) TSObjectHandler() echo.MiddlewareFunc {
+	return h.objectHandler(echoadapter.TSGetRequest)
+}
+
+func (h *Handler[T]) write(c echo.Context, fn func(ctx context.Context) error) error {
+	ctx, err := echoadapter.Context(c)
+	if err != nil {
+		return echoadapter.HTTPError(err)
+	}
+	return echoadapter.HTTPError(fn(ctx))
+}
+
+func (h *Handler[T]) CreateObject(c echo.Context, obj *T) error {
+	return h.write(c, func(ctx context.Context) error { return h.Store.Create(ctx, obj) })
+}
+
+func (h *Handler[T]) UpdateObject(c echo.Context, obj *T, values map[string]any) error {
+	return h.write(c, func(ctx context.Context) error { return h.Store.Update(ctx, obj, values) })
+}
+
+func (h *Handler[T]) DeleteObject(c echo.Context, obj *T) error {
+	return h.write(c, func(ctx context.Context) error { return h.Store.Delete(ctx, obj) })
+}
+
+func (h *Handler[T]) RestoreObject(c echo.Context, obj *T) error {
+	return h.write(c, func(ctx context.Context) error { return h.Store.Restore(ctx, obj) })
+}
+
+func (h *Handler[T]) historyStore() (store.HistoryStore[T], error) {
+	if s, ok := h.Store.(store.HistoryStore[T]); ok {
+		return s, nil
+	}
+	return nil, store.NewError(store.ErrNotImplemented, "history not supported", nil)
+}
+
+// ListObjectHistory lists the audit records of the object loaded by
+// ObjectHandler or DeletedObjectHandler.
+func (h *Handler[T]) ListObjectHistory(c echo.Context) ([]audit.Record, int64, error) {
+	s, err := h.historyStore()
+	if err != nil {
+		return nil, 0, echoadapter.HTTPError(err)
+	}
+	obj := GetObjectFromEchoContext[T](c)
+	if obj == nil {
+		return nil, 0, echoadapter.HTTPError(store.NotFound("not found"))
+	}
+	ctx, err := echoadapter.Context(c)
+	if err != nil {
+		return nil, 0, echoadapter.HTTPError(err)
+	}
+	req := echoadapter.ListRequest(c)
+	req.Preload = ""
+	req.Include = ""
+	records, total, err := s.History(ctx, obj, req)
+	return records, total, echoadapter.HTTPError(err)
+}
+
+// RevertObject restores the object loaded by ObjectHandler or
+// DeletedObjectHandler to the version given by the version path parameter.
+func (h *Handler[T]) RevertObject(c echo.Context) (*T, error) {
+	s, err := h.historyStore()
+	if err != nil {
+		return nil, echoadapter.HTTPError(err)
 	}
-	return nil, 0, errors.New("invalid db")
+	obj := GetObjectFromEchoContext[T](c)
+	if obj == nil {
+		return nil, echoadapter.HTTPError(store.NotFound("not found"))
+	}
+	ctx, err := echoadapter.Context(c)
+	if err != nil {
+		return nil, echoadapter.HTTPError(err)
+	}
+	out, err := s.Revert(ctx, obj, cast.ToInt(c.Param("version")))
+	return out, echoadapter.HTTPError(err)
+}
+
+// The functions of a db below are shims kept for the callers of the
+// handlers before Handler: they use the handler of T for db built on their
+// first call and fail with ErrUnsupportedBackend when db is not supported.
+// New code should build a Handler with New once at startup instead.
+
+type handlerKey struct {
+	db    any
+	model reflect.Type
+}
+
+// handlers caches the handlers of the shims per backend and model, so that
+// stores such as bolt build their indexes once.
+var handlers sync.Map
+
+// handlerOf returns the cached handler of T for db, built on first use.
+func handlerOf[T any](db any) (*Handler[T], error) {
+	if db == nil || !reflect.TypeOf(db).Comparable() {
+		return New[T](db)
+	}
+	key := handlerKey{db: db, model: reflect.TypeOf((*T)(nil)).Elem()}
+	if h, ok := handlers.Load(key); ok {
+		return h.(*Handler[T]), nil
+	}
+	h, err := New[T](db)
+	if err != nil {
+		return nil, err
+	}
+	v, _ := handlers.LoadOrStore(key, h)
+	return v.(*Handler[T]), nil
+}
+
+func ListObjects[T any](db any, c echo.Context, selectNames []string, handleFuncs map[string]search.SearchDataHandleFunc) ([]T, int64, error) {
+	h, err := handlerOf[T](db)
+	if err != nil {
+		return nil, 0, err
+	}
+	return h.ListObjects(c, selectNames, handleFuncs)
 }
 
 func ListDeletedObjects[T any](db any, c echo.Context, selectNames []string, handleFuncs map[string]search.SearchDataHandleFunc) ([]T, int64, error) {
-	switch db2 := db.(type) {
-	case *gorm.DB:
-		return gormdb.ListDeletedObjects[T](db2, c, selectNames, handleFuncs)
+	h, err := handlerOf[T](db)
+	if err != nil {
+		return nil, 0, err
+	}
+	return h.ListDeletedObjects(c, selectNames, handleFuncs)
+}
+
+func AggregateObjects[T any](db any, c echo.Context, handleFuncs map[string]search.SearchDataHandleFunc) ([]map[string]any, error) {
+	h, err := handlerOf[T](db)
+	if err != nil {
+		return nil, err
+	}
+	return h.AggregateObjects(c, handleFuncs)
+}
+
+func SuggestObjects[T any](db any, c echo.Context, handleFuncs map[string]search.SearchDataHandleFunc) (store.Suggestions, error) {
+	h, err := handlerOf[T](db)
+	if err != nil {
+		return store.Suggestions{}, err
 	}
-	return nil, 0, errors.New("invalid db")
+	return h.SuggestObjects(c, handleFuncs)
 }
 
 func GetObjectFromEchoContext[T any](c echo.Context) *T {
@@ -36,28 +292,27 @@ func GetObjectFromEchoContext[T any](c echo.Context) *T {
 	return nil
 }
 
-func ObjectHandler[T any](db any) echo.MiddlewareFunc {
-	switch db2 := db.(type) {
-	case *gorm.DB:
-		return gormdb.ObjectHandler[T](db2)
+// objectHandler returns the middleware of the handler of T for db. It panics
+// with ErrUnsupportedBackend when db is not supported, so that routes of an
+// unsupported backend fail at setup.
+func objectHandler[T any](db any, middleware func(h *Handler[T]) echo.MiddlewareFunc) echo.MiddlewareFunc {
+	h, err := handlerOf[T](db)
+	if err != nil {
+		panic(err)
 	}
-	return nil
+	return middleware(h)
+}
+
+func ObjectHandler[T any](db any) echo.MiddlewareFunc {
+	return objectHandler[T](db, (*Handler[T]).ObjectHandler)
 }
 
 func DeletedObjectHandler[T any](db any) echo.MiddlewareFunc {
-	switch db2 := db.(type) {
-	case *gorm.DB:
-		return gormdb.DeletedObjectHandler[T](db2)
-	}
-	return nil
+	return objectHandler[T](db, (*Handler[T]).DeletedObjectHandler)
 }
 
 func TSObjectHandler[T any](db any) echo.MiddlewareFunc {
-	switch db2 := db.(type) {
-	case *gorm.DB:
-		return gormdb.TSObjectHandler[T](db2)
-	}
-	return nil
+	return objectHandler[T](db, (*Handler[T]).TSObjectHandler)
 }
 
 func ListAnyObjects(db any, c echo.Context, tableName string, handleFuncs map[string]search.SearchDataHandleFunc) ([]map[string]any, int64, error) {
@@ -65,5 +320,53 @@ func ListAnyObjects(db any, c echo.Context, tableName string, handleFuncs map[st
 	case *gorm.DB:
 		return gormdb.ListAnyObjects(db2, c, tableName, handleFuncs)
 	}
-	return nil, 0, errors.New("invalid db")
+	return nil, 0, errors.Wrapf(ErrUnsupportedBackend, "%T", db)
+}
+
+func CreateObject[T any](db any, c echo.Context, obj *T) error {
+	h, err := handlerOf[T](db)
+	if err != nil {
+		return err
+	}
+	return h.CreateObject(c, obj)
+}
+
+func UpdateObject[T any](db any, c echo.Context, obj *T, values map[string]any) error {
+	h, err := handlerOf[T](db)
+	if err != nil {
+		return err
+	}
+	return h.UpdateObject(c, obj, values)
+}
+
+func DeleteObject[T any](db any, c echo.Context, obj *T) error {
+	h, err := handlerOf[T](db)
+	if err != nil {
+		return err
+	}
+	return h.DeleteObject(c, obj)
+}
+
+func RestoreObject[T any](db any, c echo.Context, obj *T) error {
+	h, err := handlerOf[T](db)
+	if err != nil {
+		return err
+	}
+	return h.RestoreObject(c, obj)
+}
+
+func ListObjectHistory[T any](db any, c echo.Context) ([]audit.Record, int64, error) {
+	h, err := handlerOf[T](db)
+	if err != nil {
+		return nil, 0, err
+	}
+	return h.ListObjectHistory(c)
+}
+
+func RevertObject[T any](db any, c echo.Context) (*T, error) {
+	h, err := handlerOf[T](db)
+	if err != nil {
+		return nil, err
+	}
+	return h.RevertObject(c)
 }
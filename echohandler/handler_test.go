@@ -0,0 +1,100 @@
+package echohandler_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/heypkg/store"
+	boltdb "github.com/heypkg/store/bolt"
+	"github.com/heypkg/store/echohandler"
+	"github.com/heypkg/store/memory"
+	"github.com/heypkg/store/storetest"
+	"github.com/labstack/echo/v4"
+)
+
+func newContext(id string) echo.Context {
+	c := echo.New().NewContext(httptest.NewRequest("GET", "/", nil), httptest.NewRecorder())
+	c.SetParamNames("id")
+	c.SetParamValues(id)
+	c.Set("schema", "a")
+	return c
+}
+
+func TestUnsupportedBackend(t *testing.T) {
+	for name, build := range map[string]func(db any) echo.MiddlewareFunc{
+		"object":         echohandler.ObjectHandler[storetest.Device],
+		"deleted object": echohandler.DeletedObjectHandler[storetest.Device],
+		"ts object":      echohandler.TSObjectHandler[storetest.Device],
+	} {
+		func() {
+			defer func() {
+				if err, _ := recover().(error); !errors.Is(err, echohandler.ErrUnsupportedBackend) {
+					t.Errorf("%s: recovered %v", name, err)
+				}
+			}()
+			build("db")
+			t.Errorf("%s: built for an unsupported backend", name)
+		}()
+	}
+	if _, _, err := echohandler.ListObjects[storetest.Device]("db", newContext(""), nil, nil); !errors.Is(err, echohandler.ErrUnsupportedBackend) {
+		t.Errorf("list: %v", err)
+	}
+	if _, err := echohandler.New[storetest.Device]("db"); !errors.Is(err, echohandler.ErrUnsupportedBackend) {
+		t.Errorf("new: %v", err)
+	}
+}
+
+func TestObjectHandler(t *testing.T) {
+	s := memory.NewStore[storetest.Device]()
+	d := &storetest.Device{Name: "d1"}
+	if err := s.Create(storetest.Context("a"), d); err != nil {
+		t.Fatal(err)
+	}
+	h, err := echohandler.New[storetest.Device](s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := newContext("1")
+	err = h.ObjectHandler()(func(c echo.Context) error {
+		if obj := echohandler.GetObjectFromEchoContext[storetest.Device](c); obj == nil || obj.Name != "d1" {
+			t.Errorf("object: %+v", obj)
+		}
+		return nil
+	})(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// store errors are served by echo with their status
+	err = h.ObjectHandler()(func(c echo.Context) error { return nil })(newContext("9"))
+	var he *echo.HTTPError
+	if !errors.As(err, &he) || he.Code != http.StatusNotFound || !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("missing object: %v", err)
+	}
+}
+
+func TestHandlerCache(t *testing.T) {
+	db, err := boltdb.Open(filepath.Join(t.TempDir(), "store.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	h1, err := echohandler.HandlerOf[storetest.Device](db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h2, err := echohandler.HandlerOf[storetest.Device](db)
+	if err != nil || h2 != h1 {
+		t.Errorf("handler rebuilt: %p, %p, %v", h1, h2, err)
+	}
+	type other struct{ ID uint }
+	if h3, err := echohandler.HandlerOf[other](db); err != nil || h3 == nil {
+		t.Errorf("handler of another model: %v", err)
+	}
+	if _, err := echohandler.HandlerOf[storetest.Device]("db"); !errors.Is(err, echohandler.ErrUnsupportedBackend) {
+		t.Errorf("unsupported backend: %v", err)
+	}
+}
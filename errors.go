@@ -0,0 +1,163 @@
+package store
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+)
+
+// Error is an error returned by stores: its code and the HTTP status it is
+// served with, the message shown to clients and the cause, kept for logs.
+// The errors without message or cause below are kinds: errors.Is(err, kind)
+// holds for the errors of the code of kind.
+type Error struct {
+	Code    string
+	Status  int
+	Message string
+	Cause   error
+}
+
+func (e *Error) Error() string {
+	msg := e.Message
+	if msg == "" {
+		msg = e.Code
+	}
+	if e.Cause != nil && !strings.HasSuffix(msg, e.Cause.Error()) {
+		msg += ": " + e.Cause.Error()
+	}
+	return msg
+}
+
+func (e *Error) Unwrap() error {
+	return e.Cause
+}
+
+// Is reports whether target is an error of the code of e.
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.Code == e.Code
+}
+
+var (
+	ErrNotFound           = &Error{Code: "not_found", Status: http.StatusNotFound}
+	ErrConflict           = &Error{Code: "conflict", Status: http.StatusConflict}
+	ErrInvalidQuery       = &Error{Code: "invalid_query", Status: http.StatusBadRequest}
+	ErrForbidden          = &Error{Code: "forbidden", Status: http.StatusForbidden}
+	ErrPreconditionFailed = &Error{Code: "precondition_failed", Status: http.StatusPreconditionFailed}
+	ErrTimeout            = &Error{Code: "timeout", Status: http.StatusGatewayTimeout}
+	// ErrCanceled is served with the non-standard status 499 of clients that
+	// closed their request.
+	ErrCanceled       = &Error{Code: "canceled", Status: 499}
+	ErrNotImplemented = &Error{Code: "not_implemented", Status: http.StatusNotImplemented}
+	ErrInternal       = &Error{Code: "internal", Status: http.StatusInternalServerError}
+)
+
+var errorKinds = []*Error{
+	ErrNotFound, ErrConflict, ErrInvalidQuery, ErrForbidden, ErrPreconditionFailed, ErrTimeout, ErrCanceled, ErrNotImplemented, ErrInternal,
+}
+
+// NewError returns an error of kind served with message. The cause is kept
+// for logs, not served.
+func NewError(kind *Error, message string, cause error) *Error {
+	return &Error{Code: kind.Code, Status: kind.Status, Message: message, Cause: cause}
+}
+
+func NotFound(message string) *Error {
+	return NewError(ErrNotFound, message, nil)
+}
+
+func Conflict(message string, cause error) *Error {
+	return NewError(ErrConflict, message, cause)
+}
+
+// InvalidQuery returns the error of a client query, served as "invalid
+// query: " and the message of err.
+func InvalidQuery(err error) *Error {
+	return NewError(ErrInvalidQuery, errors.Wrap(err, "invalid query").Error(), err)
+}
+
+func Forbidden(message string) *Error {
+	return NewError(ErrForbidden, message, nil)
+}
+
+func PreconditionFailed(message string) *Error {
+	return NewError(ErrPreconditionFailed, message, nil)
+}
+
+func Timeout(cause error) *Error {
+	return NewError(ErrTimeout, "timeout", cause)
+}
+
+// Internal returns a server error. The message does not show the cause.
+func Internal(cause error) *Error {
+	return NewError(ErrInternal, http.StatusText(http.StatusInternalServerError), cause)
+}
+
+// ErrorOf returns the store error of err, as WrapError makes it, or
+// ErrInternal when err is nil.
+func ErrorOf(err error) *Error {
+	var e *Error
+	if errors.As(WrapError(err), &e) {
+		return e
+	}
+	return ErrInternal
+}
+
+// ErrorOfStatus returns the kind served with an HTTP status.
+func ErrorOfStatus(status int) *Error {
+	for _, kind := range errorKinds {
+		if kind.Status == status {
+			return kind
+		}
+	}
+	return &Error{Code: codeOfStatus(status), Status: status}
+}
+
+func codeOfStatus(status int) string {
+	code := []byte{}
+	for _, c := range []byte(http.StatusText(status)) {
+		switch {
+		case c >= 'A' && c <= 'Z':
+			code = append(code, c+'a'-'A')
+		case c >= 'a' && c <= 'z' || c >= '0' && c <= '9':
+			code = append(code, c)
+		case len(code) > 0 && code[len(code)-1] != '_':
+			code = append(code, '_')
+		}
+	}
+	if len(code) == 0 {
+		return "error"
+	}
+	return string(code)
+}
+
+// WrapError returns err as an *Error. Errors of the standard library and
+// gorm get their kind; other errors are internal.
+func WrapError(err error) error {
+	if err == nil {
+		return nil
+	}
+	var e *Error
+	if errors.As(err, &e) {
+		if e.Message != "" || e.Cause != nil {
+			return e
+		}
+		return NewError(e, http.StatusText(e.Status), err)
+	}
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return NotFound("not found")
+	case errors.Is(err, gorm.ErrDuplicatedKey):
+		return Conflict("duplicated key", err)
+	case errors.Is(err, gorm.ErrForeignKeyViolated):
+		return Conflict("foreign key violation", err)
+	case errors.Is(err, context.DeadlineExceeded):
+		return Timeout(err)
+	case errors.Is(err, context.Canceled):
+		return NewError(ErrCanceled, "canceled", err)
+	}
+	return Internal(err)
+}
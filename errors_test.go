@@ -0,0 +1,62 @@
+package store_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/heypkg/store"
+	pkgerrors "github.com/pkg/errors"
+	"gorm.io/gorm"
+)
+
+func TestError(t *testing.T) {
+	cause := errors.New("row locked")
+	err := pkgerrors.Wrap(store.Conflict("duplicated key", cause), "create")
+	if !errors.Is(err, store.ErrConflict) || errors.Is(err, store.ErrNotFound) {
+		t.Errorf("kind of %v", err)
+	}
+	if !errors.Is(err, cause) {
+		t.Errorf("cause of %v", err)
+	}
+	var e *store.Error
+	if !errors.As(err, &e) || e.Status != http.StatusConflict || e.Message != "duplicated key" {
+		t.Errorf("as: %+v", e)
+	}
+	if got := store.InvalidQuery(cause).Error(); got != "invalid query: row locked" {
+		t.Errorf("message: %q", got)
+	}
+
+	tests := []struct {
+		err  error
+		want *store.Error
+	}{
+		{gorm.ErrRecordNotFound, store.ErrNotFound},
+		{gorm.ErrDuplicatedKey, store.ErrConflict},
+		{context.DeadlineExceeded, store.ErrTimeout},
+		{context.Canceled, store.ErrCanceled},
+		{pkgerrors.Wrap(store.ErrForbidden, "denied"), store.ErrForbidden},
+		{cause, store.ErrInternal},
+	}
+	for _, tt := range tests {
+		e := store.ErrorOf(tt.err)
+		if e.Code != tt.want.Code || e.Status != tt.want.Status || e.Message == "" {
+			t.Errorf("ErrorOf(%v) = %+v; want %v", tt.err, e, tt.want.Code)
+		}
+	}
+	if e := store.ErrorOf(cause); e.Message != http.StatusText(http.StatusInternalServerError) {
+		t.Errorf("message of an internal error: %q", e.Message)
+	}
+}
+
+func TestNewProblem(t *testing.T) {
+	p := store.NewProblem(store.NotFound("device not found"))
+	if p.Status != http.StatusNotFound || p.Code != "not_found" || p.Detail != "device not found" || p.Type != "about:blank" {
+		t.Errorf("problem: %+v", p)
+	}
+	p = store.NewProblem(errors.New("password=secret"))
+	if p.Status != http.StatusInternalServerError || p.Detail != "" {
+		t.Errorf("problem of a server error: %+v", p)
+	}
+}
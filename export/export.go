@@ -0,0 +1,479 @@
+// Package export streams the objects of a list query to CSV, NDJSON or XLSX
+// without loading them at once.
+package export
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/heypkg/store"
+	"github.com/heypkg/store/echoadapter"
+	"github.com/heypkg/store/response"
+	"github.com/heypkg/store/search"
+	"github.com/labstack/echo/v4"
+	"github.com/pkg/errors"
+)
+
+type Format string
+
+const (
+	FormatCSV    Format = "csv"
+	FormatNDJSON Format = "ndjson"
+	FormatXLSX   Format = "xlsx"
+)
+
+var ErrUnknownFormat = errors.New("unknown export format")
+
+func ParseFormat(text string) (Format, error) {
+	switch f := Format(strings.ToLower(text)); f {
+	case "":
+		return FormatCSV, nil
+	case FormatCSV, FormatNDJSON, FormatXLSX:
+		return f, nil
+	}
+	return "", errors.Wrap(ErrUnknownFormat, text)
+}
+
+func (f Format) ContentType() string {
+	switch f {
+	case FormatNDJSON:
+		return response.MIMENDJSON
+	case FormatXLSX:
+		return "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+	}
+	return response.MIMECSV + "; charset=UTF-8"
+}
+
+type Options struct {
+	Format Format
+	// Columns are the JSON names of the exported fields, with keys of JSON
+	// columns written column.key. By default all fields are exported and
+	// JSON objects are flattened into the keys found in the first batch.
+	Columns []string
+	// BatchSize is the number of objects read at a time from stores that
+	// do not stream, and buffered to find the default columns. It defaults
+	// to 1000.
+	BatchSize int
+	// FlushEvery is the number of rows between flushes of the output. It
+	// defaults to BatchSize.
+	FlushEvery int
+}
+
+func (o Options) withDefaults() Options {
+	if o.Format == "" {
+		o.Format = FormatCSV
+	}
+	if o.BatchSize <= 0 {
+		o.BatchSize = 1000
+	}
+	if o.FlushEvery <= 0 {
+		o.FlushEvery = o.BatchSize
+	}
+	return o
+}
+
+// OptionsFromQuery reads the format and columns query parameters.
+func OptionsFromQuery(query url.Values) (Options, error) {
+	format, err := ParseFormat(query.Get("format"))
+	if err != nil {
+		return Options{}, err
+	}
+	opts := Options{Format: format}
+	for _, name := range strings.Split(query.Get("columns"), ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			opts.Columns = append(opts.Columns, name)
+		}
+	}
+	return opts, nil
+}
+
+// Write exports the objects selected by req to w. Stores implementing
+// store.Scanner are streamed; others are read in pages of BatchSize.
+func Write[T any](ctx context.Context, w io.Writer, s store.Store[T], req store.ListRequest, opts Options) error {
+	opts = opts.withDefaults()
+	e := &exporter{w: w, opts: opts, columns: opts.Columns, base: response.Columns[T]()}
+	err := each(ctx, s, req, opts.BatchSize, func(obj *T) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		return e.add(obj)
+	})
+	if err != nil {
+		return err
+	}
+	return e.close()
+}
+
+func each[T any](ctx context.Context, s store.Store[T], req store.ListRequest, batchSize int, fn func(obj *T) error) error {
+	if scanner, ok := s.(store.Scanner[T]); ok {
+		return scanner.Scan(ctx, req, fn)
+	}
+	req.PageSize = batchSize
+	for page := 1; ; page++ {
+		req.Page = page
+		objects, total, err := s.List(ctx, req)
+		if err != nil {
+			return err
+		}
+		for i := range objects {
+			if err := fn(&objects[i]); err != nil {
+				return err
+			}
+		}
+		if int64(page*batchSize) >= total {
+			return nil
+		}
+	}
+}
+
+// WriteResponse exports to an HTTP response as an attachment named filename
+// plus the extension of the format. Headers are written with the first row,
+// so errors raised before it, such as invalid queries, can still be served
+// as error responses.
+func WriteResponse[T any](w http.ResponseWriter, ctx context.Context, s store.Store[T], req store.ListRequest, filename string, opts Options) error {
+	opts = opts.withDefaults()
+	rw := &responseWriter{ResponseWriter: w, start: func() {
+		h := w.Header()
+		h.Set("Content-Type", opts.Format.ContentType())
+		h.Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename+"."+string(opts.Format)))
+		w.WriteHeader(http.StatusOK)
+	}}
+	return Write(ctx, rw, s, req, opts)
+}
+
+// Echo exports the list of c, read like ListObjects, with the options of
+// the format and columns query parameters.
+func Echo[T any](c echo.Context, s store.Store[T], filename string, handleFuncs map[string]search.SearchDataHandleFunc) error {
+	ctx, err := echoadapter.Context(c)
+	if err != nil {
+		return echoadapter.HTTPError(err)
+	}
+	opts, err := OptionsFromQuery(c.QueryParams())
+	if err != nil {
+		return echoadapter.HTTPError(store.NewError(store.ErrInvalidQuery, err.Error(), err))
+	}
+	req := echoadapter.ListRequest(c)
+	req.HandleFuncs = handleFuncs
+	return echoadapter.HTTPError(WriteResponse(c.Response(), ctx, s, req, filename, opts))
+}
+
+type responseWriter struct {
+	http.ResponseWriter
+	started bool
+	start   func()
+}
+
+func (w *responseWriter) Write(b []byte) (int, error) {
+	if !w.started {
+		w.started = true
+		w.start()
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *responseWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok && w.started {
+		f.Flush()
+	}
+}
+
+type cellKind int
+
+const (
+	cellEmpty cellKind = iota
+	cellString
+	cellNumber
+	cellBool
+)
+
+type cell struct {
+	kind cellKind
+	text string
+	raw  json.RawMessage
+}
+
+func newCell(raw json.RawMessage) cell {
+	raw = bytes.TrimSpace(raw)
+	c := cell{raw: raw, text: string(raw)}
+	switch {
+	case len(raw) == 0 || string(raw) == "null":
+		c.kind, c.text = cellEmpty, ""
+	case raw[0] == '"':
+		c.kind = cellString
+		json.Unmarshal(raw, &c.text)
+	case string(raw) == "true" || string(raw) == "false":
+		c.kind = cellBool
+	case raw[0] == '{' || raw[0] == '[':
+		c.kind = cellString
+	default:
+		c.kind = cellNumber
+	}
+	return c
+}
+
+type row map[string]json.RawMessage
+
+// lookup returns the value of a column, matching names case-insensitively
+// and reading column.key from JSON objects.
+func (r row) lookup(name string) json.RawMessage {
+	if v, ok := r.get(name); ok {
+		return v
+	}
+	parent, key, ok := strings.Cut(name, ".")
+	if !ok {
+		return nil
+	}
+	v, _ := r.get(parent)
+	var sub row
+	if json.Unmarshal(v, &sub) != nil {
+		return nil
+	}
+	v, _ = sub.get(key)
+	return v
+}
+
+func (r row) get(name string) (json.RawMessage, bool) {
+	if v, ok := r[name]; ok {
+		return v, true
+	}
+	for k, v := range r {
+		if strings.EqualFold(k, name) {
+			return v, true
+		}
+	}
+	return nil, false
+}
+
+type rowWriter interface {
+	Write(cells []cell) error
+	Flush() error
+	Close() error
+}
+
+type exporter struct {
+	w       io.Writer
+	opts    Options
+	base    []string
+	columns []string
+	buffer  []row
+	out     rowWriter
+	rows    int
+}
+
+func (e *exporter) add(obj any) error {
+	b, err := json.Marshal(obj)
+	if err != nil {
+		return errors.Wrap(err, "encode object")
+	}
+	if e.opts.Format == FormatNDJSON && len(e.opts.Columns) == 0 {
+		if _, err := e.w.Write(append(b, '\n')); err != nil {
+			return err
+		}
+		return e.count()
+	}
+	var r row
+	if err := json.Unmarshal(b, &r); err != nil {
+		return errors.Wrap(err, "encode object")
+	}
+	if e.out == nil {
+		e.buffer = append(e.buffer, r)
+		if len(e.buffer) < e.opts.BatchSize {
+			return nil
+		}
+		return e.start()
+	}
+	return e.write(r)
+}
+
+// start resolves the columns from the buffered rows, writes the header and
+// the buffer.
+func (e *exporter) start() error {
+	if len(e.columns) == 0 {
+		e.columns = defaultColumns(e.base, e.buffer)
+	}
+	var err error
+	switch e.opts.Format {
+	case FormatNDJSON:
+		e.out = &ndjsonWriter{w: e.w, columns: e.columns}
+	case FormatXLSX:
+		e.out, err = newXLSXWriter(e.w)
+	default:
+		e.out = &csvWriter{w: csv.NewWriter(e.w)}
+	}
+	if err != nil {
+		return err
+	}
+	if e.opts.Format != FormatNDJSON {
+		header := make([]cell, len(e.columns))
+		for i, name := range e.columns {
+			header[i] = cell{kind: cellString, text: name}
+		}
+		if err := e.out.Write(header); err != nil {
+			return err
+		}
+	}
+	buffer := e.buffer
+	e.buffer = nil
+	for _, r := range buffer {
+		if err := e.write(r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *exporter) write(r row) error {
+	cells := make([]cell, len(e.columns))
+	for i, name := range e.columns {
+		cells[i] = newCell(r.lookup(name))
+	}
+	if err := e.out.Write(cells); err != nil {
+		return err
+	}
+	return e.count()
+}
+
+func (e *exporter) count() error {
+	e.rows++
+	if e.rows%e.opts.FlushEvery == 0 {
+		return e.flush()
+	}
+	return nil
+}
+
+func (e *exporter) flush() error {
+	if e.out != nil {
+		if err := e.out.Flush(); err != nil {
+			return err
+		}
+	}
+	if f, ok := e.w.(http.Flusher); ok {
+		f.Flush()
+	}
+	return nil
+}
+
+func (e *exporter) close() error {
+	if e.out == nil && !(e.opts.Format == FormatNDJSON && len(e.opts.Columns) == 0) {
+		if err := e.start(); err != nil {
+			return err
+		}
+	}
+	if e.out != nil {
+		if err := e.out.Close(); err != nil {
+			return err
+		}
+	}
+	return e.flush()
+}
+
+// defaultColumns returns the fields of the model, or the keys of the rows
+// for maps, with JSON objects replaced by their keys as column.key.
+func defaultColumns(base []string, rows []row) []string {
+	if len(base) == 0 {
+		seen := map[string]bool{}
+		for _, r := range rows {
+			for k := range r {
+				if !seen[k] {
+					seen[k] = true
+					base = append(base, k)
+				}
+			}
+		}
+		sort.Strings(base)
+	}
+	out := []string{}
+	for _, name := range base {
+		keys := map[string]bool{}
+		flat := true
+		for _, r := range rows {
+			v := bytes.TrimSpace(r[name])
+			if len(v) == 0 || string(v) == "null" {
+				continue
+			}
+			var sub map[string]json.RawMessage
+			if v[0] != '{' || json.Unmarshal(v, &sub) != nil {
+				flat = false
+				break
+			}
+			for k := range sub {
+				keys[k] = true
+			}
+		}
+		if !flat || len(keys) == 0 {
+			out = append(out, name)
+			continue
+		}
+		sorted := make([]string, 0, len(keys))
+		for k := range keys {
+			sorted = append(sorted, name+"."+k)
+		}
+		sort.Strings(sorted)
+		out = append(out, sorted...)
+	}
+	return out
+}
+
+type csvWriter struct {
+	w *csv.Writer
+}
+
+func (c *csvWriter) Write(cells []cell) error {
+	record := make([]string, len(cells))
+	for i, cell := range cells {
+		record[i] = cell.text
+	}
+	return c.w.Write(record)
+}
+
+func (c *csvWriter) Flush() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+func (c *csvWriter) Close() error {
+	return c.Flush()
+}
+
+// ndjsonWriter writes the selected columns of each row as an object.
+type ndjsonWriter struct {
+	w       io.Writer
+	columns []string
+}
+
+func (n *ndjsonWriter) Write(cells []cell) error {
+	var b bytes.Buffer
+	b.WriteByte('{')
+	for i, cell := range cells {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		name, _ := json.Marshal(n.columns[i])
+		b.Write(name)
+		b.WriteByte(':')
+		if len(cell.raw) == 0 {
+			b.WriteString("null")
+		} else {
+			b.Write(cell.raw)
+		}
+	}
+	b.WriteString("}\n")
+	_, err := n.w.Write(b.Bytes())
+	return err
+}
+
+func (n *ndjsonWriter) Flush() error {
+	return nil
+}
+
+func (n *ndjsonWriter) Close() error {
+	return nil
+}
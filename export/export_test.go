@@ -0,0 +1,47 @@
+package export_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/heypkg/store"
+	"github.com/heypkg/store/export"
+	"github.com/heypkg/store/jsontype"
+	"github.com/heypkg/store/memory"
+	"github.com/heypkg/store/storetest"
+)
+
+func TestWrite(t *testing.T) {
+	s := memory.NewStore[storetest.Device]()
+	ctx := storetest.Context("a")
+	for _, d := range []*storetest.Device{
+		{Name: "d1", Kind: "a", Value: 1.5, Tags: jsontype.Tags{"site": "x"}},
+		{Name: "d, 2", Kind: "b"},
+	} {
+		if err := s.Create(ctx, d); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := []struct {
+		name string
+		req  store.ListRequest
+		opts export.Options
+		want string
+	}{
+		{"csv", store.ListRequest{}, export.Options{BatchSize: 1},
+			"ID,Schema,Name,Kind,Value,Tags.site,Deleted\n1,a,d1,a,1.5,x,\n2,a,\"d, 2\",b,0,,\n"},
+		{"columns", store.ListRequest{Search: "kind:a"}, export.Options{Columns: []string{"Name", "Tags.site"}},
+			"Name,Tags.site\nd1,x\n"},
+		{"ndjson", store.ListRequest{OrderBy: "ID-"}, export.Options{Format: export.FormatNDJSON, Columns: []string{"ID", "Name", "Tags"}},
+			"{\"ID\":2,\"Name\":\"d, 2\",\"Tags\":null}\n{\"ID\":1,\"Name\":\"d1\",\"Tags\":{\"site\":\"x\"}}\n"},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		if err := export.Write[storetest.Device](ctx, &buf, s, tt.req, tt.opts); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if got := buf.String(); got != tt.want {
+			t.Errorf("%s: %q; want %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package feed
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/heypkg/store/echoadapter"
+	"github.com/labstack/echo/v4"
+	"golang.org/x/net/websocket"
+)
+
+// HeartbeatInterval is the interval of the keep-alive messages of streams.
+var HeartbeatInterval = 15 * time.Second
+
+// SSEHandler streams the changes of model T matching the q query parameter
+// as server-sent events named after their type.
+func SSEHandler[T any](b *Broker) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		ctx, err := echoadapter.Context(c)
+		if err != nil {
+			return echoadapter.HTTPError(err)
+		}
+		sub, err := Subscribe[T](ctx, b, c.QueryParam("q"))
+		if err != nil {
+			return echoadapter.HTTPError(err)
+		}
+		defer sub.Close()
+
+		w := c.Response()
+		w.Header().Set(echo.HeaderContentType, "text/event-stream")
+		w.Header().Set(echo.HeaderCacheControl, "no-cache")
+		w.Header().Set(echo.HeaderConnection, "keep-alive")
+		w.WriteHeader(http.StatusOK)
+		w.Flush()
+		heartbeat := time.NewTicker(HeartbeatInterval)
+		defer heartbeat.Stop()
+		for {
+			select {
+			case change, ok := <-sub.Changes():
+				if !ok {
+					if err := sub.Err(); err == ErrSlowSubscriber {
+						fmt.Fprintf(w, "event: error\ndata: %q\n\n", err.Error())
+						w.Flush()
+					}
+					return nil
+				}
+				data, err := json.Marshal(change)
+				if err != nil {
+					return echoadapter.HTTPError(err)
+				}
+				if _, err := fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", change.ID, change.Type, data); err != nil {
+					return nil
+				}
+				w.Flush()
+			case <-heartbeat.C:
+				if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+					return nil
+				}
+				w.Flush()
+			}
+		}
+	}
+}
+
+// WebSocketHandler sends the changes of model T matching the q query
+// parameter as JSON messages over a WebSocket.
+func WebSocketHandler[T any](b *Broker) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		ctx, err := echoadapter.Context(c)
+		if err != nil {
+			return echoadapter.HTTPError(err)
+		}
+		sub, err := Subscribe[T](ctx, b, c.QueryParam("q"))
+		if err != nil {
+			return echoadapter.HTTPError(err)
+		}
+		defer sub.Close()
+
+		websocket.Handler(func(ws *websocket.Conn) {
+			defer ws.Close()
+			// the client only closes the connection
+			go func() {
+				var msg []byte
+				for websocket.Message.Receive(ws, &msg) == nil {
+				}
+				sub.Close()
+			}()
+			heartbeat := time.NewTicker(HeartbeatInterval)
+			defer heartbeat.Stop()
+			for {
+				select {
+				case change, ok := <-sub.Changes():
+					if !ok {
+						if err := sub.Err(); err == ErrSlowSubscriber {
+							websocket.JSON.Send(ws, map[string]string{"type": "error", "error": err.Error()})
+						}
+						return
+					}
+					if err := websocket.JSON.Send(ws, change); err != nil {
+						return
+					}
+				case <-heartbeat.C:
+					if err := websocket.JSON.Send(ws, map[string]string{"type": "ping"}); err != nil {
+						return
+					}
+				}
+			}
+		}).ServeHTTP(c.Response(), c.Request())
+		return nil
+	}
+}
@@ -0,0 +1,322 @@
+// Package feed publishes the changes of the stores to subscribers. A
+// subscription receives the created, updated, deleted and restored objects
+// of its model that match its search, in its tenant and visible to its
+// policy subject.
+package feed
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/heypkg/store/internal/objectstore"
+	"github.com/heypkg/store/policy"
+	"github.com/heypkg/store/tenancy"
+	"github.com/heypkg/store/utils"
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+)
+
+// PluginName is the name of brokers registered with gorm.
+const PluginName = "store:feed"
+
+// DefaultBuffer is the number of changes a subscription holds for its
+// reader.
+const DefaultBuffer = 64
+
+// ErrSlowSubscriber ends the subscriptions whose reader falls behind.
+var ErrSlowSubscriber = errors.New("subscriber is too slow")
+
+type EventType string
+
+const (
+	Created  EventType = "created"
+	Updated  EventType = "updated"
+	Deleted  EventType = "deleted"
+	Restored EventType = "restored"
+)
+
+// Event is a change of an object. Object is the object itself in the
+// process that wrote it and its JSON in the others.
+type Event struct {
+	ID     string    `json:"id"`
+	Type   EventType `json:"type"`
+	Model  string    `json:"model"`
+	Tenant string    `json:"tenant,omitempty"`
+	Object any       `json:"object"`
+	Time   time.Time `json:"time"`
+	// Origin is the broker that published the event.
+	Origin string `json:"origin"`
+}
+
+// NewEvent returns the event of a change of obj, of model T, in the tenant
+// of ctx.
+func NewEvent[T any](ctx context.Context, typ EventType, obj *T) Event {
+	return Event{
+		ID:     newID(),
+		Type:   typ,
+		Model:  Model[T](),
+		Tenant: tenancy.ScopeFromContext(ctx).Tenant,
+		Object: *obj,
+		Time:   time.Now(),
+	}
+}
+
+// Model returns the model name of T in events.
+func Model[T any]() string {
+	var obj T
+	return utils.GetRawTypeName(obj)
+}
+
+// Sink receives the events published by a broker, to forward them to other
+// processes.
+type Sink interface {
+	Send(ctx context.Context, e Event) error
+}
+
+type subscriber interface {
+	deliver(e Event)
+}
+
+// Broker fans events out to the subscriptions of the process and to its
+// sinks.
+type Broker struct {
+	// Buffer is the number of changes held by new subscriptions.
+	Buffer int
+	// OnError is called with the errors of sinks. They are dropped when it
+	// is nil.
+	OnError func(err error)
+
+	id    string
+	mu    sync.RWMutex
+	subs  map[subscriber]struct{}
+	sinks []Sink
+}
+
+var _ gorm.Plugin = (*Broker)(nil)
+
+func NewBroker(sinks ...Sink) *Broker {
+	return &Broker{Buffer: DefaultBuffer, id: newID(), subs: map[subscriber]struct{}{}, sinks: sinks}
+}
+
+// Use registers b with db. The writes of the gorm stores of db are then
+// published to b.
+func Use(db *gorm.DB, b *Broker) error {
+	return db.Use(b)
+}
+
+// FromDB returns the broker registered with db, nil when there is none.
+func FromDB(db *gorm.DB) *Broker {
+	if p, ok := db.Config.Plugins[PluginName]; ok {
+		return p.(*Broker)
+	}
+	return nil
+}
+
+func (b *Broker) Name() string {
+	return PluginName
+}
+
+func (b *Broker) Initialize(db *gorm.DB) error {
+	return nil
+}
+
+// Publish delivers e to the subscriptions and sends it to the sinks.
+func (b *Broker) Publish(ctx context.Context, e Event) {
+	if b == nil {
+		return
+	}
+	if e.Origin == "" {
+		e.Origin = b.id
+	}
+	b.deliver(e)
+	for _, sink := range b.sinks {
+		if err := sink.Send(ctx, e); err != nil && b.OnError != nil {
+			b.OnError(errors.Wrap(err, "send event"))
+		}
+	}
+}
+
+// Receive delivers e, an event of another process, to the subscriptions.
+// The events of b itself are ignored.
+func (b *Broker) Receive(e Event) {
+	if e.Origin != b.id {
+		b.deliver(e)
+	}
+}
+
+func (b *Broker) deliver(e Event) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for sub := range b.subs {
+		sub.deliver(e)
+	}
+}
+
+func (b *Broker) add(sub subscriber) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.subs[sub] = struct{}{}
+}
+
+func (b *Broker) remove(sub subscriber) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.subs, sub)
+}
+
+// Change is a change received by a subscription.
+type Change[T any] struct {
+	ID     string    `json:"id"`
+	Type   EventType `json:"type"`
+	Object T         `json:"object"`
+	Time   time.Time `json:"time"`
+}
+
+// Subscription receives the changes of model T matching a search. It ends
+// when its context is done, when it is closed or when its reader falls
+// behind.
+type Subscription[T any] struct {
+	broker *Broker
+	model  *objectstore.Model[T]
+	access objectstore.Access
+	search func(obj *T) (bool, error)
+	ch     chan Change[T]
+	// done is closed when the subscription ends.
+	done chan struct{}
+
+	mu     sync.Mutex
+	closed bool
+	err    error
+}
+
+// Subscribe subscribes to the changes of model T matching q, in the tenant
+// of ctx and visible to its policy subject.
+func Subscribe[T any](ctx context.Context, b *Broker, q string) (*Subscription[T], error) {
+	m, err := objectstore.ParseModel[T]()
+	if err != nil {
+		return nil, err
+	}
+	a, err := objectstore.NewAccess[T](ctx, policy.ActionList)
+	if err != nil {
+		return nil, err
+	}
+	data, err := objectstore.ParseSearch(q, a)
+	if err != nil {
+		return nil, err
+	}
+	buffer := b.Buffer
+	if buffer <= 0 {
+		buffer = DefaultBuffer
+	}
+	s := &Subscription[T]{
+		broker: b,
+		model:  m,
+		access: a,
+		search: func(obj *T) (bool, error) { return a.Match(obj, data, nil) },
+		ch:     make(chan Change[T], buffer),
+		done:   make(chan struct{}),
+	}
+	b.add(s)
+	go func() {
+		select {
+		case <-ctx.Done():
+			s.end(ctx.Err())
+		case <-s.done:
+		}
+	}()
+	return s, nil
+}
+
+// Changes returns the channel of the changes, closed when the subscription
+// ends.
+func (s *Subscription[T]) Changes() <-chan Change[T] {
+	return s.ch
+}
+
+// Err returns the reason the subscription ended: ErrSlowSubscriber or the
+// error of its context. It is nil while the subscription runs and after
+// Close.
+func (s *Subscription[T]) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+func (s *Subscription[T]) Close() {
+	s.end(nil)
+}
+
+func (s *Subscription[T]) end(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+	s.closed = true
+	s.err = err
+	close(s.ch)
+	close(s.done)
+	go s.broker.remove(s)
+}
+
+func (s *Subscription[T]) deliver(e Event) {
+	if e.Model != Model[T]() {
+		return
+	}
+	obj, ok := s.object(e)
+	if !ok {
+		return
+	}
+	if s.model.TenantField(s.access) != nil {
+		if !s.model.InTenant(s.access, obj) {
+			return
+		}
+	} else if e.Tenant != s.access.Tenancy.Tenant {
+		return
+	}
+	if ok, err := s.search(obj); err != nil || !ok {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+	select {
+	case s.ch <- Change[T]{ID: e.ID, Type: e.Type, Object: *obj, Time: e.Time}:
+	default:
+		s.closed = true
+		s.err = ErrSlowSubscriber
+		close(s.ch)
+		go s.broker.remove(s)
+	}
+}
+
+// object returns a copy of the object of e, decoding the events of other
+// processes.
+func (s *Subscription[T]) object(e Event) (*T, bool) {
+	switch v := e.Object.(type) {
+	case T:
+		return &v, true
+	case *T:
+		obj := *v
+		return &obj, true
+	case json.RawMessage:
+		var obj T
+		if err := json.Unmarshal(v, &obj); err != nil {
+			return nil, false
+		}
+		return &obj, true
+	}
+	return nil, false
+}
+
+func newID() string {
+	b := make([]byte, 12)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
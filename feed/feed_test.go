@@ -0,0 +1,65 @@
+package feed_test
+
+import (
+	"context"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/heypkg/store/feed"
+	"github.com/heypkg/store/storetest"
+)
+
+func TestSubscribe(t *testing.T) {
+	b := feed.NewBroker()
+	ctx := storetest.Context("a")
+	sub, err := feed.Subscribe[storetest.Device](ctx, b, "kind:k")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Close()
+	b.Publish(ctx, feed.NewEvent(ctx, feed.Created, &storetest.Device{Schema: "a", Name: "other", Kind: "x"}))
+	b.Publish(storetest.Context("b"), feed.NewEvent(storetest.Context("b"), feed.Created, &storetest.Device{Schema: "b", Name: "tenant", Kind: "k"}))
+	b.Publish(ctx, feed.NewEvent(ctx, feed.Updated, &storetest.Device{Schema: "a", Name: "d1", Kind: "k"}))
+	select {
+	case c := <-sub.Changes():
+		if c.Type != feed.Updated || c.Object.Name != "d1" {
+			t.Fatalf("change: %+v", c)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no change")
+	}
+}
+
+func TestSubscriptionEnd(t *testing.T) {
+	b := feed.NewBroker()
+	before := runtime.NumGoroutine()
+	for i := 0; i < 100; i++ {
+		sub, err := feed.Subscribe[storetest.Device](storetest.Context("a"), b, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		sub.Close()
+		if _, ok := <-sub.Changes(); ok || sub.Err() != nil {
+			t.Fatalf("closed subscription: %v", sub.Err())
+		}
+	}
+	// the subscriptions no longer wait for their context
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > before+10 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := runtime.NumGoroutine(); n > before+10 {
+		t.Fatalf("%v goroutines left of %v", n, before)
+	}
+
+	ctx, cancel := context.WithCancel(storetest.Context("a"))
+	sub, err := feed.Subscribe[storetest.Device](ctx, b, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+	if _, ok := <-sub.Changes(); ok || sub.Err() != context.Canceled {
+		t.Fatalf("canceled subscription: %v", sub.Err())
+	}
+}
@@ -0,0 +1,83 @@
+package feed
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+)
+
+// DefaultChannel is the Postgres channel of the events.
+const DefaultChannel = "store_changes"
+
+// maxNotifyPayload is the limit of Postgres on NOTIFY payloads, in bytes.
+const maxNotifyPayload = 8000
+
+// ErrPayloadTooLarge is returned for events too large to NOTIFY.
+var ErrPayloadTooLarge = errors.New("event payload is too large")
+
+// Notifier is a sink sending events with Postgres NOTIFY to the brokers of
+// the other processes, which receive them with Listen.
+type Notifier struct {
+	DB      *gorm.DB
+	Channel string
+}
+
+var _ Sink = (*Notifier)(nil)
+
+func NewNotifier(db *gorm.DB) *Notifier {
+	return &Notifier{DB: db, Channel: DefaultChannel}
+}
+
+func (n *Notifier) Send(ctx context.Context, e Event) error {
+	payload, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	if len(payload) >= maxNotifyPayload {
+		return errors.Wrapf(ErrPayloadTooLarge, "%v bytes", len(payload))
+	}
+	return n.DB.WithContext(ctx).Exec("SELECT pg_notify(?, ?)", n.Channel, string(payload)).Error
+}
+
+// Listener receives the payloads of notifications on a Postgres channel
+// until ctx is done. It is implemented with the LISTEN support of the driver,
+// such as pgx.Conn.WaitForNotification or pq.Listener.
+type Listener interface {
+	Listen(ctx context.Context, channel string, fn func(payload string)) error
+}
+
+// Listen delivers the events notified on channel by other processes to the
+// subscriptions of b until ctx is done.
+func (b *Broker) Listen(ctx context.Context, l Listener, channel string) error {
+	if channel == "" {
+		channel = DefaultChannel
+	}
+	return l.Listen(ctx, channel, func(payload string) {
+		e, err := DecodeEvent([]byte(payload))
+		if err != nil {
+			if b.OnError != nil {
+				b.OnError(err)
+			}
+			return
+		}
+		b.Receive(e)
+	})
+}
+
+// DecodeEvent decodes the JSON of an event, keeping its object as a
+// json.RawMessage for the subscriptions to decode.
+func DecodeEvent(data []byte) (Event, error) {
+	type event Event
+	var e struct {
+		event
+		Object json.RawMessage `json:"object"`
+	}
+	if err := json.Unmarshal(data, &e); err != nil {
+		return Event{}, errors.Wrap(err, "decode event")
+	}
+	out := Event(e.event)
+	out.Object = e.Object
+	return out, nil
+}
@@ -0,0 +1,86 @@
+package gormdb
+
+import (
+	"context"
+
+	"github.com/heypkg/store"
+	"github.com/heypkg/store/audit"
+	"github.com/heypkg/store/echoadapter"
+	"github.com/heypkg/store/feed"
+	"github.com/heypkg/store/policy"
+	"github.com/labstack/echo/v4"
+	"github.com/pkg/errors"
+	"github.com/spf13/cast"
+	"gorm.io/gorm"
+)
+
+// History lists the audit records of obj, newest first. The records are not
+// cached, as the cache keys hold neither obj nor the writes to T.
+func History[T any](db *gorm.DB, ctx context.Context, obj *T, req store.ListRequest) ([]audit.Record, int64, error) {
+	db2, err := audit.History(db, obj)
+	if err != nil {
+		return nil, 0, store.Internal(err)
+	}
+	db2 = db2.Order("version DESC").Session(&gorm.Session{})
+	return list[audit.Record](db2, ctx, req)
+}
+
+// Revert restores obj to the given version of its audit history.
+func Revert[T any](db *gorm.DB, ctx context.Context, obj *T, version int) (*T, error) {
+	ctx, cancel := store.WithTimeout(ctx, 0)
+	defer cancel()
+	scope, err := getQueryScope[T](ctx, policy.ActionUpdate)
+	if err != nil {
+		return nil, err
+	}
+	err = scope.run(db.WithContext(ctx), func(db *gorm.DB) error {
+		if err := authorize(db, scope, policy.ActionUpdate, obj); err != nil {
+			return err
+		}
+		return audit.Revert(db, obj, version)
+	})
+	if err != nil {
+		if errors.Is(err, audit.ErrVersionNotFound) {
+			return nil, store.NotFound(err.Error())
+		}
+		if errors.Is(err, audit.ErrNoSnapshot) {
+			return nil, store.NewError(store.ErrPreconditionFailed, err.Error(), err)
+		}
+		return nil, writeError(ctx, err)
+	}
+	written[T](db, ctx, feed.Updated, obj, nil)
+	return obj, nil
+}
+
+// ListObjectHistory lists the audit records of the object loaded by
+// ObjectHandler or DeletedObjectHandler, filtered by the q query parameter.
+func ListObjectHistory[T any](db *gorm.DB, c echo.Context) ([]audit.Record, int64, error) {
+	obj := GetObjectFromEchoContext[T](c)
+	if obj == nil {
+		return nil, 0, echoadapter.HTTPError(store.NotFound("not found"))
+	}
+	ctx, err := echoadapter.Context(c)
+	if err != nil {
+		return nil, 0, echoadapter.HTTPError(err)
+	}
+	req := echoadapter.ListRequest(c)
+	req.Preload = ""
+	req.Include = ""
+	records, total, err := History(db, ctx, obj, req)
+	return records, total, echoadapter.HTTPError(err)
+}
+
+// RevertObject restores the object loaded by ObjectHandler or
+// DeletedObjectHandler to the version given by the version path parameter.
+func RevertObject[T any](db *gorm.DB, c echo.Context) (*T, error) {
+	obj := GetObjectFromEchoContext[T](c)
+	if obj == nil {
+		return nil, echoadapter.HTTPError(store.NotFound("not found"))
+	}
+	ctx, err := echoadapter.Context(c)
+	if err != nil {
+		return nil, echoadapter.HTTPError(err)
+	}
+	out, err := Revert(db, ctx, obj, cast.ToInt(c.Param("version")))
+	return out, echoadapter.HTTPError(err)
+}
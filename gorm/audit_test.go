@@ -0,0 +1,38 @@
+package gormdb_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/heypkg/store"
+	"github.com/heypkg/store/audit"
+	gormdb "github.com/heypkg/store/gorm"
+	"github.com/heypkg/store/storetest"
+)
+
+func TestRevert(t *testing.T) {
+	db := newDB(t)
+	if err := audit.Register(db); err != nil {
+		t.Fatal(err)
+	}
+	ctx := storetest.Context("a")
+	d := &storetest.Device{Name: "d1"}
+	if err := gormdb.Create(db, ctx, d); err != nil {
+		t.Fatal(err)
+	}
+	if err := gormdb.Update(db, ctx, d, map[string]any{"name": "d2"}); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := gormdb.Revert(db, ctx, d, 1); err != nil || got.Name != "d1" {
+		t.Fatalf("revert: %+v %v", got, err)
+	}
+	if _, err := gormdb.Revert(db, ctx, d, 9); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("revert to a missing version: %v", err)
+	}
+	if err := db.Unscoped().Delete(d).Error; err != nil {
+		t.Fatal(err)
+	}
+	if _, err := gormdb.Revert(db, ctx, d, 4); !errors.Is(err, store.ErrPreconditionFailed) {
+		t.Fatalf("revert to a delete: %v", err)
+	}
+}
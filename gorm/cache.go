@@ -0,0 +1,19 @@
+package gormdb
+
+import (
+	"context"
+
+	"github.com/heypkg/store/cache"
+	"gorm.io/gorm"
+)
+
+// listPage is a page of List in caches.
+type listPage[T any] struct {
+	Data  []T   `json:"data"`
+	Total int64 `json:"total"`
+}
+
+// invalidate drops the cached results of T.
+func invalidate[T any](db *gorm.DB, ctx context.Context) {
+	cache.Invalidate(ctx, cache.FromDB(db), cache.Model[T]())
+}
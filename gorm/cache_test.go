@@ -0,0 +1,92 @@
+package gormdb_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/heypkg/store"
+	"github.com/heypkg/store/audit"
+	"github.com/heypkg/store/cache"
+	gormdb "github.com/heypkg/store/gorm"
+	"github.com/heypkg/store/search"
+	"github.com/heypkg/store/storetest"
+	"gorm.io/gorm"
+)
+
+// countQueries returns the number of queries run through db.
+func countQueries(t *testing.T, db *gorm.DB) *int {
+	t.Helper()
+	queries := 0
+	if err := db.Callback().Query().Before("gorm:query").Register("test:count", func(*gorm.DB) { queries++ }); err != nil {
+		t.Fatal(err)
+	}
+	return &queries
+}
+
+func TestListCache(t *testing.T) {
+	db := newDB(t)
+	if err := cache.Use(db, cache.New(cache.NewFake(), time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	queries := countQueries(t, db)
+	ctx := storetest.Context("a")
+	if err := gormdb.Create(db, ctx, &storetest.Device{Name: "d1", Kind: "a"}); err != nil {
+		t.Fatal(err)
+	}
+	list := func(req store.ListRequest, want int64, wantQueries int) {
+		t.Helper()
+		*queries = 0
+		_, total, err := gormdb.List[storetest.Device](db, ctx, req)
+		if err != nil || total != want || *queries != wantQueries {
+			t.Fatalf("list %q: total %v, %v queries, %v; want %v, %v queries", req.Search, total, *queries, err, want, wantQueries)
+		}
+	}
+	list(store.ListRequest{Search: "kind:a"}, 1, 2)
+	list(store.ListRequest{Search: "kind:a"}, 1, 0)
+	if err := gormdb.Create(db, ctx, &storetest.Device{Name: "d2", Kind: "a"}); err != nil {
+		t.Fatal(err)
+	}
+	list(store.ListRequest{Search: "kind:a"}, 2, 2)
+
+	// the terms of search handlers depend on their caller
+	handlers := func(kind string) search.SearchDataHandleFuncMap {
+		return search.SearchDataHandleFuncMap{"mine": func([]search.SearchValue) (string, []any) {
+			return "kind = ?", []any{kind}
+		}}
+	}
+	list(store.ListRequest{Search: "mine:1", HandleFuncs: handlers("a")}, 2, 2)
+	list(store.ListRequest{Search: "mine:1", HandleFuncs: handlers("b")}, 0, 1)
+}
+
+func TestHistoryCache(t *testing.T) {
+	db := newDB(t)
+	if err := audit.Register(db); err != nil {
+		t.Fatal(err)
+	}
+	if err := cache.Use(db, cache.New(cache.NewFake(), time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	ctx := storetest.Context("a")
+	d1, d2 := &storetest.Device{Name: "d1"}, &storetest.Device{Name: "d2"}
+	for _, d := range []*storetest.Device{d1, d2} {
+		if err := gormdb.Create(db, ctx, d); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := gormdb.Update(db, ctx, d1, map[string]any{"value": 1}); err != nil {
+		t.Fatal(err)
+	}
+	history := func(d *storetest.Device, want int64) {
+		t.Helper()
+		records, total, err := gormdb.History(db, ctx, d, store.ListRequest{})
+		if err != nil || total != want || int64(len(records)) != want || records[0].Version != int(want) {
+			t.Fatalf("history of %v: %v records, %v; want %v", d.Name, total, err, want)
+		}
+	}
+	history(d1, 2)
+	history(d2, 1)
+	if err := gormdb.Update(db, ctx, d2, map[string]any{"value": 2}); err != nil {
+		t.Fatal(err)
+	}
+	history(d2, 2)
+}
@@ -0,0 +1,158 @@
+package gormdb
+
+import (
+	"context"
+
+	"github.com/heypkg/store"
+	"github.com/heypkg/store/echoadapter"
+	"github.com/heypkg/store/feed"
+	"github.com/heypkg/store/policy"
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+func Create[T any](db *gorm.DB, ctx context.Context, obj *T) error {
+	ctx, cancel := store.WithTimeout(ctx, 0)
+	defer cancel()
+	scope, err := getQueryScope[T](ctx, policy.ActionCreate)
+	if err != nil {
+		return err
+	}
+	return written[T](db, ctx, feed.Created, obj, writeError(ctx, scope.run(db.WithContext(ctx), func(db *gorm.DB) error {
+		if err := scope.tenancy.Assign(db, obj, nil); err != nil {
+			return err
+		}
+		if err := authorize(db, scope, policy.ActionCreate, obj); err != nil {
+			return err
+		}
+		return db.Create(obj).Error
+	})))
+}
+
+// Update saves all fields of obj, or only the given values when values
+// is not empty. The tenant of obj cannot be changed, and objects of other
+// tenants are not found.
+func Update[T any](db *gorm.DB, ctx context.Context, obj *T, values map[string]any) error {
+	ctx, cancel := store.WithTimeout(ctx, 0)
+	defer cancel()
+	scope, err := getQueryScope[T](ctx, policy.ActionUpdate)
+	if err != nil {
+		return err
+	}
+	return written[T](db, ctx, feed.Updated, obj, writeError(ctx, scope.run(db.WithContext(ctx), func(db *gorm.DB) error {
+		if err := scope.tenancy.Assign(db, obj, values); err != nil {
+			return err
+		}
+		if err := authorize(db, scope, policy.ActionUpdate, obj); err != nil {
+			return err
+		}
+		var result *gorm.DB
+		if len(values) == 0 {
+			result = scope.apply(db.Model(obj)).Select("*").Updates(obj)
+		} else {
+			result = scope.apply(db.Model(obj)).Updates(values)
+		}
+		if result.Error != nil || result.RowsAffected > 0 {
+			return result.Error
+		}
+		// MySQL does not count the rows whose values did not change
+		return exists(db, scope, obj)
+	})))
+}
+
+// exists returns a not found error when obj is not in the scope. Deleted
+// objects are found when db is unscoped.
+func exists[T any](db *gorm.DB, scope queryScope, obj *T) error {
+	var model T
+	var count int64
+	stmt := &gorm.Statement{DB: db}
+	if err := stmt.Parse(obj); err != nil {
+		return store.Internal(err)
+	}
+	db2 := db.Session(&gorm.Session{NewDB: true})
+	if db.Statement.Unscoped {
+		db2 = db2.Unscoped()
+	}
+	db2 = scope.apply(db2.Model(&model))
+	for _, field := range stmt.Schema.PrimaryFields {
+		v, _ := field.ValueOf(db.Statement.Context, reflectValueOf(obj))
+		db2 = db2.Where(db.Statement.Quote(field.DBName)+" = ?", v)
+	}
+	if err := db2.Count(&count).Error; err != nil {
+		return err
+	}
+	if count == 0 {
+		return store.NotFound("not found")
+	}
+	return nil
+}
+
+func Delete[T any](db *gorm.DB, ctx context.Context, obj *T) error {
+	ctx, cancel := store.WithTimeout(ctx, 0)
+	defer cancel()
+	scope, err := getQueryScope[T](ctx, policy.ActionDelete)
+	if err != nil {
+		return err
+	}
+	return written[T](db, ctx, feed.Deleted, obj, writeError(ctx, scope.run(db.WithContext(ctx), func(db *gorm.DB) error {
+		if err := authorize(db, scope, policy.ActionDelete, obj); err != nil {
+			return err
+		}
+		result := scope.apply(db.Model(obj)).Delete(obj)
+		if result.Error != nil || result.RowsAffected > 0 {
+			return result.Error
+		}
+		return exists(db, scope, obj)
+	})))
+}
+
+func Restore[T any](db *gorm.DB, ctx context.Context, obj *T) error {
+	ctx, cancel := store.WithTimeout(ctx, 0)
+	defer cancel()
+	scope, err := getQueryScope[T](ctx, policy.ActionRestore)
+	if err != nil {
+		return err
+	}
+	return written[T](db, ctx, feed.Restored, obj, writeError(ctx, scope.run(db.WithContext(ctx), func(db *gorm.DB) error {
+		if err := authorize(db, scope, policy.ActionRestore, obj); err != nil {
+			return err
+		}
+		result := scope.apply(db.Unscoped().Model(obj)).Update("deleted", nil)
+		if result.Error != nil || result.RowsAffected > 0 {
+			return result.Error
+		}
+		return exists(db.Unscoped(), scope, obj)
+	})))
+}
+
+func CreateObject[T any](db *gorm.DB, c echo.Context, obj *T) error {
+	ctx, err := echoadapter.Context(c)
+	if err != nil {
+		return echoadapter.HTTPError(err)
+	}
+	return echoadapter.HTTPError(Create(db, ctx, obj))
+}
+
+func UpdateObject[T any](db *gorm.DB, c echo.Context, obj *T, values map[string]any) error {
+	ctx, err := echoadapter.Context(c)
+	if err != nil {
+		return echoadapter.HTTPError(err)
+	}
+	return echoadapter.HTTPError(Update(db, ctx, obj, values))
+}
+
+func DeleteObject[T any](db *gorm.DB, c echo.Context, obj *T) error {
+	ctx, err := echoadapter.Context(c)
+	if err != nil {
+		return echoadapter.HTTPError(err)
+	}
+	return echoadapter.HTTPError(Delete(db, ctx, obj))
+}
+
+func RestoreObject[T any](db *gorm.DB, c echo.Context, obj *T) error {
+	ctx, err := echoadapter.Context(c)
+	if err != nil {
+		return echoadapter.HTTPError(err)
+	}
+	return echoadapter.HTTPError(Restore(db, ctx, obj))
+}
@@ -0,0 +1,52 @@
+package gormdb_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/heypkg/store"
+	gormdb "github.com/heypkg/store/gorm"
+	"github.com/heypkg/store/storetest"
+)
+
+func TestUpdateTenant(t *testing.T) {
+	db := newDB(t)
+	ctxA, ctxB := storetest.Context("a"), storetest.Context("b")
+	d := &storetest.Device{Name: "d1", Kind: "a", Value: 1}
+	if err := gormdb.Create(db, ctxA, d); err != nil {
+		t.Fatal(err)
+	}
+
+	// tenant b writes over the object of tenant a by its id
+	other := &storetest.Device{ID: d.ID, Name: "stolen", Kind: "b"}
+	for _, values := range []map[string]any{nil, {"name": "stolen"}} {
+		err := gormdb.Update(db, ctxB, other, values)
+		if !errors.Is(err, store.ErrNotFound) {
+			t.Fatalf("update of another tenant with %v: %v", values, err)
+		}
+	}
+	if _, err := gormdb.Get[storetest.Device](db, ctxB, store.GetRequest{Conditions: map[string]any{"id": d.ID}}); err == nil {
+		t.Fatal("get of another tenant")
+	}
+	got, err := gormdb.Get[storetest.Device](db, ctxA, store.GetRequest{Conditions: map[string]any{"id": d.ID}})
+	if err != nil || got.Name != "d1" || got.Schema != "a" {
+		t.Fatalf("object of tenant a changed: %+v %v", got, err)
+	}
+	var count int64
+	db.Model(&storetest.Device{}).Count(&count)
+	if count != 1 {
+		t.Fatalf("update added objects: %v", count)
+	}
+
+	// unchanged values of an existing object are not a miss
+	if err := gormdb.Update(db, ctxA, got, map[string]any{"name": "d1"}); err != nil {
+		t.Fatal(err)
+	}
+	got.Value = 2
+	if err := gormdb.Update(db, ctxA, got, nil); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ = gormdb.Get[storetest.Device](db, ctxA, store.GetRequest{Conditions: map[string]any{"id": d.ID}}); got.Value != 2 {
+		t.Fatalf("update all fields: %+v", got)
+	}
+}
@@ -0,0 +1,74 @@
+package gormdb
+
+import (
+	"context"
+	"strings"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/heypkg/store"
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+)
+
+// SQLite extended result codes of constraint violations.
+const (
+	sqliteConstraintForeignKey = 787
+	sqliteConstraintPrimaryKey = 1555
+	sqliteConstraintUnique     = 2067
+)
+
+// TranslateError maps unique and foreign key violations of the MySQL,
+// Postgres and SQLite drivers to gorm.ErrDuplicatedKey and
+// gorm.ErrForeignKeyViolated. Other errors are returned as they are.
+func TranslateError(err error) error {
+	if err == nil || errors.Is(err, gorm.ErrDuplicatedKey) || errors.Is(err, gorm.ErrForeignKeyViolated) {
+		return err
+	}
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) {
+		switch mysqlErr.Number {
+		case 1062, 1586:
+			return errors.Wrap(gorm.ErrDuplicatedKey, err.Error())
+		case 1216, 1217, 1451, 1452:
+			return errors.Wrap(gorm.ErrForeignKeyViolated, err.Error())
+		}
+		return err
+	}
+	// pgx and lib/pq
+	var pgErr interface{ SQLState() string }
+	if errors.As(err, &pgErr) {
+		switch pgErr.SQLState() {
+		case "23505":
+			return errors.Wrap(gorm.ErrDuplicatedKey, err.Error())
+		case "23503":
+			return errors.Wrap(gorm.ErrForeignKeyViolated, err.Error())
+		}
+		return err
+	}
+	// modernc.org/sqlite and github.com/glebarez/sqlite
+	var sqliteErr interface{ Code() int }
+	if errors.As(err, &sqliteErr) {
+		switch sqliteErr.Code() {
+		case sqliteConstraintUnique, sqliteConstraintPrimaryKey:
+			return errors.Wrap(gorm.ErrDuplicatedKey, err.Error())
+		case sqliteConstraintForeignKey:
+			return errors.Wrap(gorm.ErrForeignKeyViolated, err.Error())
+		}
+		return err
+	}
+	// github.com/mattn/go-sqlite3 reports extended codes in its message only
+	msg := err.Error()
+	switch {
+	case strings.Contains(msg, "UNIQUE constraint failed"):
+		return errors.Wrap(gorm.ErrDuplicatedKey, msg)
+	case strings.Contains(msg, "FOREIGN KEY constraint failed"):
+		return errors.Wrap(gorm.ErrForeignKeyViolated, msg)
+	}
+	return err
+}
+
+// writeError returns err as a store error with driver errors translated.
+// Errors after the deadline of ctx are timeouts.
+func writeError(ctx context.Context, err error) error {
+	return store.ContextError(ctx, store.WrapError(TranslateError(err)))
+}
@@ -0,0 +1,21 @@
+package gormdb
+
+import (
+	"context"
+
+	"github.com/heypkg/store/feed"
+	"gorm.io/gorm"
+)
+
+// written invalidates the cached results of T and publishes the change of
+// obj after a successful write. It returns the error of the write.
+func written[T any](db *gorm.DB, ctx context.Context, typ feed.EventType, obj *T, err error) error {
+	if err != nil {
+		return err
+	}
+	invalidate[T](db, ctx)
+	if b := feed.FromDB(db); b != nil {
+		b.Publish(ctx, feed.NewEvent(ctx, typ, obj))
+	}
+	return nil
+}
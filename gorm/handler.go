@@ -1,93 +1,276 @@
 package gormdb
 
 import (
+	"context"
 	"fmt"
-	"net/http"
-	"strings"
-	"time"
+	"sort"
 
-	"github.com/heypkg/store/jsontype"
+	"github.com/heypkg/store"
+	"github.com/heypkg/store/cache"
+	"github.com/heypkg/store/echoadapter"
+	"github.com/heypkg/store/metrics"
+	"github.com/heypkg/store/policy"
+	"github.com/heypkg/store/savedsearch"
 	"github.com/heypkg/store/search"
+	"github.com/heypkg/store/telemetry"
 	"github.com/heypkg/store/utils"
 	"github.com/labstack/echo/v4"
 	"github.com/pkg/errors"
-	"github.com/spf13/cast"
 	"gorm.io/gorm"
 )
 
-func ListObjects[T any](db *gorm.DB, c echo.Context, selectNames []string, handleFuncs map[string]search.SearchDataHandleFunc) ([]T, int64, error) {
-	var err error
-	var obj T
-
-	db2 := db.Model(&obj)
-	db2, err = appendToTotalParamsToDBWithHandlers(db2, c, handleFuncs)
+// List returns a page of the objects selected by req and the total number of
+// matching objects. Pages are cached by the cache registered with db, but
+// for requests with search handlers, which the cache keys do not hold.
+func List[T any](db *gorm.DB, ctx context.Context, req store.ListRequest) ([]T, int64, error) {
+	req, err := applyView[T](db, ctx, req)
 	if err != nil {
 		return nil, 0, err
 	}
-	var total int64
-	if err := db2.Count(&total).Error; err != nil {
-		return nil, 0, err
+	if len(req.HandleFuncs) > 0 {
+		return list[T](db, ctx, req)
 	}
+	page, err := cache.Load(ctx, cache.FromDB(db), cache.Model[T](), cache.ListKey(req), func() (listPage[T], error) {
+		data, total, err := list[T](db, ctx, req)
+		return listPage[T]{Data: data, Total: total}, err
+	})
+	return page.Data, page.Total, err
+}
 
-	db2 = db.Model(&obj)
-	if len(selectNames) > 0 {
-		db2 = db2.Select(selectNames)
-	}
-	db2, err = appendToListParamsToDBWithHandlers(db2, c, int(total), handleFuncs)
+func list[T any](db *gorm.DB, ctx context.Context, req store.ListRequest) ([]T, int64, error) {
+	ctx, cancel := store.WithTimeout(ctx, req.Timeout)
+	defer cancel()
+	ctx, op := startOperation[T](ctx, metrics.OpList)
+	var data []T
+	var total int64
+	var err error
+	defer func() { op.end(err, len(data), total) }()
+	scope, err := getQueryScope[T](ctx, policy.ActionList)
 	if err != nil {
 		return nil, 0, err
 	}
-	preloads := strings.Split(cast.ToString(c.Get("preload")), ",")
-	for _, preload := range preloads {
-		if preload != "" {
-			db2.Preload(preload)
+	err = scope.read(ctx, db, func(db *gorm.DB) error {
+		var err error
+		var obj T
+
+		db2 := listModelDB(db, &obj, req)
+		db2, err = appendToTotalParamsToDBWithHandlers(db2, req, scope)
+		if err != nil {
+			return err
 		}
-	}
-	var data []T
-	if result := db2.Find(&data); result.Error != nil {
-		return nil, 0, err
+		if err := count(ctx, op.model, &total, func(total *int64) error { return db2.Count(total).Error }); err != nil {
+			return err
+		}
+		if total == 0 {
+			return nil
+		}
+
+		db2 = listModelDB(db, &obj, req)
+		if len(req.Select) > 0 {
+			db2 = db2.Select(req.Select)
+		}
+		db2, err = appendToListParamsToDBWithHandlers(db2, req, scope, int(total))
+		if err != nil {
+			return err
+		}
+		db2, err = appendPreloadsToDB[T](db2, req.Preload, req.Include, scope)
+		if err != nil {
+			return err
+		}
+		_, querySpan := telemetry.Start(ctx, "store.query")
+		err = db2.Find(&data).Error
+		endSpan(querySpan, err, len(data), -1)
+		return err
+	})
+	if err != nil {
+		return nil, 0, writeError(ctx, err)
 	}
 	return data, total, nil
 }
 
-func ListDeletedObjects[T any](db *gorm.DB, c echo.Context, selectNames []string, handleFuncs map[string]search.SearchDataHandleFunc) ([]T, int64, error) {
+// Scan calls fn with the objects selected by req, read from a server-side
+// cursor. Page, PageSize and preloads are ignored.
+func Scan[T any](db *gorm.DB, ctx context.Context, req store.ListRequest, fn func(obj *T) error) error {
+	ctx, cancel := store.WithTimeout(ctx, req.Timeout)
+	defer cancel()
+	ctx, op := startOperation[T](ctx, metrics.OpScan)
+	rows := 0
 	var err error
-	var obj T
-	db2 := db.Model(&obj).Unscoped().Where("deleted IS NOT NULL")
-	db, err = appendToTotalParamsToDBWithHandlers(db2, c, handleFuncs)
+	defer func() { op.end(err, rows, -1) }()
+	scope, err := getQueryScope[T](ctx, policy.ActionList)
 	if err != nil {
-		return nil, 0, err
+		return err
 	}
+	if req, err = applyView[T](db, ctx, req); err != nil {
+		return err
+	}
+	req.Page, req.PageSize = 0, 0
+	// errors of fn are returned as they are
+	var fnErr error
+	err = scope.read(ctx, db, func(db *gorm.DB) error {
+		var obj T
+		db2 := listModelDB(db, &obj, req)
+		if len(req.Select) > 0 {
+			db2 = db2.Select(req.Select)
+		}
+		db2, err := appendToListParamsToDBWithHandlers(db2, req, scope, 0)
+		if err != nil {
+			return err
+		}
+		cursor, err := db2.Rows()
+		if err != nil {
+			return err
+		}
+		defer cursor.Close()
+		for cursor.Next() {
+			var obj T
+			if err := db2.ScanRows(cursor, &obj); err != nil {
+				return err
+			}
+			rows++
+			if fnErr = fn(&obj); fnErr != nil {
+				return fnErr
+			}
+		}
+		return cursor.Err()
+	})
+	if fnErr != nil {
+		return fnErr
+	}
+	return writeError(ctx, err)
+}
+
+func Count[T any](db *gorm.DB, ctx context.Context, req store.ListRequest) (int64, error) {
+	ctx, cancel := store.WithTimeout(ctx, req.Timeout)
+	defer cancel()
+	ctx, op := startOperation[T](ctx, metrics.OpCount)
 	var total int64
-	var data []T
+	var err error
+	defer func() { op.end(err, -1, total) }()
+	scope, err := getQueryScope[T](ctx, policy.ActionList)
+	if err != nil {
+		return 0, err
+	}
+	if req, err = applyView[T](db, ctx, req); err != nil {
+		return 0, err
+	}
+	err = scope.read(ctx, db, func(db *gorm.DB) error {
+		var obj T
+		db2, err := appendToTotalParamsToDBWithHandlers(listModelDB(db, &obj, req), req, scope)
+		if err != nil {
+			return err
+		}
+		return db2.Count(&total).Error
+	})
+	if err != nil {
+		return 0, writeError(ctx, err)
+	}
+	return total, nil
+}
 
-	if err := db2.Count(&total).Error; err != nil {
-		return nil, 0, err
+// applyView extends req with the saved search of its view.
+func applyView[T any](db *gorm.DB, ctx context.Context, req store.ListRequest) (store.ListRequest, error) {
+	if req.View == "" {
+		return req, nil
 	}
-	if total == 0 {
-		return data, 0, nil
+	s := savedsearch.FromDB(db)
+	if s == nil {
+		return req, store.NewError(store.ErrInvalidQuery, "views are not enabled", nil)
 	}
-	db2 = db.Model(&obj).Unscoped().Where("deleted IS NOT NULL")
-	if len(selectNames) > 0 {
-		db2 = db2.Select(selectNames)
+	return s.Apply(ctx, savedsearch.Model[T](), req)
+}
+
+func listModelDB(db *gorm.DB, obj any, req store.ListRequest) *gorm.DB {
+	if req.Deleted {
+		return db.Model(obj).Unscoped().Where("deleted IS NOT NULL")
 	}
+	return db.Model(obj)
+}
 
-	db2, err = appendToListParamsToDBWithHandlers(db2, c, int(total), handleFuncs)
+// Get loads the object whose columns equal req.Conditions. Objects are cached
+// by the cache registered with db.
+func Get[T any](db *gorm.DB, ctx context.Context, req store.GetRequest) (*T, error) {
+	return cache.Load(ctx, cache.FromDB(db), cache.Model[T](), cache.GetKey(req), func() (*T, error) {
+		return get[T](db, ctx, req)
+	})
+}
+
+func get[T any](db *gorm.DB, ctx context.Context, req store.GetRequest) (*T, error) {
+	ctx, cancel := store.WithTimeout(ctx, req.Timeout)
+	defer cancel()
+	ctx, op := startOperation[T](ctx, metrics.OpGet)
+	var obj T
+	var err error
+	defer func() {
+		rows := 1
+		if err != nil {
+			rows = 0
+		}
+		op.end(err, rows, -1)
+	}()
+	scope, err := getQueryScope[T](ctx, policy.ActionRead)
 	if err != nil {
-		return nil, 0, err
+		return nil, err
 	}
-
-	preloads := strings.Split(cast.ToString(c.Get("preload")), ",")
-	for _, preload := range preloads {
-		if preload != "" {
-			db2.Preload(preload)
+	err = scope.run(db.WithContext(ctx), func(db *gorm.DB) error {
+		db2, err := appendPreloadsToDB[T](db, req.Preload, req.Include, scope)
+		if err != nil {
+			return err
+		}
+		db2 = db2.Session(&gorm.Session{})
+		names := make([]string, 0, len(req.Conditions))
+		for name := range req.Conditions {
+			names = append(names, name)
 		}
+		sort.Strings(names)
+		return findObject(scope, policy.ActionRead, &obj, func() *gorm.DB {
+			db3 := db2.Model(&obj)
+			if req.Deleted {
+				db3 = db3.Unscoped().Where("deleted IS NOT NULL")
+			}
+			for _, name := range names {
+				db3 = db3.Where(db.Statement.Quote(name)+" = ?", req.Conditions[name])
+			}
+			return db3
+		})
+	})
+	if err != nil {
+		return nil, writeError(ctx, err)
 	}
+	return &obj, nil
+}
 
-	if result := db2.Find(&data); result.Error != nil {
-		return nil, 0, err
+func ListObjects[T any](db *gorm.DB, c echo.Context, selectNames []string, handleFuncs map[string]search.SearchDataHandleFunc) ([]T, int64, error) {
+	ctx, err := echoadapter.Context(c)
+	if err != nil {
+		return nil, 0, echoadapter.HTTPError(err)
 	}
-	return data, total, nil
+	req := echoadapter.ListRequest(c)
+	req.Select = selectNames
+	req.HandleFuncs = handleFuncs
+	data, total, err := listObjects[T](db, c, ctx, req)
+	return data, total, err
+}
+
+func ListDeletedObjects[T any](db *gorm.DB, c echo.Context, selectNames []string, handleFuncs map[string]search.SearchDataHandleFunc) ([]T, int64, error) {
+	ctx, err := echoadapter.Context(c)
+	if err != nil {
+		return nil, 0, echoadapter.HTTPError(err)
+	}
+	req := echoadapter.ListRequest(c)
+	req.Select = selectNames
+	req.HandleFuncs = handleFuncs
+	req.Deleted = true
+	data, total, err := listObjects[T](db, c, ctx, req)
+	return data, total, err
+}
+
+// listObjects lists the objects of req and sets the cache headers of c.
+func listObjects[T any](db *gorm.DB, c echo.Context, ctx context.Context, req store.ListRequest) ([]T, int64, error) {
+	ctx, info := cache.WithInfo(ctx)
+	data, total, err := List[T](db, ctx, req)
+	info.SetHeaders(c.Response().Header())
+	return data, total, err
 }
 
 func GetObjectFromEchoContext[T any](c echo.Context) *T {
@@ -101,133 +284,108 @@ func GetObjectFromEchoContext[T any](c echo.Context) *T {
 	return nil
 }
 
-func ObjectHandler[T any](db *gorm.DB) echo.MiddlewareFunc {
+func objectHandler[T any](db *gorm.DB, getRequest func(c echo.Context) store.GetRequest) echo.MiddlewareFunc {
 	return func(next echo.HandlerFunc) echo.HandlerFunc {
 		return func(c echo.Context) error {
 			var obj T
 			key := utils.GetRawTypeName(obj)
-			schema := cast.ToString(c.Get("schema"))
-			id := cast.ToUint(c.Param("id"))
-
-			db2 := db
-			preloads := strings.Split(cast.ToString(c.Get("preload")), ",")
-			for _, preload := range preloads {
-				if preload != "" {
-					db2.Preload(preload)
-				}
+			ctx, err := echoadapter.Context(c)
+			if err != nil {
+				return echoadapter.HTTPError(err)
 			}
-
-			result := db2.Where("schema = ? AND id = ?", schema, id).First(&obj)
-
-			if result.Error != nil {
-				if errors.Is(result.Error, gorm.ErrRecordNotFound) {
-					return echo.NewHTTPError(http.StatusNotFound, "not found")
-				} else {
-					return echo.NewHTTPError(http.StatusInternalServerError, result.Error)
-				}
-			} else {
-				c.Set(key, &obj)
+			ctx, info := cache.WithInfo(ctx)
+			out, err := Get[T](db, ctx, getRequest(c))
+			if err != nil {
+				return echoadapter.HTTPError(err)
 			}
+			info.SetHeaders(c.Response().Header())
+			c.Set(key, out)
 			return next(c)
 		}
 	}
 }
 
+func ObjectHandler[T any](db *gorm.DB) echo.MiddlewareFunc {
+	return objectHandler[T](db, echoadapter.GetRequest)
+}
+
 func DeletedObjectHandler[T any](db *gorm.DB) echo.MiddlewareFunc {
-	return func(next echo.HandlerFunc) echo.HandlerFunc {
-		return func(c echo.Context) error {
-			var obj T
-			key := utils.GetRawTypeName(obj)
-			schema := cast.ToString(c.Get("schema"))
-			id := cast.ToUint(c.Param("id"))
-
-			db2 := db.Unscoped()
-			result := db2.Where("schema = ? AND id = ? AND deleted IS NOT NULL", schema, id).First(&obj)
-
-			if result.Error != nil {
-				if errors.Is(result.Error, gorm.ErrRecordNotFound) {
-					return echo.NewHTTPError(http.StatusNotFound, "not found")
-				} else {
-					return echo.NewHTTPError(http.StatusInternalServerError, result.Error)
-				}
-			} else {
-				c.Set(key, &obj)
-			}
-			return next(c)
-		}
-	}
+	return objectHandler[T](db, echoadapter.DeletedGetRequest)
 }
 
 func TSObjectHandler[T any](db *gorm.DB) echo.MiddlewareFunc {
-	return func(next echo.HandlerFunc) echo.HandlerFunc {
-		return func(c echo.Context) error {
-			var obj T
-			key := utils.GetRawTypeName(obj)
-			schema := cast.ToString(c.Get("schema"))
-			ts := cast.ToInt64(c.Param("ts"))
-
-			db2 := db
-			result := db2.Where("schema = ? AND time = ?", schema, jsontype.JSONTime(time.Unix(0, ts*int64(time.Microsecond)))).First(&obj)
-			if result.Error != nil {
-				if errors.Is(result.Error, gorm.ErrRecordNotFound) {
-					return echo.NewHTTPError(http.StatusNotFound, "not found")
-				} else {
-					return echo.NewHTTPError(http.StatusInternalServerError, result.Error)
-				}
-			} else {
-				c.Set(key, &obj)
-			}
-			return next(c)
-		}
-	}
+	return objectHandler[T](db, echoadapter.TSGetRequest)
 }
 
-func ListAnyObjects(db *gorm.DB, c echo.Context, tableName string, handleFuncs map[string]search.SearchDataHandleFunc) ([]map[string]any, int64, error) {
-	var err error
-	db2 := db
-
-	where, args, err := getTotalParamsToStringWithHandlers(c, handleFuncs)
-	if err != nil {
-		return nil, 0, err
-	}
-
+// ListAny lists the rows of a table without a model. Only the tenant is
+// applied; policies are registered per model.
+func ListAny(db *gorm.DB, ctx context.Context, tableName string, req store.ListRequest) ([]map[string]any, int64, error) {
+	ctx, cancel := store.WithTimeout(ctx, req.Timeout)
+	defer cancel()
+	ctx, op := startModelOperation(ctx, metrics.OpList, tableName)
+	scope := getTenantQueryScope(ctx)
 	var total int64
 	records := []map[string]any{}
+	var err error
+	defer func() { op.end(err, len(records), total) }()
+	err = scope.read(ctx, db, func(db2 *gorm.DB) error {
+		where, args, err := getTotalParamsToStringWithHandlers(ctx, req, scope)
+		if err != nil {
+			return err
+		}
 
-	q := fmt.Sprintf("select count(*) from %v where %v", tableName, where)
-	if err := db2.Debug().Raw(q, args...).Scan(&total).Error; err != nil {
-		return nil, 0, err
-	}
-	if total == 0 {
-		return records, 0, nil
-	}
+		q := fmt.Sprintf("select count(*) from %v where %v", tableName, where)
+		if err := count(ctx, tableName, &total, func(total *int64) error { return db2.Raw(q, args...).Scan(total).Error }); err != nil {
+			return err
+		}
+		if total == 0 {
+			return nil
+		}
 
-	where, args, err = getListParamsToStringWithHandlers(c, int(total), handleFuncs)
-	if err != nil {
-		return nil, 0, err
-	}
+		where, args, err = getListParamsToStringWithHandlers(ctx, req, scope, int(total))
+		if err != nil {
+			return err
+		}
 
-	q = fmt.Sprintf("select * from %v where %v", tableName, where)
-	rows, err := db2.Debug().Raw(q, args...).Rows()
-	if err != nil {
-		return nil, 0, errors.Wrap(err, "query")
-	}
-	defer rows.Close()
-	columns, _ := rows.Columns()
-	values := make([]any, len(columns))
-	for i := range values {
-		values[i] = new(any)
-	}
-	for rows.Next() {
-		err := rows.Scan(values...)
+		q = fmt.Sprintf("select * from %v where %v", tableName, where)
+		_, querySpan := telemetry.Start(ctx, "store.query")
+		defer func() { endSpan(querySpan, err, len(records), -1) }()
+		rows, err := db2.Raw(q, args...).Rows()
 		if err != nil {
-			return nil, 0, errors.Wrap(err, "scan record")
+			return errors.Wrap(err, "query")
+		}
+		defer rows.Close()
+		columns, _ := rows.Columns()
+		values := make([]any, len(columns))
+		for i := range values {
+			values[i] = new(any)
 		}
-		record := map[string]any{}
-		for i, column := range columns {
-			record[column] = *values[i].(*any)
+		for rows.Next() {
+			err := rows.Scan(values...)
+			if err != nil {
+				return errors.Wrap(err, "scan record")
+			}
+			record := map[string]any{}
+			for i, column := range columns {
+				record[column] = *values[i].(*any)
+			}
+			records = append(records, record)
 		}
-		records = append(records, record)
+		return nil
+	})
+	if err != nil {
+		return nil, 0, writeError(ctx, err)
 	}
 	return records, total, nil
 }
+
+func ListAnyObjects(db *gorm.DB, c echo.Context, tableName string, handleFuncs map[string]search.SearchDataHandleFunc) ([]map[string]any, int64, error) {
+	ctx, err := echoadapter.Context(c)
+	if err != nil {
+		return nil, 0, echoadapter.HTTPError(err)
+	}
+	req := echoadapter.ListRequest(c)
+	req.HandleFuncs = handleFuncs
+	data, total, err := ListAny(db, ctx, tableName, req)
+	return data, total, err
+}
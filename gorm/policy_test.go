@@ -0,0 +1,56 @@
+package gormdb_test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/heypkg/store"
+	gormdb "github.com/heypkg/store/gorm"
+	"github.com/heypkg/store/policy"
+	"github.com/heypkg/store/storetest"
+)
+
+type note struct {
+	ID     uint `gorm:"primarykey"`
+	Schema string
+	Owner  string
+	Text   string
+}
+
+func TestPolicyDeniedStatus(t *testing.T) {
+	db := newDB(t)
+	if err := db.AutoMigrate(&note{}); err != nil {
+		t.Fatal(err)
+	}
+	owner := policy.WithSubject(storetest.Context("a"), policy.Subject{ID: "u1"})
+	other := policy.WithSubject(storetest.Context("a"), policy.Subject{ID: "u2"})
+	for _, denied := range []int{http.StatusForbidden, http.StatusNotFound} {
+		policy.Register[note](policy.OwnerPolicy{OwnerColumn: "owner"}, denied)
+		n := &note{Owner: "u1", Text: "x"}
+		if err := gormdb.Create(db, owner, n); err != nil {
+			t.Fatal(err)
+		}
+		id := map[string]any{"id": n.ID}
+		if _, err := gormdb.Get[note](db, owner, store.GetRequest{Conditions: id}); err != nil {
+			t.Fatalf("get of the owner: %v", err)
+		}
+
+		status := func(name string, err error, want int) {
+			t.Helper()
+			if got := store.ErrorOf(err).Status; err == nil || got != want {
+				t.Errorf("%s with denied status %v: %v (%v), want %v", name, denied, got, err, want)
+			}
+		}
+		_, err := gormdb.Get[note](db, other, store.GetRequest{Conditions: id})
+		status("get", err, denied)
+		status("update", gormdb.Update(db, other, &note{ID: n.ID, Text: "y"}, map[string]any{"text": "y"}), denied)
+		status("delete", gormdb.Delete(db, other, &note{ID: n.ID}), denied)
+		_, err = gormdb.Get[note](db, other, store.GetRequest{Conditions: map[string]any{"id": n.ID + 100}})
+		status("get of a missing object", err, http.StatusNotFound)
+
+		got, err := gormdb.Get[note](db, owner, store.GetRequest{Conditions: id})
+		if err != nil || got.Text != "x" {
+			t.Fatalf("object changed by a denied write: %+v %v", got, err)
+		}
+	}
+}
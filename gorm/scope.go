@@ -0,0 +1,192 @@
+package gormdb
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+
+	"github.com/heypkg/store"
+	"github.com/heypkg/store/policy"
+	"github.com/heypkg/store/replica"
+	"github.com/heypkg/store/search"
+	"github.com/heypkg/store/tenancy"
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+)
+
+// queryScope holds the conditions the server adds to every query of a
+// request: the tenant and the row-level policy of the model.
+type queryScope struct {
+	tenancy      tenancy.Scope
+	policy       *policy.Registration
+	subject      policy.Subject
+	filter       policy.Filter
+	filterSearch search.SearchData
+}
+
+// getQueryScope reads the tenant and the policy subject of the request from
+// ctx.
+func getQueryScope[T any](ctx context.Context, action policy.Action) (queryScope, error) {
+	var err error
+	scope := queryScope{tenancy: tenancy.ScopeFromContext(ctx), policy: policy.Lookup[T]()}
+	if scope.policy == nil {
+		return scope, nil
+	}
+	scope.subject = policy.SubjectFromContext(ctx)
+	if action == policy.ActionList {
+		if err := scope.allow(action, nil); err != nil {
+			return queryScope{}, err
+		}
+	}
+	scope.filter, err = scope.policy.Policy.Filter(scope.subject, action)
+	if err != nil {
+		return queryScope{}, store.Internal(err)
+	}
+	scope.filterSearch, err = scope.filter.SearchData()
+	if err != nil {
+		return queryScope{}, store.Internal(err)
+	}
+	return scope, nil
+}
+
+func getTenantQueryScope(ctx context.Context) queryScope {
+	return queryScope{tenancy: tenancy.ScopeFromContext(ctx)}
+}
+
+func (s queryScope) run(db *gorm.DB, fn func(db *gorm.DB) error) error {
+	return runWithTenancyScope(db, s.tenancy, fn)
+}
+
+// read runs fn like run, on a replica of db when one is registered.
+func (s queryScope) read(ctx context.Context, db *gorm.DB, fn func(db *gorm.DB) error) error {
+	return replica.Run(ctx, db, func(db *gorm.DB) error {
+		return s.run(db.WithContext(ctx), fn)
+	})
+}
+
+func (s queryScope) apply(db *gorm.DB) *gorm.DB {
+	db = s.tenancy.Apply(db)
+	if len(s.filterSearch) > 0 {
+		db = s.filterSearch.ResolveTimes(search.ModelTimes(db)).SearchDB(db, nil)
+	}
+	if len(s.filter.Scopes) > 0 {
+		db = db.Scopes(s.filter.Scopes...)
+	}
+	return db
+}
+
+func (s queryScope) where() (string, []any, error) {
+	where, args := s.tenancy.Where()
+	if len(s.filterSearch) == 0 {
+		return where, args, nil
+	}
+	if len(s.filter.Scopes) > 0 {
+		return "", nil, store.Internal(errors.New("policy scopes cannot be used with raw queries"))
+	}
+	filterWhere, filterArgs, err := s.filterSearch.WhereString(nil)
+	if err != nil {
+		return "", nil, store.Internal(err)
+	}
+	if where == "" {
+		return filterWhere, filterArgs, nil
+	}
+	return where + " AND " + filterWhere, append(args, filterArgs...), nil
+}
+
+func (s queryScope) deniedStatus() int {
+	if s.policy == nil {
+		return http.StatusForbidden
+	}
+	return s.policy.DeniedStatus
+}
+
+func (s queryScope) allow(action policy.Action, obj any) error {
+	if s.policy == nil {
+		return nil
+	}
+	allowed, err := s.policy.Policy.Allow(s.subject, action, obj)
+	if err != nil {
+		return store.Internal(err)
+	}
+	if !allowed {
+		status := s.deniedStatus()
+		if obj == nil || action == policy.ActionCreate {
+			status = http.StatusForbidden
+		}
+		return denied(status, action)
+	}
+	return nil
+}
+
+// denied returns the error of a denied action, served with status.
+func denied(status int, action policy.Action) error {
+	err := errors.Wrap(policy.ErrDenied, string(action))
+	return store.NewError(store.ErrorOfStatus(status), err.Error(), err)
+}
+
+func (s queryScope) filtered() bool {
+	return s.policy != nil && (len(s.filterSearch) > 0 || len(s.filter.Scopes) > 0)
+}
+
+// authorize checks that the subject may perform the scope's action on obj.
+// Objects that exist but are hidden by the policy filter are reported with
+// the model's denied status.
+func authorize[T any](db *gorm.DB, scope queryScope, action policy.Action, obj *T) error {
+	if scope.policy == nil {
+		return nil
+	}
+	if err := scope.allow(action, obj); err != nil {
+		return err
+	}
+	if !scope.filtered() {
+		return nil
+	}
+	if action == policy.ActionCreate {
+		ok, err := scope.filter.Evaluate(obj)
+		if err != nil {
+			return store.Internal(err)
+		}
+		if !ok {
+			return denied(http.StatusForbidden, action)
+		}
+		return nil
+	}
+	var count int64
+	var model T
+	stmt := &gorm.Statement{DB: db}
+	if err := stmt.Parse(obj); err != nil {
+		return store.Internal(err)
+	}
+	db2 := scope.apply(db.Session(&gorm.Session{NewDB: true}).Unscoped().Model(&model))
+	for _, field := range stmt.Schema.PrimaryFields {
+		v, _ := field.ValueOf(db.Statement.Context, reflectValueOf(obj))
+		db2 = db2.Where(db.Statement.Quote(field.DBName)+" = ?", v)
+	}
+	if err := db2.Count(&count).Error; err != nil {
+		return store.Internal(err)
+	}
+	if count == 0 {
+		return denied(scope.deniedStatus(), action)
+	}
+	return nil
+}
+
+// findObject loads the object selected by query into obj. When the policy
+// filter hides an existing object and the model's denied status is 403, the
+// caller gets 403 instead of not found.
+func findObject[T any](scope queryScope, action policy.Action, obj *T, query func() *gorm.DB) error {
+	if err := scope.apply(query()).First(obj).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) && scope.filtered() && scope.deniedStatus() == http.StatusForbidden {
+			var count int64
+			if err2 := scope.tenancy.Apply(query()).Count(&count).Error; err2 == nil && count > 0 {
+				return denied(http.StatusForbidden, action)
+			}
+		}
+		return err
+	}
+	return scope.allow(action, obj)
+}
+
+func reflectValueOf(obj any) reflect.Value {
+	return reflect.Indirect(reflect.ValueOf(obj))
+}
@@ -0,0 +1,145 @@
+package gormdb
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/heypkg/store"
+	"github.com/heypkg/store/audit"
+	"github.com/heypkg/store/metrics"
+	"github.com/heypkg/store/policy"
+	"gorm.io/gorm"
+)
+
+// Store is the GORM backend of store.Store.
+type Store[T any] struct {
+	db *gorm.DB
+}
+
+var (
+	_ store.Store[struct{}]        = (*Store[struct{}])(nil)
+	_ store.HistoryStore[struct{}] = (*Store[struct{}])(nil)
+	_ store.Scanner[struct{}]      = (*Store[struct{}])(nil)
+	_ store.Suggester              = (*Store[struct{}])(nil)
+)
+
+func NewStore[T any](db *gorm.DB) *Store[T] {
+	return &Store[T]{db: db}
+}
+
+func (s *Store[T]) DB() *gorm.DB {
+	return s.db
+}
+
+func (s *Store[T]) List(ctx context.Context, req store.ListRequest) ([]T, int64, error) {
+	return List[T](s.db, ctx, req)
+}
+
+func (s *Store[T]) Scan(ctx context.Context, req store.ListRequest, fn func(obj *T) error) error {
+	return Scan[T](s.db, ctx, req, fn)
+}
+
+func (s *Store[T]) Count(ctx context.Context, req store.ListRequest) (int64, error) {
+	return Count[T](s.db, ctx, req)
+}
+
+func (s *Store[T]) Get(ctx context.Context, req store.GetRequest) (*T, error) {
+	return Get[T](s.db, ctx, req)
+}
+
+func (s *Store[T]) Create(ctx context.Context, obj *T) error {
+	return Create(s.db, ctx, obj)
+}
+
+func (s *Store[T]) Update(ctx context.Context, obj *T, values map[string]any) error {
+	return Update(s.db, ctx, obj, values)
+}
+
+func (s *Store[T]) Delete(ctx context.Context, obj *T) error {
+	return Delete(s.db, ctx, obj)
+}
+
+func (s *Store[T]) Restore(ctx context.Context, obj *T) error {
+	return Restore(s.db, ctx, obj)
+}
+
+func (s *Store[T]) Aggregate(ctx context.Context, req store.AggregateRequest) ([]map[string]any, error) {
+	return Aggregate[T](s.db, ctx, req)
+}
+
+func (s *Store[T]) Suggest(ctx context.Context, req store.SuggestRequest) (store.Suggestions, error) {
+	return Suggest[T](s.db, ctx, req)
+}
+
+func (s *Store[T]) History(ctx context.Context, obj *T, req store.ListRequest) ([]audit.Record, int64, error) {
+	return History(s.db, ctx, obj, req)
+}
+
+func (s *Store[T]) Revert(ctx context.Context, obj *T, version int) (*T, error) {
+	return Revert(s.db, ctx, obj, version)
+}
+
+// aggregateField returns the SQL expression of a column, or of a key of a
+// JSON column written as column.key.
+func aggregateField(db *gorm.DB, name string) string {
+	if column, key, ok := strings.Cut(name, "."); ok {
+		return fmt.Sprintf("%v->>'%v'", db.Statement.Quote(column), key)
+	}
+	return db.Statement.Quote(name)
+}
+
+// Aggregate groups the objects matching req.Search and computes the requested
+// aggregations of every group.
+func Aggregate[T any](db *gorm.DB, ctx context.Context, req store.AggregateRequest) ([]map[string]any, error) {
+	ctx, cancel := store.WithTimeout(ctx, req.Timeout)
+	defer cancel()
+	ctx, op := startOperation[T](ctx, metrics.OpAggregate)
+	out := []map[string]any{}
+	var err error
+	defer func() { op.end(err, len(out), -1) }()
+	if err = req.Validate(); err != nil {
+		return nil, store.NewError(store.ErrInvalidQuery, err.Error(), err)
+	}
+	scope, err := getQueryScope[T](ctx, policy.ActionList)
+	if err != nil {
+		return nil, err
+	}
+	selects := []string{}
+	groups := []string{}
+	for _, name := range req.GroupBy {
+		expr := aggregateField(db, name)
+		groups = append(groups, expr)
+		selects = append(selects, fmt.Sprintf("%v AS %v", expr, db.Statement.Quote(strings.ReplaceAll(name, ".", "_"))))
+	}
+	for _, a := range req.Aggregations {
+		expr := "*"
+		if a.Field != "*" {
+			expr = aggregateField(db, a.Field)
+			if strings.Contains(a.Field, ".") && (a.Func == store.AggregateSum || a.Func == store.AggregateAvg) {
+				expr = fmt.Sprintf("CAST(%v AS DOUBLE PRECISION)", expr)
+			}
+		}
+		selects = append(selects, fmt.Sprintf("%v(%v) AS %v", strings.ToUpper(string(a.Func)), expr, db.Statement.Quote(a.Name)))
+	}
+	if len(selects) == len(groups) {
+		selects = append(selects, "COUNT(*) AS count")
+	}
+
+	err = scope.read(ctx, db, func(db *gorm.DB) error {
+		var obj T
+		db2, err := appendToTotalParamsToDBWithHandlers(db.Model(&obj), store.ListRequest{Search: req.Search, HandleFuncs: req.HandleFuncs}, scope)
+		if err != nil {
+			return err
+		}
+		db2 = db2.Select(strings.Join(selects, ", "))
+		if len(groups) > 0 {
+			db2 = db2.Group(strings.Join(groups, ", ")).Order(strings.Join(groups, ", "))
+		}
+		return db2.Find(&out).Error
+	})
+	if err != nil {
+		return nil, writeError(ctx, err)
+	}
+	return out, nil
+}
@@ -0,0 +1,31 @@
+package gormdb_test
+
+import (
+	"testing"
+
+	"github.com/glebarez/sqlite"
+	"github.com/heypkg/store"
+	gormdb "github.com/heypkg/store/gorm"
+	"github.com/heypkg/store/storetest"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// newDB returns an empty sqlite database with the devices of storetest.
+func newDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(t.TempDir()+"/store.db"), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&storetest.Device{}); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestStore(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) store.Store[storetest.Device] {
+		return gormdb.NewStore[storetest.Device](newDB(t))
+	})
+}
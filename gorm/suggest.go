@@ -0,0 +1,315 @@
+package gormdb
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/heypkg/store"
+	"github.com/heypkg/store/echoadapter"
+	"github.com/heypkg/store/metrics"
+	"github.com/heypkg/store/policy"
+	"github.com/heypkg/store/search"
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+// DefaultSuggestLimit is the number of values suggested when the request
+// sets none.
+const DefaultSuggestLimit = 10
+
+// suggestSample is the number of latest objects whose JSON columns are read
+// for their keys.
+const suggestSample = 1000
+
+// relativeTimes are the values suggested for time fields.
+var relativeTimes = []string{"now", "now-1h", "now-1d", "now-7d", "now-30d"}
+
+// plainValueRe matches the values written without quotes in search strings.
+var plainValueRe = regexp.MustCompile(`^[A-Za-z0-9\p{Han}_\.\-\+\*]+$`)
+
+type suggestField struct {
+	name string
+	typ  string
+	// handled fields are terms of search handlers, without values.
+	handled bool
+}
+
+// Suggest completes req.Search at req.Cursor with the fields of model T, the
+// operators valid for the type of a field, or the most frequent values of a
+// column or of a key of a JSON column, written column.key. Values are counted
+// among the objects of the tenant matching the other terms of the search.
+func Suggest[T any](db *gorm.DB, ctx context.Context, req store.SuggestRequest) (store.Suggestions, error) {
+	ctx, cancel := store.WithTimeout(ctx, req.Timeout)
+	defer cancel()
+	ctx, op := startOperation[T](ctx, metrics.OpSuggest)
+	partial := search.ParsePartial(req.Search, req.Cursor)
+	out := store.Suggestions{Start: partial.Start, End: partial.End, Items: []store.Suggestion{}}
+	var err error
+	defer func() { op.end(err, len(out.Items), -1) }()
+	scope, err := getQueryScope[T](ctx, policy.ActionList)
+	if err != nil {
+		return out, err
+	}
+	var obj T
+	stmt := &gorm.Statement{DB: db}
+	if err = stmt.Parse(&obj); err != nil {
+		return out, store.Internal(err)
+	}
+	fields := suggestFields(stmt.Schema, scope, req.HandleFuncs)
+	limit := req.Limit
+	if limit <= 0 {
+		limit = DefaultSuggestLimit
+	}
+
+	if partial.Kind == search.PartField {
+		for _, f := range fields {
+			if !hasPrefixFold(f.name, partial.Prefix) {
+				continue
+			}
+			text := f.name + ":"
+			if f.typ == "json" {
+				text = f.name + "."
+			}
+			out.Items = append(out.Items, store.Suggestion{Kind: search.PartField, Text: text, Type: f.typ})
+		}
+		return out, nil
+	}
+	var field suggestField
+	for _, f := range fields {
+		if f.name == partial.Field {
+			field = f
+		}
+	}
+	// JSON columns are completed with their keys, whose values are compared
+	// as text
+	isKey := partial.Kind == search.PartKey || partial.Key != ""
+	if field.name == "" || field.handled || (field.typ == "json") != isKey {
+		return out, nil
+	}
+	// keys are written in SQL, like the fields of aggregations
+	if partial.Key != "" && !store.ValidFieldName(field.name+"."+partial.Key) {
+		return out, nil
+	}
+	typ := field.typ
+	if isKey {
+		typ = "string"
+	}
+
+	if partial.Kind == search.PartOperator || (partial.Kind == search.PartValue && partial.Operator == "" && partial.Prefix == "") {
+		for _, symbol := range operatorsOf(typ) {
+			if strings.HasPrefix(symbol, partial.Prefix) {
+				out.Items = append(out.Items, store.Suggestion{Kind: search.PartOperator, Text: symbol, Type: typ})
+			}
+		}
+		if partial.Kind == search.PartOperator {
+			return out, nil
+		}
+	}
+	switch typ {
+	case "time":
+		for _, v := range relativeTimes {
+			if strings.HasPrefix(v, partial.Prefix) {
+				out.Items = append(out.Items, store.Suggestion{Kind: search.PartValue, Text: v, Type: typ})
+			}
+		}
+		return out, nil
+	case "bool":
+		for _, v := range []string{"true", "false"} {
+			if strings.HasPrefix(v, partial.Prefix) {
+				out.Items = append(out.Items, store.Suggestion{Kind: search.PartValue, Text: v, Type: typ})
+			}
+		}
+		return out, nil
+	}
+
+	err = scope.read(ctx, db, func(db *gorm.DB) error {
+		// objects of the tenant matching the other terms, or all the objects of
+		// the tenant when they are invalid
+		db2, err := appendToTotalParamsToDBWithHandlers(db.Model(&obj), store.ListRequest{Search: partial.Rest, HandleFuncs: req.HandleFuncs}, scope)
+		if err != nil {
+			if db2, err = appendToTotalParamsToDBWithHandlers(db.Model(&obj), store.ListRequest{}, scope); err != nil {
+				return err
+			}
+		}
+		var items []store.Suggestion
+		if partial.Kind == search.PartKey {
+			primary := ""
+			if stmt.Schema.PrioritizedPrimaryField != nil {
+				primary = stmt.Schema.PrioritizedPrimaryField.DBName
+			}
+			items, err = suggestKeys(db2, field.name, primary, partial.Prefix, limit)
+		} else {
+			name := field.name
+			if partial.Key != "" {
+				name += "." + partial.Key
+			}
+			items, err = suggestValues(db2, name, typ, partial.Prefix, limit)
+		}
+		out.Items = append(out.Items, items...)
+		return err
+	})
+	if err != nil {
+		return out, writeError(ctx, err)
+	}
+	return out, nil
+}
+
+// suggestFields returns the searchable fields of a model: its columns but
+// the tenant column, and the terms of the search handlers.
+func suggestFields(s *schema.Schema, scope queryScope, handleFuncs search.SearchDataHandleFuncMap) []suggestField {
+	tenantColumn := scope.tenancy.Strategy.Column()
+	fields := []suggestField{}
+	for _, f := range s.Fields {
+		if f.DBName == "" || strings.EqualFold(f.DBName, tenantColumn) || handleFuncs[f.DBName] != nil {
+			continue
+		}
+		typ := string(f.GORMDataType)
+		switch f.GORMDataType {
+		case schema.Bool, schema.Int, schema.Uint, schema.Float, schema.String, schema.Time:
+		default:
+			typ = "json"
+		}
+		if f.Serializer != nil {
+			typ = "json"
+		}
+		fields = append(fields, suggestField{name: f.DBName, typ: typ})
+	}
+	names := []string{}
+	for name := range handleFuncs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fields = append(fields, suggestField{name: name, handled: true})
+	}
+	return fields
+}
+
+// operatorsOf returns the operators of the fields of typ. Equality is the
+// colon of the term and is not suggested.
+func operatorsOf(typ string) []string {
+	switch typ {
+	case "int", "uint", "float", "time":
+		return []string{">", ">=", "<", "<=", "!="}
+	}
+	return []string{"!="}
+}
+
+// suggestValues returns the most frequent values of the field name of the
+// rows of db starting with prefix.
+func suggestValues(db *gorm.DB, name string, typ string, prefix string, limit int) ([]store.Suggestion, error) {
+	expr := aggregateField(db, name)
+	db = db.Select(expr + " AS value, COUNT(*) AS count").Where(expr + " IS NOT NULL").
+		Group(expr).Order("count DESC").Order(expr)
+	// only text can be compared to the prefix in SQL, the other values are
+	// filtered from a larger set
+	if typ == "string" && prefix != "" {
+		db = db.Where("SUBSTR("+expr+", 1, ?) = ?", utf8.RuneCountInString(prefix), prefix).Limit(limit)
+	} else if prefix != "" {
+		db = db.Limit(limit * 10)
+	} else {
+		db = db.Limit(limit)
+	}
+	// rows are scanned by hand, as the model may have a value field
+	rows, err := db.Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []store.Suggestion{}
+	for rows.Next() && len(items) < limit {
+		var value any
+		var count int64
+		if err := rows.Scan(&value, &count); err != nil {
+			return nil, err
+		}
+		if b, ok := value.([]byte); ok {
+			value = string(b)
+		}
+		text := fmt.Sprint(value)
+		if strings.HasPrefix(text, prefix) {
+			items = append(items, store.Suggestion{Kind: search.PartValue, Text: quoteValue(text), Type: typ, Count: count})
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+// suggestKeys returns the most frequent keys of the JSON column name
+// starting with prefix among the latest rows of db, by primary key.
+func suggestKeys(db *gorm.DB, name string, primary string, prefix string, limit int) ([]store.Suggestion, error) {
+	column := db.Statement.Quote(name)
+	if primary != "" {
+		db = db.Order(db.Statement.Quote(primary) + " DESC")
+	}
+	var raws [][]byte
+	if err := db.Where(column+" IS NOT NULL").Limit(suggestSample).Pluck(name, &raws).Error; err != nil {
+		return nil, err
+	}
+	counts := map[string]int64{}
+	for _, raw := range raws {
+		var m map[string]any
+		if json.Unmarshal(raw, &m) != nil {
+			continue
+		}
+		for key := range m {
+			if strings.HasPrefix(key, prefix) {
+				counts[key]++
+			}
+		}
+	}
+	keys := []string{}
+	for key := range counts {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if counts[keys[i]] != counts[keys[j]] {
+			return counts[keys[i]] > counts[keys[j]]
+		}
+		return keys[i] < keys[j]
+	})
+	if len(keys) > limit {
+		keys = keys[:limit]
+	}
+	items := []store.Suggestion{}
+	for _, key := range keys {
+		items = append(items, store.Suggestion{Kind: search.PartKey, Text: key + ":", Type: "string", Count: counts[key]})
+	}
+	return items, nil
+}
+
+// quoteValue returns value as written in search strings.
+func quoteValue(value string) string {
+	if plainValueRe.MatchString(value) {
+		return value
+	}
+	if strings.Contains(value, "'") {
+		return `"` + value + `"`
+	}
+	return "'" + value + "'"
+}
+
+func hasPrefixFold(s string, prefix string) bool {
+	return len(s) >= len(prefix) && strings.EqualFold(s[:len(prefix)], prefix)
+}
+
+// SuggestObjects completes the q query parameter at the cursor parameter
+// with the fields and the values of model T.
+func SuggestObjects[T any](db *gorm.DB, c echo.Context, handleFuncs map[string]search.SearchDataHandleFunc) (store.Suggestions, error) {
+	ctx, err := echoadapter.Context(c)
+	if err != nil {
+		return store.Suggestions{}, echoadapter.HTTPError(err)
+	}
+	req := echoadapter.SuggestRequest(c)
+	req.HandleFuncs = handleFuncs
+	suggestions, err := Suggest[T](db, ctx, req)
+	return suggestions, echoadapter.HTTPError(err)
+}
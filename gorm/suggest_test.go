@@ -0,0 +1,60 @@
+package gormdb_test
+
+import (
+	"testing"
+
+	"github.com/heypkg/store"
+	gormdb "github.com/heypkg/store/gorm"
+	"github.com/heypkg/store/jsontype"
+	"github.com/heypkg/store/storetest"
+)
+
+func TestSuggest(t *testing.T) {
+	db := newDB(t)
+	for _, d := range []storetest.Device{
+		{Schema: "a", Name: "alpha", Kind: "sensor", Value: 1, Tags: jsontype.Tags{"region": "eu"}},
+		{Schema: "a", Name: "alpine", Kind: "sensor", Value: 2, Tags: jsontype.Tags{"region": "eu"}},
+		{Schema: "a", Name: "beta", Kind: "gateway", Value: 3, Tags: jsontype.Tags{"region": "us"}},
+		{Schema: "b", Name: "other", Kind: "secret", Value: 4, Tags: jsontype.Tags{"private": "x"}},
+	} {
+		if err := db.Create(&d).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	ctx := storetest.Context("a")
+	tests := []struct {
+		search string
+		want   []string
+	}{
+		{"na", []string{"name:"}},
+		{"kind:", []string{"!=", "sensor", "gateway"}},
+		{"kind:s", []string{"sensor"}},
+		{"name:al", []string{"alpha", "alpine"}},
+		{"kind:gateway name:", []string{"!=", "beta"}},
+		{"tags.", []string{"region:"}},
+		{"tags.region:", []string{"!=", "eu", "us"}},
+		{"schema:", nil},
+		{"tags.region')--:", nil},
+		{"tags.a'||'b:", nil},
+	}
+	for _, tt := range tests {
+		out, err := gormdb.Suggest[storetest.Device](db, ctx, store.SuggestRequest{Search: tt.search, Cursor: len(tt.search)})
+		if err != nil {
+			t.Fatalf("%q: %v", tt.search, err)
+		}
+		got := []string{}
+		for _, item := range out.Items {
+			got = append(got, item.Text)
+		}
+		if len(got) != len(tt.want) {
+			t.Errorf("%q: got %v, want %v", tt.search, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("%q: got %v, want %v", tt.search, got, tt.want)
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package gormdb
+
+import (
+	"context"
+	"reflect"
+	"time"
+
+	"github.com/heypkg/store/metrics"
+	"github.com/heypkg/store/telemetry"
+	"github.com/heypkg/store/tenancy"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// operation traces and measures a store operation on a model.
+type operation struct {
+	ctx   context.Context
+	span  trace.Span
+	op    string
+	model string
+	begin time.Time
+}
+
+// startOperation starts the operation op on the model T in the tenant of
+// ctx.
+func startOperation[T any](ctx context.Context, op string) (context.Context, *operation) {
+	return startModelOperation(ctx, op, reflect.TypeOf((*T)(nil)).Elem().Name())
+}
+
+func startModelOperation(ctx context.Context, op string, model string) (context.Context, *operation) {
+	ctx, span := telemetry.Start(ctx, "store."+op,
+		telemetry.AttrModel.String(model),
+		telemetry.AttrSchema.String(tenancy.ScopeFromContext(ctx).Tenant),
+	)
+	return ctx, &operation{ctx: ctx, span: span, op: op, model: model, begin: time.Now()}
+}
+
+// end ends the operation with the number of rows read and the total number
+// of matching rows. Negative counts are not recorded.
+func (o *operation) end(err error, rows int, total int64) {
+	var storeErr error
+	if err != nil {
+		storeErr = writeError(o.ctx, err)
+	}
+	metrics.ObserveOperation(o.op, o.model, o.begin, rows, storeErr)
+	endSpan(o.span, err, rows, total)
+}
+
+// endSpan ends span with the number of rows returned and the total number of
+// matching rows. Negative counts are not recorded.
+func endSpan(span trace.Span, err error, rows int, total int64) {
+	if rows >= 0 {
+		span.SetAttributes(telemetry.AttrRows.Int(rows))
+	}
+	if total >= 0 {
+		span.SetAttributes(telemetry.AttrTotal.Int64(total))
+	}
+	telemetry.End(span, err)
+}
+
+// count runs fn to count the rows of model as an operation of its own.
+func count(ctx context.Context, model string, total *int64, fn func(total *int64) error) error {
+	_, op := startModelOperation(ctx, metrics.OpCount, model)
+	err := fn(total)
+	op.end(err, -1, *total)
+	return err
+}
@@ -0,0 +1,89 @@
+package gormdb_test
+
+import (
+	"testing"
+
+	"github.com/heypkg/store"
+	gormdb "github.com/heypkg/store/gorm"
+	"github.com/heypkg/store/storetest"
+	"github.com/heypkg/store/telemetry"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// attr returns the value of the attribute key of span, nil when unset.
+func attr(span sdktrace.ReadOnlySpan, key attribute.Key) any {
+	for _, kv := range span.Attributes() {
+		if kv.Key == key {
+			return kv.Value.AsInterface()
+		}
+	}
+	return nil
+}
+
+func TestListSpans(t *testing.T) {
+	exp := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exp))
+	prev := otel.GetTracerProvider()
+	otel.SetTracerProvider(tp)
+	t.Cleanup(func() { otel.SetTracerProvider(prev) })
+
+	db := newDB(t)
+	ctx := storetest.Context("a")
+	for _, name := range []string{"d1", "d2"} {
+		if err := gormdb.Create(db, ctx, &storetest.Device{Name: name}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	exp.Reset()
+	objs, total, err := gormdb.List[storetest.Device](db, ctx, store.ListRequest{Search: "name:d1"})
+	if err != nil || len(objs) != 1 || total != 1 {
+		t.Fatalf("List() = %d objects, total %d, %v", len(objs), total, err)
+	}
+	spans := map[string]sdktrace.ReadOnlySpan{}
+	for _, span := range exp.GetSpans().Snapshots() {
+		spans[span.Name()] = span
+	}
+	for name, want := range map[string]map[attribute.Key]any{
+		"store.list":  {telemetry.AttrModel: "Device", telemetry.AttrSchema: "a", telemetry.AttrRows: int64(1), telemetry.AttrTotal: int64(1)},
+		"store.parse": {telemetry.AttrTerms: int64(1)},
+		"store.count": {telemetry.AttrModel: "Device", telemetry.AttrTotal: int64(1)},
+		"store.query": {telemetry.AttrRows: int64(1)},
+	} {
+		span, ok := spans[name]
+		if !ok {
+			t.Errorf("no %s span in %v", name, spans)
+			continue
+		}
+		if span.Status().Code == codes.Error {
+			t.Errorf("%s status = %v", name, span.Status())
+		}
+		for key, v := range want {
+			if got := attr(span, key); got != v {
+				t.Errorf("%s %s = %v, want %v", name, key, got, v)
+			}
+		}
+		if name != "store.list" && span.Parent().SpanID() != spans["store.list"].SpanContext().SpanID() {
+			t.Errorf("%s is not a child of store.list", name)
+		}
+	}
+
+	exp.Reset()
+	if _, _, err := gormdb.List[storetest.Device](db, ctx, store.ListRequest{Search: "(("}); err == nil {
+		t.Fatal("List() with an invalid search succeeded")
+	}
+	failed := map[string]bool{}
+	for _, span := range exp.GetSpans().Snapshots() {
+		failed[span.Name()] = span.Status().Code == codes.Error
+	}
+	if !failed["store.parse"] || !failed["store.list"] {
+		t.Errorf("failed spans = %v, want store.parse and store.list", failed)
+	}
+	if _, ok := failed["store.query"]; ok {
+		t.Error("invalid search was queried")
+	}
+}
@@ -1,44 +1,94 @@
 package gormdb
 
 import (
+	"context"
 	"fmt"
-	"net/http"
+	"strings"
 
+	"github.com/heypkg/store"
+	"github.com/heypkg/store/preload"
 	"github.com/heypkg/store/search"
-	"github.com/labstack/echo/v4"
+	"github.com/heypkg/store/telemetry"
+	"github.com/heypkg/store/tenancy"
 	"github.com/pkg/errors"
-	"github.com/spf13/cast"
 	"gorm.io/gorm"
 )
 
-func appendToTotalParamsToDBWithHandlers(db *gorm.DB, c echo.Context, handleFuncs map[string]search.SearchDataHandleFunc) (*gorm.DB, error) {
-	schema := cast.ToString(c.Get("schema"))
+func runWithTenancyScope(db *gorm.DB, scope tenancy.Scope, fn func(db *gorm.DB) error) error {
+	err := scope.Run(db, fn)
+	if err != nil {
+		var e *store.Error
+		if errors.As(err, &e) {
+			return err
+		}
+		if errors.Is(err, tenancy.ErrInvalidTenant) || errors.Is(err, tenancy.ErrTenantRequired) {
+			return store.NewError(store.ErrForbidden, err.Error(), err)
+		}
+	}
+	return err
+}
 
-	q := c.QueryParam("q")
-	q = q + " schema:" + schema
-	search, err := search.ParseSearchString(q)
+func parseSearchParams(ctx context.Context, text string, scope queryScope) (search.SearchData, error) {
+	_, span := telemetry.Start(ctx, "store.parse")
+	s, err := search.ParseSearchString(text)
+	if err == nil {
+		span.SetAttributes(telemetry.AttrTerms.Int(len(s)))
+		err = scope.tenancy.Check(s)
+	}
+	telemetry.End(span, err)
 	if err != nil {
-		return nil, echo.NewHTTPError(http.StatusBadRequest, errors.Wrap(err, "invalid query").Error())
+		return nil, store.InvalidQuery(err)
 	}
-	out := search.SearchDB(db, handleFuncs)
+	return s, nil
+}
+
+func invalidInclude(err error) error {
+	return store.NewError(store.ErrInvalidQuery, errors.Wrap(err, "invalid include").Error(), err)
+}
+
+// appendPreloadsToDB preloads the associations set by the server and the
+// ones requested by the client. Client includes must be allowed for the
+// model.
+func appendPreloadsToDB[T any](db *gorm.DB, serverIncludes string, clientIncludes string, scope queryScope) (*gorm.DB, error) {
+	includes, err := preload.ParseIncludeString(serverIncludes)
+	if err != nil {
+		return nil, store.Internal(err)
+	}
+	includes2, err := preload.ParseIncludeString(clientIncludes)
+	if err != nil {
+		return nil, invalidInclude(err)
+	}
+	includes2, err = preload.Check[T](includes2)
+	if err != nil {
+		return nil, invalidInclude(err)
+	}
+	out, err := preload.Apply[T](db, append(includes, includes2...), scope.tenancy)
+	if err != nil {
+		return nil, invalidInclude(err)
+	}
+	return out, nil
+}
+
+func appendToTotalParamsToDBWithHandlers(db *gorm.DB, req store.ListRequest, scope queryScope) (*gorm.DB, error) {
+	s, err := parseSearchParams(db.Statement.Context, req.Search, scope)
+	if err != nil {
+		return nil, err
+	}
+	out := s.ResolveTimes(search.ModelTimes(db)).SearchDB(scope.apply(db), req.HandleFuncs)
 	return out, nil
 }
 
-func appendToListParamsToDBWithHandlers(db *gorm.DB, c echo.Context, total int, handleFuncs map[string]search.SearchDataHandleFunc) (*gorm.DB, error) {
-	schema := cast.ToString(c.Get("schema"))
-	page := cast.ToInt(c.QueryParam("page"))
-	pageSize := cast.ToInt(c.QueryParam("page_size"))
+func appendToListParamsToDBWithHandlers(db *gorm.DB, req store.ListRequest, scope queryScope, total int) (*gorm.DB, error) {
+	page := req.Page
+	pageSize := req.PageSize
 
-	q := c.QueryParam("q")
-	q = q + " schema:" + schema
-	s, err := search.ParseSearchString(q)
+	s, err := parseSearchParams(db.Statement.Context, req.Search, scope)
 	if err != nil {
-		return nil, echo.NewHTTPError(http.StatusBadRequest, errors.Wrap(err, "invalid query").Error())
+		return nil, err
 	}
-	out := s.SearchDB(db, handleFuncs)
+	out := s.ResolveTimes(search.ModelTimes(db)).SearchDB(scope.apply(db), req.HandleFuncs)
 
-	order := c.QueryParam("order_by")
-	orders := search.ParseOrderByString(order)
+	orders := search.ParseOrderByString(req.OrderBy)
 	for _, v := range orders {
 		if v.Desc {
 			out = out.Order(v.Name + " DESC")
@@ -60,36 +110,47 @@ func appendToListParamsToDBWithHandlers(db *gorm.DB, c echo.Context, total int,
 	return out, nil
 }
 
-func getTotalParamsToStringWithHandlers(c echo.Context, handleFuncs map[string]search.SearchDataHandleFunc) (string, []any, error) {
-	schema := cast.ToString(c.Get("schema"))
-
-	q := c.QueryParam("q")
-	q = q + " schema:" + schema
-	search, err := search.ParseSearchString(q)
+func getWhereStringWithHandlers(ctx context.Context, req store.ListRequest, scope queryScope) (string, []any, error) {
+	s, err := parseSearchParams(ctx, req.Search, scope)
 	if err != nil {
-		return "", nil, echo.NewHTTPError(http.StatusBadRequest, errors.Wrap(err, "invalid query").Error())
+		return "", nil, err
 	}
-	where, args, err := search.WhereString(handleFuncs)
+	queries := []string{}
+	args := []any{}
+	where, whereArgs, err := scope.where()
 	if err != nil {
-		return "", nil, echo.NewHTTPError(http.StatusBadRequest, errors.Wrap(err, "invalid query").Error())
+		return "", nil, err
 	}
-	return where, args, nil
+	if where != "" {
+		queries = append(queries, where)
+		args = append(args, whereArgs...)
+	}
+	if len(s) > 0 {
+		where, whereArgs, err := s.WhereString(req.HandleFuncs)
+		if err == nil {
+			queries = append(queries, where)
+			args = append(args, whereArgs...)
+		} else if !errors.Is(err, search.ErrNoValidSearchConditions) {
+			return "", nil, store.InvalidQuery(err)
+		}
+	}
+	if len(queries) == 0 {
+		return "1 = 1", args, nil
+	}
+	return strings.Join(queries, " AND "), args, nil
 }
 
-func getListParamsToStringWithHandlers(c echo.Context, total int, handleFuncs map[string]search.SearchDataHandleFunc) (string, []any, error) {
-	schema := cast.ToString(c.Get("schema"))
-	page := cast.ToInt(c.QueryParam("page"))
-	pageSize := cast.ToInt(c.QueryParam("page_size"))
+func getTotalParamsToStringWithHandlers(ctx context.Context, req store.ListRequest, scope queryScope) (string, []any, error) {
+	return getWhereStringWithHandlers(ctx, req, scope)
+}
 
-	q := c.QueryParam("q")
-	q = q + " schema:" + schema
-	search, err := search.ParseSearchString(q)
-	if err != nil {
-		return "", nil, echo.NewHTTPError(http.StatusBadRequest, errors.Wrap(err, "invalid query").Error())
-	}
-	where, args, err := search.WhereString(handleFuncs)
+func getListParamsToStringWithHandlers(ctx context.Context, req store.ListRequest, scope queryScope, total int) (string, []any, error) {
+	page := req.Page
+	pageSize := req.PageSize
+
+	where, args, err := getWhereStringWithHandlers(ctx, req, scope)
 	if err != nil {
-		return "", nil, echo.NewHTTPError(http.StatusBadRequest, errors.Wrap(err, "invalid query").Error())
+		return "", nil, err
 	}
 
 	if pageSize > 0 {
@@ -100,7 +161,8 @@ func getListParamsToStringWithHandlers(c echo.Context, total int, handleFuncs ma
 		if page < 1 {
 			page = 1
 		}
-		where = where + fmt.Sprintf(" offset %v limit %v", (page-1)*pageSize, pageSize)
+		// limit before offset, the order every dialect accepts
+		where = where + fmt.Sprintf(" limit %v offset %v", pageSize, (page-1)*pageSize)
 	}
 	return where, args, nil
 }
@@ -0,0 +1,189 @@
+package importer
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/heypkg/store/jsontype"
+	"github.com/pkg/errors"
+	"github.com/spf13/cast"
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+var (
+	timeType      = reflect.TypeOf(time.Time{})
+	jsonTimeType  = reflect.TypeOf(jsontype.JSONTime{})
+	deletedAtType = reflect.TypeOf(gorm.DeletedAt{})
+	schemaCache   = &sync.Map{}
+)
+
+// field is a field of the model that rows can set.
+type field struct {
+	name   string // JSON name
+	schema *schema.Field
+	typ    reflect.Type
+}
+
+// fields resolves row keys to the fields of a model.
+type fields struct {
+	schema  *schema.Schema
+	byName  map[string]*field
+	mapping map[string]string
+}
+
+func parseFields[T any](mapping map[string]string) (*fields, error) {
+	var obj T
+	s, err := schema.Parse(&obj, schemaCache, schema.NamingStrategy{})
+	if err != nil {
+		return nil, errors.Wrap(err, "parse model")
+	}
+	out := &fields{schema: s, byName: map[string]*field{}, mapping: map[string]string{}}
+	for k, v := range mapping {
+		out.mapping[strings.ToLower(k)] = v
+	}
+	for _, sf := range s.Fields {
+		if sf.StructField.PkgPath != "" {
+			continue
+		}
+		tag := sf.StructField.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, _, _ := strings.Cut(tag, ",")
+		if name == "" {
+			name = sf.Name
+		}
+		typ := sf.FieldType
+		for typ.Kind() == reflect.Pointer {
+			typ = typ.Elem()
+		}
+		f := &field{name: name, schema: sf, typ: typ}
+		for _, key := range []string{name, sf.Name, sf.DBName} {
+			if key == "" {
+				continue
+			}
+			if _, ok := out.byName[strings.ToLower(key)]; !ok {
+				out.byName[strings.ToLower(key)] = f
+			}
+		}
+	}
+	return out, nil
+}
+
+// resolve returns the field of a row key and the key inside it for keys of
+// JSON objects written field.key.
+func (fs *fields) resolve(key string) (*field, string, bool) {
+	if mapped, ok := fs.mapping[strings.ToLower(key)]; ok {
+		key = mapped
+	}
+	if f, ok := fs.byName[strings.ToLower(key)]; ok {
+		return f, "", true
+	}
+	parent, sub, ok := strings.Cut(key, ".")
+	if !ok || sub == "" {
+		return nil, "", false
+	}
+	f, ok := fs.byName[strings.ToLower(parent)]
+	if !ok || f.typ.Kind() != reflect.Map {
+		return nil, "", false
+	}
+	return f, sub, true
+}
+
+// coerce converts a row value, text from CSV or a decoded JSON value, to
+// the JSON value of f. Empty text is null.
+func (f *field) coerce(v any) (any, error) {
+	if s, ok := v.(string); ok && s == "" && f.typ.Kind() != reflect.String {
+		return nil, nil
+	}
+	if v == nil {
+		return nil, nil
+	}
+	switch f.typ {
+	case jsonTimeType:
+		if n, err := cast.ToInt64E(v); err == nil {
+			return n, nil
+		}
+		t, err := parseTime(v)
+		if err != nil {
+			return nil, err
+		}
+		return t.UnixNano() / int64(time.Microsecond), nil
+	case timeType, deletedAtType:
+		t, err := parseTime(v)
+		if err != nil {
+			return nil, err
+		}
+		return t.Format(time.RFC3339Nano), nil
+	}
+	switch f.typ.Kind() {
+	case reflect.Bool:
+		return cast.ToBoolE(v)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if s, ok := v.(string); ok {
+			return strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+		}
+		return cast.ToInt64E(v)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if s, ok := v.(string); ok {
+			return strconv.ParseUint(strings.TrimSpace(s), 10, 64)
+		}
+		return cast.ToUint64E(v)
+	case reflect.Float32, reflect.Float64:
+		if s, ok := v.(string); ok {
+			return strconv.ParseFloat(strings.TrimSpace(s), 64)
+		}
+		return cast.ToFloat64E(v)
+	case reflect.String:
+		return cast.ToStringE(v)
+	}
+	if s, ok := v.(string); ok {
+		var out any
+		if err := json.Unmarshal([]byte(s), &out); err != nil {
+			return nil, errors.New("invalid JSON")
+		}
+		return out, nil
+	}
+	return v, nil
+}
+
+// coerceKey converts the value of a key of a JSON object. Text that reads
+// as a JSON number or boolean keeps that type, as exports write them.
+func coerceKey(v any) any {
+	s, ok := v.(string)
+	if !ok {
+		return v
+	}
+	trimmed := strings.TrimSpace(s)
+	if trimmed == "true" || trimmed == "false" {
+		return trimmed == "true"
+	}
+	if trimmed != "" && (trimmed[0] == '-' || (trimmed[0] >= '0' && trimmed[0] <= '9')) {
+		d := json.NewDecoder(bytes.NewReader([]byte(trimmed)))
+		d.UseNumber()
+		var n json.Number
+		if d.Decode(&n) == nil && !d.More() {
+			return n
+		}
+	}
+	return s
+}
+
+func parseTime(v any) (time.Time, error) {
+	if s, ok := v.(string); ok {
+		s = strings.TrimSpace(s)
+		for _, layout := range []string{time.RFC3339Nano, "2006-01-02 15:04:05", "2006-01-02"} {
+			if t, err := time.Parse(layout, s); err == nil {
+				return t, nil
+			}
+		}
+		return time.Time{}, errors.Errorf("invalid time %v", s)
+	}
+	return cast.ToTimeE(v)
+}
@@ -0,0 +1,499 @@
+// Package importer upserts objects from CSV or NDJSON files through a
+// store, with column mapping, type coercion, per-row validation, dry runs
+// and error reports. Large files run as resumable background jobs.
+package importer
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/heypkg/store"
+	"github.com/heypkg/store/echoadapter"
+	"github.com/labstack/echo/v4"
+	"github.com/pkg/errors"
+)
+
+type Format string
+
+const (
+	FormatCSV    Format = "csv"
+	FormatNDJSON Format = "ndjson"
+)
+
+var ErrUnknownFormat = errors.New("unknown import format")
+
+func ParseFormat(text string) (Format, error) {
+	switch f := Format(strings.ToLower(text)); f {
+	case FormatCSV, FormatNDJSON:
+		return f, nil
+	case "jsonl":
+		return FormatNDJSON, nil
+	}
+	return "", errors.Wrap(ErrUnknownFormat, text)
+}
+
+// Mode chooses between creating and updating objects.
+type Mode string
+
+const (
+	// ModeUpsert updates the objects whose primary key exists and creates
+	// the others.
+	ModeUpsert Mode = "upsert"
+	ModeCreate Mode = "create"
+	// ModeUpdate fails the rows whose object does not exist.
+	ModeUpdate Mode = "update"
+)
+
+type Options struct {
+	Format Format `json:"format"`
+	Mode   Mode   `json:"mode,omitempty"`
+	// Mapping maps file columns to model fields, for example
+	// {"Device name": "name"}. Other columns are matched to the JSON name,
+	// field name or database column, and field.key columns set keys of
+	// JSON object fields such as Tags.
+	Mapping map[string]string `json:"mapping,omitempty"`
+	// DryRun validates every row and reports what would be created and
+	// updated without writing.
+	DryRun bool `json:"dry_run,omitempty"`
+	// IgnoreUnknown skips columns that match no field instead of failing
+	// the row.
+	IgnoreUnknown bool `json:"ignore_unknown,omitempty"`
+	// MaxErrors stops the import after that many failed rows; 0 for no
+	// limit.
+	MaxErrors int `json:"max_errors,omitempty"`
+	// Validate checks each object before it is written, after the
+	// Validate() error method of the model if it has one.
+	Validate func(obj any) error `json:"-"`
+}
+
+// RowError is the failure of a row. Row is the line of the record in the
+// file, counting the CSV header.
+type RowError struct {
+	Row     int    `json:"row"`
+	Column  string `json:"column,omitempty"`
+	Message string `json:"message"`
+}
+
+// Progress counts the rows handled so far.
+type Progress struct {
+	Rows    int `json:"rows"`
+	Created int `json:"created"`
+	Updated int `json:"updated"`
+	Failed  int `json:"failed"`
+}
+
+type Report struct {
+	Progress
+	DryRun bool       `json:"dry_run,omitempty"`
+	Errors []RowError `json:"errors"`
+}
+
+// WriteErrors writes errors as CSV with row, column and message columns.
+func WriteErrors(w io.Writer, errs []RowError) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"row", "column", "message"})
+	for _, e := range errs {
+		cw.Write([]string{strconv.Itoa(e.Row), e.Column, e.Message})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+var ErrTooManyErrors = errors.New("too many errors")
+
+// Import reads the rows of r and writes them to s.
+func Import[T any](ctx context.Context, s store.Store[T], r io.Reader, opts Options) (*Report, error) {
+	report := &Report{DryRun: opts.DryRun, Errors: []RowError{}}
+	err := run(ctx, s, r, opts, 0, func(p Progress, errs []RowError) error {
+		report.Progress = p
+		report.Errors = append(report.Errors, errs...)
+		return nil
+	})
+	return report, err
+}
+
+// Echo imports the file of c: the file field of a multipart form or the
+// request body. Options are read with OptionsFromRequest.
+func Echo[T any](c echo.Context, s store.Store[T]) (*Report, error) {
+	ctx, err := echoadapter.Context(c)
+	if err != nil {
+		return nil, echoadapter.HTTPError(err)
+	}
+	opts, body, err := readRequest(c)
+	if err != nil {
+		return nil, echoadapter.HTTPError(err)
+	}
+	defer body.Close()
+	report, err := Import(ctx, s, body, opts)
+	if err != nil && !errors.Is(err, ErrTooManyErrors) {
+		return nil, echoadapter.HTTPError(badRequest(err))
+	}
+	return report, nil
+}
+
+func readRequest(c echo.Context) (Options, io.ReadCloser, error) {
+	opts, err := OptionsFromRequest(c.Request())
+	if err != nil {
+		return opts, nil, store.NewError(store.ErrInvalidQuery, err.Error(), err)
+	}
+	body := c.Request().Body
+	if fh, err := c.FormFile("file"); err == nil {
+		f, err := fh.Open()
+		if err != nil {
+			return opts, nil, store.NewError(store.ErrInvalidQuery, err.Error(), err)
+		}
+		body = f
+		if c.QueryParam("format") == "" {
+			if i := strings.LastIndex(fh.Filename, "."); i >= 0 {
+				if format, err := ParseFormat(fh.Filename[i+1:]); err == nil {
+					opts.Format = format
+				}
+			}
+		}
+	}
+	return opts, body, nil
+}
+
+func badRequest(err error) error {
+	var e *store.Error
+	var he *echo.HTTPError
+	if errors.As(err, &e) || errors.As(err, &he) {
+		return err
+	}
+	return store.NewError(store.ErrInvalidQuery, err.Error(), err)
+}
+
+// OptionsFromRequest reads the format, mode, dry_run, ignore_unknown,
+// max_errors and mapping query parameters. Mapping is written
+// column:field,column:field. The format defaults to the content type, then
+// CSV.
+func OptionsFromRequest(r *http.Request) (Options, error) {
+	query := r.URL.Query()
+	opts := Options{Format: FormatCSV, Mode: ModeUpsert}
+	if text := query.Get("format"); text != "" {
+		format, err := ParseFormat(text)
+		if err != nil {
+			return opts, err
+		}
+		opts.Format = format
+	} else if ct := r.Header.Get("Content-Type"); strings.Contains(ct, "ndjson") || strings.Contains(ct, "jsonl") {
+		opts.Format = FormatNDJSON
+	}
+	switch mode := Mode(query.Get("mode")); mode {
+	case "":
+	case ModeUpsert, ModeCreate, ModeUpdate:
+		opts.Mode = mode
+	default:
+		return opts, errors.Errorf("unknown import mode %v", mode)
+	}
+	opts.DryRun, _ = strconv.ParseBool(query.Get("dry_run"))
+	opts.IgnoreUnknown, _ = strconv.ParseBool(query.Get("ignore_unknown"))
+	opts.MaxErrors, _ = strconv.Atoi(query.Get("max_errors"))
+	for _, pair := range strings.Split(query.Get("mapping"), ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		column, name, ok := strings.Cut(pair, ":")
+		if !ok {
+			return opts, errors.Errorf("invalid mapping %v", pair)
+		}
+		if opts.Mapping == nil {
+			opts.Mapping = map[string]string{}
+		}
+		opts.Mapping[strings.TrimSpace(column)] = strings.TrimSpace(name)
+	}
+	return opts, nil
+}
+
+// rowReader returns the records of a file with their line, and io.EOF at
+// the end. A record that cannot be read is returned with a RowError.
+type rowReader interface {
+	Next() (map[string]any, int, *RowError, error)
+}
+
+type csvReader struct {
+	r      *csv.Reader
+	header []string
+	line   int
+}
+
+func newCSVReader(r io.Reader) (*csvReader, error) {
+	cr := csv.NewReader(r)
+	cr.ReuseRecord = true
+	header, err := cr.Read()
+	if err != nil {
+		if err == io.EOF {
+			return nil, errors.New("empty file")
+		}
+		return nil, errors.Wrap(err, "read header")
+	}
+	header = append([]string{}, header...)
+	if len(header) > 0 {
+		header[0] = strings.TrimPrefix(header[0], "\ufeff")
+	}
+	return &csvReader{r: cr, header: header, line: 1}, nil
+}
+
+func (c *csvReader) Next() (map[string]any, int, *RowError, error) {
+	record, err := c.r.Read()
+	if err == io.EOF {
+		return nil, 0, nil, err
+	}
+	c.line++
+	if err != nil {
+		var pe *csv.ParseError
+		if errors.As(err, &pe) {
+			c.line = pe.Line
+			return nil, pe.Line, &RowError{Row: pe.Line, Message: pe.Err.Error()}, nil
+		}
+		return nil, c.line, nil, err
+	}
+	line, _ := c.r.FieldPos(0)
+	c.line = line
+	row := make(map[string]any, len(c.header))
+	for i, name := range c.header {
+		if name != "" && i < len(record) {
+			row[name] = record[i]
+		}
+	}
+	return row, line, nil, nil
+}
+
+type ndjsonReader struct {
+	r    *bufio.Reader
+	line int
+}
+
+func (n *ndjsonReader) Next() (map[string]any, int, *RowError, error) {
+	for {
+		b, err := n.r.ReadBytes('\n')
+		if err != nil && (err != io.EOF || len(b) == 0) {
+			return nil, 0, nil, err
+		}
+		n.line++
+		b = bytes.TrimSpace(b)
+		if len(b) == 0 {
+			continue
+		}
+		d := json.NewDecoder(bytes.NewReader(b))
+		d.UseNumber()
+		row := map[string]any{}
+		if err := d.Decode(&row); err != nil {
+			return nil, n.line, &RowError{Row: n.line, Message: "invalid JSON: " + err.Error()}, nil
+		}
+		return row, n.line, nil, nil
+	}
+}
+
+func newRowReader(r io.Reader, format Format) (rowReader, error) {
+	switch format {
+	case FormatNDJSON:
+		return &ndjsonReader{r: bufio.NewReader(r)}, nil
+	case FormatCSV, "":
+		return newCSVReader(r)
+	}
+	return nil, errors.Wrap(ErrUnknownFormat, string(format))
+}
+
+type validator interface {
+	Validate() error
+}
+
+// run imports the rows of r after skipping the first skip records. report
+// is called after each record with the progress and the new errors.
+func run[T any](ctx context.Context, s store.Store[T], r io.Reader, opts Options, skip int, report func(p Progress, errs []RowError) error) error {
+	if opts.Mode == "" {
+		opts.Mode = ModeUpsert
+	}
+	fs, err := parseFields[T](opts.Mapping)
+	if err != nil {
+		return err
+	}
+	rows, err := newRowReader(r, opts.Format)
+	if err != nil {
+		return err
+	}
+	p := Progress{}
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		row, line, rowErr, err := rows.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return errors.Wrap(err, "read file")
+		}
+		p.Rows++
+		if p.Rows <= skip {
+			continue
+		}
+		var errs []RowError
+		if rowErr != nil {
+			errs = []RowError{*rowErr}
+		} else {
+			created, rowErrs, err := importRow(ctx, s, fs, opts, row, line)
+			if err != nil {
+				return err
+			}
+			errs = rowErrs
+			if len(errs) == 0 {
+				if created {
+					p.Created++
+				} else {
+					p.Updated++
+				}
+			}
+		}
+		if len(errs) > 0 {
+			p.Failed++
+		}
+		if err := report(p, errs); err != nil {
+			return err
+		}
+		if opts.MaxErrors > 0 && p.Failed >= opts.MaxErrors {
+			return ErrTooManyErrors
+		}
+	}
+}
+
+// importRow writes one record. It reports whether the object is new, or the
+// errors of the row. Errors returned stop the import.
+func importRow[T any](ctx context.Context, s store.Store[T], fs *fields, opts Options, row map[string]any, line int) (bool, []RowError, error) {
+	values := map[string]any{}
+	errs := []RowError{}
+	for key, v := range row {
+		f, sub, ok := fs.resolve(key)
+		if !ok {
+			if !opts.IgnoreUnknown {
+				errs = append(errs, RowError{Row: line, Column: key, Message: "unknown column"})
+			}
+			continue
+		}
+		if sub != "" {
+			// exports write missing keys as empty cells
+			if s, ok := v.(string); ok && s == "" {
+				continue
+			}
+			m, _ := values[f.name].(map[string]any)
+			if m == nil {
+				m = map[string]any{}
+				values[f.name] = m
+			}
+			m[sub] = coerceKey(v)
+			continue
+		}
+		out, err := f.coerce(v)
+		if err != nil {
+			errs = append(errs, RowError{Row: line, Column: key, Message: err.Error()})
+			continue
+		}
+		if m, ok := values[f.name].(map[string]any); ok {
+			// keys written column.key win over a whole object
+			if om, ok := out.(map[string]any); ok {
+				for k, v := range om {
+					if _, ok := m[k]; !ok {
+						m[k] = v
+					}
+				}
+			}
+			continue
+		}
+		values[f.name] = out
+	}
+	if len(errs) > 0 {
+		return false, errs, nil
+	}
+	data, err := json.Marshal(values)
+	if err != nil {
+		return false, []RowError{{Row: line, Message: err.Error()}}, nil
+	}
+
+	var obj T
+	if err := json.Unmarshal(data, &obj); err != nil {
+		return false, []RowError{{Row: line, Message: err.Error()}}, nil
+	}
+	var existing *T
+	if opts.Mode != ModeCreate {
+		if conditions, ok := primaryKey(fs, &obj); ok {
+			existing, err = s.Get(ctx, store.GetRequest{Conditions: conditions})
+			if err != nil && !isNotFound(err) {
+				if ctx.Err() != nil {
+					return false, nil, ctx.Err()
+				}
+				return false, []RowError{{Row: line, Message: errorMessage(err)}}, nil
+			}
+		}
+	}
+	if existing != nil {
+		obj = *existing
+		if err := json.Unmarshal(data, &obj); err != nil {
+			return false, []RowError{{Row: line, Message: err.Error()}}, nil
+		}
+	} else if opts.Mode == ModeUpdate {
+		return false, []RowError{{Row: line, Message: "not found"}}, nil
+	}
+	if v, ok := any(&obj).(validator); ok {
+		if err := v.Validate(); err != nil {
+			return false, []RowError{{Row: line, Message: err.Error()}}, nil
+		}
+	}
+	if opts.Validate != nil {
+		if err := opts.Validate(&obj); err != nil {
+			return false, []RowError{{Row: line, Message: err.Error()}}, nil
+		}
+	}
+	if opts.DryRun {
+		return existing == nil, nil, nil
+	}
+	if existing != nil {
+		err = s.Update(ctx, &obj, nil)
+	} else {
+		err = s.Create(ctx, &obj)
+	}
+	if err != nil {
+		if ctx.Err() != nil {
+			return false, nil, ctx.Err()
+		}
+		return false, []RowError{{Row: line, Message: errorMessage(err)}}, nil
+	}
+	return existing == nil, nil, nil
+}
+
+// primaryKey returns the primary key conditions of obj, if it has a key.
+func primaryKey[T any](fs *fields, obj *T) (map[string]any, bool) {
+	if len(fs.schema.PrimaryFields) == 0 {
+		return nil, false
+	}
+	rv := reflect.ValueOf(obj).Elem()
+	conditions := map[string]any{}
+	for _, f := range fs.schema.PrimaryFields {
+		v, zero := f.ValueOf(context.Background(), rv)
+		if zero {
+			return nil, false
+		}
+		conditions[f.DBName] = v
+	}
+	return conditions, true
+}
+
+func isNotFound(err error) bool {
+	return errors.Is(err, store.ErrNotFound)
+}
+
+func errorMessage(err error) string {
+	var e *store.Error
+	if errors.As(err, &e) && e.Message != "" {
+		return e.Message
+	}
+	return err.Error()
+}
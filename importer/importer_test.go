@@ -0,0 +1,75 @@
+package importer_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/heypkg/store"
+	"github.com/heypkg/store/export"
+	"github.com/heypkg/store/importer"
+	"github.com/heypkg/store/jsontype"
+	"github.com/heypkg/store/memory"
+	"github.com/heypkg/store/storetest"
+)
+
+func TestRoundTrip(t *testing.T) {
+	ctx := storetest.Context("a")
+	src := memory.NewStore[storetest.Device]()
+	for _, d := range []*storetest.Device{
+		{Name: "d1", Kind: "a", Value: 1.5, Tags: jsontype.Tags{"site": "x"}},
+		{Name: "d, 2", Kind: "b", Value: -2},
+	} {
+		if err := src.Create(ctx, d); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, _, err := src.List(ctx, store.ListRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, format := range []importer.Format{importer.FormatCSV, importer.FormatNDJSON} {
+		var buf bytes.Buffer
+		opts := export.Options{Format: export.Format(format), Columns: []string{"ID", "Name", "Kind", "Value", "Tags.site"}}
+		if format == importer.FormatNDJSON {
+			opts.Columns = []string{"ID", "Name", "Kind", "Value", "Tags"}
+		}
+		if err := export.Write[storetest.Device](ctx, &buf, src, store.ListRequest{}, opts); err != nil {
+			t.Fatal(err)
+		}
+		dst := memory.NewStore[storetest.Device]()
+		report, err := importer.Import[storetest.Device](ctx, dst, &buf, importer.Options{Format: format, Mode: importer.ModeCreate})
+		if err != nil || report.Created != 2 || len(report.Errors) != 0 {
+			t.Fatalf("%v: %+v, %v", format, report, err)
+		}
+		got, _, err := dst.List(ctx, store.ListRequest{})
+		if err != nil || len(got) != len(want) {
+			t.Fatalf("%v: %+v, %v", format, got, err)
+		}
+		for i := range want {
+			g, w := got[i], want[i]
+			if g.ID != w.ID || g.Name != w.Name || g.Kind != w.Kind || g.Value != w.Value || g.Schema != "a" || g.Tags["site"] != w.Tags["site"] {
+				t.Errorf("%v: %+v; want %+v", format, g, w)
+			}
+		}
+	}
+}
+
+func TestImportErrors(t *testing.T) {
+	ctx := storetest.Context("a")
+	s := memory.NewStore[storetest.Device]()
+	if err := s.Create(ctx, &storetest.Device{Name: "d1"}); err != nil {
+		t.Fatal(err)
+	}
+	csv := "ID,Name,Value\n1,renamed,1\n,d2,x\n,d3,3\n"
+	report, err := importer.Import[storetest.Device](ctx, s, strings.NewReader(csv), importer.Options{Format: importer.FormatCSV, DryRun: true})
+	if err != nil || report.Updated != 1 || report.Created != 1 || report.Failed != 1 || len(report.Errors) != 1 || report.Errors[0].Row != 3 {
+		t.Fatalf("dry run: %+v, %v", report, err)
+	}
+	if _, total, _ := s.List(ctx, store.ListRequest{}); total != 1 {
+		t.Errorf("dry run wrote %v objects", total)
+	}
+	if d, err := s.Get(ctx, store.GetRequest{Conditions: map[string]any{"id": 1}}); err != nil || d.Name != "d1" {
+		t.Errorf("dry run updated: %+v, %v", d, err)
+	}
+}
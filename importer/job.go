@@ -0,0 +1,420 @@
+package importer
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/heypkg/store"
+	"github.com/heypkg/store/audit"
+	"github.com/heypkg/store/echoadapter"
+	"github.com/heypkg/store/policy"
+	"github.com/heypkg/store/tenancy"
+	"github.com/labstack/echo/v4"
+	"github.com/pkg/errors"
+)
+
+type Status string
+
+const (
+	StatusPending   Status = "pending"
+	StatusRunning   Status = "running"
+	StatusDone      Status = "done"
+	StatusFailed    Status = "failed"
+	StatusCancelled Status = "cancelled"
+)
+
+// Job is a background import. Jobs are saved in the directory of their
+// manager with the uploaded file and the error report, so that unfinished
+// jobs resume after a restart.
+type Job struct {
+	ID      string   `json:"id"`
+	Status  Status   `json:"status"`
+	Options Options  `json:"options"`
+	Tenant  string   `json:"tenant,omitempty"`
+	Actor   string   `json:"actor,omitempty"`
+	Groups  []string `json:"groups,omitempty"`
+	Progress
+	Error     string    `json:"error,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	// ErrorsSize is the size of the error report at the last save; rows
+	// read after it are read again on resume.
+	ErrorsSize int64 `json:"errors_size"`
+}
+
+// Manager runs the import jobs of model T.
+type Manager[T any] struct {
+	store store.Store[T]
+	dir   string
+	// Strategy resolves the tenant of resumed jobs. It defaults to the
+	// default strategy.
+	Strategy tenancy.Strategy
+	// Validate is the Options.Validate of every job.
+	Validate func(obj any) error
+	// SaveEvery is the number of rows between saves of the progress. It
+	// defaults to 100.
+	SaveEvery int
+
+	mu      sync.Mutex
+	jobs    map[string]*Job
+	cancels map[string]context.CancelFunc
+	wg      sync.WaitGroup
+}
+
+// NewManager returns the manager of the jobs saved in dir. Call Resume to
+// restart unfinished jobs.
+func NewManager[T any](s store.Store[T], dir string) (*Manager[T], error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, errors.Wrap(err, "create import directory")
+	}
+	m := &Manager[T]{store: s, dir: dir, jobs: map[string]*Job{}, cancels: map[string]context.CancelFunc{}}
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, errors.Wrap(err, "read import job")
+		}
+		job := &Job{}
+		if err := json.Unmarshal(data, job); err != nil {
+			return nil, errors.Wrapf(err, "read import job %v", path)
+		}
+		m.jobs[job.ID] = job
+	}
+	return m, nil
+}
+
+func (m *Manager[T]) path(id string, ext string) string {
+	return filepath.Join(m.dir, id+ext)
+}
+
+// Start saves the file of r and imports it in the background for the
+// tenant and subject of ctx.
+func (m *Manager[T]) Start(ctx context.Context, r io.Reader, opts Options) (*Job, error) {
+	if opts.Format != "" {
+		if _, err := ParseFormat(string(opts.Format)); err != nil {
+			return nil, err
+		}
+	}
+	id, err := newJobID()
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(m.path(id, ".data"), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, errors.Wrap(err, "save import file")
+	}
+	_, err = io.Copy(f, r)
+	if err2 := f.Close(); err == nil {
+		err = err2
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return nil, errors.Wrap(err, "save import file")
+	}
+	subject := policy.SubjectFromContext(ctx)
+	now := time.Now()
+	job := &Job{
+		ID:        id,
+		Status:    StatusPending,
+		Options:   opts,
+		Tenant:    tenancy.ScopeFromContext(ctx).Tenant,
+		Actor:     audit.ActorFromContext(ctx),
+		Groups:    subject.Groups,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if job.Actor == "" {
+		job.Actor = subject.ID
+	}
+	m.mu.Lock()
+	m.jobs[id] = job
+	err = m.save(job)
+	m.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	m.launch(job, tenancy.ScopeFromContext(ctx).Strategy)
+	return m.copy(job), nil
+}
+
+// Resume restarts the jobs that were pending or running.
+func (m *Manager[T]) Resume() {
+	m.mu.Lock()
+	jobs := []*Job{}
+	for _, job := range m.jobs {
+		if (job.Status == StatusPending || job.Status == StatusRunning) && m.cancels[job.ID] == nil {
+			jobs = append(jobs, job)
+		}
+	}
+	m.mu.Unlock()
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].CreatedAt.Before(jobs[j].CreatedAt) })
+	for _, job := range jobs {
+		m.launch(job, m.Strategy)
+	}
+}
+
+// Close stops the running jobs, which stay resumable, and waits for them.
+func (m *Manager[T]) Close() {
+	m.mu.Lock()
+	for _, cancel := range m.cancels {
+		cancel()
+	}
+	m.mu.Unlock()
+	m.wg.Wait()
+}
+
+func (m *Manager[T]) launch(job *Job, strategy tenancy.Strategy) {
+	ctx, cancel := context.WithCancel(context.Background())
+	ctx = tenancy.WithScope(ctx, tenancy.NewScope(strategy, job.Tenant))
+	ctx = policy.WithSubject(ctx, policy.Subject{ID: job.Actor, Groups: job.Groups})
+	ctx = audit.WithActor(ctx, job.Actor)
+	m.mu.Lock()
+	m.cancels[job.ID] = cancel
+	m.mu.Unlock()
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		defer cancel()
+		m.run(ctx, job)
+	}()
+}
+
+func (m *Manager[T]) run(ctx context.Context, job *Job) {
+	m.mu.Lock()
+	if job.Status == StatusCancelled {
+		delete(m.cancels, job.ID)
+		m.mu.Unlock()
+		return
+	}
+	job.Status = StatusRunning
+	skip := job.Rows
+	done := job.Progress
+	opts := job.Options
+	errorsSize := job.ErrorsSize
+	m.save(job)
+	m.mu.Unlock()
+	opts.Validate = m.Validate
+
+	err := func() error {
+		data, err := os.Open(m.path(job.ID, ".data"))
+		if err != nil {
+			return errors.Wrap(err, "open import file")
+		}
+		defer data.Close()
+		report, err := os.OpenFile(m.path(job.ID, ".errors.csv"), os.O_CREATE|os.O_RDWR, 0600)
+		if err != nil {
+			return errors.Wrap(err, "open error report")
+		}
+		defer report.Close()
+		if err := report.Truncate(errorsSize); err != nil {
+			return errors.Wrap(err, "open error report")
+		}
+		if _, err := report.Seek(errorsSize, io.SeekStart); err != nil {
+			return errors.Wrap(err, "open error report")
+		}
+		cw := csv.NewWriter(report)
+		if errorsSize == 0 {
+			cw.Write([]string{"row", "column", "message"})
+		}
+		saveEvery := m.SaveEvery
+		if saveEvery <= 0 {
+			saveEvery = 100
+		}
+		err = run(ctx, m.store, data, opts, skip, func(p Progress, errs []RowError) error {
+			for _, e := range errs {
+				cw.Write([]string{strconv.Itoa(e.Row), e.Column, e.Message})
+			}
+			p.Created += done.Created
+			p.Updated += done.Updated
+			p.Failed += done.Failed
+			m.mu.Lock()
+			defer m.mu.Unlock()
+			job.Progress = p
+			if p.Rows%saveEvery != 0 {
+				return nil
+			}
+			cw.Flush()
+			if err := cw.Error(); err != nil {
+				return err
+			}
+			if job.ErrorsSize, err = report.Seek(0, io.SeekCurrent); err != nil {
+				return err
+			}
+			return m.save(job)
+		})
+		cw.Flush()
+		if err2 := cw.Error(); err == nil {
+			err = err2
+		}
+		return err
+	}()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.cancels, job.ID)
+	if info, statErr := os.Stat(m.path(job.ID, ".errors.csv")); statErr == nil {
+		job.ErrorsSize = info.Size()
+	}
+	switch {
+	case err == nil:
+		job.Status = StatusDone
+	case errors.Is(err, context.Canceled) && job.Status == StatusCancelled:
+	case errors.Is(err, context.Canceled):
+		// stopped by Close; resumable
+		job.Status = StatusRunning
+	default:
+		job.Status = StatusFailed
+		job.Error = err.Error()
+	}
+	m.save(job)
+	if job.Status != StatusRunning {
+		os.Remove(m.path(job.ID, ".data"))
+	}
+}
+
+// save writes job. Callers hold the lock.
+func (m *Manager[T]) save(job *Job) error {
+	job.UpdatedAt = time.Now()
+	data, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	tmp := m.path(job.ID, ".json.tmp")
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return errors.Wrap(err, "save import job")
+	}
+	return errors.Wrap(os.Rename(tmp, m.path(job.ID, ".json")), "save import job")
+}
+
+func (m *Manager[T]) copy(job *Job) *Job {
+	out := *job
+	return &out
+}
+
+// Job returns the job of the tenant of ctx.
+func (m *Manager[T]) Job(ctx context.Context, id string) (*Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	job, ok := m.jobs[id]
+	if !ok || job.Tenant != tenancy.ScopeFromContext(ctx).Tenant {
+		return nil, store.NotFound("not found")
+	}
+	return m.copy(job), nil
+}
+
+// Cancel stops a job of the tenant of ctx. Cancelled jobs do not resume.
+func (m *Manager[T]) Cancel(ctx context.Context, id string) (*Job, error) {
+	if _, err := m.Job(ctx, id); err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	job := m.jobs[id]
+	if job.Status != StatusPending && job.Status != StatusRunning {
+		return m.copy(job), nil
+	}
+	job.Status = StatusCancelled
+	if cancel := m.cancels[id]; cancel != nil {
+		cancel()
+	} else {
+		os.Remove(m.path(id, ".data"))
+	}
+	return m.copy(job), m.save(job)
+}
+
+// WriteErrors copies the error report of a job of the tenant of ctx to w.
+func (m *Manager[T]) WriteErrors(ctx context.Context, id string, w io.Writer) error {
+	job, err := m.Job(ctx, id)
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(m.path(id, ".errors.csv"))
+	if errors.Is(err, os.ErrNotExist) {
+		return WriteErrors(w, nil)
+	}
+	if err != nil {
+		return store.Internal(err)
+	}
+	defer f.Close()
+	_, err = io.Copy(w, io.LimitReader(f, job.ErrorsSize))
+	return err
+}
+
+// StartEcho starts a job with the file and options of c, read like Echo.
+func (m *Manager[T]) StartEcho(c echo.Context) (*Job, error) {
+	ctx, err := echoadapter.Context(c)
+	if err != nil {
+		return nil, echoadapter.HTTPError(err)
+	}
+	opts, body, err := readRequest(c)
+	if err != nil {
+		return nil, echoadapter.HTTPError(err)
+	}
+	defer body.Close()
+	job, err := m.Start(ctx, body, opts)
+	if err != nil {
+		return nil, echoadapter.HTTPError(badRequest(err))
+	}
+	return job, nil
+}
+
+// JobEcho returns the job of the id path parameter, for progress endpoints.
+func (m *Manager[T]) JobEcho(c echo.Context) (*Job, error) {
+	ctx, err := echoadapter.Context(c)
+	if err != nil {
+		return nil, echoadapter.HTTPError(err)
+	}
+	job, err := m.Job(ctx, c.Param("id"))
+	return job, echoadapter.HTTPError(err)
+}
+
+// CancelEcho cancels the job of the id path parameter.
+func (m *Manager[T]) CancelEcho(c echo.Context) (*Job, error) {
+	ctx, err := echoadapter.Context(c)
+	if err != nil {
+		return nil, echoadapter.HTTPError(err)
+	}
+	job, err := m.Cancel(ctx, c.Param("id"))
+	return job, echoadapter.HTTPError(err)
+}
+
+// ErrorsEcho serves the error report of the job of the id path parameter
+// as a CSV attachment.
+func (m *Manager[T]) ErrorsEcho(c echo.Context) error {
+	ctx, err := echoadapter.Context(c)
+	if err != nil {
+		return echoadapter.HTTPError(err)
+	}
+	id := c.Param("id")
+	if _, err := m.Job(ctx, id); err != nil {
+		return echoadapter.HTTPError(err)
+	}
+	h := c.Response().Header()
+	h.Set("Content-Type", "text/csv; charset=UTF-8")
+	h.Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", "import-"+id+"-errors.csv"))
+	c.Response().WriteHeader(http.StatusOK)
+	return echoadapter.HTTPError(m.WriteErrors(ctx, id, c.Response()))
+}
+
+func newJobID() (string, error) {
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		return "", errors.Wrap(err, "new job id")
+	}
+	return hex.EncodeToString(b), nil
+}
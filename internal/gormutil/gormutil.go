@@ -0,0 +1,78 @@
+// Package gormutil holds the helpers of the GORM callbacks that record the
+// objects of writes, shared by the audit log and the outbox.
+package gormutil
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+// DeletedAtField returns the soft delete field of s, or nil.
+func DeletedAtField(s *schema.Schema) *schema.Field {
+	for _, field := range s.Fields {
+		if field.FieldType == reflect.TypeOf(gorm.DeletedAt{}) {
+			return field
+		}
+	}
+	return nil
+}
+
+// EachObject calls fn with the objects of the statement of db.
+func EachObject(db *gorm.DB, fn func(rv reflect.Value)) {
+	rv := reflect.Indirect(db.Statement.ReflectValue)
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < rv.Len(); i++ {
+			fn(reflect.Indirect(rv.Index(i)))
+		}
+	case reflect.Struct:
+		fn(rv)
+	}
+}
+
+// LoadObject reads the row of the object rv of the statement of db,
+// including soft-deleted rows. It fails for hard deletes.
+func LoadObject(db *gorm.DB, rv reflect.Value) (reflect.Value, bool) {
+	stmt := db.Statement
+	obj := reflect.New(stmt.Schema.ModelType)
+	result := db.Session(&gorm.Session{NewDB: true}).Unscoped().
+		Table(stmt.Table).
+		Where(PrimaryKeyCondition(stmt, rv)).
+		Take(obj.Interface())
+	if result.Error != nil {
+		return reflect.Value{}, false
+	}
+	return obj.Elem(), true
+}
+
+// PrimaryKeyString returns the primary key of rv, its fields joined by
+// commas. It fails when a field of the key is zero.
+func PrimaryKeyString(stmt *gorm.Statement, rv reflect.Value) (string, bool) {
+	if stmt.Schema == nil || len(stmt.Schema.PrimaryFields) == 0 {
+		return "", false
+	}
+	parts := []string{}
+	for _, field := range stmt.Schema.PrimaryFields {
+		v, zero := field.ValueOf(stmt.Context, rv)
+		if zero {
+			return "", false
+		}
+		parts = append(parts, fmt.Sprintf("%v", v))
+	}
+	return strings.Join(parts, ","), true
+}
+
+// PrimaryKeyCondition returns the condition selecting the row of rv.
+func PrimaryKeyCondition(stmt *gorm.Statement, rv reflect.Value) clause.Expression {
+	exprs := []clause.Expression{}
+	for _, field := range stmt.Schema.PrimaryFields {
+		v, _ := field.ValueOf(stmt.Context, rv)
+		exprs = append(exprs, clause.Eq{Column: clause.Column{Name: field.DBName}, Value: v})
+	}
+	return clause.And(exprs...)
+}
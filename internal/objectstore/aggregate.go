@@ -0,0 +1,142 @@
+package objectstore
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/heypkg/store"
+	"github.com/heypkg/store/search"
+	"github.com/spf13/cast"
+)
+
+type group struct {
+	keys    []any
+	objects []map[string]any
+}
+
+// Aggregate computes the aggregations of the objects grouped by req.GroupBy,
+// ordered by the group values like the GORM store.
+func Aggregate[T any](objects []*T, req store.AggregateRequest) ([]map[string]any, error) {
+	groups := []*group{}
+	index := map[string]*group{}
+	if len(req.GroupBy) == 0 {
+		groups = append(groups, &group{})
+	}
+	for _, obj := range objects {
+		values, err := search.ObjectValues(obj)
+		if err != nil {
+			return nil, err
+		}
+		if len(req.GroupBy) == 0 {
+			groups[0].objects = append(groups[0].objects, values)
+			continue
+		}
+		keys := make([]any, 0, len(req.GroupBy))
+		for _, name := range req.GroupBy {
+			keys = append(keys, fieldValue(values, name))
+		}
+		id := fmt.Sprintf("%#v", keys)
+		g, ok := index[id]
+		if !ok {
+			g = &group{keys: keys}
+			index[id] = g
+			groups = append(groups, g)
+		}
+		g.objects = append(g.objects, values)
+	}
+	sort.SliceStable(groups, func(i, j int) bool {
+		for k := range req.GroupBy {
+			if c := search.Compare(groups[i].keys[k], groups[j].keys[k]); c != 0 {
+				return c < 0
+			}
+		}
+		return false
+	})
+
+	aggregations := req.Aggregations
+	if len(aggregations) == 0 {
+		aggregations = []store.Aggregation{{Name: "count", Func: store.AggregateCount, Field: "*"}}
+	}
+	out := make([]map[string]any, 0, len(groups))
+	for _, g := range groups {
+		row := map[string]any{}
+		for i, name := range req.GroupBy {
+			row[strings.ReplaceAll(name, ".", "_")] = g.keys[i]
+		}
+		for _, a := range aggregations {
+			row[a.Name] = aggregateValues(g.objects, a)
+		}
+		out = append(out, row)
+	}
+	return out, nil
+}
+
+// fieldValue returns the value of a column, or of a key of a JSON column as
+// text like the ->> operator.
+func fieldValue(values map[string]any, name string) any {
+	column, key, ok := strings.Cut(name, ".")
+	v := search.NormalizeValue(lookupValue(values, column))
+	if !ok {
+		return v
+	}
+	m, _ := v.(map[string]any)
+	sub := m[key]
+	switch vv := sub.(type) {
+	case nil:
+		return nil
+	case string:
+		return vv
+	case map[string]any, []any:
+		data, _ := json.Marshal(vv)
+		return string(data)
+	}
+	return fmt.Sprintf("%v", sub)
+}
+
+func aggregateValues(objects []map[string]any, a store.Aggregation) any {
+	if a.Field == "*" {
+		return int64(len(objects))
+	}
+	var count int64
+	var sum float64
+	var best any
+	for _, values := range objects {
+		v := fieldValue(values, a.Field)
+		if v == nil {
+			continue
+		}
+		count++
+		switch a.Func {
+		case store.AggregateSum, store.AggregateAvg:
+			if n, ok := v.(json.Number); ok {
+				v = n.String()
+			}
+			sum += cast.ToFloat64(v)
+		case store.AggregateMin:
+			if best == nil || search.Compare(v, best) < 0 {
+				best = v
+			}
+		case store.AggregateMax:
+			if best == nil || search.Compare(v, best) > 0 {
+				best = v
+			}
+		}
+	}
+	switch a.Func {
+	case store.AggregateCount:
+		return count
+	case store.AggregateSum:
+		if count == 0 {
+			return nil
+		}
+		return sum
+	case store.AggregateAvg:
+		if count == 0 {
+			return nil
+		}
+		return sum / float64(count)
+	}
+	return best
+}
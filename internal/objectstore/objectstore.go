@@ -0,0 +1,398 @@
+// Package objectstore holds the semantics shared by the stores that evaluate
+// queries in Go rather than in SQL: tenancy, policies, search, ordering,
+// pagination and soft delete, matching the GORM store.
+package objectstore
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/heypkg/store"
+	"github.com/heypkg/store/policy"
+	"github.com/heypkg/store/preload"
+	"github.com/heypkg/store/search"
+	"github.com/heypkg/store/tenancy"
+	"github.com/pkg/errors"
+	"github.com/spf13/cast"
+	"gorm.io/gorm/schema"
+)
+
+var schemaCache = &sync.Map{}
+
+// Model describes the columns of model T.
+type Model[T any] struct {
+	Schema *schema.Schema
+}
+
+func ParseModel[T any]() (*Model[T], error) {
+	var obj T
+	s, err := schema.Parse(&obj, schemaCache, schema.NamingStrategy{})
+	if err != nil {
+		return nil, store.Internal(errors.Wrap(err, "parse model"))
+	}
+	if len(s.PrimaryFields) == 0 {
+		return nil, store.Internal(errors.Errorf("model %v has no primary key", s.Name))
+	}
+	return &Model[T]{Schema: s}, nil
+}
+
+func (m *Model[T]) Value(field *schema.Field, obj *T) any {
+	return field.ReflectValueOf(context.Background(), reflect.ValueOf(obj).Elem()).Interface()
+}
+
+func (m *Model[T]) IsZero(field *schema.Field, obj *T) bool {
+	_, zero := field.ValueOf(context.Background(), reflect.ValueOf(obj).Elem())
+	return zero
+}
+
+func (m *Model[T]) Set(field *schema.Field, obj *T, v any) error {
+	return field.Set(context.Background(), reflect.ValueOf(obj).Elem(), v)
+}
+
+// PrimaryKey returns the primary key of obj as text.
+func (m *Model[T]) PrimaryKey(obj *T) string {
+	parts := make([]string, 0, len(m.Schema.PrimaryFields))
+	for _, field := range m.Schema.PrimaryFields {
+		parts = append(parts, cast.ToString(search.NormalizeValue(m.Value(field, obj))))
+	}
+	return strings.Join(parts, ",")
+}
+
+func (m *Model[T]) DeletedField() *schema.Field {
+	return m.Schema.LookUpField("deleted")
+}
+
+func (m *Model[T]) IsDeleted(obj *T) bool {
+	field := m.DeletedField()
+	return field != nil && search.NormalizeValue(m.Value(field, obj)) != nil
+}
+
+// SetDeleted soft deletes or restores obj. It reports false when the model
+// has no deleted column.
+func (m *Model[T]) SetDeleted(obj *T, deleted bool) (bool, error) {
+	field := m.DeletedField()
+	if field == nil {
+		return false, nil
+	}
+	var v any
+	if deleted {
+		v = time.Now()
+	}
+	return true, m.Set(field, obj, v)
+}
+
+// Touch sets the creation and update times gorm would set.
+func (m *Model[T]) Touch(obj *T, create bool) error {
+	now := time.Now()
+	for _, field := range m.Schema.Fields {
+		if (create && field.AutoCreateTime > 0 && m.IsZero(field, obj)) || field.AutoUpdateTime > 0 {
+			if err := m.Set(field, obj, now); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// ApplyValues sets the columns of obj named by values.
+func (m *Model[T]) ApplyValues(obj *T, values map[string]any) error {
+	for name, v := range values {
+		field := m.Schema.LookUpField(name)
+		if field == nil {
+			return errors.Errorf("unknown column %v", name)
+		}
+		if err := m.Set(field, obj, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SelectFields resets the fields not listed in names, like a SELECT of those
+// columns would leave them.
+func (m *Model[T]) SelectFields(obj *T, names []string) {
+	selected := map[string]bool{}
+	for _, name := range names {
+		selected[strings.ToLower(name)] = true
+	}
+	rv := reflect.ValueOf(obj).Elem()
+	for _, field := range m.Schema.Fields {
+		if field.DBName == "" || selected[strings.ToLower(field.DBName)] {
+			continue
+		}
+		v := field.ReflectValueOf(context.Background(), rv)
+		v.Set(reflect.Zero(v.Type()))
+	}
+}
+
+// TenantField returns the tenant column of the model, or nil when tenants
+// are not separated by rows or the model has no tenant column.
+func (m *Model[T]) TenantField(a Access) *schema.Field {
+	column := a.Tenancy.Strategy.Column()
+	if column == "" {
+		return nil
+	}
+	return m.Schema.LookUpField(column)
+}
+
+func (m *Model[T]) InTenant(a Access, obj *T) bool {
+	field := m.TenantField(a)
+	return field == nil || cast.ToString(m.Value(field, obj)) == a.Tenancy.Tenant
+}
+
+// AssignTenant sets the tenant column of obj and removes it from values.
+func (m *Model[T]) AssignTenant(a Access, obj *T, values map[string]any) error {
+	column := a.Tenancy.Strategy.Column()
+	if column == "" {
+		return nil
+	}
+	for k := range values {
+		if strings.EqualFold(k, column) {
+			delete(values, k)
+		}
+	}
+	if field := m.TenantField(a); field != nil {
+		return m.Set(field, obj, a.Tenancy.Tenant)
+	}
+	return nil
+}
+
+// Access holds the tenant and the policy of a call, like the query scope of
+// the GORM store.
+type Access struct {
+	Tenancy tenancy.Scope
+	Action  policy.Action
+	policy  *policy.Registration
+	subject policy.Subject
+	filter  policy.Filter
+}
+
+func NewAccess[T any](ctx context.Context, action policy.Action) (Access, error) {
+	a := Access{Tenancy: tenancy.ScopeFromContext(ctx), Action: action, policy: policy.Lookup[T]()}
+	if a.policy == nil {
+		return a, nil
+	}
+	a.subject = policy.SubjectFromContext(ctx)
+	if action == policy.ActionList {
+		if err := a.Allow(nil); err != nil {
+			return Access{}, err
+		}
+	}
+	filter, err := a.policy.Policy.Filter(a.subject, action)
+	if err != nil {
+		return Access{}, store.Internal(err)
+	}
+	if _, err := filter.SearchData(); err != nil {
+		return Access{}, store.Internal(err)
+	}
+	a.filter = filter
+	return a, nil
+}
+
+// Partition returns the partition holding the tenant's objects: the tenant
+// for strategies that do not separate tenants by rows, "" otherwise.
+func (a Access) Partition() string {
+	if a.Tenancy.Strategy.Column() == "" {
+		return a.Tenancy.Tenant
+	}
+	return ""
+}
+
+func (a Access) DeniedStatus() int {
+	if a.policy == nil {
+		return http.StatusForbidden
+	}
+	return a.policy.DeniedStatus
+}
+
+func (a Access) Denied(status int) error {
+	err := errors.Wrap(policy.ErrDenied, string(a.Action))
+	return store.NewError(store.ErrorOfStatus(status), err.Error(), err)
+}
+
+func (a Access) Allow(obj any) error {
+	if a.policy == nil {
+		return nil
+	}
+	allowed, err := a.policy.Policy.Allow(a.subject, a.Action, obj)
+	if err != nil {
+		return store.Internal(err)
+	}
+	if !allowed {
+		status := a.DeniedStatus()
+		if obj == nil || a.Action == policy.ActionCreate {
+			status = http.StatusForbidden
+		}
+		return a.Denied(status)
+	}
+	return nil
+}
+
+func (a Access) Filtered() bool {
+	return a.policy != nil && !a.filter.IsEmpty()
+}
+
+// Visible reports whether the policy filter lets the subject see obj.
+func (a Access) Visible(obj any) (bool, error) {
+	if !a.Filtered() {
+		return true, nil
+	}
+	ok, err := a.filter.Evaluate(obj)
+	if err != nil {
+		return false, store.Internal(err)
+	}
+	return ok, nil
+}
+
+// Authorize checks a write like the GORM store: the policy filter is applied
+// to obj for creates and to stored, the current object, otherwise.
+func (a Access) Authorize(obj any, stored any) error {
+	if a.policy == nil {
+		return nil
+	}
+	if err := a.Allow(obj); err != nil {
+		return err
+	}
+	if !a.Filtered() {
+		return nil
+	}
+	if a.Action == policy.ActionCreate {
+		stored = obj
+	} else if stored == nil || reflect.ValueOf(stored).IsNil() {
+		return a.Denied(a.DeniedStatus())
+	}
+	ok, err := a.Visible(stored)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		if a.Action == policy.ActionCreate {
+			return a.Denied(http.StatusForbidden)
+		}
+		return a.Denied(a.DeniedStatus())
+	}
+	return nil
+}
+
+// Match reports whether obj is visible to the subject and matches data.
+func (a Access) Match(obj any, data search.SearchData, handleFuncs search.SearchDataHandleFuncMap) (bool, error) {
+	ok, err := a.Visible(obj)
+	if err != nil || !ok {
+		return false, err
+	}
+	ok, err = data.Match(obj, handleFuncs)
+	if err != nil {
+		if errors.Is(err, search.ErrNotEvaluable) {
+			return false, store.Internal(err)
+		}
+		return false, store.InvalidQuery(err)
+	}
+	return ok, nil
+}
+
+func ParseSearch(text string, a Access) (search.SearchData, error) {
+	data, err := search.ParseSearchString(text)
+	if err != nil {
+		return nil, store.InvalidQuery(err)
+	}
+	if err := a.Tenancy.Check(data); err != nil {
+		return nil, store.InvalidQuery(err)
+	}
+	return data, nil
+}
+
+// CheckIncludes validates client includes. Associations are returned as
+// stored; includes are not loaded.
+func CheckIncludes[T any](text string) error {
+	includes, err := preload.ParseIncludeString(text)
+	if err == nil {
+		_, err = preload.Check[T](includes)
+	}
+	if err != nil {
+		return store.NewError(store.ErrInvalidQuery, errors.Wrap(err, "invalid include").Error(), err)
+	}
+	return nil
+}
+
+// MatchConditions reports whether the columns of obj equal conditions.
+func MatchConditions(obj any, conditions map[string]any) (bool, error) {
+	values, err := search.ObjectValues(obj)
+	if err != nil {
+		return false, store.Internal(err)
+	}
+	for name, v := range conditions {
+		if search.Compare(lookupValue(values, name), v) != 0 {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// Page sorts objects by req.OrderBy and returns the page of req together
+// with the fields of req.Select.
+func Page[T any](m *Model[T], objects []*T, req store.ListRequest) []T {
+	total := len(objects)
+	if orders := search.ParseOrderByString(req.OrderBy); len(orders) > 0 {
+		values := make(map[*T]map[string]any, len(objects))
+		for _, obj := range objects {
+			values[obj], _ = search.ObjectValues(obj)
+		}
+		sort.SliceStable(objects, func(i, j int) bool {
+			for _, o := range orders {
+				c := search.Compare(lookupValue(values[objects[i]], o.Name), lookupValue(values[objects[j]], o.Name))
+				if c == 0 {
+					continue
+				}
+				if o.Desc {
+					return c > 0
+				}
+				return c < 0
+			}
+			return false
+		})
+	}
+	if req.PageSize > 0 {
+		page := req.Page
+		totalPage := (total + req.PageSize - 1) / req.PageSize
+		if page > totalPage {
+			page = totalPage
+		}
+		if page < 1 {
+			page = 1
+		}
+		start := (page - 1) * req.PageSize
+		end := start + req.PageSize
+		if end > total {
+			end = total
+		}
+		if start > total {
+			start = total
+		}
+		objects = objects[start:end]
+	}
+	out := make([]T, 0, len(objects))
+	for _, obj := range objects {
+		if len(req.Select) > 0 {
+			m.SelectFields(obj, req.Select)
+		}
+		out = append(out, *obj)
+	}
+	return out
+}
+
+func lookupValue(values map[string]any, name string) any {
+	if v, ok := values[name]; ok {
+		return v
+	}
+	for k, v := range values {
+		if strings.EqualFold(k, name) {
+			return v
+		}
+	}
+	return nil
+}
@@ -0,0 +1,328 @@
+package memory
+
+import (
+	"context"
+	"net/http"
+	"sync"
+
+	"github.com/heypkg/store"
+	"github.com/heypkg/store/internal/objectstore"
+	"github.com/heypkg/store/policy"
+	"github.com/heypkg/store/search"
+	"github.com/spf13/cast"
+	"gorm.io/gorm"
+)
+
+// Store keeps the objects of model T in memory, with the semantics of the
+// GORM store: the search language, ordering, pagination, soft delete through
+// the deleted column, tenancy and policies.
+//
+// Tenants of column strategies share the store and are filtered by the
+// tenant column; tenants of other strategies get separate partitions.
+// Associations are returned as stored: includes are validated, not loaded.
+type Store[T any] struct {
+	mu         sync.RWMutex
+	seq        int64
+	partitions map[string][]*T
+}
+
+var (
+	_ store.Store[struct{}]   = (*Store[struct{}])(nil)
+	_ store.Scanner[struct{}] = (*Store[struct{}])(nil)
+)
+
+func NewStore[T any]() *Store[T] {
+	return &Store[T]{partitions: map[string][]*T{}}
+}
+
+// lookup returns the stored object with the primary key of obj, deleted or
+// not. Callers hold the lock.
+func (s *Store[T]) lookup(m *objectstore.Model[T], a objectstore.Access, obj *T) *T {
+	key := m.PrimaryKey(obj)
+	for _, stored := range s.partitions[a.Partition()] {
+		if m.InTenant(a, stored) && m.PrimaryKey(stored) == key {
+			return stored
+		}
+	}
+	return nil
+}
+
+// match returns copies of the objects of the tenant that match data and the
+// policy filter. It stops when ctx is done. Callers hold the lock.
+func (s *Store[T]) match(ctx context.Context, m *objectstore.Model[T], a objectstore.Access, data search.SearchData, handleFuncs search.SearchDataHandleFuncMap, deleted bool) ([]*T, error) {
+	out := []*T{}
+	for _, stored := range s.partitions[a.Partition()] {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if !m.InTenant(a, stored) || m.IsDeleted(stored) != deleted {
+			continue
+		}
+		ok, err := a.Match(stored, data, handleFuncs)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			obj := *stored
+			out = append(out, &obj)
+		}
+	}
+	return out, nil
+}
+
+func (s *Store[T]) List(ctx context.Context, req store.ListRequest) ([]T, int64, error) {
+	ctx, cancel := store.WithTimeout(ctx, req.Timeout)
+	defer cancel()
+	m, err := objectstore.ParseModel[T]()
+	if err != nil {
+		return nil, 0, err
+	}
+	a, err := objectstore.NewAccess[T](ctx, policy.ActionList)
+	if err != nil {
+		return nil, 0, err
+	}
+	data, err := objectstore.ParseSearch(req.Search, a)
+	if err != nil {
+		return nil, 0, err
+	}
+	if err := objectstore.CheckIncludes[T](req.Include); err != nil {
+		return nil, 0, err
+	}
+
+	s.mu.RLock()
+	objects, err := s.match(ctx, m, a, data, req.HandleFuncs, req.Deleted)
+	s.mu.RUnlock()
+	if err != nil {
+		return nil, 0, store.ContextError(ctx, err)
+	}
+	return objectstore.Page(m, objects, req), int64(len(objects)), nil
+}
+
+// Scan calls fn with copies of the matching objects, taken when the scan
+// starts.
+func (s *Store[T]) Scan(ctx context.Context, req store.ListRequest, fn func(obj *T) error) error {
+	req.Page, req.PageSize = 0, 0
+	objects, _, err := s.List(ctx, req)
+	if err != nil {
+		return err
+	}
+	for i := range objects {
+		if err := ctx.Err(); err != nil {
+			return store.ContextError(ctx, err)
+		}
+		if err := fn(&objects[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Store[T]) Count(ctx context.Context, req store.ListRequest) (int64, error) {
+	req.Page, req.PageSize, req.OrderBy, req.Select = 0, 0, "", nil
+	_, total, err := s.List(ctx, req)
+	return total, err
+}
+
+func (s *Store[T]) Get(ctx context.Context, req store.GetRequest) (*T, error) {
+	m, err := objectstore.ParseModel[T]()
+	if err != nil {
+		return nil, err
+	}
+	a, err := objectstore.NewAccess[T](ctx, policy.ActionRead)
+	if err != nil {
+		return nil, err
+	}
+	if err := objectstore.CheckIncludes[T](req.Include); err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	hidden := false
+	for _, stored := range s.partitions[a.Partition()] {
+		if !m.InTenant(a, stored) || m.IsDeleted(stored) != req.Deleted {
+			continue
+		}
+		ok, err := objectstore.MatchConditions(stored, req.Conditions)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+		ok, err = a.Visible(stored)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			hidden = true
+			continue
+		}
+		obj := *stored
+		if err := a.Allow(&obj); err != nil {
+			return nil, err
+		}
+		return &obj, nil
+	}
+	if hidden && a.DeniedStatus() == http.StatusForbidden {
+		return nil, a.Denied(http.StatusForbidden)
+	}
+	return nil, store.NotFound("not found")
+}
+
+func (s *Store[T]) Create(ctx context.Context, obj *T) error {
+	m, err := objectstore.ParseModel[T]()
+	if err != nil {
+		return err
+	}
+	a, err := objectstore.NewAccess[T](ctx, policy.ActionCreate)
+	if err != nil {
+		return err
+	}
+	if err := m.AssignTenant(a, obj, nil); err != nil {
+		return store.Internal(err)
+	}
+	if err := a.Authorize(obj, nil); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(m.Schema.PrimaryFields) == 1 {
+		field := m.Schema.PrimaryFields[0]
+		if m.IsZero(field, obj) && field.AutoIncrement {
+			s.seq++
+			if err := m.Set(field, obj, s.seq); err != nil {
+				return store.Internal(err)
+			}
+		} else if id := cast.ToInt64(m.Value(field, obj)); id > s.seq {
+			s.seq = id
+		}
+	}
+	if s.lookup(m, a, obj) != nil {
+		return store.Conflict("duplicated key", gorm.ErrDuplicatedKey)
+	}
+	if err := m.Touch(obj, true); err != nil {
+		return store.Internal(err)
+	}
+	stored := *obj
+	s.partitions[a.Partition()] = append(s.partitions[a.Partition()], &stored)
+	return nil
+}
+
+// Update saves all fields of obj, or only the given values when values is
+// not empty. The tenant of obj cannot be changed.
+func (s *Store[T]) Update(ctx context.Context, obj *T, values map[string]any) error {
+	m, err := objectstore.ParseModel[T]()
+	if err != nil {
+		return err
+	}
+	a, err := objectstore.NewAccess[T](ctx, policy.ActionUpdate)
+	if err != nil {
+		return err
+	}
+	if err := m.AssignTenant(a, obj, values); err != nil {
+		return store.Internal(err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stored := s.lookup(m, a, obj)
+	if err := a.Authorize(obj, stored); err != nil {
+		return err
+	}
+	if stored == nil || m.IsDeleted(stored) {
+		return store.NotFound("not found")
+	}
+	updated := *obj
+	if len(values) > 0 {
+		updated = *stored
+		if err := m.ApplyValues(&updated, values); err != nil {
+			return store.Internal(err)
+		}
+	}
+	if err := m.Touch(&updated, false); err != nil {
+		return store.Internal(err)
+	}
+	*stored = updated
+	*obj = updated
+	return nil
+}
+
+func (s *Store[T]) Delete(ctx context.Context, obj *T) error {
+	return s.setDeleted(ctx, policy.ActionDelete, obj, true)
+}
+
+func (s *Store[T]) Restore(ctx context.Context, obj *T) error {
+	return s.setDeleted(ctx, policy.ActionRestore, obj, false)
+}
+
+func (s *Store[T]) setDeleted(ctx context.Context, action policy.Action, obj *T, deleted bool) error {
+	m, err := objectstore.ParseModel[T]()
+	if err != nil {
+		return err
+	}
+	a, err := objectstore.NewAccess[T](ctx, action)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stored := s.lookup(m, a, obj)
+	if err := a.Authorize(obj, stored); err != nil {
+		return err
+	}
+	if stored == nil || deleted && m.IsDeleted(stored) {
+		return store.NotFound("not found")
+	}
+	if m.IsDeleted(stored) == deleted {
+		return nil
+	}
+	ok, err := m.SetDeleted(stored, deleted)
+	if err != nil {
+		return store.Internal(err)
+	}
+	if ok {
+		_, err = m.SetDeleted(obj, deleted)
+		return err
+	}
+	if deleted {
+		objects := s.partitions[a.Partition()]
+		for i, v := range objects {
+			if v == stored {
+				s.partitions[a.Partition()] = append(objects[:i:i], objects[i+1:]...)
+				break
+			}
+		}
+	}
+	return nil
+}
+
+func (s *Store[T]) Aggregate(ctx context.Context, req store.AggregateRequest) ([]map[string]any, error) {
+	ctx, cancel := store.WithTimeout(ctx, req.Timeout)
+	defer cancel()
+	m, err := objectstore.ParseModel[T]()
+	if err != nil {
+		return nil, err
+	}
+	if err := req.Validate(); err != nil {
+		return nil, store.NewError(store.ErrInvalidQuery, err.Error(), err)
+	}
+	a, err := objectstore.NewAccess[T](ctx, policy.ActionList)
+	if err != nil {
+		return nil, err
+	}
+	data, err := objectstore.ParseSearch(req.Search, a)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	objects, err := s.match(ctx, m, a, data, req.HandleFuncs, false)
+	s.mu.RUnlock()
+	if err != nil {
+		return nil, store.ContextError(ctx, err)
+	}
+	return objectstore.Aggregate(objects, req)
+}
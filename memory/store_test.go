@@ -0,0 +1,15 @@
+package memory_test
+
+import (
+	"testing"
+
+	"github.com/heypkg/store"
+	"github.com/heypkg/store/memory"
+	"github.com/heypkg/store/storetest"
+)
+
+func TestStore(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) store.Store[storetest.Device] {
+		return memory.NewStore[storetest.Device]()
+	})
+}
@@ -0,0 +1,197 @@
+// Package metrics collects Prometheus metrics of the store and tsdb queries.
+// Nothing is collected until a collector is set with SetDefault.
+package metrics
+
+import (
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/heypkg/store"
+	"github.com/heypkg/store/search"
+	"github.com/labstack/echo/v4"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Operations of the stores.
+const (
+	OpList      = "list"
+	OpCount     = "count"
+	OpGet       = "get"
+	OpScan      = "scan"
+	OpAggregate = "aggregate"
+	OpSuggest   = "suggest"
+)
+
+// MetricsPath is the path served by Middleware.
+const MetricsPath = "/metrics"
+
+// OtherLabel replaces the label values past the limit of a collector.
+const OtherLabel = "other"
+
+// DefaultMaxLabelValues is the number of models and sources a collector
+// labels before it uses OtherLabel.
+const DefaultMaxLabelValues = 100
+
+// Collector holds the metrics of the stores. Models and sources are labels
+// of bounded cardinality: the first MaxLabelValues of each are kept and the
+// later ones are counted as OtherLabel. Statuses are "ok" or the codes of
+// store errors.
+type Collector struct {
+	// OperationDuration is store_operation_duration_seconds{op,model,status}.
+	OperationDuration *prometheus.HistogramVec
+	// RowsScanned is store_rows_scanned_total{op,model}.
+	RowsScanned *prometheus.CounterVec
+	// ParseFailures is store_parse_failures_total{model}, the searches that
+	// are not valid syntax. Failures of tsdb queries are labeled with their
+	// source.
+	ParseFailures *prometheus.CounterVec
+	// TSQueryDuration is tsdb_query_duration_seconds{source,status}.
+	TSQueryDuration *prometheus.HistogramVec
+	// TSPoints is tsdb_points_total{source}.
+	TSPoints *prometheus.CounterVec
+
+	MaxLabelValues int
+
+	mu      sync.Mutex
+	models  map[string]struct{}
+	sources map[string]struct{}
+}
+
+// NewCollector returns a collector registered with reg, or with
+// prometheus.DefaultRegisterer when reg is nil.
+func NewCollector(reg prometheus.Registerer) (*Collector, error) {
+	if reg == nil {
+		reg = prometheus.DefaultRegisterer
+	}
+	c := &Collector{
+		OperationDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "store_operation_duration_seconds",
+			Help:    "Duration of store operations.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"op", "model", "status"}),
+		RowsScanned: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "store_rows_scanned_total",
+			Help: "Rows read by store operations.",
+		}, []string{"op", "model"}),
+		ParseFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "store_parse_failures_total",
+			Help: "Searches rejected as invalid syntax.",
+		}, []string{"model"}),
+		TSQueryDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "tsdb_query_duration_seconds",
+			Help:    "Duration of time series queries.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"source", "status"}),
+		TSPoints: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "tsdb_points_total",
+			Help: "Points returned by time series queries.",
+		}, []string{"source"}),
+		MaxLabelValues: DefaultMaxLabelValues,
+		models:         map[string]struct{}{},
+		sources:        map[string]struct{}{},
+	}
+	for _, m := range []prometheus.Collector{c.OperationDuration, c.RowsScanned, c.ParseFailures, c.TSQueryDuration, c.TSPoints} {
+		if err := reg.Register(m); err != nil {
+			return nil, errors.Wrap(err, "register metrics")
+		}
+	}
+	return c, nil
+}
+
+var defaultCollector atomic.Pointer[Collector]
+
+// SetDefault sets the collector of the stores. A nil c stops collecting.
+func SetDefault(c *Collector) {
+	defaultCollector.Store(c)
+}
+
+// Default returns the collector of the stores, nil when none is set.
+func Default() *Collector {
+	return defaultCollector.Load()
+}
+
+// ObserveOperation records a store operation on model started at begin
+// with the collector set by SetDefault.
+func ObserveOperation(op string, model string, begin time.Time, rows int, err error) {
+	if c := Default(); c != nil {
+		c.ObserveOperation(op, model, begin, rows, err)
+	}
+}
+
+// ObserveTSQuery records a tsdb query of source started at begin with the
+// collector set by SetDefault.
+func ObserveTSQuery(source string, begin time.Time, points int, err error) {
+	if c := Default(); c != nil {
+		c.ObserveTSQuery(source, begin, points, err)
+	}
+}
+
+func (c *Collector) ObserveOperation(op string, model string, begin time.Time, rows int, err error) {
+	model = c.label(c.models, model)
+	c.OperationDuration.WithLabelValues(op, model, status(err)).Observe(time.Since(begin).Seconds())
+	if rows > 0 {
+		c.RowsScanned.WithLabelValues(op, model).Add(float64(rows))
+	}
+	if errors.Is(err, search.ErrInvalidSearchSyntax) {
+		c.ParseFailures.WithLabelValues(model).Inc()
+	}
+}
+
+func (c *Collector) ObserveTSQuery(source string, begin time.Time, points int, err error) {
+	source = c.label(c.sources, source)
+	c.TSQueryDuration.WithLabelValues(source, status(err)).Observe(time.Since(begin).Seconds())
+	if points > 0 {
+		c.TSPoints.WithLabelValues(source).Add(float64(points))
+	}
+	if errors.Is(err, search.ErrInvalidSearchSyntax) {
+		c.ParseFailures.WithLabelValues(source).Inc()
+	}
+}
+
+// label returns value when it is one of the first MaxLabelValues of seen.
+func (c *Collector) label(seen map[string]struct{}, value string) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := seen[value]; ok {
+		return value
+	}
+	if c.MaxLabelValues > 0 && len(seen) >= c.MaxLabelValues {
+		return OtherLabel
+	}
+	seen[value] = struct{}{}
+	return value
+}
+
+func status(err error) string {
+	if err == nil {
+		return "ok"
+	}
+	return store.ErrorOf(err).Code
+}
+
+// Handler serves the metrics of g, or of prometheus.DefaultGatherer when g
+// is nil.
+func Handler(g prometheus.Gatherer) echo.HandlerFunc {
+	if g == nil {
+		g = prometheus.DefaultGatherer
+	}
+	return echo.WrapHandler(promhttp.HandlerFor(g, promhttp.HandlerOpts{}))
+}
+
+// Middleware serves the metrics of g at MetricsPath and passes the other
+// requests on.
+func Middleware(g prometheus.Gatherer) echo.MiddlewareFunc {
+	h := Handler(g)
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if c.Request().URL.Path == MetricsPath && (c.Request().Method == http.MethodGet || c.Request().Method == http.MethodHead) {
+				return h(c)
+			}
+			return next(c)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package metrics_test
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/heypkg/store"
+	"github.com/heypkg/store/metrics"
+	"github.com/heypkg/store/search"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// series returns the label values of name, joined by commas, with the
+// sample counts of histograms and the values of counters.
+func series(t *testing.T, reg *prometheus.Registry, name string) map[string]float64 {
+	t.Helper()
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := map[string]float64{}
+	for _, f := range families {
+		if f.GetName() != name {
+			continue
+		}
+		for _, m := range f.GetMetric() {
+			key := ""
+			for i, l := range m.GetLabel() {
+				if i > 0 {
+					key += ","
+				}
+				key += l.GetName() + "=" + l.GetValue()
+			}
+			if h := m.GetHistogram(); h != nil {
+				out[key] = float64(h.GetSampleCount())
+			} else {
+				out[key] = m.GetCounter().GetValue()
+			}
+		}
+	}
+	return out
+}
+
+func TestLabelCapping(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	c, err := metrics.NewCollector(reg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.MaxLabelValues = 2
+	begin := time.Now()
+	for i := 0; i < 5; i++ {
+		c.ObserveOperation(metrics.OpList, fmt.Sprintf("m%d", i), begin, 1, nil)
+	}
+	// models seen before the limit keep their label
+	c.ObserveOperation(metrics.OpList, "m0", begin, 0, store.NotFound("not found"))
+	c.ObserveOperation(metrics.OpList, "m1", begin, 0, errors.New("boom"))
+
+	got := series(t, reg, "store_operation_duration_seconds")
+	want := map[string]float64{
+		"model=m0,op=list,status=ok":        1,
+		"model=m1,op=list,status=ok":        1,
+		"model=other,op=list,status=ok":     3,
+		"model=m0,op=list,status=not_found": 1,
+		"model=m1,op=list,status=internal":  1,
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("operations: %v; want %v", got, want)
+	}
+	if rows := series(t, reg, "store_rows_scanned_total"); rows["model=other,op=list"] != 3 || len(rows) != 3 {
+		t.Errorf("rows: %v", rows)
+	}
+
+	// sources are capped apart from models
+	for i := 0; i < 3; i++ {
+		c.ObserveTSQuery(fmt.Sprintf("s%d", i), begin, 2, nil)
+	}
+	c.ObserveTSQuery("s9", begin, 0, search.ErrInvalidSearchSyntax)
+	if points := series(t, reg, "tsdb_points_total"); points["source=s0"] != 2 || points["source=s1"] != 2 || points["source=other"] != 2 {
+		t.Errorf("points: %v", points)
+	}
+	if failures := series(t, reg, "store_parse_failures_total"); failures["model=other"] != 1 {
+		t.Errorf("parse failures: %v", failures)
+	}
+}
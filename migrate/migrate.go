@@ -0,0 +1,418 @@
+// Package migrate applies versioned migrations to a database, which can drop
+// and rename what AutoMigrate cannot. Migrations are Go functions or SQL,
+// applied in version order and recorded with a checksum in a history table;
+// a lock table keeps two processes from migrating at once.
+package migrate
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/logger"
+)
+
+var (
+	// ErrLocked is returned while another migrator holds the lock.
+	ErrLocked = errors.New("migrations are locked")
+	// ErrDrift is returned when applied migrations were changed or removed.
+	ErrDrift = errors.New("applied migrations drifted")
+	// ErrIrreversible is returned to roll back migrations without down.
+	ErrIrreversible = errors.New("migration is irreversible")
+)
+
+// Migration is a change of the schema. Up and Down are Go functions, UpSQL
+// and DownSQL statements; the function runs when both are set. Source
+// identifies the code of Go migrations in their checksum, which covers the
+// name and the SQL otherwise.
+type Migration struct {
+	Version int64
+	Name    string
+	Up      func(tx *gorm.DB) error
+	Down    func(tx *gorm.DB) error
+	UpSQL   string
+	DownSQL string
+	Source  string
+	// NoTransaction runs the migration outside of a transaction, as
+	// statements such as the creation of continuous aggregates require.
+	NoTransaction bool
+}
+
+// Checksum returns the hex SHA-256 of the definition of m.
+func (m Migration) Checksum() string {
+	h := sha256.New()
+	for _, s := range []string{m.Name, m.UpSQL, m.DownSQL, m.Source} {
+		fmt.Fprintf(h, "%d:%s;", len(s), s)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func (m Migration) String() string {
+	return fmt.Sprintf("%d_%s", m.Version, m.Name)
+}
+
+func (m Migration) reversible() bool {
+	return m.Down != nil || m.DownSQL != ""
+}
+
+// run applies m, or rolls it back when up is false.
+func (m Migration) run(tx *gorm.DB, up bool) error {
+	fn, sql := m.Up, m.UpSQL
+	if !up {
+		fn, sql = m.Down, m.DownSQL
+	}
+	if fn != nil {
+		return fn(tx)
+	}
+	if strings.TrimSpace(sql) == "" {
+		if up {
+			return nil
+		}
+		return ErrIrreversible
+	}
+	return tx.Exec(sql).Error
+}
+
+// Record is the history of an applied migration.
+type Record struct {
+	Version   int64         `gorm:"primarykey;autoIncrement:false" json:"Version"`
+	Name      string        `json:"Name"`
+	Checksum  string        `gorm:"size:64" json:"Checksum"`
+	AppliedAt time.Time     `json:"AppliedAt"`
+	Duration  time.Duration `json:"Duration"`
+}
+
+func (Record) TableName() string {
+	return "schema_migrations"
+}
+
+// Lock is the row held by the running migrator.
+type Lock struct {
+	ID       int       `gorm:"primarykey;autoIncrement:false" json:"ID"`
+	Owner    string    `json:"Owner"`
+	LockedAt time.Time `json:"LockedAt"`
+}
+
+func (Lock) TableName() string {
+	return "schema_migrations_lock"
+}
+
+// Migrator applies migrations to DB.
+type Migrator struct {
+	DB         *gorm.DB
+	Migrations []Migration
+	// DryRun logs and returns the statements of the migrations instead of
+	// executing them. Go migrations reading the database see no rows.
+	DryRun bool
+	// Owner identifies the migrator in the lock, the host and the process
+	// by default.
+	Owner  string
+	Logger *slog.Logger
+}
+
+// New returns a migrator of migrations, sorted by version, which must be
+// unique and positive.
+func New(db *gorm.DB, migrations ...Migration) (*Migrator, error) {
+	sorted := append([]Migration(nil), migrations...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Version < sorted[j].Version })
+	for i, m := range sorted {
+		if m.Version <= 0 {
+			return nil, errors.Errorf("invalid migration version %v", m.Version)
+		}
+		if i > 0 && sorted[i-1].Version == m.Version {
+			return nil, errors.Errorf("duplicate migration version %v", m.Version)
+		}
+	}
+	host, _ := os.Hostname()
+	return &Migrator{
+		DB:         db,
+		Migrations: sorted,
+		Owner:      fmt.Sprintf("%s:%d", host, os.Getpid()),
+		Logger:     slog.Default(),
+	}, nil
+}
+
+// Step is a migration applied or rolled back, with its statements in dry
+// runs.
+type Step struct {
+	Version  int64
+	Name     string
+	Up       bool
+	Duration time.Duration
+	SQL      []string
+}
+
+// State is the state of a migration in the database.
+type State struct {
+	Version   int64
+	Name      string
+	Applied   bool
+	AppliedAt time.Time
+	// Drifted reports an applied migration whose checksum changed.
+	Drifted bool
+	// Missing reports an applied migration that is no longer defined.
+	Missing bool
+}
+
+// init creates the history and lock tables, which dry runs leave missing.
+func (m *Migrator) init(ctx context.Context) error {
+	if m.DryRun {
+		return nil
+	}
+	if err := m.DB.WithContext(ctx).AutoMigrate(&Record{}, &Lock{}); err != nil {
+		return errors.Wrap(err, "migrate history")
+	}
+	return nil
+}
+
+func (m *Migrator) history(ctx context.Context) (map[int64]Record, error) {
+	applied := map[int64]Record{}
+	db := m.DB.WithContext(ctx)
+	if !db.Migrator().HasTable(&Record{}) {
+		return applied, nil
+	}
+	var records []Record
+	if err := db.Order("version").Find(&records).Error; err != nil {
+		return nil, errors.Wrap(err, "read history")
+	}
+	for _, r := range records {
+		applied[r.Version] = r
+	}
+	return applied, nil
+}
+
+// Status returns the state of the migrations, with the applied migrations
+// that are no longer defined.
+func (m *Migrator) Status(ctx context.Context) ([]State, error) {
+	if err := m.init(ctx); err != nil {
+		return nil, err
+	}
+	applied, err := m.history(ctx)
+	if err != nil {
+		return nil, err
+	}
+	states := []State{}
+	for _, mig := range m.Migrations {
+		s := State{Version: mig.Version, Name: mig.Name}
+		if r, ok := applied[mig.Version]; ok {
+			s.Applied = true
+			s.AppliedAt = r.AppliedAt
+			s.Drifted = r.Checksum != mig.Checksum()
+			delete(applied, mig.Version)
+		}
+		states = append(states, s)
+	}
+	for _, r := range applied {
+		states = append(states, State{Version: r.Version, Name: r.Name, Applied: true, AppliedAt: r.AppliedAt, Missing: true})
+	}
+	sort.SliceStable(states, func(i, j int) bool { return states[i].Version < states[j].Version })
+	return states, nil
+}
+
+// Verify returns ErrDrift when applied migrations were changed or removed.
+func (m *Migrator) Verify(ctx context.Context) error {
+	states, err := m.Status(ctx)
+	if err != nil {
+		return err
+	}
+	drifted := []string{}
+	for _, s := range states {
+		switch {
+		case s.Drifted:
+			drifted = append(drifted, fmt.Sprintf("%d_%s changed", s.Version, s.Name))
+		case s.Missing:
+			drifted = append(drifted, fmt.Sprintf("%d_%s missing", s.Version, s.Name))
+		}
+	}
+	if len(drifted) > 0 {
+		return errors.Wrap(ErrDrift, strings.Join(drifted, ", "))
+	}
+	return nil
+}
+
+// Up applies the pending migrations.
+func (m *Migrator) Up(ctx context.Context) ([]Step, error) {
+	return m.UpTo(ctx, 0)
+}
+
+// UpTo applies the pending migrations up to version, all of them when it
+// is zero.
+func (m *Migrator) UpTo(ctx context.Context, version int64) ([]Step, error) {
+	steps := []Step{}
+	err := m.locked(ctx, func(applied map[int64]Record) error {
+		for _, mig := range m.Migrations {
+			if version > 0 && mig.Version > version {
+				break
+			}
+			if _, ok := applied[mig.Version]; ok {
+				continue
+			}
+			step, err := m.apply(ctx, mig, true)
+			if err != nil {
+				return err
+			}
+			steps = append(steps, step)
+		}
+		return nil
+	})
+	return steps, err
+}
+
+// Down rolls back the last n applied migrations.
+func (m *Migrator) Down(ctx context.Context, n int) ([]Step, error) {
+	steps := []Step{}
+	err := m.locked(ctx, func(applied map[int64]Record) error {
+		for i := len(m.Migrations) - 1; i >= 0 && len(steps) < n; i-- {
+			mig := m.Migrations[i]
+			if _, ok := applied[mig.Version]; !ok {
+				continue
+			}
+			if !mig.reversible() {
+				return errors.Wrap(ErrIrreversible, mig.String())
+			}
+			step, err := m.apply(ctx, mig, false)
+			if err != nil {
+				return err
+			}
+			steps = append(steps, step)
+		}
+		return nil
+	})
+	return steps, err
+}
+
+// locked runs fn with the history while holding the lock, after checking
+// for drift. Dry runs do not take the lock.
+func (m *Migrator) locked(ctx context.Context, fn func(applied map[int64]Record) error) error {
+	if err := m.Verify(ctx); err != nil {
+		return err
+	}
+	if !m.DryRun {
+		if err := m.lock(ctx); err != nil {
+			return err
+		}
+		defer m.unlock()
+	}
+	applied, err := m.history(ctx)
+	if err != nil {
+		return err
+	}
+	return fn(applied)
+}
+
+func (m *Migrator) lock(ctx context.Context) error {
+	result := m.DB.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).
+		Create(&Lock{ID: 1, Owner: m.Owner, LockedAt: time.Now()})
+	if result.Error != nil {
+		return errors.Wrap(result.Error, "lock migrations")
+	}
+	if result.RowsAffected == 0 {
+		var lock Lock
+		m.DB.WithContext(ctx).Take(&lock, 1)
+		return errors.Wrapf(ErrLocked, "by %v since %v", lock.Owner, lock.LockedAt.Format(time.RFC3339))
+	}
+	return nil
+}
+
+func (m *Migrator) unlock() {
+	if err := m.DB.Where("id = ? AND owner = ?", 1, m.Owner).Delete(&Lock{}).Error; err != nil {
+		m.logger().Error("unlock migrations", "error", err)
+	}
+}
+
+// Unlock releases the lock whatever its owner, after a migrator died
+// holding it.
+func (m *Migrator) Unlock(ctx context.Context) error {
+	if err := m.init(ctx); err != nil {
+		return err
+	}
+	return m.DB.WithContext(ctx).Where("id = ?", 1).Delete(&Lock{}).Error
+}
+
+// apply runs mig and records it in the history, in a transaction unless
+// the migration opts out.
+func (m *Migrator) apply(ctx context.Context, mig Migration, up bool) (Step, error) {
+	step := Step{Version: mig.Version, Name: mig.Name, Up: up}
+	direction := "up"
+	if !up {
+		direction = "down"
+	}
+	begin := time.Now()
+	if m.DryRun {
+		rec := &recorder{}
+		tx := m.DB.Session(&gorm.Session{DryRun: true, Logger: rec, Context: ctx})
+		if err := mig.run(tx, up); err != nil {
+			return step, errors.Wrapf(err, "migration %v %v", mig, direction)
+		}
+		step.SQL = rec.statements
+		m.logger().InfoContext(ctx, "migration dry run", "version", mig.Version, "name", mig.Name, "direction", direction, "sql", strings.Join(step.SQL, "\n"))
+		return step, nil
+	}
+	record := func(tx *gorm.DB) error {
+		if !up {
+			return tx.Delete(&Record{}, mig.Version).Error
+		}
+		return tx.Create(&Record{
+			Version:   mig.Version,
+			Name:      mig.Name,
+			Checksum:  mig.Checksum(),
+			AppliedAt: time.Now(),
+			Duration:  time.Since(begin),
+		}).Error
+	}
+	db := m.DB.WithContext(ctx)
+	var err error
+	if mig.NoTransaction {
+		if err = mig.run(db, up); err == nil {
+			err = record(db)
+		}
+	} else {
+		err = db.Transaction(func(tx *gorm.DB) error {
+			if err := mig.run(tx, up); err != nil {
+				return err
+			}
+			return record(tx)
+		})
+	}
+	if err != nil {
+		return step, errors.Wrapf(err, "migration %v %v", mig, direction)
+	}
+	step.Duration = time.Since(begin)
+	m.logger().InfoContext(ctx, "migration applied", "version", mig.Version, "name", mig.Name, "direction", direction, "elapsed", step.Duration)
+	return step, nil
+}
+
+// recorder is a gorm logger keeping the statements of a dry run.
+type recorder struct {
+	statements []string
+}
+
+func (r *recorder) LogMode(logger.LogLevel) logger.Interface {
+	return r
+}
+
+func (r *recorder) Info(context.Context, string, ...any)  {}
+func (r *recorder) Warn(context.Context, string, ...any)  {}
+func (r *recorder) Error(context.Context, string, ...any) {}
+
+func (r *recorder) Trace(ctx context.Context, begin time.Time, fc func() (string, int64), err error) {
+	if sql, _ := fc(); sql != "" {
+		r.statements = append(r.statements, sql)
+	}
+}
+
+func (m *Migrator) logger() *slog.Logger {
+	if m.Logger == nil {
+		return slog.Default()
+	}
+	return m.Logger
+}
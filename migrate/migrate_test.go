@@ -0,0 +1,148 @@
+package migrate_test
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/glebarez/sqlite"
+	"github.com/heypkg/store/migrate"
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+var migrations = []migrate.Migration{
+	{Version: 1, Name: "devices", UpSQL: "CREATE TABLE devices (id INTEGER PRIMARY KEY, name TEXT)", DownSQL: "DROP TABLE devices"},
+	{Version: 2, Name: "device_kind", UpSQL: "ALTER TABLE devices ADD COLUMN kind TEXT"},
+}
+
+func newMigrator(t *testing.T, db *gorm.DB, migrations ...migrate.Migration) *migrate.Migrator {
+	t.Helper()
+	m, err := migrate.New(db, migrations...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	return m
+}
+
+func openDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(t.TempDir()+"/migrate.db"), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestLock(t *testing.T) {
+	ctx := context.Background()
+	db := openDB(t)
+	m := newMigrator(t, db, migrations...)
+	if _, err := m.Status(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Create(&migrate.Lock{ID: 1, Owner: "other:1", LockedAt: time.Now()}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := m.Up(ctx)
+	if !errors.Is(err, migrate.ErrLocked) {
+		t.Fatalf("up while locked = %v, want ErrLocked", err)
+	}
+	if !strings.Contains(err.Error(), "other:1") {
+		t.Errorf("error %q does not name the owner", err)
+	}
+	if db.Migrator().HasTable("devices") {
+		t.Error("migration applied while locked")
+	}
+
+	if err := m.Unlock(ctx); err != nil {
+		t.Fatal(err)
+	}
+	steps, err := m.Up(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(steps) != 2 {
+		t.Fatalf("steps = %+v", steps)
+	}
+	var locks int64
+	db.Model(&migrate.Lock{}).Count(&locks)
+	if locks != 0 {
+		t.Errorf("lock kept after up: %v rows", locks)
+	}
+}
+
+func TestDrift(t *testing.T) {
+	ctx := context.Background()
+	db := openDB(t)
+	if _, err := newMigrator(t, db, migrations[0]).Up(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	changed := migrations[0]
+	changed.UpSQL = "CREATE TABLE devices (id INTEGER PRIMARY KEY, name TEXT, kind TEXT)"
+	m := newMigrator(t, db, changed, migrations[1])
+	err := m.Verify(ctx)
+	if !errors.Is(err, migrate.ErrDrift) || !strings.Contains(err.Error(), "1_devices changed") {
+		t.Fatalf("verify = %v, want 1_devices changed", err)
+	}
+	if _, err := m.Up(ctx); !errors.Is(err, migrate.ErrDrift) {
+		t.Fatalf("up = %v, want ErrDrift", err)
+	}
+	if db.Migrator().HasColumn("devices", "kind") {
+		t.Error("pending migration applied despite drift")
+	}
+
+	m = newMigrator(t, db, migrations[1])
+	if err := m.Verify(ctx); err == nil || !strings.Contains(err.Error(), "1_devices missing") {
+		t.Fatalf("verify = %v, want 1_devices missing", err)
+	}
+
+	m = newMigrator(t, db, migrations...)
+	if err := m.Verify(ctx); err != nil {
+		t.Fatal(err)
+	}
+	states, err := m.Status(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(states) != 2 || !states[0].Applied || states[1].Applied || states[0].Drifted {
+		t.Errorf("status = %+v", states)
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	ctx := context.Background()
+	db := openDB(t)
+	m := newMigrator(t, db, migrations...)
+	m.DryRun = true
+
+	steps, err := m.Up(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(steps) != 2 {
+		t.Fatalf("steps = %+v", steps)
+	}
+	for i, step := range steps {
+		if len(step.SQL) != 1 || step.SQL[0] != migrations[i].UpSQL {
+			t.Errorf("step %v sql = %q, want %q", step.Version, step.SQL, migrations[i].UpSQL)
+		}
+	}
+	for _, table := range []any{"devices", &migrate.Record{}, &migrate.Lock{}} {
+		if db.Migrator().HasTable(table) {
+			t.Errorf("dry run created %v", table)
+		}
+	}
+
+	m.DryRun = false
+	if steps, err := m.Up(ctx); err != nil || len(steps) != 2 {
+		t.Fatalf("up after dry run = %+v, %v", steps, err)
+	}
+}
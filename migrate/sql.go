@@ -0,0 +1,60 @@
+package migrate
+
+import (
+	"io/fs"
+	"path"
+	"regexp"
+	"strconv"
+
+	"github.com/pkg/errors"
+)
+
+var sqlFilePattern = regexp.MustCompile(`^(\d+)_(.+)\.(up|down)\.sql$`)
+
+// LoadSQL reads the SQL migrations of dir in fsys, such as an embed.FS. A
+// migration is a <version>_<name>.up.sql file with an optional
+// <version>_<name>.down.sql file; other files are ignored.
+func LoadSQL(fsys fs.FS, dir string) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, errors.Wrap(err, "read migrations")
+	}
+	byVersion := map[int64]*Migration{}
+	versions := []int64{}
+	for _, entry := range entries {
+		matches := sqlFilePattern.FindStringSubmatch(entry.Name())
+		if entry.IsDir() || matches == nil {
+			continue
+		}
+		version, err := strconv.ParseInt(matches[1], 10, 64)
+		if err != nil {
+			return nil, errors.Wrapf(err, "migration %v", entry.Name())
+		}
+		data, err := fs.ReadFile(fsys, path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, errors.Wrapf(err, "migration %v", entry.Name())
+		}
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: matches[2]}
+			byVersion[version] = m
+			versions = append(versions, version)
+		} else if m.Name != matches[2] {
+			return nil, errors.Errorf("migration %v has two names, %v and %v", version, m.Name, matches[2])
+		}
+		if matches[3] == "up" {
+			m.UpSQL = string(data)
+		} else {
+			m.DownSQL = string(data)
+		}
+	}
+	migrations := []Migration{}
+	for _, version := range versions {
+		m := byVersion[version]
+		if m.UpSQL == "" {
+			return nil, errors.Errorf("migration %v has no up file", m)
+		}
+		migrations = append(migrations, *m)
+	}
+	return migrations, nil
+}
@@ -0,0 +1,75 @@
+package migrate
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/heypkg/store/tsdb"
+	"gorm.io/gorm"
+)
+
+// HyperTable turns table into a TimescaleDB hypertable with a retention
+// period, none when it is zero. It cannot be rolled back.
+func HyperTable(version int64, table string, retention time.Duration) Migration {
+	return Migration{
+		Version: version,
+		Name:    "create_hypertable_" + table,
+		Source:  fmt.Sprintf("tsdb.CreateHyperTable(%q, %v)", table, retention),
+		Up: func(tx *gorm.DB) error {
+			return tsdb.CreateHyperTable(tx, table, retention)
+		},
+	}
+}
+
+// RetentionPolicy changes the retention period of the hypertable table from
+// previous, to which it is rolled back. Zero periods keep the data forever.
+func RetentionPolicy(version int64, table string, retention time.Duration, previous time.Duration) Migration {
+	return Migration{
+		Version: version,
+		Name:    "set_retention_policy_" + table,
+		Source:  fmt.Sprintf("tsdb.SetDataRetentionPolicyForHyperTalbe(%q, %v, %v)", table, retention, previous),
+		Up: func(tx *gorm.DB) error {
+			return tsdb.SetDataRetentionPolicyForHyperTalbe(tx, table, retention)
+		},
+		Down: func(tx *gorm.DB) error {
+			return tsdb.SetDataRetentionPolicyForHyperTalbe(tx, table, previous)
+		},
+	}
+}
+
+// CountView creates the continuous aggregate view counting the rows of
+// table per time bucket and index columns, as tsdb.CreateHyperTableCountView.
+func CountView(version int64, table string, view string, timeBucket string, indexColumns []string) Migration {
+	return Migration{
+		Version: version,
+		Name:    "create_count_view_" + view,
+		Source:  fmt.Sprintf("tsdb.CreateHyperTableCountView(%q, %q, %q, %q)", table, view, timeBucket, indexColumns),
+		Up: func(tx *gorm.DB) error {
+			return tsdb.CreateHyperTableCountView(tx, table, view, timeBucket, indexColumns)
+		},
+		Down: func(tx *gorm.DB) error {
+			return tsdb.DropHyperTableView(tx, view)
+		},
+		NoTransaction: true,
+	}
+}
+
+// AvgValuesView creates the continuous aggregate view of the average values
+// of names per time bucket and index columns, as
+// tsdb.CreateHyperTableAvgValuesView.
+func AvgValuesView(version int64, table string, view string, timeBucket string,
+	nameColumn string, valueColumn string, indexColumns []string, names []string, where string) Migration {
+	return Migration{
+		Version: version,
+		Name:    "create_avg_values_view_" + view,
+		Source: fmt.Sprintf("tsdb.CreateHyperTableAvgValuesView(%q, %q, %q, %q, %q, %q, %q, %q)",
+			table, view, timeBucket, nameColumn, valueColumn, indexColumns, names, where),
+		Up: func(tx *gorm.DB) error {
+			return tsdb.CreateHyperTableAvgValuesView(tx, table, view, timeBucket, nameColumn, valueColumn, indexColumns, names, where)
+		},
+		Down: func(tx *gorm.DB) error {
+			return tsdb.DropHyperTableView(tx, view)
+		},
+		NoTransaction: true,
+	}
+}
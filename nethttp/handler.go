@@ -0,0 +1,192 @@
+package nethttp
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/heypkg/store"
+	"github.com/heypkg/store/audit"
+	"github.com/heypkg/store/search"
+	"github.com/heypkg/store/utils"
+	"github.com/spf13/cast"
+)
+
+// ParamFunc returns a path parameter of r. Routers provide their own, for
+// example chi.URLParam.
+type ParamFunc func(r *http.Request, name string) string
+
+// Handler serves the objects of model T from a store, like the echo handler.
+type Handler[T any] struct {
+	Store store.Store[T]
+	Param ParamFunc
+	// ErrorHandler writes the errors of the object middlewares. It defaults
+	// to WriteError.
+	ErrorHandler func(w http.ResponseWriter, r *http.Request, err error)
+}
+
+func New[T any](s store.Store[T], param ParamFunc) *Handler[T] {
+	return &Handler[T]{Store: s, Param: param}
+}
+
+func (h *Handler[T]) ListObjects(r *http.Request, selectNames []string, handleFuncs map[string]search.SearchDataHandleFunc) ([]T, int64, error) {
+	ctx, err := Context(r)
+	if err != nil {
+		return nil, 0, err
+	}
+	req := ListRequest(r)
+	req.Select = selectNames
+	req.HandleFuncs = handleFuncs
+	return h.Store.List(ctx, req)
+}
+
+func (h *Handler[T]) ListDeletedObjects(r *http.Request, selectNames []string, handleFuncs map[string]search.SearchDataHandleFunc) ([]T, int64, error) {
+	ctx, err := Context(r)
+	if err != nil {
+		return nil, 0, err
+	}
+	req := ListRequest(r)
+	req.Select = selectNames
+	req.HandleFuncs = handleFuncs
+	req.Deleted = true
+	return h.Store.List(ctx, req)
+}
+
+func (h *Handler[T]) AggregateObjects(r *http.Request, handleFuncs map[string]search.SearchDataHandleFunc) ([]map[string]any, error) {
+	ctx, err := Context(r)
+	if err != nil {
+		return nil, err
+	}
+	req, err := AggregateRequest(r)
+	if err != nil {
+		return nil, err
+	}
+	req.HandleFuncs = handleFuncs
+	return h.Store.Aggregate(ctx, req)
+}
+
+func (h *Handler[T]) param(r *http.Request, name string) string {
+	if h.Param == nil {
+		return ""
+	}
+	return h.Param(r, name)
+}
+
+func (h *Handler[T]) objectHandler(getRequest func(r *http.Request) store.GetRequest) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var obj T
+			key := utils.GetRawTypeName(obj)
+			ctx, err := Context(r)
+			if err == nil {
+				var out *T
+				if out, err = h.Store.Get(ctx, getRequest(r)); err == nil {
+					next.ServeHTTP(w, Set(r, key, out))
+					return
+				}
+			}
+			if h.ErrorHandler != nil {
+				h.ErrorHandler(w, r, err)
+				return
+			}
+			WriteError(w, err)
+		})
+	}
+}
+
+// ObjectHandler loads the object of the id path parameter for next.
+func (h *Handler[T]) ObjectHandler() func(http.Handler) http.Handler {
+	return h.objectHandler(func(r *http.Request) store.GetRequest {
+		return store.ParseGetRequest(h.param(r, "id"), r.URL.Query(), Values(r))
+	})
+}
+
+func (h *Handler[T]) DeletedObjectHandler() func(http.Handler) http.Handler {
+	return h.objectHandler(func(r *http.Request) store.GetRequest {
+		return store.ParseDeletedGetRequest(h.param(r, "id"))
+	})
+}
+
+func (h *Handler[T]) TSObjectHandler() func(http.Handler) http.Handler {
+	return h.objectHandler(func(r *http.Request) store.GetRequest {
+		return store.ParseTSGetRequest(h.param(r, "ts"))
+	})
+}
+
+func GetObject[T any](r *http.Request) *T {
+	var obj T
+	key := utils.GetRawTypeName(obj)
+	if out, ok := Get(r, key).(*T); ok {
+		return out
+	}
+	return nil
+}
+
+func (h *Handler[T]) write(r *http.Request, fn func(ctx context.Context) error) error {
+	ctx, err := Context(r)
+	if err != nil {
+		return err
+	}
+	return fn(ctx)
+}
+
+func (h *Handler[T]) CreateObject(r *http.Request, obj *T) error {
+	return h.write(r, func(ctx context.Context) error { return h.Store.Create(ctx, obj) })
+}
+
+func (h *Handler[T]) UpdateObject(r *http.Request, obj *T, values map[string]any) error {
+	return h.write(r, func(ctx context.Context) error { return h.Store.Update(ctx, obj, values) })
+}
+
+func (h *Handler[T]) DeleteObject(r *http.Request, obj *T) error {
+	return h.write(r, func(ctx context.Context) error { return h.Store.Delete(ctx, obj) })
+}
+
+func (h *Handler[T]) RestoreObject(r *http.Request, obj *T) error {
+	return h.write(r, func(ctx context.Context) error { return h.Store.Restore(ctx, obj) })
+}
+
+func (h *Handler[T]) historyStore() (store.HistoryStore[T], error) {
+	if s, ok := h.Store.(store.HistoryStore[T]); ok {
+		return s, nil
+	}
+	return nil, store.NewError(store.ErrNotImplemented, "history not supported", nil)
+}
+
+// ListObjectHistory lists the audit records of the object loaded by
+// ObjectHandler or DeletedObjectHandler.
+func (h *Handler[T]) ListObjectHistory(r *http.Request) ([]audit.Record, int64, error) {
+	s, err := h.historyStore()
+	if err != nil {
+		return nil, 0, err
+	}
+	obj := GetObject[T](r)
+	if obj == nil {
+		return nil, 0, store.NotFound("not found")
+	}
+	ctx, err := Context(r)
+	if err != nil {
+		return nil, 0, err
+	}
+	req := ListRequest(r)
+	req.Preload = ""
+	req.Include = ""
+	return s.History(ctx, obj, req)
+}
+
+// RevertObject restores the object loaded by ObjectHandler or
+// DeletedObjectHandler to the version given by the version path parameter.
+func (h *Handler[T]) RevertObject(r *http.Request) (*T, error) {
+	s, err := h.historyStore()
+	if err != nil {
+		return nil, err
+	}
+	obj := GetObject[T](r)
+	if obj == nil {
+		return nil, store.NotFound("not found")
+	}
+	ctx, err := Context(r)
+	if err != nil {
+		return nil, err
+	}
+	return s.Revert(ctx, obj, cast.ToInt(h.param(r, "version")))
+}
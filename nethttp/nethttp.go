@@ -0,0 +1,98 @@
+// Package nethttp serves stores from net/http handlers. Request values that
+// echo keeps on its context, such as the tenant, "actor" and "preload", are
+// kept on the request context and set with Set.
+package nethttp
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/heypkg/store"
+	"github.com/heypkg/store/tenancy"
+)
+
+type valuesContextKey struct{}
+
+type values map[string]any
+
+func (v values) Get(key string) any {
+	return v[key]
+}
+
+// Set returns a copy of r with a request value set, to be passed to the next
+// handler. The values of r are copied, not changed, so that they are safe
+// for concurrent use and do not leak into the other handlers of r.
+func Set(r *http.Request, key string, v any) *http.Request {
+	old, _ := r.Context().Value(valuesContextKey{}).(values)
+	m := make(values, len(old)+1)
+	for k, v := range old {
+		m[k] = v
+	}
+	m[key] = v
+	return r.WithContext(context.WithValue(r.Context(), valuesContextKey{}, m))
+}
+
+func Get(r *http.Request, key string) any {
+	if m, ok := r.Context().Value(valuesContextKey{}).(values); ok {
+		return m[key]
+	}
+	return nil
+}
+
+// Values returns the request values of r.
+func Values(r *http.Request) store.Values {
+	if m, ok := r.Context().Value(valuesContextKey{}).(values); ok {
+		return m
+	}
+	return values{}
+}
+
+// Middleware makes s the tenancy strategy of every request handled by next.
+func Middleware(s tenancy.Strategy) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(w, Set(r, tenancy.StrategyContextKey, s))
+		})
+	}
+}
+
+// Context returns the request context carrying the tenant, the policy
+// subject and the audit actor of r. See store.RequestContext for the reads
+// from the primary database.
+func Context(r *http.Request) (context.Context, error) {
+	return store.RequestContext(r, Values(r))
+}
+
+func ListRequest(r *http.Request) store.ListRequest {
+	return store.ParseListRequest(r.URL.Query(), Values(r))
+}
+
+func AggregateRequest(r *http.Request) (store.AggregateRequest, error) {
+	return store.ParseAggregateRequest(r.URL.Query())
+}
+
+// WriteError writes the message of the store error of err as JSON with its
+// status. Causes are not shown.
+func WriteError(w http.ResponseWriter, err error) {
+	e := store.ErrorOf(err)
+	message := e.Message
+	if message == "" {
+		message = http.StatusText(e.Status)
+	}
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(e.Status)
+	json.NewEncoder(w).Encode(map[string]any{"message": message})
+}
+
+// WriteProblem writes err as an RFC 7807 problem. It can be used as the
+// ErrorHandler of a Handler.
+func WriteProblem(w http.ResponseWriter, r *http.Request, err error) {
+	p := store.NewProblem(err)
+	p.Instance = r.URL.Path
+	w.Header().Set("Content-Type", store.MIMEProblemJSON)
+	w.WriteHeader(p.Status)
+	if r.Method != http.MethodHead {
+		json.NewEncoder(w).Encode(p)
+	}
+}
@@ -0,0 +1,71 @@
+package nethttp_test
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/heypkg/store"
+	"github.com/heypkg/store/nethttp"
+)
+
+func TestSet(t *testing.T) {
+	r := nethttp.Set(httptest.NewRequest("GET", "/", nil), "actor", "u1")
+	r1 := nethttp.Set(r, "preload", "Owner")
+	r2 := nethttp.Set(r, "actor", "u2")
+	if got := nethttp.Get(r, "preload"); got != nil {
+		t.Errorf("value leaked into the parent request: %v", got)
+	}
+	if got := nethttp.Get(r, "actor"); got != "u1" {
+		t.Errorf("parent actor: %v", got)
+	}
+	if got := nethttp.Get(r1, "actor"); got != "u1" {
+		t.Errorf("inherited actor: %v", got)
+	}
+	if got := nethttp.Get(r2, "preload"); got != nil {
+		t.Errorf("value leaked across branches: %v", got)
+	}
+	if got := nethttp.Values(r2).Get("actor"); got != "u2" {
+		t.Errorf("actor: %v", got)
+	}
+
+	// branches of a request set values concurrently
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			r := nethttp.Set(r, "n", i)
+			if got := nethttp.Get(r, "n"); got != i {
+				t.Errorf("branch %v: %v", i, got)
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestWriteError(t *testing.T) {
+	tests := []struct {
+		err     error
+		status  int
+		message string
+	}{
+		{store.NotFound("device not found"), http.StatusNotFound, "device not found"},
+		{store.InvalidQuery(errors.New("unknown field")), http.StatusBadRequest, "invalid query: unknown field"},
+		{errors.New("password=secret"), http.StatusInternalServerError, "Internal Server Error"},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		nethttp.WriteError(rec, tt.err)
+		var body map[string]string
+		if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+			t.Fatal(err)
+		}
+		if rec.Code != tt.status || body["message"] != tt.message {
+			t.Errorf("%v: %v %q", tt.err, rec.Code, body["message"])
+		}
+	}
+}
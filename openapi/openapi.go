@@ -0,0 +1,413 @@
+// Package openapi generates an OpenAPI 3.1 document for the models served by
+// the store handlers, so that the API description follows the models.
+package openapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/heypkg/store"
+	"github.com/heypkg/store/audit"
+	"github.com/heypkg/store/tenancy"
+	"github.com/labstack/echo/v4"
+	"github.com/pkg/errors"
+	"gorm.io/gorm/schema"
+)
+
+// Kind is what a route does, which sets its parameters and responses.
+type Kind string
+
+const (
+	KindList        Kind = "list"
+	KindListDeleted Kind = "list_deleted"
+	KindGet         Kind = "get"
+	KindGetDeleted  Kind = "get_deleted"
+	KindGetTS       Kind = "get_ts"
+	KindCreate      Kind = "create"
+	KindUpdate      Kind = "update"
+	KindDelete      Kind = "delete"
+	KindRestore     Kind = "restore"
+	KindAggregate   Kind = "aggregate"
+	KindHistory     Kind = "history"
+	KindRevert      Kind = "revert"
+	KindExport      Kind = "export"
+	KindSuggest     Kind = "suggest"
+)
+
+// Route is a route of a model. Path parameters are written :name, as in
+// echo, or {name}.
+type Route struct {
+	Method  string
+	Path    string
+	Kind    Kind
+	Summary string
+}
+
+// Routes returns the conventional routes of a model served at path: list
+// and create on path, get, update and delete on path/:id.
+func Routes(path string) []Route {
+	path = strings.TrimSuffix(path, "/")
+	return []Route{
+		{Method: http.MethodGet, Path: path, Kind: KindList},
+		{Method: http.MethodPost, Path: path, Kind: KindCreate},
+		{Method: http.MethodGet, Path: path + "/:id", Kind: KindGet},
+		{Method: http.MethodPut, Path: path + "/:id", Kind: KindUpdate},
+		{Method: http.MethodDelete, Path: path + "/:id", Kind: KindDelete},
+	}
+}
+
+type resource struct {
+	tag    string
+	model  reflect.Type
+	routes []Route
+}
+
+// Spec collects the models and routes of an API.
+type Spec struct {
+	Title   string
+	Version string
+
+	mu        sync.Mutex
+	resources []resource
+}
+
+func New(title string, version string) *Spec {
+	return &Spec{Title: title, Version: version}
+}
+
+// Register adds the routes of model T, grouped under tag. The tag defaults
+// to the model name.
+func Register[T any](s *Spec, tag string, routes ...Route) {
+	t := reflect.TypeOf((*T)(nil)).Elem()
+	if tag == "" {
+		tag = componentName(t)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.resources = append(s.resources, resource{tag: tag, model: t, routes: routes})
+}
+
+// Document generates the document of the registered routes.
+func (s *Spec) Document() (*Document, error) {
+	s.mu.Lock()
+	resources := append([]resource{}, s.resources...)
+	s.mu.Unlock()
+
+	doc := &Document{
+		OpenAPI:    "3.1.0",
+		Info:       Info{Title: s.Title, Version: s.Version},
+		Paths:      map[string]PathItem{},
+		Components: Components{Responses: errorResponses()},
+	}
+	schemas := schemas{"Error": errorSchema()}
+	for _, r := range resources {
+		fields, err := searchFields(r.model)
+		if err != nil {
+			return nil, err
+		}
+		for _, route := range r.routes {
+			path, params := pathParameters(route.Path)
+			op, err := operation(schemas, r, route, fields)
+			if err != nil {
+				return nil, err
+			}
+			op.Parameters = append(params, op.Parameters...)
+			item := doc.Paths[path]
+			if item == nil {
+				item = PathItem{}
+				doc.Paths[path] = item
+			}
+			method := strings.ToLower(route.Method)
+			if _, ok := item[method]; ok {
+				return nil, errors.Errorf("duplicated route %v %v", route.Method, route.Path)
+			}
+			item[method] = op
+		}
+	}
+	doc.Components.Schemas = schemas
+	return doc, nil
+}
+
+// Handler serves the document as JSON, for example on /openapi.json.
+func (s *Spec) Handler() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		doc, err := s.Document()
+		if err != nil {
+			return store.Internal(err)
+		}
+		return c.JSON(http.StatusOK, doc)
+	}
+}
+
+// ServeHTTP serves the document as JSON from net/http routers.
+func (s *Spec) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	doc, err := s.Document()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	json.NewEncoder(w).Encode(doc)
+}
+
+func operation(schemas schemas, r resource, route Route, fields []searchField) (*Operation, error) {
+	model := schemas.schemaOf(r.model)
+	op := &Operation{
+		OperationID: operationID(route.Kind, r.model),
+		Summary:     route.Summary,
+		Tags:        []string{r.tag},
+		Responses:   map[string]Response{},
+	}
+	if route.Summary == "" {
+		op.Summary = fmt.Sprintf("%v %v", strings.ReplaceAll(string(route.Kind), "_", " "), r.tag)
+	}
+	switch route.Kind {
+	case KindList, KindListDeleted:
+		op.Parameters = append(listParameters(fields), viewParameter(), includeParameter(), formatParameter(), timeoutParameter())
+		op.Responses["200"] = listResponse(model)
+		addErrors(op, "400", "403", "500", "504")
+	case KindGet, KindGetDeleted, KindGetTS:
+		if route.Kind == KindGet {
+			op.Parameters = append(op.Parameters, includeParameter(), timeoutParameter())
+		}
+		op.Responses["200"] = jsonResponse("The object.", model)
+		addErrors(op, "400", "403", "404", "500", "504")
+	case KindCreate, KindUpdate:
+		op.RequestBody = &RequestBody{Required: true, Content: map[string]MediaType{"application/json": {Schema: model}}}
+		op.Responses["200"] = jsonResponse("The saved object.", model)
+		addErrors(op, "400", "403", "404", "500")
+	case KindDelete, KindRestore:
+		op.Responses["204"] = Response{Description: "Done."}
+		addErrors(op, "403", "404", "500")
+	case KindAggregate:
+		op.Parameters = append(op.Parameters, queryParameter(fields),
+			Parameter{Name: "group_by", In: "query", Description: "Comma separated columns to group by. JSON keys are written column.key.", Schema: &Schema{Type: "string"}},
+			Parameter{Name: "aggregate", In: "query", Description: "Comma separated aggregations: count(*), count(column), sum(column), avg(column), min(column) or max(column). Results are named func_column, or count for count(*).", Schema: &Schema{Type: "string"}},
+			timeoutParameter(),
+		)
+		row := &Schema{Type: "object", AdditionalProperties: &Schema{}}
+		op.Responses["200"] = jsonResponse("One row per group.", &Schema{Type: "array", Items: row})
+		addErrors(op, "400", "403", "500", "504")
+	case KindHistory:
+		op.Parameters = append(op.Parameters, listParameters(nil)...)
+		op.Responses["200"] = jsonResponse("The audit records of the object.", &Schema{Type: "array", Items: schemas.schemaOf(reflect.TypeOf(audit.Record{}))})
+		addErrors(op, "400", "403", "404", "500", "501")
+	case KindExport:
+		op.Parameters = append(op.Parameters, queryParameter(fields),
+			Parameter{Name: "order_by", In: "query", Description: "Comma separated columns to sort by. A column ending with - sorts descending, with + ascending.", Schema: &Schema{Type: "string"}},
+			Parameter{Name: "format", In: "query", Description: "csv (default), ndjson or xlsx.", Schema: &Schema{Type: "string"}},
+			Parameter{Name: "columns", In: "query", Description: "Comma separated fields to export, with keys of JSON columns written column.key. Defaults to all fields with JSON objects flattened.", Schema: &Schema{Type: "string"}},
+		)
+		op.Responses["200"] = Response{
+			Description: "The matching objects as an attachment.",
+			Content: map[string]MediaType{
+				"text/csv":             {Schema: &Schema{Type: "string"}},
+				"application/x-ndjson": {Schema: &Schema{Type: "object", AdditionalProperties: &Schema{}}},
+				"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet": {Schema: &Schema{Type: "string", Format: "binary"}},
+			},
+		}
+		addErrors(op, "400", "403", "500")
+	case KindSuggest:
+		op.Parameters = append(op.Parameters, queryParameter(fields),
+			Parameter{Name: "cursor", In: "query", Description: "Byte offset of the cursor in q. Defaults to the end of q.", Schema: &Schema{Type: "integer"}},
+			Parameter{Name: "limit", In: "query", Description: "Maximum number of values suggested.", Schema: &Schema{Type: "integer"}},
+			timeoutParameter(),
+		)
+		suggestion := &Schema{Type: "object", Properties: map[string]*Schema{
+			"kind":  {Type: "string", Description: "field, key, operator or value."},
+			"text":  {Type: "string"},
+			"type":  {Type: "string"},
+			"count": {Type: "integer"},
+		}}
+		op.Responses["200"] = jsonResponse("Completions of the part of q between start and end, which their text replaces.", &Schema{Type: "object", Properties: map[string]*Schema{
+			"start": {Type: "integer"},
+			"end":   {Type: "integer"},
+			"items": {Type: "array", Items: suggestion},
+		}})
+		addErrors(op, "400", "403", "500", "501", "504")
+	case KindRevert:
+		op.Responses["200"] = jsonResponse("The reverted object.", model)
+		addErrors(op, "403", "404", "500", "501")
+	default:
+		return nil, errors.Errorf("unknown route kind %v", route.Kind)
+	}
+	return op, nil
+}
+
+// operationID returns the kind in camel case followed by the model name,
+// for example listDeletedDevice.
+func operationID(kind Kind, model reflect.Type) string {
+	words := strings.Split(string(kind), "_")
+	for i := 1; i < len(words); i++ {
+		if words[i] != "" {
+			words[i] = strings.ToUpper(words[i][:1]) + words[i][1:]
+		}
+	}
+	return strings.Join(words, "") + componentName(model)
+}
+
+// pathParameters converts :name segments to {name} and returns the path
+// parameters.
+func pathParameters(path string) (string, []Parameter) {
+	params := []Parameter{}
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		name := ""
+		if strings.HasPrefix(segment, ":") {
+			name = segment[1:]
+		} else if strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}") {
+			name = segment[1 : len(segment)-1]
+		}
+		if name == "" {
+			continue
+		}
+		segments[i] = "{" + name + "}"
+		p := Parameter{Name: name, In: "path", Required: true, Schema: &Schema{Type: "string"}}
+		switch name {
+		case "id", "version":
+			p.Schema = &Schema{Type: "integer"}
+		case "ts":
+			p.Schema = &Schema{Type: "integer", Format: "int64"}
+			p.Description = "Unix time in microseconds."
+		}
+		params = append(params, p)
+	}
+	return strings.Join(segments, "/"), params
+}
+
+func listParameters(fields []searchField) []Parameter {
+	return []Parameter{
+		queryParameter(fields),
+		{Name: "page", In: "query", Description: "Page number, from 1.", Schema: &Schema{Type: "integer"}},
+		{Name: "page_size", In: "query", Description: "Objects per page. All objects are returned when omitted.", Schema: &Schema{Type: "integer"}},
+		{Name: "cursor", In: "query", Description: "The next_cursor of the previous page. Replaces page and page_size.", Schema: &Schema{Type: "string"}},
+		{Name: "order_by", In: "query", Description: "Comma separated columns to sort by. A column ending with - sorts descending, with + ascending.", Schema: &Schema{Type: "string"}},
+	}
+}
+
+func viewParameter() Parameter {
+	return Parameter{Name: "view", In: "query", Description: "Id of a saved search to apply. The terms of q replace the saved terms on the same columns.", Schema: &Schema{Type: "integer"}}
+}
+
+func timeoutParameter() Parameter {
+	return Parameter{Name: "timeout", In: "query", Description: "Time limit of the query, such as 500ms or a number of seconds. The server may cap it.", Schema: &Schema{Type: "string"}}
+}
+
+func formatParameter() Parameter {
+	return Parameter{Name: "format", In: "query", Description: "json, ndjson or csv. Overrides the Accept header.", Schema: &Schema{Type: "string"}}
+}
+
+// listResponse describes the bodies and headers written by response.WriteList.
+func listResponse(model *Schema) Response {
+	envelope := &Schema{Type: "object", Properties: map[string]*Schema{
+		"data":        {Type: "array", Items: model},
+		"total":       {Type: "integer"},
+		"page":        {Type: "integer"},
+		"page_size":   {Type: "integer", Description: "0 when every object is returned."},
+		"next_cursor": {Type: "string", Description: "Cursor of the next page, omitted on the last page."},
+	}}
+	return Response{
+		Description: "The objects. NDJSON and CSV bodies hold the objects only.",
+		Headers: map[string]Header{
+			"X-Total-Count": {Description: "Number of matching objects.", Schema: &Schema{Type: "integer"}},
+			"Link":          {Description: "RFC 8288 links to the first, prev, next and last pages.", Schema: &Schema{Type: "string"}},
+		},
+		Content: map[string]MediaType{
+			"application/json":     {Schema: envelope},
+			"application/x-ndjson": {Schema: model},
+			"text/csv":             {Schema: &Schema{Type: "string"}},
+		},
+	}
+}
+
+func includeParameter() Parameter {
+	return Parameter{Name: "include", In: "query", Description: "Comma separated associations to load, from those the server allows.", Schema: &Schema{Type: "string"}}
+}
+
+const searchSyntax = "Search terms separated by spaces, all of which must match. " +
+	"A term is column:value or column:value1,value2 for any of the values. " +
+	"Values may start with !=, >, >=, < or <=, be a range a..b with * for an open end, " +
+	"or be quoted text. Keys of JSON columns are searched with column.key:value."
+
+func queryParameter(fields []searchField) Parameter {
+	description := searchSyntax
+	if len(fields) > 0 {
+		names := make([]string, 0, len(fields))
+		for _, f := range fields {
+			names = append(names, fmt.Sprintf("%v (%v)", f.Name, f.Type))
+		}
+		description += " Searchable columns: " + strings.Join(names, ", ") + "."
+	}
+	return Parameter{Name: "q", In: "query", Description: description, Schema: &Schema{Type: "string"}}
+}
+
+type searchField struct {
+	Name string
+	Type string
+}
+
+// searchFields returns the columns of model t. The tenant column of the
+// default strategy is left out, as searching it is rejected.
+func searchFields(t reflect.Type) ([]searchField, error) {
+	if t.Kind() != reflect.Struct {
+		return nil, nil
+	}
+	s, err := schema.Parse(reflect.New(t).Interface(), schemaCache, schema.NamingStrategy{})
+	if err != nil {
+		return nil, errors.Wrapf(err, "parse model %v", t)
+	}
+	tenantColumn := tenancy.DefaultStrategy().Column()
+	fields := []searchField{}
+	for _, f := range s.Fields {
+		if f.DBName == "" || strings.EqualFold(f.DBName, tenantColumn) {
+			continue
+		}
+		typ := string(f.GORMDataType)
+		switch f.GORMDataType {
+		case schema.Bool, schema.Int, schema.Uint, schema.Float, schema.String, schema.Time:
+		default:
+			typ = "json"
+		}
+		if f.Serializer != nil {
+			typ = "json"
+		}
+		fields = append(fields, searchField{Name: f.DBName, Type: typ})
+	}
+	return fields, nil
+}
+
+var schemaCache = &sync.Map{}
+
+func jsonResponse(description string, s *Schema) Response {
+	return Response{Description: description, Content: map[string]MediaType{"application/json": {Schema: s}}}
+}
+
+func addErrors(op *Operation, codes ...string) {
+	for _, code := range codes {
+		op.Responses[code] = Response{Ref: "#/components/responses/Error" + code}
+	}
+}
+
+func errorSchema() *Schema {
+	return &Schema{Type: "object", Properties: map[string]*Schema{"message": {Type: "string"}}}
+}
+
+func errorResponses() map[string]Response {
+	descriptions := map[string]string{
+		"400": "Invalid query, include or body.",
+		"403": "Tenant missing or access denied.",
+		"404": "Not found, or hidden by a policy.",
+		"500": "Server error.",
+		"501": "Not supported by the store.",
+		"504": "The query did not finish before its timeout.",
+	}
+	out := map[string]Response{}
+	for code, description := range descriptions {
+		out["Error"+code] = jsonResponse(description, refSchema("Error"))
+	}
+	return out
+}
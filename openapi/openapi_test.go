@@ -0,0 +1,73 @@
+package openapi_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/heypkg/store/openapi"
+	"github.com/heypkg/store/storetest"
+)
+
+func TestDocument(t *testing.T) {
+	spec := openapi.New("devices", "1.0")
+	routes := append(openapi.Routes("/devices/"), openapi.Route{Method: "GET", Path: "/devices/deleted/{id}", Kind: openapi.KindGetDeleted})
+	openapi.Register[storetest.Device](spec, "", routes...)
+	doc, err := spec.Document()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if doc.OpenAPI != "3.1.0" || doc.Info.Title != "devices" {
+		t.Errorf("info: %v %+v", doc.OpenAPI, doc.Info)
+	}
+
+	list := doc.Paths["/devices"]["get"]
+	if list == nil || list.OperationID != "listDevice" || list.Tags[0] != "Device" {
+		t.Fatalf("list operation: %+v", list)
+	}
+	var q string
+	for _, p := range list.Parameters {
+		if p.Name == "q" {
+			q = p.Description
+		}
+	}
+	for _, want := range []string{"name (string)", "value (float)", "tags (json)"} {
+		if !strings.Contains(q, want) {
+			t.Errorf("searchable columns without %v: %v", want, q)
+		}
+	}
+	if strings.Contains(q, "schema (") {
+		t.Errorf("tenant column searchable: %v", q)
+	}
+	if _, ok := list.Responses["200"].Headers["X-Total-Count"]; !ok {
+		t.Errorf("list headers: %+v", list.Responses["200"].Headers)
+	}
+
+	get := doc.Paths["/devices/{id}"]["get"]
+	if get == nil || len(get.Parameters) == 0 || get.Parameters[0].Name != "id" || get.Parameters[0].In != "path" || get.Parameters[0].Schema.Type != "integer" {
+		t.Fatalf("get operation: %+v", get)
+	}
+	if get.Responses["404"].Ref != "#/components/responses/Error404" {
+		t.Errorf("get responses: %+v", get.Responses)
+	}
+	if doc.Paths["/devices/{id}"]["delete"].Responses["204"].Description == "" {
+		t.Error("delete without 204")
+	}
+	if doc.Paths["/devices/deleted/{id}"]["get"].OperationID != "getDeletedDevice" {
+		t.Errorf("deleted get: %+v", doc.Paths["/devices/deleted/{id}"])
+	}
+
+	device := doc.Components.Schemas["Device"]
+	if device == nil {
+		t.Fatalf("schemas: %v", doc.Components.Schemas)
+	}
+	for _, name := range []string{"ID", "Name", "Tags", "Deleted"} {
+		if device.Properties[name] == nil {
+			t.Errorf("property %v missing: %v", name, device.Properties)
+		}
+	}
+
+	openapi.Register[storetest.Device](spec, "", openapi.Route{Method: "GET", Path: "/devices", Kind: openapi.KindList})
+	if _, err := spec.Document(); err == nil {
+		t.Error("duplicated route accepted")
+	}
+}
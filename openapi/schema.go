@@ -0,0 +1,130 @@
+package openapi
+
+import (
+	"encoding/json"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/heypkg/store/jsontype"
+	"gorm.io/gorm"
+)
+
+var (
+	timeType      = reflect.TypeOf(time.Time{})
+	jsonTimeType  = reflect.TypeOf(jsontype.JSONTime{})
+	deletedAtType = reflect.TypeOf(gorm.DeletedAt{})
+	rawType       = reflect.TypeOf(json.RawMessage{})
+	marshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	jsonTypePkg   = reflect.TypeOf(jsontype.JSONType[any]{}).PkgPath()
+)
+
+var componentNameRe = regexp.MustCompile(`[^A-Za-z0-9_.\-]+`)
+
+// schemas builds the component schemas of struct types.
+type schemas map[string]*Schema
+
+func componentName(t reflect.Type) string {
+	return strings.Trim(componentNameRe.ReplaceAllString(t.Name(), "_"), "_")
+}
+
+// schemaOf returns the schema of values of t as encoding/json writes them.
+// Named structs become components.
+func (s schemas) schemaOf(t reflect.Type) *Schema {
+	switch t {
+	case timeType:
+		return &Schema{Type: "string", Format: "date-time"}
+	case jsonTimeType:
+		return &Schema{Type: "integer", Format: "int64", Description: "Unix time in microseconds, -1 for none."}
+	case deletedAtType:
+		return &Schema{Type: []string{"string", "null"}, Format: "date-time"}
+	case rawType:
+		return &Schema{}
+	}
+	if t.Kind() == reflect.Struct && t.PkgPath() == jsonTypePkg && strings.HasPrefix(t.Name(), "JSONType[") {
+		if f, ok := t.FieldByName("Data"); ok {
+			return s.schemaOf(f.Type)
+		}
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		return nullable(s.schemaOf(t.Elem()))
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int64:
+		return &Schema{Type: "integer", Format: "int64"}
+	case reflect.Int8, reflect.Int16, reflect.Int32:
+		return &Schema{Type: "integer", Format: "int32"}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		min := 0.0
+		return &Schema{Type: "integer", Minimum: &min}
+	case reflect.Float32:
+		return &Schema{Type: "number", Format: "float"}
+	case reflect.Float64:
+		return &Schema{Type: "number", Format: "double"}
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &Schema{Type: "string", Format: "byte"}
+		}
+		return &Schema{Type: "array", Items: s.schemaOf(t.Elem())}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: s.schemaOf(t.Elem())}
+	case reflect.Struct:
+		if t.Implements(marshalerType) || reflect.PointerTo(t).Implements(marshalerType) {
+			return &Schema{}
+		}
+		name := componentName(t)
+		if name == "" {
+			return s.structSchema(t)
+		}
+		if _, ok := s[name]; !ok {
+			s[name] = &Schema{}
+			*s[name] = *s.structSchema(t)
+		}
+		return refSchema(name)
+	}
+	if t.Implements(marshalerType) {
+		return &Schema{}
+	}
+	return &Schema{}
+}
+
+func (s schemas) structSchema(t reflect.Type) *Schema {
+	out := &Schema{Type: "object", Properties: map[string]*Schema{}}
+	s.addFields(out, t)
+	return out
+}
+
+// addFields adds the fields of t to out, inlining embedded structs without a
+// json name like encoding/json.
+func (s schemas) addFields(out *Schema, t reflect.Type) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, _, _ := strings.Cut(tag, ",")
+		ft := f.Type
+		if f.Anonymous && name == "" {
+			if ft.Kind() == reflect.Pointer {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct && !ft.Implements(marshalerType) && !reflect.PointerTo(ft).Implements(marshalerType) {
+				s.addFields(out, ft)
+				continue
+			}
+		}
+		if !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		out.Properties[name] = s.schemaOf(ft)
+	}
+}
@@ -0,0 +1,199 @@
+package outbox
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+
+	"github.com/heypkg/store/audit"
+	"github.com/heypkg/store/feed"
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+)
+
+const (
+	DefaultInterval   = time.Second
+	DefaultBatchSize  = 100
+	DefaultMinBackoff = time.Second
+	DefaultMaxBackoff = 5 * time.Minute
+)
+
+// Dispatcher delivers the pending messages of an outbox to a sink. A failed
+// message is retried with an exponential backoff, and the later messages of
+// its aggregate wait for it. Run a single dispatcher per outbox table: two
+// would deliver the same messages.
+type Dispatcher struct {
+	DB   *gorm.DB
+	Sink feed.Sink
+	// Interval is the delay between the polls of the outbox.
+	Interval time.Duration
+	// BatchSize is the number of messages read per poll.
+	BatchSize  int
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	// MaxAttempts is the number of attempts after which a message is marked
+	// failed and skipped. Messages are retried forever when it is zero.
+	MaxAttempts int
+	// OnError is called with the errors of the sink and of the outbox. They
+	// are dropped when it is nil.
+	OnError func(err error)
+}
+
+func NewDispatcher(db *gorm.DB, sink feed.Sink) *Dispatcher {
+	return &Dispatcher{
+		DB:         db,
+		Sink:       sink,
+		Interval:   DefaultInterval,
+		BatchSize:  DefaultBatchSize,
+		MinBackoff: DefaultMinBackoff,
+		MaxBackoff: DefaultMaxBackoff,
+	}
+}
+
+// Run dispatches the outbox every Interval until ctx is done.
+func (d *Dispatcher) Run(ctx context.Context) error {
+	interval := d.Interval
+	if interval <= 0 {
+		interval = DefaultInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if _, err := d.Dispatch(ctx); err != nil && ctx.Err() == nil {
+			d.error(err)
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// Dispatch sends the pending messages due now, in ID order, and returns the
+// number of messages delivered. Once a message of an aggregate is not sent,
+// the following ones of the aggregate are left for a later dispatch, and
+// the aggregates waiting for a retry do not hold up the others. Failed
+// messages no longer hold up their aggregate.
+func (d *Dispatcher) Dispatch(ctx context.Context) (int, error) {
+	size := d.BatchSize
+	if size <= 0 {
+		size = DefaultBatchSize
+	}
+	db := audit.Skip(Skip(d.DB.WithContext(ctx))).Session(&gorm.Session{})
+	table := (Message{}).TableName()
+	now := time.Now()
+	// the older pending messages due now are in the batch before the later
+	// ones, and block them below when they are not sent
+	waiting := db.Table(table+" AS o2").Select("1").
+		Where("o2.aggregate = "+table+".aggregate AND o2.id < "+table+".id").
+		Where("o2.delivered_at IS NULL AND o2.failed_at IS NULL AND o2.next_attempt_at > ?", now)
+	var messages []Message
+	if err := db.Where("delivered_at IS NULL AND failed_at IS NULL AND next_attempt_at <= ?", now).
+		Where("NOT EXISTS (?)", waiting).
+		Order("id").
+		Limit(size).
+		Find(&messages).Error; err != nil {
+		return 0, errors.Wrap(err, "read outbox")
+	}
+	delivered := 0
+	blocked := map[string]bool{}
+	for i := range messages {
+		m := &messages[i]
+		if blocked[m.Aggregate] {
+			continue
+		}
+		if ctx.Err() != nil {
+			return delivered, ctx.Err()
+		}
+		err := d.send(ctx, m)
+		if err := d.save(db, m, err); err != nil {
+			return delivered, err
+		}
+		if err != nil {
+			d.error(errors.Wrapf(err, "send outbox message %v", m.ID))
+			blocked[m.Aggregate] = true
+			continue
+		}
+		delivered++
+	}
+	return delivered, nil
+}
+
+func (d *Dispatcher) send(ctx context.Context, m *Message) error {
+	e, err := m.Event()
+	if err != nil {
+		return err
+	}
+	return d.Sink.Send(ctx, e)
+}
+
+// save records the outcome of an attempt to send m.
+func (d *Dispatcher) save(db *gorm.DB, m *Message, err error) error {
+	now := time.Now()
+	values := map[string]any{"attempts": m.Attempts + 1}
+	if err == nil {
+		values["delivered_at"] = now
+		values["last_error"] = ""
+	} else {
+		values["last_error"] = err.Error()
+		values["next_attempt_at"] = now.Add(d.backoff(m.Attempts + 1))
+		if d.MaxAttempts > 0 && m.Attempts+1 >= d.MaxAttempts {
+			values["failed_at"] = now
+		}
+	}
+	if err := db.Model(&Message{}).Where("id = ?", m.ID).Updates(values).Error; err != nil {
+		return errors.Wrap(err, "update outbox message")
+	}
+	return nil
+}
+
+// backoff returns the delay before the next attempt after attempts failed
+// ones.
+func (d *Dispatcher) backoff(attempts int) time.Duration {
+	min, max := d.MinBackoff, d.MaxBackoff
+	if min <= 0 {
+		min = DefaultMinBackoff
+	}
+	if max < min {
+		max = min
+	}
+	delay := min
+	for i := 1; i < attempts && delay < max; i++ {
+		delay *= 2
+	}
+	if delay > max {
+		delay = max
+	}
+	return delay
+}
+
+func (d *Dispatcher) error(err error) {
+	if d.OnError != nil {
+		d.OnError(err)
+	}
+}
+
+// Retry schedules the failed messages of db, or of aggregate when it is not
+// empty, to be sent again by the next dispatch.
+func Retry(db *gorm.DB, aggregate string) (int64, error) {
+	tx := audit.Skip(Skip(db)).Model(&Message{}).Where("failed_at IS NOT NULL AND delivered_at IS NULL")
+	if aggregate != "" {
+		tx = tx.Where("aggregate = ?", aggregate)
+	}
+	result := tx.Updates(map[string]any{"failed_at": nil, "attempts": 0, "next_attempt_at": time.Now()})
+	return result.RowsAffected, result.Error
+}
+
+// Purge deletes the messages delivered before t.
+func Purge(db *gorm.DB, t time.Time) (int64, error) {
+	result := audit.Skip(Skip(db)).Where("delivered_at < ?", t).Delete(&Message{})
+	return result.RowsAffected, result.Error
+}
+
+func newID() string {
+	b := make([]byte, 12)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,76 @@
+package outbox_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/glebarez/sqlite"
+	"github.com/heypkg/store/feed"
+	"github.com/heypkg/store/outbox"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func TestDispatchOrder(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(t.TempDir()+"/outbox.db"), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := outbox.Register(db); err != nil {
+		t.Fatal(err)
+	}
+	// the messages of aggregate a come first and fill a batch
+	for _, id := range []string{"a1", "a2", "a3", "b1", "b2", "c1"} {
+		if err := outbox.Record(db, id[:1], feed.Event{ID: id, Type: feed.Created, Model: "m", Tenant: "t"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sink := outbox.NewMemorySink()
+	down := map[string]bool{"a1": true, "c1": true}
+	sink.Fail = func(e feed.Event) error {
+		if down[e.ID] {
+			return errors.New("down")
+		}
+		return nil
+	}
+	d := outbox.NewDispatcher(db, sink)
+	d.BatchSize = 3
+	d.MinBackoff = time.Hour
+	d.MaxAttempts = 2
+	sent := func() string {
+		ids := []string{}
+		for _, e := range sink.Events() {
+			ids = append(ids, e.ID)
+		}
+		sink.Reset()
+		return strings.Join(ids, " ")
+	}
+	dispatch := func(want int, wantSent string) {
+		t.Helper()
+		n, err := d.Dispatch(context.Background())
+		if got := sent(); err != nil || n != want || got != wantSent {
+			t.Fatalf("dispatch: %v %q %v, want %v %q", n, got, err, want, wantSent)
+		}
+	}
+
+	// a1 fails and holds up a2 and a3, which are in its batch
+	dispatch(0, "")
+	// a waits for its retry without holding up b and c
+	dispatch(2, "b1 b2")
+	dispatch(0, "")
+	if err := db.Model(&outbox.Message{}).Where("event_id = ?", "c1").Update("next_attempt_at", time.Now()).Error; err != nil {
+		t.Fatal(err)
+	}
+	// c1 fails for the last time and no longer holds up its aggregate
+	dispatch(0, "")
+	// a1 is sent before the rest of its aggregate when it is due
+	delete(down, "a1")
+	if err := db.Model(&outbox.Message{}).Where("event_id = ?", "a1").Update("next_attempt_at", time.Now()).Error; err != nil {
+		t.Fatal(err)
+	}
+	dispatch(3, "a1 a2 a3")
+	dispatch(0, "")
+}
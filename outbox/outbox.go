@@ -0,0 +1,183 @@
+// Package outbox records the changes of the stores in an outbox table, in the
+// transaction of the write, and delivers them to sinks from a dispatcher.
+// Delivery is at least once: a message is sent again until its sink accepts
+// it, so sinks should deduplicate on the event ID. The messages of an object
+// are delivered in the order of its writes.
+package outbox
+
+import (
+	"encoding/json"
+	"reflect"
+	"time"
+
+	"github.com/heypkg/store/audit"
+	"github.com/heypkg/store/feed"
+	"github.com/heypkg/store/internal/gormutil"
+	"github.com/heypkg/store/jsontype"
+	"github.com/heypkg/store/tenancy"
+	"github.com/heypkg/store/utils"
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+)
+
+// Message is an event waiting in the outbox. Aggregate identifies the object
+// of the event; the messages of an aggregate are delivered in ID order.
+type Message struct {
+	ID            uint                               `gorm:"primarykey" json:"ID"`
+	EventID       string                             `gorm:"uniqueIndex;size:32" json:"EventID"`
+	Schema        string                             `gorm:"index" json:"Schema"`
+	Aggregate     string                             `gorm:"index" json:"Aggregate"`
+	Type          feed.EventType                     `json:"Type"`
+	Payload       jsontype.JSONType[json.RawMessage] `json:"Payload"`
+	CreatedAt     time.Time                          `json:"CreatedAt"`
+	Attempts      int                                `json:"Attempts"`
+	NextAttemptAt time.Time                          `gorm:"index" json:"NextAttemptAt"`
+	DeliveredAt   *time.Time                         `gorm:"index" json:"DeliveredAt"`
+	FailedAt      *time.Time                         `gorm:"index" json:"FailedAt"`
+	LastError     string                             `json:"LastError"`
+}
+
+func (Message) TableName() string {
+	return "outbox_messages"
+}
+
+// Event decodes the event of m, its object as a json.RawMessage.
+func (m *Message) Event() (feed.Event, error) {
+	return feed.DecodeEvent(m.Payload.Data)
+}
+
+const (
+	settingSkip = "outbox:skip"
+	settingType = "outbox:type"
+)
+
+// Skip disables the outbox for the writes executed through the returned db.
+func Skip(db *gorm.DB) *gorm.DB {
+	return db.Set(settingSkip, true)
+}
+
+// WithType overrides the event type recorded for the writes executed through
+// the returned db.
+func WithType(db *gorm.DB, typ feed.EventType) *gorm.DB {
+	return db.Set(settingType, typ)
+}
+
+// Register migrates the outbox table and installs the callbacks that record
+// every create, update and delete executed through db. The messages are
+// written with the write itself, inside the transaction gorm opens for it,
+// so they are lost with a rolled back write and kept with a committed one;
+// this does not hold with SkipDefaultTransaction outside of a transaction.
+func Register(db *gorm.DB) error {
+	if err := db.AutoMigrate(&Message{}); err != nil {
+		return errors.Wrap(err, "migrate outbox messages")
+	}
+	cb := db.Callback()
+	if err := cb.Create().After("gorm:create").Register("outbox:after_create", afterWrite(feed.Created)); err != nil {
+		return err
+	}
+	if err := cb.Update().After("gorm:update").Register("outbox:after_update", afterWrite(feed.Updated)); err != nil {
+		return err
+	}
+	if err := cb.Delete().After("gorm:delete").Register("outbox:after_delete", afterWrite(feed.Deleted)); err != nil {
+		return err
+	}
+	return nil
+}
+
+func enabled(db *gorm.DB) bool {
+	if db.Error != nil || db.Statement.Schema == nil {
+		return false
+	}
+	switch db.Statement.Schema.Table {
+	case (Message{}).TableName(), (audit.Record{}).TableName():
+		return false
+	}
+	if v, ok := db.Get(settingSkip); ok {
+		if skip, _ := v.(bool); skip {
+			return false
+		}
+	}
+	return true
+}
+
+func afterWrite(typ feed.EventType) func(db *gorm.DB) {
+	return func(db *gorm.DB) {
+		if !enabled(db) {
+			return
+		}
+		typ := typeOf(db, typ)
+		gormutil.EachObject(db, func(rv reflect.Value) {
+			key, ok := gormutil.PrimaryKeyString(db.Statement, rv)
+			if !ok {
+				return
+			}
+			obj := rv
+			if loaded, ok := gormutil.LoadObject(db, rv); ok {
+				obj = loaded
+			}
+			model := utils.GetRawTypeName(obj.Interface())
+			e := feed.Event{
+				ID:     newID(),
+				Type:   typ,
+				Model:  model,
+				Tenant: tenancy.ScopeFromContext(db.Statement.Context).Tenant,
+				Object: obj.Interface(),
+				Time:   time.Now(),
+			}
+			if err := Record(db.Session(&gorm.Session{NewDB: true}), model+":"+key, e); err != nil {
+				db.AddError(errors.Wrap(err, "write outbox message"))
+			}
+		})
+	}
+}
+
+// Record writes e to the outbox of db as a change of aggregate. It is used
+// for events that are not writes of db, within the transaction of the
+// changes they describe.
+func Record(db *gorm.DB, aggregate string, e feed.Event) error {
+	if e.ID == "" {
+		e.ID = newID()
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	data, err := json.Marshal(e)
+	if err != nil {
+		return errors.Wrap(err, "encode event")
+	}
+	return audit.Skip(Skip(db)).Create(&Message{
+		EventID:       e.ID,
+		Schema:        e.Tenant,
+		Aggregate:     aggregate,
+		Type:          e.Type,
+		Payload:       jsontype.NewJSONType(json.RawMessage(data)),
+		NextAttemptAt: e.Time,
+	}).Error
+}
+
+// typeOf returns the event type of the write, telling the updates of the
+// soft delete column apart as deletes and restores.
+func typeOf(db *gorm.DB, typ feed.EventType) feed.EventType {
+	if v, ok := db.Get(settingType); ok {
+		if typ2, ok := v.(feed.EventType); ok && typ2 != "" {
+			return typ2
+		}
+	}
+	if typ != feed.Updated {
+		return typ
+	}
+	field := gormutil.DeletedAtField(db.Statement.Schema)
+	values, ok := db.Statement.Dest.(map[string]any)
+	if field == nil || !ok {
+		return typ
+	}
+	for _, name := range []string{field.DBName, field.Name} {
+		if v, ok := values[name]; ok {
+			if v == nil {
+				return feed.Restored
+			}
+			return feed.Deleted
+		}
+	}
+	return typ
+}
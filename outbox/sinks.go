@@ -0,0 +1,153 @@
+package outbox
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/heypkg/store/feed"
+	"github.com/pkg/errors"
+)
+
+// HTTPSink posts the events as JSON to a webhook URL. Responses other than
+// 2xx are errors, so the events are sent again.
+type HTTPSink struct {
+	URL    string
+	Client *http.Client
+	// Header is added to the requests.
+	Header http.Header
+}
+
+var _ feed.Sink = (*HTTPSink)(nil)
+
+func NewHTTPSink(url string) *HTTPSink {
+	return &HTTPSink{URL: url, Client: http.DefaultClient}
+}
+
+func (s *HTTPSink) Send(ctx context.Context, e feed.Event) error {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return errors.Wrap(err, "encode event")
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for key, values := range s.Header {
+		req.Header[key] = values
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Idempotency-Key", e.ID)
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<16))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return errors.Errorf("webhook responded %v", resp.Status)
+	}
+	return nil
+}
+
+// ChannelSink sends the events to a channel of the process. Send blocks
+// until the event is received or its context is done.
+type ChannelSink chan<- feed.Event
+
+var _ feed.Sink = ChannelSink(nil)
+
+func (s ChannelSink) Send(ctx context.Context, e feed.Event) error {
+	select {
+	case s <- e:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Publisher publishes messages on subjects, as nats.Conn does.
+type Publisher interface {
+	Publish(subject string, data []byte) error
+}
+
+// NATSSink publishes the events as JSON on the subject
+// <Prefix>.<model>.<type>.
+type NATSSink struct {
+	Conn   Publisher
+	Prefix string
+}
+
+var _ feed.Sink = (*NATSSink)(nil)
+
+func NewNATSSink(conn Publisher, prefix string) *NATSSink {
+	return &NATSSink{Conn: conn, Prefix: prefix}
+}
+
+func (s *NATSSink) Send(ctx context.Context, e feed.Event) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return errors.Wrap(err, "encode event")
+	}
+	return s.Conn.Publish(s.Subject(e), data)
+}
+
+// Subject returns the subject of e.
+func (s *NATSSink) Subject(e feed.Event) string {
+	subject := fmt.Sprintf("%s.%s", e.Model, e.Type)
+	if s.Prefix != "" {
+		subject = strings.TrimSuffix(s.Prefix, ".") + "." + subject
+	}
+	return subject
+}
+
+// MemorySink keeps the events it receives. It stands in for the other sinks
+// in tests.
+type MemorySink struct {
+	// Fail, when set, is called before an event is kept; its error fails the
+	// send.
+	Fail func(e feed.Event) error
+
+	mu     sync.Mutex
+	events []feed.Event
+}
+
+var _ feed.Sink = (*MemorySink)(nil)
+
+func NewMemorySink() *MemorySink {
+	return &MemorySink{}
+}
+
+func (s *MemorySink) Send(ctx context.Context, e feed.Event) error {
+	if s.Fail != nil {
+		if err := s.Fail(e); err != nil {
+			return err
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.events = append(s.events, e)
+	return nil
+}
+
+// Events returns the events received, in order.
+func (s *MemorySink) Events() []feed.Event {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]feed.Event(nil), s.events...)
+}
+
+// Reset forgets the events received.
+func (s *MemorySink) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.events = nil
+}
@@ -0,0 +1,61 @@
+package policy
+
+import (
+	"github.com/heypkg/store/search"
+	"github.com/spf13/cast"
+	"gorm.io/gorm"
+)
+
+// OwnerPolicy lets subjects see the objects they own and the objects shared
+// with one of their groups. Only owners may update, delete or restore them.
+// Members of AdminGroups may do everything.
+type OwnerPolicy struct {
+	OwnerColumn string
+	GroupColumn string
+	AdminGroups []string
+}
+
+func (p OwnerPolicy) Allow(subject Subject, action Action, obj any) (bool, error) {
+	if subject.InGroup(p.AdminGroups...) {
+		return true, nil
+	}
+	if subject.ID == "" {
+		return false, nil
+	}
+	if action == ActionCreate && obj != nil {
+		values, err := search.ObjectValues(obj)
+		if err != nil {
+			return false, err
+		}
+		owner := cast.ToString(values[p.OwnerColumn])
+		return owner == "" || owner == subject.ID, nil
+	}
+	return true, nil
+}
+
+func (p OwnerPolicy) Filter(subject Subject, action Action) (Filter, error) {
+	if subject.InGroup(p.AdminGroups...) || action == ActionCreate {
+		return Filter{}, nil
+	}
+	shared := p.GroupColumn != "" && len(subject.Groups) > 0 && (action == ActionList || action == ActionRead)
+	return Filter{
+		Scopes: []func(db *gorm.DB) *gorm.DB{
+			func(db *gorm.DB) *gorm.DB {
+				if shared {
+					return db.Where(db.Statement.Quote(p.OwnerColumn)+" = ? OR "+db.Statement.Quote(p.GroupColumn)+" IN ?", subject.ID, subject.Groups)
+				}
+				return db.Where(db.Statement.Quote(p.OwnerColumn)+" = ?", subject.ID)
+			},
+		},
+		Match: func(obj any) bool {
+			values, err := search.ObjectValues(obj)
+			if err != nil {
+				return false
+			}
+			if cast.ToString(values[p.OwnerColumn]) == subject.ID {
+				return true
+			}
+			return shared && subject.InGroup(cast.ToString(values[p.GroupColumn]))
+		},
+	}, nil
+}
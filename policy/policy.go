@@ -0,0 +1,200 @@
+package policy
+
+import (
+	"context"
+	"net/http"
+	"sync"
+
+	"github.com/heypkg/store/search"
+	"github.com/heypkg/store/utils"
+	"github.com/pkg/errors"
+	"github.com/spf13/cast"
+	"gorm.io/gorm"
+)
+
+type Action string
+
+const (
+	ActionList    Action = "list"
+	ActionRead    Action = "read"
+	ActionCreate  Action = "create"
+	ActionUpdate  Action = "update"
+	ActionDelete  Action = "delete"
+	ActionRestore Action = "restore"
+)
+
+var ErrDenied = errors.New("access denied")
+
+// Subject is the user a request is made for.
+type Subject struct {
+	ID     string
+	Groups []string
+}
+
+// SubjectFromValues reads the subject from the "actor" and "groups" values
+// set by the authentication middleware.
+func SubjectFromValues(v interface{ Get(key string) any }) Subject {
+	return Subject{
+		ID:     cast.ToString(v.Get("actor")),
+		Groups: cast.ToStringSlice(v.Get("groups")),
+	}
+}
+
+type subjectContextKey struct{}
+
+func WithSubject(ctx context.Context, subject Subject) context.Context {
+	return context.WithValue(ctx, subjectContextKey{}, subject)
+}
+
+func SubjectFromContext(ctx context.Context) Subject {
+	if ctx != nil {
+		if v, ok := ctx.Value(subjectContextKey{}).(Subject); ok {
+			return v
+		}
+	}
+	return Subject{}
+}
+
+func (s Subject) InGroup(groups ...string) bool {
+	for _, g := range s.Groups {
+		for _, g2 := range groups {
+			if g == g2 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Filter restricts the objects an action applies to. Search is written in the
+// search language and is evaluated both by the database and in memory.
+// Scopes only run in the database, so policies that use them provide Match as
+// the in-memory equivalent.
+type Filter struct {
+	Search string
+	Scopes []func(db *gorm.DB) *gorm.DB
+	Match  func(obj any) bool
+}
+
+func (f Filter) IsEmpty() bool {
+	return f.Search == "" && len(f.Scopes) == 0 && f.Match == nil
+}
+
+func (f Filter) SearchData() (search.SearchData, error) {
+	if f.Search == "" {
+		return search.SearchData{}, nil
+	}
+	s, err := search.ParseSearchString(f.Search)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid policy filter")
+	}
+	return s, nil
+}
+
+// Policy decides what a subject may do with the objects of a model.
+type Policy interface {
+	// Allow decides whether subject may perform action. obj is nil for
+	// ActionList.
+	Allow(subject Subject, action Action, obj any) (bool, error)
+	// Filter returns the conditions objects must match to be visible to the
+	// action.
+	Filter(subject Subject, action Action) (Filter, error)
+}
+
+// Funcs adapts plain functions to a Policy. A nil function allows everything.
+type Funcs struct {
+	AllowFunc  func(subject Subject, action Action, obj any) (bool, error)
+	FilterFunc func(subject Subject, action Action) (Filter, error)
+}
+
+func (p Funcs) Allow(subject Subject, action Action, obj any) (bool, error) {
+	if p.AllowFunc == nil {
+		return true, nil
+	}
+	return p.AllowFunc(subject, action, obj)
+}
+
+func (p Funcs) Filter(subject Subject, action Action) (Filter, error) {
+	if p.FilterFunc == nil {
+		return Filter{}, nil
+	}
+	return p.FilterFunc(subject, action)
+}
+
+type Registration struct {
+	Policy Policy
+	// DeniedStatus is returned when an object exists but the subject may not
+	// see or change it: http.StatusForbidden, or http.StatusNotFound to hide
+	// its existence.
+	DeniedStatus int
+}
+
+var registry sync.Map
+
+// Register declares the policy of model T.
+func Register[T any](p Policy, deniedStatus int) {
+	var obj T
+	if deniedStatus == 0 {
+		deniedStatus = http.StatusForbidden
+	}
+	registry.Store(utils.GetRawTypeName(obj), &Registration{Policy: p, DeniedStatus: deniedStatus})
+}
+
+// Lookup returns the policy of model T, or nil when none is registered.
+func Lookup[T any]() *Registration {
+	var obj T
+	if v, ok := registry.Load(utils.GetRawTypeName(obj)); ok {
+		return v.(*Registration)
+	}
+	return nil
+}
+
+// Evaluate decides in memory whether subject may perform action on obj,
+// applying both Allow and the action's filter. It needs no database.
+func Evaluate(p Policy, subject Subject, action Action, obj any) (bool, error) {
+	allowed, err := p.Allow(subject, action, obj)
+	if err != nil || !allowed {
+		return false, err
+	}
+	if obj == nil {
+		return true, nil
+	}
+	filter, err := p.Filter(subject, action)
+	if err != nil {
+		return false, err
+	}
+	return filter.Evaluate(obj)
+}
+
+// Evaluate reports whether obj matches the filter without a database.
+func (f Filter) Evaluate(obj any) (bool, error) {
+	s, err := f.SearchData()
+	if err != nil {
+		return false, err
+	}
+	if ok, err := s.Match(obj, nil); err != nil || !ok {
+		return false, err
+	}
+	if f.Match != nil {
+		return f.Match(obj), nil
+	}
+	if len(f.Scopes) > 0 {
+		return false, errors.Wrap(search.ErrNotEvaluable, "policy scopes without Match")
+	}
+	return true, nil
+}
+
+// Apply adds the filter to a gorm query.
+func (f Filter) Apply(db *gorm.DB) (*gorm.DB, error) {
+	s, err := f.SearchData()
+	if err != nil {
+		return nil, err
+	}
+	if len(s) > 0 {
+		db = s.ResolveTimes(search.ModelTimes(db)).SearchDB(db, nil)
+	}
+	if len(f.Scopes) > 0 {
+		db = db.Scopes(f.Scopes...)
+	}
+	return db, nil
+}
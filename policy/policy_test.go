@@ -0,0 +1,128 @@
+package policy_test
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/heypkg/store/policy"
+	"github.com/heypkg/store/search"
+	"gorm.io/gorm"
+)
+
+type doc struct {
+	ID    uint
+	Kind  string
+	Owner string
+	Team  string
+}
+
+func TestEvaluate(t *testing.T) {
+	p := policy.Funcs{
+		AllowFunc: func(subject policy.Subject, action policy.Action, obj any) (bool, error) {
+			return action != policy.ActionDelete || subject.InGroup("admin"), nil
+		},
+		FilterFunc: func(subject policy.Subject, action policy.Action) (policy.Filter, error) {
+			return policy.Filter{Search: "kind:a"}, nil
+		},
+	}
+	user, admin := policy.Subject{ID: "u1"}, policy.Subject{ID: "u2", Groups: []string{"admin"}}
+	tests := []struct {
+		subject policy.Subject
+		action  policy.Action
+		obj     any
+		want    bool
+	}{
+		{user, policy.ActionList, nil, true},
+		{user, policy.ActionRead, &doc{Kind: "a"}, true},
+		{user, policy.ActionRead, &doc{Kind: "b"}, false},
+		{user, policy.ActionDelete, &doc{Kind: "a"}, false},
+		{admin, policy.ActionDelete, &doc{Kind: "a"}, true},
+		{admin, policy.ActionDelete, &doc{Kind: "b"}, false},
+	}
+	for _, tt := range tests {
+		got, err := policy.Evaluate(p, tt.subject, tt.action, tt.obj)
+		if err != nil || got != tt.want {
+			t.Errorf("Evaluate(%v, %v, %+v) = %v, %v; want %v", tt.subject.ID, tt.action, tt.obj, got, err, tt.want)
+		}
+	}
+}
+
+func TestFilterEvaluate(t *testing.T) {
+	scope := func(db *gorm.DB) *gorm.DB { return db }
+	owned := func(obj any) bool { return obj.(*doc).Owner == "u1" }
+	tests := []struct {
+		name   string
+		filter policy.Filter
+		obj    *doc
+		want   bool
+	}{
+		{"empty", policy.Filter{}, &doc{Kind: "b"}, true},
+		{"search", policy.Filter{Search: "kind:a,c"}, &doc{Kind: "c"}, true},
+		{"search miss", policy.Filter{Search: "kind:a"}, &doc{Kind: "b"}, false},
+		{"match", policy.Filter{Scopes: []func(*gorm.DB) *gorm.DB{scope}, Match: owned}, &doc{Owner: "u1"}, true},
+		{"match miss", policy.Filter{Scopes: []func(*gorm.DB) *gorm.DB{scope}, Match: owned}, &doc{Owner: "u2"}, false},
+		{"search and match", policy.Filter{Search: "kind:a", Match: owned}, &doc{Kind: "b", Owner: "u1"}, false},
+	}
+	for _, tt := range tests {
+		got, err := tt.filter.Evaluate(tt.obj)
+		if err != nil || got != tt.want {
+			t.Errorf("%s: %v, %v; want %v", tt.name, got, err, tt.want)
+		}
+	}
+
+	if _, err := (policy.Filter{Scopes: []func(*gorm.DB) *gorm.DB{scope}}).Evaluate(&doc{}); !errors.Is(err, search.ErrNotEvaluable) {
+		t.Errorf("scopes without Match: %v", err)
+	}
+	if _, err := (policy.Filter{Search: "(("}).Evaluate(&doc{}); err == nil {
+		t.Error("invalid search evaluated")
+	}
+}
+
+func TestOwnerPolicy(t *testing.T) {
+	p := policy.OwnerPolicy{OwnerColumn: "owner", GroupColumn: "team", AdminGroups: []string{"admin"}}
+	user := policy.Subject{ID: "u1", Groups: []string{"t1"}}
+	admin := policy.Subject{ID: "u9", Groups: []string{"admin"}}
+	tests := []struct {
+		subject policy.Subject
+		action  policy.Action
+		obj     *doc
+		want    bool
+	}{
+		{user, policy.ActionCreate, &doc{Owner: "u1"}, true},
+		{user, policy.ActionCreate, &doc{}, true},
+		{user, policy.ActionCreate, &doc{Owner: "u2"}, false},
+		{policy.Subject{}, policy.ActionCreate, &doc{}, false},
+		{user, policy.ActionRead, &doc{Owner: "u1"}, true},
+		{user, policy.ActionRead, &doc{Owner: "u2", Team: "t1"}, true},
+		{user, policy.ActionRead, &doc{Owner: "u2", Team: "t2"}, false},
+		{user, policy.ActionUpdate, &doc{Owner: "u2", Team: "t1"}, false},
+		{user, policy.ActionDelete, &doc{Owner: "u1"}, true},
+		{admin, policy.ActionDelete, &doc{Owner: "u2"}, true},
+	}
+	for _, tt := range tests {
+		got, err := policy.Evaluate(p, tt.subject, tt.action, tt.obj)
+		if err != nil || got != tt.want {
+			t.Errorf("Evaluate(%v, %v, %+v) = %v, %v; want %v", tt.subject.ID, tt.action, tt.obj, got, err, tt.want)
+		}
+	}
+	if filter, err := p.Filter(admin, policy.ActionList); err != nil || !filter.IsEmpty() {
+		t.Errorf("filter of admins: %+v, %v", filter, err)
+	}
+}
+
+func TestRegister(t *testing.T) {
+	type hidden struct{ ID uint }
+	type unregistered struct{ ID uint }
+	policy.Register[doc](policy.Funcs{}, 0)
+	policy.Register[hidden](policy.Funcs{}, http.StatusNotFound)
+	if r := policy.Lookup[doc](); r == nil || r.DeniedStatus != http.StatusForbidden {
+		t.Errorf("default denied status: %+v", r)
+	}
+	if r := policy.Lookup[hidden](); r == nil || r.DeniedStatus != http.StatusNotFound {
+		t.Errorf("denied status: %+v", r)
+	}
+	if r := policy.Lookup[unregistered](); r != nil {
+		t.Errorf("lookup of an unregistered model: %+v", r)
+	}
+}
@@ -0,0 +1,350 @@
+package preload
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/heypkg/store/search"
+	"github.com/heypkg/store/tenancy"
+	"github.com/heypkg/store/utils"
+	"github.com/pkg/errors"
+	"github.com/spf13/cast"
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+var (
+	ErrInvalidInclude    = errors.New("invalid include")
+	ErrIncludeNotAllowed = errors.New("include not allowed")
+)
+
+// Association declares an association clients may include.
+type Association struct {
+	// Path is the association name, nested with dots: Owner.Group.
+	Path string
+	// Fields limits the columns clients may select; empty allows all.
+	Fields []string
+	// Limit is the default number of rows loaded per parent for has-many
+	// associations, 0 for no limit.
+	Limit int
+	// MaxLimit caps the limit clients may ask for, 0 for no cap.
+	MaxLimit int
+}
+
+var registry sync.Map
+
+// Allow declares the associations of model T that clients may include.
+func Allow[T any](associations ...Association) {
+	var obj T
+	m := map[string]Association{}
+	for _, a := range associations {
+		m[a.Path] = a
+	}
+	registry.Store(utils.GetRawTypeName(obj), m)
+}
+
+func lookup[T any]() (map[string]Association, bool) {
+	var obj T
+	if v, ok := registry.Load(utils.GetRawTypeName(obj)); ok {
+		return v.(map[string]Association), true
+	}
+	return nil, false
+}
+
+// Include is one parsed item of an include string.
+type Include struct {
+	Path   string
+	Search search.SearchData
+	Fields []string
+	Order  []search.Order
+	Limit  int
+}
+
+// ParseIncludeString parses a comma-separated list of associations. Each item
+// may carry a search string in parentheses, in which the special terms
+// $fields, $order_by and $limit select columns, order and rows per parent:
+//
+//	Owner.Group,Readings(time:>now-1h $fields:time,value $limit:10)
+func ParseIncludeString(text string) ([]Include, error) {
+	includes := []Include{}
+	for _, part := range splitIncludeString(text) {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		include := Include{Path: part}
+		if i := strings.Index(part, "("); i >= 0 {
+			if !strings.HasSuffix(part, ")") {
+				return nil, errors.Wrap(ErrInvalidInclude, part)
+			}
+			include.Path = strings.TrimSpace(part[:i])
+			s, err := search.ParseSearchString(part[i+1 : len(part)-1])
+			if err != nil {
+				return nil, errors.Wrap(err, part)
+			}
+			for name, values := range s {
+				switch name {
+				case "$fields":
+					for _, v := range values {
+						include.Fields = append(include.Fields, cast.ToString(v.Value))
+					}
+					delete(s, name)
+				case "$order_by":
+					names := []string{}
+					for _, v := range values {
+						names = append(names, cast.ToString(v.Value))
+					}
+					include.Order = search.ParseOrderByString(strings.Join(names, ","))
+					delete(s, name)
+				case "$limit":
+					if len(values) != 1 {
+						return nil, errors.Wrap(ErrInvalidInclude, part)
+					}
+					include.Limit = cast.ToInt(values[0].Value)
+					delete(s, name)
+				}
+			}
+			include.Search = s
+		}
+		if include.Path == "" {
+			return nil, errors.Wrap(ErrInvalidInclude, part)
+		}
+		includes = append(includes, include)
+	}
+	return includes, nil
+}
+
+func splitIncludeString(text string) []string {
+	parts := []string{}
+	depth := 0
+	quote := rune(0)
+	start := 0
+	for i, r := range text {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '\'' || r == '"':
+			quote = r
+		case r == '(':
+			depth++
+		case r == ')':
+			depth--
+		case r == ',' && depth == 0:
+			parts = append(parts, text[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, text[start:])
+}
+
+// Check validates client includes against the associations declared for
+// model T and applies their limits.
+func Check[T any](includes []Include) ([]Include, error) {
+	if len(includes) == 0 {
+		return includes, nil
+	}
+	allowed, ok := lookup[T]()
+	if !ok {
+		return nil, errors.Wrap(ErrIncludeNotAllowed, includes[0].Path)
+	}
+	out := make([]Include, 0, len(includes))
+	for _, include := range includes {
+		a, ok := allowed[include.Path]
+		if !ok {
+			return nil, errors.Wrap(ErrIncludeNotAllowed, include.Path)
+		}
+		if len(a.Fields) > 0 {
+			if len(include.Fields) == 0 {
+				include.Fields = a.Fields
+			}
+			for _, f := range include.Fields {
+				if !containsString(a.Fields, f) {
+					return nil, errors.Wrapf(ErrIncludeNotAllowed, "%v field %v", include.Path, f)
+				}
+			}
+		}
+		if include.Limit <= 0 {
+			include.Limit = a.Limit
+		}
+		if a.MaxLimit > 0 && (include.Limit <= 0 || include.Limit > a.MaxLimit) {
+			include.Limit = a.MaxLimit
+		}
+		out = append(out, include)
+	}
+	return out, nil
+}
+
+// Apply adds the includes to a query over model T. Included rows are
+// restricted to the tenant like the rows of T.
+func Apply[T any](db *gorm.DB, includes []Include, tenant tenancy.Scope) (*gorm.DB, error) {
+	if len(includes) == 0 {
+		return db, nil
+	}
+	var obj T
+	stmt := &gorm.Statement{DB: db}
+	if err := stmt.Parse(&obj); err != nil {
+		return nil, errors.Wrap(err, "parse model")
+	}
+	for _, include := range includes {
+		rel, err := lookupRelationship(stmt.Schema, include.Path)
+		if err != nil {
+			return nil, err
+		}
+		if include, err = resolveFields(include, rel.FieldSchema); err != nil {
+			return nil, err
+		}
+		include.Search = include.Search.ResolveTimes(search.SchemaTimes(rel.FieldSchema))
+		db = db.Preload(include.Path, includeScope(include, rel, tenant))
+	}
+	return db, nil
+}
+
+func lookupRelationship(s *schema.Schema, path string) (*schema.Relationship, error) {
+	var rel *schema.Relationship
+	for _, name := range strings.Split(path, ".") {
+		r, ok := s.Relationships.Relations[name]
+		if !ok {
+			return nil, errors.Wrapf(ErrInvalidInclude, "unknown association %v", path)
+		}
+		rel = r
+		s = r.FieldSchema
+	}
+	return rel, nil
+}
+
+// resolveFields replaces the selected and ordering fields of include with
+// the columns of s, which are written in SQL.
+func resolveFields(include Include, s *schema.Schema) (Include, error) {
+	column := func(name string) (string, error) {
+		f := s.LookUpField(name)
+		if f == nil || f.DBName == "" {
+			return "", errors.Wrapf(ErrInvalidInclude, "unknown field %v of %v", name, include.Path)
+		}
+		return f.DBName, nil
+	}
+	fields := make([]string, 0, len(include.Fields))
+	for _, name := range include.Fields {
+		name, err := column(name)
+		if err != nil {
+			return include, err
+		}
+		fields = append(fields, name)
+	}
+	order := make([]search.Order, 0, len(include.Order))
+	for _, o := range include.Order {
+		name, err := column(o.Name)
+		if err != nil {
+			return include, err
+		}
+		order = append(order, search.Order{Name: name, Desc: o.Desc})
+	}
+	include.Fields, include.Order = fields, order
+	return include, nil
+}
+
+func includeScope(include Include, rel *schema.Relationship, tenant tenancy.Scope) func(db *gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		s := rel.FieldSchema
+		if column := tenant.Strategy.Column(); column != "" && s.LookUpField(column) != nil {
+			db = db.Where(fmt.Sprintf("%v.%v = ?", db.Statement.Quote(s.Table), db.Statement.Quote(column)), tenant.Tenant)
+		}
+		if len(include.Search) > 0 {
+			db = include.Search.SearchDB(db, nil)
+		}
+		if len(include.Fields) > 0 {
+			db = db.Select(requiredFields(rel, include.Fields))
+		}
+		for _, o := range include.Order {
+			if o.Desc {
+				db = db.Order(db.Statement.Quote(o.Name) + " DESC")
+			} else {
+				db = db.Order(db.Statement.Quote(o.Name))
+			}
+		}
+		if include.Limit > 0 {
+			db = limitPerParent(db, include, rel)
+		}
+		return db
+	}
+}
+
+// requiredFields adds the keys gorm needs to attach the rows to their
+// parents and to load nested associations.
+func requiredFields(rel *schema.Relationship, fields []string) []string {
+	out := append([]string{}, fields...)
+	add := func(name string) {
+		if name != "" && !containsString(out, name) {
+			out = append(out, name)
+		}
+	}
+	s := rel.FieldSchema
+	for _, f := range s.PrimaryFields {
+		add(f.DBName)
+	}
+	for _, ref := range rel.References {
+		if ref.OwnPrimaryKey && ref.ForeignKey != nil {
+			add(ref.ForeignKey.DBName)
+		} else if ref.PrimaryKey != nil {
+			add(ref.PrimaryKey.DBName)
+		}
+	}
+	for _, r := range s.Relationships.BelongsTo {
+		for _, ref := range r.References {
+			if ref.ForeignKey != nil {
+				add(ref.ForeignKey.DBName)
+			}
+		}
+	}
+	return out
+}
+
+// limitPerParent keeps the first rows of every parent of a has-many
+// association by ranking the matching rows per foreign key.
+func limitPerParent(db *gorm.DB, include Include, rel *schema.Relationship) *gorm.DB {
+	if rel.Type != schema.HasMany || len(rel.FieldSchema.PrimaryFields) != 1 {
+		return db
+	}
+	s := rel.FieldSchema
+	partition := []string{}
+	for _, ref := range rel.References {
+		if ref.OwnPrimaryKey && ref.ForeignKey != nil {
+			partition = append(partition, db.Statement.Quote(ref.ForeignKey.DBName))
+		}
+	}
+	if len(partition) == 0 {
+		return db
+	}
+	pk := db.Statement.Quote(s.PrimaryFields[0].DBName)
+	order := []string{}
+	for _, o := range include.Order {
+		if o.Desc {
+			order = append(order, db.Statement.Quote(o.Name)+" DESC")
+		} else {
+			order = append(order, db.Statement.Quote(o.Name))
+		}
+	}
+	if len(order) == 0 {
+		order = append(order, pk)
+	}
+	ranked := db.Session(&gorm.Session{NewDB: true}).Table(s.Table).
+		Select(fmt.Sprintf("%v, ROW_NUMBER() OVER (PARTITION BY %v ORDER BY %v) AS preload_rank",
+			pk, strings.Join(partition, ", "), strings.Join(order, ", ")))
+	if len(include.Search) > 0 {
+		ranked = include.Search.SearchDB(ranked, nil)
+	}
+	limited := db.Session(&gorm.Session{NewDB: true}).Table("(?) AS preload_ranked", ranked).
+		Select(pk).Where("preload_rank <= ?", include.Limit)
+	return db.Where(fmt.Sprintf("%v.%v IN (?)", db.Statement.Quote(s.Table), pk), limited)
+}
+
+func containsString(values []string, v string) bool {
+	for _, v2 := range values {
+		if v2 == v {
+			return true
+		}
+	}
+	return false
+}
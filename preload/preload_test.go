@@ -0,0 +1,92 @@
+package preload_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/glebarez/sqlite"
+	"github.com/heypkg/store/preload"
+	"github.com/heypkg/store/tenancy"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+type sensor struct {
+	ID       uint `gorm:"primarykey"`
+	Schema   string
+	Name     string
+	Readings []reading
+}
+
+type reading struct {
+	ID       uint `gorm:"primarykey"`
+	Schema   string
+	SensorID uint
+	Time     time.Time
+	Value    float64
+}
+
+func newDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(t.TempDir()+"/preload.db"), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&sensor{}, &reading{}); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	s := sensor{Schema: "a", Name: "s1"}
+	for i := 0; i < 5; i++ {
+		s.Readings = append(s.Readings, reading{Schema: "a", Time: now.Add(-time.Duration(i) * time.Hour), Value: float64(i)})
+	}
+	if err := db.Create(&s).Error; err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func load(db *gorm.DB, include string) ([]sensor, error) {
+	includes, err := preload.ParseIncludeString(include)
+	if err != nil {
+		return nil, err
+	}
+	if includes, err = preload.Check[sensor](includes); err != nil {
+		return nil, err
+	}
+	db, err = preload.Apply[sensor](db, includes, tenancy.NewScope(nil, "a"))
+	if err != nil {
+		return nil, err
+	}
+	var out []sensor
+	return out, db.Find(&out).Error
+}
+
+func TestInclude(t *testing.T) {
+	db := newDB(t)
+	preload.Allow[sensor](preload.Association{Path: "Readings", MaxLimit: 3})
+
+	out, err := load(db, "Readings(time:>now-150m $order_by:value- $fields:time,value $limit:2)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out) != 1 || len(out[0].Readings) != 2 || out[0].Readings[0].Value != 2 || out[0].Readings[1].Value != 1 {
+		t.Fatalf("readings: %+v", out)
+	}
+	if out, err = load(db, "Readings"); err != nil || len(out[0].Readings) != 3 {
+		t.Fatalf("max limit: %+v %v", out, err)
+	}
+
+	for _, include := range []string{
+		"Readings($order_by:'value; DROP TABLE readings')",
+		"Readings($order_by:'(SELECT 1)')",
+		"Readings($fields:'value, 1 AS x' $limit:1)",
+		"Readings($order_by:unknown)",
+		"Owner",
+	} {
+		if _, err := load(db, include); !errors.Is(err, preload.ErrInvalidInclude) && !errors.Is(err, preload.ErrIncludeNotAllowed) {
+			t.Errorf("%q: %v", include, err)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package store
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/spf13/cast"
+)
+
+const (
+	// HeaderReadPrimary pins the reads of a request to the primary database
+	// when it is true.
+	HeaderReadPrimary = "X-Read-Primary"
+	// CookieLastWrite holds the time of the last write of a client in Unix
+	// milliseconds. It is set by StickyPrimary.
+	CookieLastWrite = "store_last_write"
+)
+
+// PrimaryStickiness is how long the reads of a client go to the primary
+// database after its writes, so that they see them while the replicas catch
+// up. Set it to the MaxLag of the replicas, or to their health interval when
+// their lag is not bounded. Zero disables stickiness.
+var PrimaryStickiness = 10 * time.Second
+
+type primaryContextKey struct{}
+
+// WithPrimary returns ctx whose reads go to the primary database instead of
+// the replicas.
+func WithPrimary(ctx context.Context) context.Context {
+	return context.WithValue(ctx, primaryContextKey{}, true)
+}
+
+// UsePrimary reports whether the reads of ctx go to the primary database.
+func UsePrimary(ctx context.Context) bool {
+	v, _ := ctx.Value(primaryContextKey{}).(bool)
+	return v
+}
+
+// PrimaryRequest reports whether r reads from the primary database: the
+// requests that write, so that they read their own writes, the ones with
+// HeaderReadPrimary and the reads of clients that wrote within
+// PrimaryStickiness.
+func PrimaryRequest(r *http.Request) bool {
+	if !readRequest(r) {
+		return true
+	}
+	return cast.ToBool(r.Header.Get(HeaderReadPrimary)) || recentWrite(r)
+}
+
+func readRequest(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	return false
+}
+
+// recentWrite reports whether the CookieLastWrite of r is within
+// PrimaryStickiness.
+func recentWrite(r *http.Request) bool {
+	if PrimaryStickiness <= 0 {
+		return false
+	}
+	cookie, err := r.Cookie(CookieLastWrite)
+	if err != nil {
+		return false
+	}
+	ms, err := strconv.ParseInt(cookie.Value, 10, 64)
+	if err != nil {
+		return false
+	}
+	since := time.Since(time.UnixMilli(ms))
+	return since >= 0 && since < PrimaryStickiness
+}
+
+// StickyPrimary sets CookieLastWrite on the responses of the requests that
+// write, so that the reads of their clients go to the primary database for
+// PrimaryStickiness. Clients without cookies pin their reads with
+// HeaderReadPrimary instead. Use echo.WrapMiddleware to serve it from echo.
+func StickyPrimary(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if PrimaryStickiness > 0 && !readRequest(r) {
+			http.SetCookie(w, &http.Cookie{
+				Name:     CookieLastWrite,
+				Value:    strconv.FormatInt(time.Now().UnixMilli(), 10),
+				Path:     "/",
+				MaxAge:   int(math.Ceil(PrimaryStickiness.Seconds())),
+				HttpOnly: true,
+				SameSite: http.SameSiteLaxMode,
+			})
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RequestContext returns the context of r carrying the request values v, as
+// Context does, whose reads go to the primary database when PrimaryRequest
+// is true.
+func RequestContext(r *http.Request, v Values) (context.Context, error) {
+	ctx, err := Context(r.Context(), v)
+	if err != nil {
+		return nil, err
+	}
+	if PrimaryRequest(r) {
+		ctx = WithPrimary(ctx)
+	}
+	return ctx, nil
+}
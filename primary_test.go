@@ -0,0 +1,68 @@
+package store_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/heypkg/store"
+)
+
+func TestStickyPrimary(t *testing.T) {
+	var cookie *http.Cookie
+	h := store.StickyPrimary(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	for _, method := range []string{"GET", "POST"} {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(method, "/devices", nil))
+		cookies := rec.Result().Cookies()
+		if method == "GET" && len(cookies) > 0 {
+			t.Errorf("cookie set by a read: %v", cookies)
+		}
+		if method == "POST" {
+			if len(cookies) != 1 || cookies[0].Name != store.CookieLastWrite {
+				t.Fatalf("cookies of a write: %v", cookies)
+			}
+			cookie = cookies[0]
+		}
+	}
+
+	read := func(cookie *http.Cookie, header string) bool {
+		r := httptest.NewRequest("GET", "/devices", nil)
+		if cookie != nil {
+			r.AddCookie(cookie)
+		}
+		if header != "" {
+			r.Header.Set(store.HeaderReadPrimary, header)
+		}
+		return store.PrimaryRequest(r)
+	}
+	old := &http.Cookie{Name: store.CookieLastWrite, Value: strconv.FormatInt(time.Now().Add(-store.PrimaryStickiness).UnixMilli(), 10)}
+	tests := []struct {
+		name   string
+		cookie *http.Cookie
+		header string
+		want   bool
+	}{
+		{"replica", nil, "", false},
+		{"header", nil, "true", true},
+		{"recent write", cookie, "", true},
+		{"old write", old, "", false},
+		{"invalid cookie", &http.Cookie{Name: store.CookieLastWrite, Value: "x"}, "", false},
+	}
+	for _, tt := range tests {
+		if got := read(tt.cookie, tt.header); got != tt.want {
+			t.Errorf("%s: %v; want %v", tt.name, got, tt.want)
+		}
+	}
+	if !store.PrimaryRequest(httptest.NewRequest("DELETE", "/devices/1", nil)) {
+		t.Error("write read from a replica")
+	}
+
+	defer func(d time.Duration) { store.PrimaryStickiness = d }(store.PrimaryStickiness)
+	store.PrimaryStickiness = 0
+	if read(cookie, "") {
+		t.Error("sticky without stickiness")
+	}
+}
@@ -0,0 +1,40 @@
+package store
+
+import (
+	"net/http"
+	"strings"
+)
+
+const MIMEProblemJSON = "application/problem+json"
+
+// ProblemTypeBase prefixes the codes of errors to make the type URIs of
+// problems. Problems are typed about:blank when it is empty.
+var ProblemTypeBase = ""
+
+// Problem is an RFC 7807 problem details object, with the code of the error
+// as an extension member.
+type Problem struct {
+	Type     string `json:"type"`
+	Title    string `json:"title"`
+	Status   int    `json:"status"`
+	Detail   string `json:"detail,omitempty"`
+	Instance string `json:"instance,omitempty"`
+	Code     string `json:"code"`
+}
+
+// NewProblem returns the problem of err. Details of server errors are not
+// shown.
+func NewProblem(err error) Problem {
+	e := ErrorOf(err)
+	p := Problem{Type: "about:blank", Title: http.StatusText(e.Status), Status: e.Status, Detail: e.Message, Code: e.Code}
+	if ProblemTypeBase != "" {
+		p.Type = ProblemTypeBase + e.Code
+	}
+	if p.Title == "" {
+		p.Title = e.Code
+	}
+	if strings.EqualFold(p.Detail, p.Title) {
+		p.Detail = ""
+	}
+	return p
+}
@@ -0,0 +1,257 @@
+// Package replica routes the reads of the stores to read replicas of the
+// primary database. Writes, transactions and the contexts pinned with
+// store.WithPrimary stay on the primary.
+package replica
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/heypkg/store"
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+)
+
+// PluginName is the name of routers registered with gorm.
+const PluginName = "store:replicas"
+
+// Policy selects the replica of a read.
+type Policy int
+
+const (
+	// RoundRobin takes the healthy replicas in turn.
+	RoundRobin Policy = iota
+	// LeastLag takes the healthy replica with the least replication lag.
+	LeastLag
+)
+
+// DefaultHealthInterval is the interval of health checks when the config
+// has none.
+const DefaultHealthInterval = 10 * time.Second
+
+// LagFunc returns the replication lag of a replica.
+type LagFunc func(ctx context.Context, db *sql.DB) (time.Duration, error)
+
+// PostgresLag returns the time since the last transaction replayed by a
+// Postgres standby. Note that it grows while the primary is idle.
+func PostgresLag(ctx context.Context, db *sql.DB) (time.Duration, error) {
+	var seconds float64
+	err := db.QueryRowContext(ctx, "SELECT COALESCE(EXTRACT(EPOCH FROM now() - pg_last_xact_replay_timestamp()), 0)").Scan(&seconds)
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(seconds * float64(time.Second)), nil
+}
+
+type Config struct {
+	Policy Policy
+	// HealthInterval is the interval of the health checks of replicas.
+	HealthInterval time.Duration
+	// Lag measures the replication lag of replicas in health checks. The
+	// lag is zero when it is nil.
+	Lag LagFunc
+	// MaxLag is the lag above which replicas are unhealthy. Zero is no
+	// limit.
+	MaxLag time.Duration
+}
+
+// Replica is a read replica of the primary database.
+type Replica struct {
+	Name string
+	DB   *sql.DB
+}
+
+// Status is the state of a replica at its last health check.
+type Status struct {
+	Name      string
+	Healthy   bool
+	Lag       time.Duration
+	Error     string
+	CheckedAt time.Time
+}
+
+type replica struct {
+	Replica
+	mu     sync.Mutex
+	status Status
+}
+
+func (r *replica) get() Status {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.status
+}
+
+func (r *replica) set(s Status) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.status = s
+}
+
+// Router picks the replicas of reads and checks their health in the
+// background until it is closed. Replicas are healthy until their first
+// check fails.
+type Router struct {
+	config   Config
+	replicas []*replica
+	next     atomic.Uint64
+	done     chan struct{}
+	once     sync.Once
+}
+
+var _ gorm.Plugin = (*Router)(nil)
+
+// NewRouter returns the router of replicas and starts their health checks.
+func NewRouter(config Config, replicas ...Replica) *Router {
+	if config.HealthInterval <= 0 {
+		config.HealthInterval = DefaultHealthInterval
+	}
+	r := &Router{config: config, done: make(chan struct{})}
+	for _, v := range replicas {
+		r.replicas = append(r.replicas, &replica{Replica: v, status: Status{Name: v.Name, Healthy: true}})
+	}
+	go r.loop()
+	return r
+}
+
+// Use registers r with db, whose reads are then routed by Run.
+func Use(db *gorm.DB, r *Router) error {
+	return db.Use(r)
+}
+
+func (r *Router) Name() string {
+	return PluginName
+}
+
+func (r *Router) Initialize(db *gorm.DB) error {
+	return nil
+}
+
+// Close stops the health checks.
+func (r *Router) Close() error {
+	r.once.Do(func() { close(r.done) })
+	return nil
+}
+
+func (r *Router) loop() {
+	r.Check(context.Background())
+	ticker := time.NewTicker(r.config.HealthInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.done:
+			return
+		case <-ticker.C:
+			r.Check(context.Background())
+		}
+	}
+}
+
+// Check pings the replicas and measures their lag.
+func (r *Router) Check(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, rep := range r.replicas {
+		wg.Add(1)
+		go func(rep *replica) {
+			defer wg.Done()
+			rep.set(r.check(ctx, rep))
+		}(rep)
+	}
+	wg.Wait()
+}
+
+func (r *Router) check(ctx context.Context, rep *replica) Status {
+	ctx, cancel := context.WithTimeout(ctx, r.config.HealthInterval)
+	defer cancel()
+	s := Status{Name: rep.Name, CheckedAt: time.Now()}
+	err := rep.DB.PingContext(ctx)
+	if err == nil && r.config.Lag != nil {
+		s.Lag, err = r.config.Lag(ctx, rep.DB)
+		if err == nil && r.config.MaxLag > 0 && s.Lag > r.config.MaxLag {
+			err = errors.Errorf("lag %v exceeds %v", s.Lag, r.config.MaxLag)
+		}
+	}
+	if err != nil {
+		s.Error = err.Error()
+		return s
+	}
+	s.Healthy = true
+	return s
+}
+
+// Status returns the states of the replicas.
+func (r *Router) Status() []Status {
+	out := make([]Status, 0, len(r.replicas))
+	for _, rep := range r.replicas {
+		out = append(out, rep.get())
+	}
+	return out
+}
+
+// pick returns a healthy replica, nil when there is none.
+func (r *Router) pick() *replica {
+	healthy := make([]*replica, 0, len(r.replicas))
+	for _, rep := range r.replicas {
+		if rep.get().Healthy {
+			healthy = append(healthy, rep)
+		}
+	}
+	if len(healthy) == 0 {
+		return nil
+	}
+	if r.config.Policy == LeastLag {
+		best := healthy[0]
+		for _, rep := range healthy[1:] {
+			if rep.get().Lag < best.get().Lag {
+				best = rep
+			}
+		}
+		return best
+	}
+	return healthy[r.next.Add(1)%uint64(len(healthy))]
+}
+
+// down marks rep unhealthy until its next successful check.
+func (r *Router) down(rep *replica, err error) {
+	s := rep.get()
+	s.Healthy = false
+	s.Error = err.Error()
+	rep.set(s)
+}
+
+// Run runs fn with a replica of db for the reads of ctx. fn runs with db
+// itself when no router is registered with db, when db is in a transaction,
+// when ctx is pinned to the primary or when no replica is healthy. Reads
+// failing to reach their replica are retried on the primary.
+func Run(ctx context.Context, db *gorm.DB, fn func(db *gorm.DB) error) error {
+	p, ok := db.Config.Plugins[PluginName]
+	if !ok || store.UsePrimary(ctx) {
+		return fn(db)
+	}
+	if _, ok := db.Statement.ConnPool.(gorm.TxCommitter); ok {
+		return fn(db)
+	}
+	r := p.(*Router)
+	rep := r.pick()
+	if rep == nil {
+		return fn(db)
+	}
+	tx := db.Session(&gorm.Session{Context: ctx})
+	tx.Statement.ConnPool = rep.DB
+	err := fn(tx)
+	if err != nil && ctx.Err() == nil && unreachable(err) {
+		r.down(rep, err)
+		return fn(db)
+	}
+	return err
+}
+
+func unreachable(err error) bool {
+	var netErr net.Error
+	return errors.Is(err, driver.ErrBadConn) || errors.Is(err, sql.ErrConnDone) || errors.As(err, &netErr)
+}
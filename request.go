@@ -0,0 +1,184 @@
+package store
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/heypkg/store/audit"
+	"github.com/heypkg/store/jsontype"
+	"github.com/heypkg/store/policy"
+	"github.com/heypkg/store/tenancy"
+	"github.com/pkg/errors"
+	"github.com/spf13/cast"
+)
+
+// Values holds the values set on a request by middlewares: the tenant,
+// "actor", "groups" and "preload". echo.Context implements it.
+type Values = tenancy.Values
+
+// MapValues holds request values for callers without a web framework, such
+// as gRPC services.
+type MapValues map[string]any
+
+func (m MapValues) Get(key string) any {
+	return m[key]
+}
+
+// Context returns ctx carrying the tenant, the policy subject and the audit
+// actor of the request values v.
+func Context(ctx context.Context, v Values) (context.Context, error) {
+	scope, err := tenancy.ScopeFromValues(v)
+	if err != nil {
+		return nil, NewError(ErrForbidden, err.Error(), err)
+	}
+	ctx = tenancy.WithScope(ctx, scope)
+	ctx = policy.WithSubject(ctx, policy.SubjectFromValues(v))
+	ctx = audit.WithActor(ctx, cast.ToString(v.Get("actor")))
+	return ctx, nil
+}
+
+// ParseListRequest reads the q, view, page, page_size, cursor, order_by,
+// include and timeout query parameters and the server preloads of v. A valid cursor
+// replaces page and page_size.
+func ParseListRequest(query url.Values, v Values) ListRequest {
+	req := ListRequest{
+		Search:   query.Get("q"),
+		Page:     cast.ToInt(query.Get("page")),
+		PageSize: cast.ToInt(query.Get("page_size")),
+		OrderBy:  query.Get("order_by"),
+		View:     query.Get("view"),
+		Preload:  cast.ToString(v.Get("preload")),
+		Include:  query.Get("include"),
+		Timeout:  ParseTimeout(query.Get("timeout")),
+	}
+	if c, err := DecodeCursor(query.Get("cursor")); err == nil {
+		req.Page, req.PageSize = c.Page, c.PageSize
+	}
+	return req
+}
+
+// ParseSuggestRequest reads the q, cursor, limit and timeout query
+// parameters. The cursor defaults to the end of q.
+func ParseSuggestRequest(query url.Values) SuggestRequest {
+	req := SuggestRequest{
+		Search:  query.Get("q"),
+		Cursor:  cast.ToInt(query.Get("cursor")),
+		Limit:   cast.ToInt(query.Get("limit")),
+		Timeout: ParseTimeout(query.Get("timeout")),
+	}
+	if query.Get("cursor") == "" {
+		req.Cursor = len(req.Search)
+	}
+	return req
+}
+
+// Cursor points at a page of a list. Clients pass it back as the cursor
+// query parameter without looking into it.
+type Cursor struct {
+	Page     int `json:"p"`
+	PageSize int `json:"s"`
+}
+
+func (c Cursor) Encode() string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func DecodeCursor(text string) (Cursor, error) {
+	var c Cursor
+	if text == "" {
+		return c, errors.New("empty cursor")
+	}
+	b, err := base64.RawURLEncoding.DecodeString(text)
+	if err != nil {
+		return c, errors.Wrap(err, "invalid cursor")
+	}
+	if err := json.Unmarshal(b, &c); err != nil {
+		return c, errors.Wrap(err, "invalid cursor")
+	}
+	if c.Page < 1 || c.PageSize < 1 {
+		return c, errors.New("invalid cursor")
+	}
+	return c, nil
+}
+
+// ParseGetRequest selects the object of an id path parameter.
+func ParseGetRequest(id string, query url.Values, v Values) GetRequest {
+	return GetRequest{
+		Conditions: map[string]any{"id": cast.ToUint(id)},
+		Preload:    cast.ToString(v.Get("preload")),
+		Include:    query.Get("include"),
+		Timeout:    ParseTimeout(query.Get("timeout")),
+	}
+}
+
+// ParseDeletedGetRequest selects the soft-deleted object of an id path
+// parameter.
+func ParseDeletedGetRequest(id string) GetRequest {
+	return GetRequest{
+		Conditions: map[string]any{"id": cast.ToUint(id)},
+		Deleted:    true,
+	}
+}
+
+// ParseTSGetRequest selects the object of a ts path parameter, a time in
+// microseconds.
+func ParseTSGetRequest(ts string) GetRequest {
+	us := cast.ToInt64(ts)
+	return GetRequest{
+		Conditions: map[string]any{"time": jsontype.JSONTime(time.Unix(0, us*int64(time.Microsecond)))},
+	}
+}
+
+var aggregationRe = regexp.MustCompile(`^(count|sum|avg|min|max)\(([A-Za-z0-9_\.\*]*)\)$`)
+
+// ParseAggregateRequest reads the q, group_by, aggregate and timeout query
+// parameters, for example aggregate=count(*),avg(value).
+func ParseAggregateRequest(query url.Values) (AggregateRequest, error) {
+	req := AggregateRequest{Search: query.Get("q"), Timeout: ParseTimeout(query.Get("timeout"))}
+	for _, name := range strings.Split(query.Get("group_by"), ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			req.GroupBy = append(req.GroupBy, name)
+		}
+	}
+	aggregations, err := ParseAggregationString(query.Get("aggregate"))
+	if err != nil {
+		return req, NewError(ErrInvalidQuery, err.Error(), err)
+	}
+	req.Aggregations = aggregations
+	return req, nil
+}
+
+func ParseAggregationString(text string) ([]Aggregation, error) {
+	aggregations := []Aggregation{}
+	for _, part := range strings.Split(text, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		subs := aggregationRe.FindStringSubmatch(part)
+		if len(subs) != 3 {
+			return nil, errors.Errorf("invalid aggregation %v", part)
+		}
+		a := Aggregation{Func: AggregateFunc(subs[1]), Field: subs[2]}
+		if a.Field == "" || a.Field == "*" {
+			a.Field = "*"
+			a.Name = subs[1]
+		} else {
+			a.Name = subs[1] + "_" + strings.ReplaceAll(a.Field, ".", "_")
+		}
+		if a.Field == "*" && a.Func != AggregateCount {
+			return nil, errors.Errorf("invalid aggregation %v", part)
+		}
+		aggregations = append(aggregations, a)
+	}
+	if len(aggregations) == 0 {
+		aggregations = append(aggregations, Aggregation{Name: "count", Func: AggregateCount, Field: "*"})
+	}
+	return aggregations, nil
+}
@@ -0,0 +1,312 @@
+// Package response writes list results in a standard envelope, with
+// pagination headers and content negotiation between JSON, NDJSON and CSV.
+package response
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/heypkg/store"
+	"github.com/heypkg/store/echoadapter"
+	"github.com/labstack/echo/v4"
+	"github.com/pkg/errors"
+)
+
+const (
+	MIMEJSON   = "application/json"
+	MIMENDJSON = "application/x-ndjson"
+	MIMECSV    = "text/csv"
+)
+
+// Envelope is the JSON body of a list.
+type Envelope[T any] struct {
+	Data       []T    `json:"data"`
+	Total      int64  `json:"total"`
+	Page       int    `json:"page"`
+	PageSize   int    `json:"page_size"`
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// Pagination is the page of a list response.
+type Pagination struct {
+	Page      int
+	PageSize  int
+	Total     int64
+	TotalPage int
+}
+
+// NewPagination returns the page served for req, clamped like the stores
+// clamp it. Without a page size the single page holds every object.
+func NewPagination(req store.ListRequest, total int64) Pagination {
+	p := Pagination{Page: 1, PageSize: req.PageSize, Total: total, TotalPage: 1}
+	if req.PageSize <= 0 {
+		p.PageSize = 0
+		return p
+	}
+	p.TotalPage = int((total + int64(req.PageSize) - 1) / int64(req.PageSize))
+	p.Page = req.Page
+	if p.Page > p.TotalPage {
+		p.Page = p.TotalPage
+	}
+	if p.Page < 1 {
+		p.Page = 1
+	}
+	if p.TotalPage < 1 {
+		p.TotalPage = 1
+	}
+	return p
+}
+
+func (p Pagination) NextCursor() string {
+	if p.PageSize == 0 || p.Page >= p.TotalPage {
+		return ""
+	}
+	return store.Cursor{Page: p.Page + 1, PageSize: p.PageSize}.Encode()
+}
+
+// Links returns the RFC 8288 Link header value of the first, prev, next and
+// last pages, relative to u.
+func (p Pagination) Links(u *url.URL) string {
+	if p.PageSize == 0 {
+		return ""
+	}
+	link := func(page int, rel string) string {
+		u2 := *u
+		q := u2.Query()
+		q.Del("cursor")
+		q.Set("page", strconv.Itoa(page))
+		q.Set("page_size", strconv.Itoa(p.PageSize))
+		u2.RawQuery = q.Encode()
+		return fmt.Sprintf("<%v>; rel=\"%v\"", u2.String(), rel)
+	}
+	links := []string{link(1, "first")}
+	if p.Page > 1 {
+		links = append(links, link(p.Page-1, "prev"))
+	}
+	if p.Page < p.TotalPage {
+		links = append(links, link(p.Page+1, "next"))
+	}
+	links = append(links, link(p.TotalPage, "last"))
+	return strings.Join(links, ", ")
+}
+
+// Negotiate returns the media type of the response to r: the format query
+// parameter (json, ndjson or csv) when set, otherwise the most preferred
+// type of the Accept header. It falls back to JSON.
+func Negotiate(r *http.Request) string {
+	switch r.URL.Query().Get("format") {
+	case "json":
+		return MIMEJSON
+	case "ndjson":
+		return MIMENDJSON
+	case "csv":
+		return MIMECSV
+	}
+	type accepted struct {
+		mediaType string
+		q         float64
+	}
+	types := []accepted{}
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		if q > 0 {
+			types = append(types, accepted{mediaType: mediaType, q: q})
+		}
+	}
+	sort.SliceStable(types, func(i, j int) bool { return types[i].q > types[j].q })
+	for _, t := range types {
+		switch t.mediaType {
+		case MIMEJSON, "application/*", "*/*":
+			return MIMEJSON
+		case MIMENDJSON, "application/ndjson", "application/jsonl":
+			return MIMENDJSON
+		case MIMECSV, "text/*":
+			return MIMECSV
+		}
+	}
+	return MIMEJSON
+}
+
+// WriteList writes a page of objects listed for req in the negotiated
+// format, with the X-Total-Count and Link headers. NDJSON and CSV bodies
+// hold the objects only.
+func WriteList[T any](w http.ResponseWriter, r *http.Request, req store.ListRequest, objects []T, total int64) error {
+	if objects == nil {
+		objects = []T{}
+	}
+	p := NewPagination(req, total)
+	h := w.Header()
+	h.Set("X-Total-Count", strconv.FormatInt(total, 10))
+	if links := p.Links(r.URL); links != "" {
+		h.Set("Link", links)
+	}
+	h.Add("Vary", "Accept")
+
+	switch Negotiate(r) {
+	case MIMENDJSON:
+		h.Set("Content-Type", MIMENDJSON)
+		w.WriteHeader(http.StatusOK)
+		enc := json.NewEncoder(w)
+		for _, obj := range objects {
+			if err := enc.Encode(obj); err != nil {
+				return errors.Wrap(err, "write ndjson")
+			}
+		}
+		return nil
+	case MIMECSV:
+		h.Set("Content-Type", MIMECSV+"; charset=UTF-8")
+		w.WriteHeader(http.StatusOK)
+		return writeCSV(w, objects)
+	}
+	body, err := json.Marshal(Envelope[T]{
+		Data:       objects,
+		Total:      total,
+		Page:       p.Page,
+		PageSize:   p.PageSize,
+		NextCursor: p.NextCursor(),
+	})
+	if err != nil {
+		return errors.Wrap(err, "write json")
+	}
+	h.Set("Content-Type", MIMEJSON+"; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	_, err = w.Write(body)
+	return err
+}
+
+// List writes the result of a list handler of c.
+func List[T any](c echo.Context, objects []T, total int64) error {
+	return WriteList(c.Response(), c.Request(), echoadapter.ListRequest(c), objects, total)
+}
+
+// Columns returns the JSON names of the fields of T in declaration order,
+// with embedded structs inlined.
+func Columns[T any]() []string {
+	return columns(reflect.TypeOf((*T)(nil)).Elem())
+}
+
+func columns(t reflect.Type) []string {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return nil
+	}
+	out := []string{}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, _, _ := strings.Cut(tag, ",")
+		if f.Anonymous && name == "" {
+			ft := f.Type
+			if ft.Kind() == reflect.Pointer {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				out = append(out, columns(ft)...)
+				continue
+			}
+		}
+		if !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		out = append(out, name)
+	}
+	return out
+}
+
+// CSVRecord returns the cells of obj for columns. Values are written as in
+// JSON; strings unquoted, null empty and objects as JSON text.
+func CSVRecord(obj any, columns []string) ([]string, error) {
+	b, err := json.Marshal(obj)
+	if err != nil {
+		return nil, err
+	}
+	values := map[string]json.RawMessage{}
+	if err := json.Unmarshal(b, &values); err != nil {
+		return nil, err
+	}
+	record := make([]string, len(columns))
+	for i, name := range columns {
+		raw := bytes.TrimSpace(values[name])
+		switch {
+		case len(raw) == 0 || string(raw) == "null":
+		case raw[0] == '"':
+			var s string
+			if err := json.Unmarshal(raw, &s); err != nil {
+				return nil, err
+			}
+			record[i] = s
+		default:
+			record[i] = string(raw)
+		}
+	}
+	return record, nil
+}
+
+func writeCSV[T any](w http.ResponseWriter, objects []T) (err error) {
+	cw := csv.NewWriter(w)
+	cols := Columns[T]()
+	if len(cols) == 0 && len(objects) > 0 {
+		if cols, err = jsonKeys(objects[0]); err != nil {
+			return errors.Wrap(err, "write csv")
+		}
+	}
+	if err := cw.Write(cols); err != nil {
+		return errors.Wrap(err, "write csv")
+	}
+	for _, obj := range objects {
+		record, err := CSVRecord(obj, cols)
+		if err != nil {
+			return errors.Wrap(err, "write csv")
+		}
+		if err := cw.Write(record); err != nil {
+			return errors.Wrap(err, "write csv")
+		}
+	}
+	cw.Flush()
+	return errors.Wrap(cw.Error(), "write csv")
+}
+
+// jsonKeys returns the sorted keys of obj as a JSON object, for rows without
+// a struct type.
+func jsonKeys(obj any) ([]string, error) {
+	b, err := json.Marshal(obj)
+	if err != nil {
+		return nil, err
+	}
+	values := map[string]json.RawMessage{}
+	if err := json.Unmarshal(b, &values); err != nil {
+		return nil, err
+	}
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
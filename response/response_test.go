@@ -0,0 +1,97 @@
+package response_test
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/heypkg/store"
+	"github.com/heypkg/store/response"
+)
+
+type item struct {
+	ID     uint   `json:"id"`
+	Name   string `json:"name"`
+	Secret string `json:"-"`
+}
+
+func TestNegotiate(t *testing.T) {
+	tests := []struct {
+		url    string
+		accept string
+		want   string
+	}{
+		{"/items", "", response.MIMEJSON},
+		{"/items", "text/csv", response.MIMECSV},
+		{"/items", "application/x-ndjson;q=0.5, text/csv;q=0.9", response.MIMECSV},
+		{"/items", "text/html, application/jsonl", response.MIMENDJSON},
+		{"/items", "text/csv;q=0", response.MIMEJSON},
+		{"/items", "image/png", response.MIMEJSON},
+		{"/items?format=ndjson", "text/csv", response.MIMENDJSON},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", tt.url, nil)
+		r.Header.Set("Accept", tt.accept)
+		if got := response.Negotiate(r); got != tt.want {
+			t.Errorf("Negotiate(%v, %q) = %v; want %v", tt.url, tt.accept, got, tt.want)
+		}
+	}
+}
+
+func TestWriteList(t *testing.T) {
+	items := []item{{ID: 3, Name: "c", Secret: "s"}, {ID: 4, Name: "d, e"}}
+	req := store.ListRequest{Page: 2, PageSize: 2}
+
+	rec := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/items?q=name:c&page=2&page_size=2", nil)
+	if err := response.WriteList(rec, r, req, items, 5); err != nil {
+		t.Fatal(err)
+	}
+	if got := rec.Header().Get("X-Total-Count"); got != "5" {
+		t.Errorf("X-Total-Count: %v", got)
+	}
+	links := rec.Header().Get("Link")
+	for _, want := range []string{
+		`</items?page=1&page_size=2&q=name%3Ac>; rel="first"`,
+		`</items?page=1&page_size=2&q=name%3Ac>; rel="prev"`,
+		`</items?page=3&page_size=2&q=name%3Ac>; rel="next"`,
+		`</items?page=3&page_size=2&q=name%3Ac>; rel="last"`,
+	} {
+		if !strings.Contains(links, want) {
+			t.Errorf("Link without %v: %v", want, links)
+		}
+	}
+	var envelope response.Envelope[item]
+	if err := json.Unmarshal(rec.Body.Bytes(), &envelope); err != nil {
+		t.Fatal(err)
+	}
+	if envelope.Total != 5 || envelope.Page != 2 || envelope.PageSize != 2 || len(envelope.Data) != 2 {
+		t.Errorf("envelope: %+v", envelope)
+	}
+	if c, err := store.DecodeCursor(envelope.NextCursor); err != nil || c.Page != 3 || c.PageSize != 2 {
+		t.Errorf("next cursor: %+v, %v", c, err)
+	}
+
+	rec = httptest.NewRecorder()
+	r = httptest.NewRequest("GET", "/items?format=csv", nil)
+	if err := response.WriteList(rec, r, store.ListRequest{}, items, 2); err != nil {
+		t.Fatal(err)
+	}
+	if got := rec.Body.String(); got != "id,name\n3,c\n4,\"d, e\"\n" {
+		t.Errorf("csv: %q", got)
+	}
+	if rec.Header().Get("Link") != "" || rec.Header().Get("X-Total-Count") != "2" {
+		t.Errorf("headers of a single page: %v", rec.Header())
+	}
+
+	rec = httptest.NewRecorder()
+	r = httptest.NewRequest("GET", "/items", nil)
+	r.Header.Set("Accept", response.MIMENDJSON)
+	if err := response.WriteList(rec, r, store.ListRequest{}, items, 2); err != nil {
+		t.Fatal(err)
+	}
+	if got := rec.Body.String(); got != "{\"id\":3,\"name\":\"c\"}\n{\"id\":4,\"name\":\"d, e\"}\n" {
+		t.Errorf("ndjson: %q", got)
+	}
+}
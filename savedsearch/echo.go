@@ -0,0 +1,85 @@
+package savedsearch
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+
+	"github.com/heypkg/store"
+	"github.com/heypkg/store/echoadapter"
+	"github.com/labstack/echo/v4"
+	"github.com/spf13/cast"
+)
+
+// Routes serves the saved search API of the user of the requests on g:
+//
+//	GET    /searches?model=&broken=  searches seen by the user
+//	POST   /searches                 save a search
+//	GET    /searches/:id             search
+//	PUT    /searches/:id             update a search of the user
+//	DELETE /searches/:id             delete a search of the user
+func (s *Service) Routes(g *echo.Group) {
+	g.GET("/searches", s.handle(func(c echo.Context, ctx context.Context) (int, any, error) {
+		searches, err := s.Searches(ctx, c.QueryParam("model"), cast.ToBool(c.QueryParam("broken")))
+		return http.StatusOK, searches, err
+	}))
+	g.POST("/searches", s.handle(func(c echo.Context, ctx context.Context) (int, any, error) {
+		search := &Search{}
+		if err := c.Bind(search); err != nil {
+			return 0, nil, err
+		}
+		return http.StatusCreated, search, s.Save(ctx, search)
+	}))
+	g.GET("/searches/:id", s.handle(func(c echo.Context, ctx context.Context) (int, any, error) {
+		id, err := paramID(c)
+		if err != nil {
+			return 0, nil, err
+		}
+		search, err := s.Search(ctx, id)
+		return http.StatusOK, search, err
+	}))
+	g.PUT("/searches/:id", s.handle(func(c echo.Context, ctx context.Context) (int, any, error) {
+		id, err := paramID(c)
+		if err != nil {
+			return 0, nil, err
+		}
+		search := &Search{}
+		if err := c.Bind(search); err != nil {
+			return 0, nil, err
+		}
+		search, err = s.Update(ctx, id, search)
+		return http.StatusOK, search, err
+	}))
+	g.DELETE("/searches/:id", s.handle(func(c echo.Context, ctx context.Context) (int, any, error) {
+		id, err := paramID(c)
+		if err != nil {
+			return 0, nil, err
+		}
+		return http.StatusNoContent, nil, s.Delete(ctx, id)
+	}))
+}
+
+func (s *Service) handle(fn func(c echo.Context, ctx context.Context) (int, any, error)) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		ctx, err := echoadapter.Context(c)
+		if err != nil {
+			return echoadapter.HTTPError(err)
+		}
+		status, body, err := fn(c, ctx)
+		if err != nil {
+			return echoadapter.HTTPError(err)
+		}
+		if body == nil {
+			return c.NoContent(status)
+		}
+		return c.JSON(status, body)
+	}
+}
+
+func paramID(c echo.Context) (uint, error) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		return 0, store.NewError(store.ErrInvalidQuery, "invalid id", err)
+	}
+	return uint(id), nil
+}
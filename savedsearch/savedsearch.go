@@ -0,0 +1,408 @@
+// Package savedsearch stores the searches users name and share, such as
+// "offline devices in EU". A saved search is applied to the lists of its
+// model with the view query parameter, the terms of the request refining
+// the saved ones. Saved searches are checked against the fields of their
+// model whenever they are loaded, and the ones that no longer match, after
+// a column is renamed or dropped, are flagged as broken.
+package savedsearch
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/heypkg/store"
+	"github.com/heypkg/store/audit"
+	"github.com/heypkg/store/jsontype"
+	"github.com/heypkg/store/outbox"
+	"github.com/heypkg/store/search"
+	"github.com/heypkg/store/tenancy"
+	"github.com/heypkg/store/utils"
+	"github.com/pkg/errors"
+	"github.com/spf13/cast"
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+// PluginName is the name of services registered with gorm.
+const PluginName = "store:savedsearch"
+
+type Visibility string
+
+const (
+	// VisibilityPrivate searches are seen by their owner only.
+	VisibilityPrivate Visibility = "private"
+	// VisibilityShared searches are seen by the users of their tenant.
+	VisibilityShared Visibility = "shared"
+)
+
+// Search is a named search of the objects of a model. Query, OrderBy and
+// Fields are the q, order_by and field selection of the lists it is applied
+// to. Broken is set, with the reason in Problem, when it no longer matches
+// the fields of its model.
+type Search struct {
+	ID         uint                       `gorm:"primarykey" json:"ID"`
+	Schema     string                     `gorm:"index" json:"Schema"`
+	Model      string                     `gorm:"index" json:"Model"`
+	Name       string                     `json:"Name"`
+	Owner      string                     `gorm:"index" json:"Owner"`
+	Visibility Visibility                 `json:"Visibility"`
+	Query      string                     `json:"Query"`
+	OrderBy    string                     `json:"OrderBy"`
+	Fields     jsontype.JSONSlice[string] `json:"Fields"`
+	Broken     bool                       `json:"Broken"`
+	Problem    string                     `json:"Problem"`
+	CheckedAt  *time.Time                 `json:"CheckedAt"`
+	CreatedAt  time.Time                  `json:"CreatedAt"`
+	UpdatedAt  time.Time                  `json:"UpdatedAt"`
+}
+
+func (Search) TableName() string {
+	return "saved_searches"
+}
+
+// model is the field schema of a registered model: its columns and the
+// terms handled by search handlers.
+type model struct {
+	columns map[string]bool
+	terms   map[string]bool
+}
+
+// Service stores the saved searches. Models are registered with Register
+// before searches of them are saved.
+type Service struct {
+	DB *gorm.DB
+
+	mu     sync.RWMutex
+	models map[string]model
+}
+
+var _ gorm.Plugin = (*Service)(nil)
+
+// New migrates the table of the saved searches in db and registers their
+// service with db, so that the lists of the gorm stores of db accept views.
+func New(db *gorm.DB) (*Service, error) {
+	if err := db.AutoMigrate(&Search{}); err != nil {
+		return nil, errors.Wrap(err, "migrate saved searches")
+	}
+	s := &Service{DB: db, models: map[string]model{}}
+	if err := db.Use(s); err != nil {
+		return nil, errors.Wrap(err, "register saved searches")
+	}
+	return s, nil
+}
+
+// FromDB returns the service registered with db, nil when there is none.
+func FromDB(db *gorm.DB) *Service {
+	if p, ok := db.Config.Plugins[PluginName]; ok {
+		return p.(*Service)
+	}
+	return nil
+}
+
+func (s *Service) Name() string {
+	return PluginName
+}
+
+func (s *Service) Initialize(db *gorm.DB) error {
+	return nil
+}
+
+// Model returns the model name of T in saved searches.
+func Model[T any]() string {
+	var obj T
+	return utils.GetRawTypeName(obj)
+}
+
+// Register lets the users save searches of model T. Terms are the search
+// terms that are not columns, handled by the search handlers of the lists.
+func Register[T any](s *Service, terms ...string) error {
+	var obj T
+	sch, err := schema.Parse(&obj, &sync.Map{}, s.DB.NamingStrategy)
+	if err != nil {
+		return errors.Wrap(err, "parse model")
+	}
+	m := model{columns: map[string]bool{}, terms: map[string]bool{}}
+	for _, field := range sch.Fields {
+		if field.DBName != "" {
+			m.columns[field.DBName] = true
+		}
+	}
+	for _, term := range terms {
+		m.terms[term] = true
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.models[Model[T]()] = m
+	return nil
+}
+
+func (s *Service) model(name string) (model, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	m, ok := s.models[name]
+	return m, ok
+}
+
+// db returns the db of the saved searches, whose writes are neither audited
+// nor recorded in the outbox.
+func (s *Service) db(ctx context.Context) *gorm.DB {
+	return audit.Skip(outbox.Skip(s.DB.WithContext(ctx))).Session(&gorm.Session{})
+}
+
+func tenantOf(ctx context.Context) string {
+	return tenancy.ScopeFromContext(ctx).Tenant
+}
+
+// visible returns the searches of the tenant of ctx its user sees: the
+// shared ones and their own.
+func (s *Service) visible(ctx context.Context) *gorm.DB {
+	return s.db(ctx).Where(map[string]any{"schema": tenantOf(ctx)}).
+		Where(s.DB.Where("visibility = ?", VisibilityShared).Or("owner = ?", audit.ActorFromContext(ctx)))
+}
+
+// validate checks saved against the fields of its model.
+func (s *Service) validate(saved *Search) error {
+	m, ok := s.model(saved.Model)
+	if !ok {
+		return errors.Errorf("unknown model %v", saved.Model)
+	}
+	data, err := search.ParseSearchString(saved.Query)
+	if err != nil {
+		return err
+	}
+	for name, values := range data {
+		if len(values) == 0 || m.terms[name] {
+			continue
+		}
+		if !m.columns[name] {
+			return errors.Errorf("unknown field %v", name)
+		}
+	}
+	for _, order := range search.ParseOrderByString(saved.OrderBy) {
+		if !m.columns[order.Name] {
+			return errors.Errorf("unknown order field %v", order.Name)
+		}
+	}
+	for _, field := range saved.Fields {
+		if !m.columns[field] {
+			return errors.Errorf("unknown selected field %v", field)
+		}
+	}
+	return nil
+}
+
+// check validates search and saves its broken flag when it changed.
+func (s *Service) check(ctx context.Context, search *Search) error {
+	problem := ""
+	if err := s.validate(search); err != nil {
+		problem = err.Error()
+	}
+	now := time.Now()
+	if search.Broken == (problem != "") && search.Problem == problem && search.CheckedAt != nil {
+		return nil
+	}
+	search.Broken, search.Problem, search.CheckedAt = problem != "", problem, &now
+	return store.WrapError(s.db(ctx).Model(search).UpdateColumns(map[string]any{
+		"broken":     search.Broken,
+		"problem":    search.Problem,
+		"checked_at": search.CheckedAt,
+	}).Error)
+}
+
+// Save adds search for the user and the tenant of ctx. It must match the
+// fields of its model.
+func (s *Service) Save(ctx context.Context, search *Search) error {
+	if err := s.checkInput(search); err != nil {
+		return err
+	}
+	now := time.Now()
+	search.ID = 0
+	search.Schema = tenantOf(ctx)
+	search.Owner = audit.ActorFromContext(ctx)
+	search.Broken, search.Problem, search.CheckedAt = false, "", &now
+	return store.WrapError(s.db(ctx).Create(search).Error)
+}
+
+// Update replaces the name, the visibility, the query, the order and the
+// fields of the search id of the user of ctx with those of search.
+func (s *Service) Update(ctx context.Context, id uint, search *Search) (*Search, error) {
+	old, err := s.owned(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	search.Model = old.Model
+	if err := s.checkInput(search); err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	old.Name, old.Visibility = search.Name, search.Visibility
+	old.Query, old.OrderBy, old.Fields = search.Query, search.OrderBy, search.Fields
+	old.Broken, old.Problem, old.CheckedAt = false, "", &now
+	if err := s.db(ctx).Select("*").Omit("created_at").Updates(old).Error; err != nil {
+		return nil, store.WrapError(err)
+	}
+	return old, nil
+}
+
+// Delete deletes the search id of the user of ctx.
+func (s *Service) Delete(ctx context.Context, id uint) error {
+	search, err := s.owned(ctx, id)
+	if err != nil {
+		return err
+	}
+	return store.WrapError(s.db(ctx).Delete(search).Error)
+}
+
+func (s *Service) checkInput(search *Search) error {
+	if search.Name == "" {
+		return store.NewError(store.ErrInvalidQuery, "missing saved search name", nil)
+	}
+	switch search.Visibility {
+	case "":
+		search.Visibility = VisibilityPrivate
+	case VisibilityPrivate, VisibilityShared:
+	default:
+		return store.NewError(store.ErrInvalidQuery, "invalid saved search visibility "+string(search.Visibility), nil)
+	}
+	if err := s.validate(search); err != nil {
+		return store.InvalidQuery(err)
+	}
+	return nil
+}
+
+// owned returns the search id of the user of ctx.
+func (s *Service) owned(ctx context.Context, id uint) (*Search, error) {
+	search, err := s.load(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if search.Owner != audit.ActorFromContext(ctx) {
+		return nil, store.NewError(store.ErrForbidden, "saved search of another user", nil)
+	}
+	return search, nil
+}
+
+func (s *Service) load(ctx context.Context, id uint) (*Search, error) {
+	var search Search
+	if err := s.visible(ctx).Where("id = ?", id).Take(&search).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, store.NotFound("saved search not found")
+		}
+		return nil, store.WrapError(err)
+	}
+	return &search, nil
+}
+
+// Search returns the search id seen by the user of ctx, checked against the
+// fields of its model.
+func (s *Service) Search(ctx context.Context, id uint) (*Search, error) {
+	search, err := s.load(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.check(ctx, search); err != nil {
+		return nil, err
+	}
+	return search, nil
+}
+
+// Searches returns the searches of model seen by the user of ctx, of all
+// models when it is empty, checked against the fields of their model. Only
+// the broken ones are returned when broken is true.
+func (s *Service) Searches(ctx context.Context, model string, broken bool) ([]Search, error) {
+	tx := s.visible(ctx)
+	if model != "" {
+		tx = tx.Where("model = ?", model)
+	}
+	var searches []Search
+	if err := tx.Order("name").Order("id").Find(&searches).Error; err != nil {
+		return nil, store.WrapError(err)
+	}
+	out := []Search{}
+	for i := range searches {
+		if err := s.check(ctx, &searches[i]); err != nil {
+			return nil, err
+		}
+		if !broken || searches[i].Broken {
+			out = append(out, searches[i])
+		}
+	}
+	return out, nil
+}
+
+// Check checks the searches of all tenants against the fields of their
+// model, for example after a migration, and returns the broken ones.
+func (s *Service) Check(ctx context.Context) ([]Search, error) {
+	broken := []Search{}
+	var searches []Search
+	err := s.db(ctx).Order("id").FindInBatches(&searches, 100, func(tx *gorm.DB, batch int) error {
+		for i := range searches {
+			if err := s.check(ctx, &searches[i]); err != nil {
+				return err
+			}
+			if searches[i].Broken {
+				broken = append(broken, searches[i])
+			}
+		}
+		return nil
+	}).Error
+	if err != nil {
+		return nil, store.WrapError(err)
+	}
+	return broken, nil
+}
+
+// Apply returns req extended with the search of its View, a search of
+// model. The terms of req replace the saved terms on the same columns, its
+// order replaces the saved one, and the saved fields narrow its selection.
+// Broken searches are rejected.
+func (s *Service) Apply(ctx context.Context, model string, req store.ListRequest) (store.ListRequest, error) {
+	id := cast.ToUint(req.View)
+	if id == 0 {
+		return req, store.NewError(store.ErrInvalidQuery, "invalid view "+req.View, nil)
+	}
+	saved, err := s.Search(ctx, id)
+	if err != nil {
+		return req, err
+	}
+	if saved.Model != model {
+		return req, store.NewError(store.ErrInvalidQuery, fmt.Sprintf("view %v is not a search of %v", id, model), nil)
+	}
+	if saved.Broken {
+		return req, store.NewError(store.ErrInvalidQuery, fmt.Sprintf("view %v is broken: %v", id, saved.Problem), nil)
+	}
+	req.View = ""
+	req.Search = search.MergeSearchStrings(saved.Query, req.Search)
+	if req.OrderBy == "" {
+		req.OrderBy = saved.OrderBy
+	}
+	req.Select = selectFields(req.Select, saved.Fields)
+	return req, nil
+}
+
+// selectFields returns the fields of a saved search selected by the server,
+// all of them when the server selects none.
+func selectFields(selected []string, fields []string) []string {
+	if len(fields) == 0 {
+		return selected
+	}
+	if len(selected) == 0 {
+		return fields
+	}
+	allowed := map[string]bool{}
+	for _, name := range selected {
+		allowed[name] = true
+	}
+	out := []string{}
+	for _, name := range fields {
+		if allowed[name] {
+			out = append(out, name)
+		}
+	}
+	if len(out) == 0 {
+		return selected
+	}
+	return out
+}
@@ -0,0 +1,182 @@
+package savedsearch_test
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/glebarez/sqlite"
+	"github.com/heypkg/store"
+	"github.com/heypkg/store/audit"
+	"github.com/heypkg/store/savedsearch"
+	"github.com/heypkg/store/storetest"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func newService(t *testing.T) *savedsearch.Service {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(t.TempDir()+"/savedsearch.db"), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := savedsearch.New(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func userContext(user string) context.Context {
+	return audit.WithActor(storetest.Context("acme"), user)
+}
+
+func TestApply(t *testing.T) {
+	s := newService(t)
+	if err := savedsearch.Register[storetest.Device](s, "site"); err != nil {
+		t.Fatal(err)
+	}
+	ctx := userContext("alice")
+	model := savedsearch.Model[storetest.Device]()
+	saved := &savedsearch.Search{
+		Model:   model,
+		Name:    "eu sensors",
+		Query:   "kind:sensor site:eu",
+		OrderBy: "name",
+		Fields:  []string{"id", "name", "kind"},
+	}
+	if err := s.Save(ctx, saved); err != nil {
+		t.Fatal(err)
+	}
+	view := strconv.FormatUint(uint64(saved.ID), 10)
+
+	tests := []struct {
+		name string
+		req  store.ListRequest
+		want store.ListRequest
+	}{
+		{
+			"saved only",
+			store.ListRequest{View: view},
+			store.ListRequest{Search: "kind:sensor site:eu", OrderBy: "name", Select: []string{"id", "name", "kind"}},
+		},
+		{
+			"request terms replace saved terms of their column",
+			store.ListRequest{View: view, Search: "site:us value:>1"},
+			store.ListRequest{Search: "kind:sensor site:us value:>1", OrderBy: "name", Select: []string{"id", "name", "kind"}},
+		},
+		{
+			"request order replaces saved order",
+			store.ListRequest{View: view, OrderBy: "-id"},
+			store.ListRequest{Search: "kind:sensor site:eu", OrderBy: "-id", Select: []string{"id", "name", "kind"}},
+		},
+		{
+			"saved fields narrow the selection",
+			store.ListRequest{View: view, Select: []string{"name", "value"}},
+			store.ListRequest{Search: "kind:sensor site:eu", OrderBy: "name", Select: []string{"name"}},
+		},
+		{
+			"disjoint selection is kept",
+			store.ListRequest{View: view, Select: []string{"value"}},
+			store.ListRequest{Search: "kind:sensor site:eu", OrderBy: "name", Select: []string{"value"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := s.Apply(ctx, model, tt.req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Apply = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+
+	for _, tt := range []struct {
+		name  string
+		ctx   context.Context
+		model string
+		view  string
+		want  error
+	}{
+		{"invalid view", ctx, model, "x", store.ErrInvalidQuery},
+		{"other model", ctx, "other.Model", view, store.ErrInvalidQuery},
+		{"private search of another user", userContext("bob"), model, view, store.ErrNotFound},
+		{"other tenant", audit.WithActor(storetest.Context("other"), "alice"), model, view, store.ErrNotFound},
+	} {
+		if _, err := s.Apply(tt.ctx, tt.model, store.ListRequest{View: tt.view}); !errors.Is(err, tt.want) {
+			t.Errorf("%v: Apply = %v, want %v", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestBroken(t *testing.T) {
+	s := newService(t)
+	ctx := userContext("alice")
+	var id uint
+	var model string
+	{
+		type Device struct {
+			ID   uint
+			Name string
+			Kind string
+		}
+		if err := savedsearch.Register[Device](s); err != nil {
+			t.Fatal(err)
+		}
+		model = savedsearch.Model[Device]()
+		saved := &savedsearch.Search{Model: model, Name: "sensors", Query: "kind:sensor", Visibility: savedsearch.VisibilityShared}
+		if err := s.Save(ctx, saved); err != nil {
+			t.Fatal(err)
+		}
+		id = saved.ID
+	}
+	view := strconv.FormatUint(uint64(id), 10)
+	if _, err := s.Apply(ctx, model, store.ListRequest{View: view}); err != nil {
+		t.Fatal(err)
+	}
+
+	// kind is dropped from the model
+	type Device struct {
+		ID   uint
+		Name string
+	}
+	if savedsearch.Model[Device]() != model {
+		t.Fatalf("model %v, want %v", savedsearch.Model[Device](), model)
+	}
+	if err := savedsearch.Register[Device](s); err != nil {
+		t.Fatal(err)
+	}
+
+	broken, err := s.Check(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(broken) != 1 || broken[0].ID != id || !broken[0].Broken || broken[0].Problem != "unknown field kind" {
+		t.Fatalf("Check = %+v", broken)
+	}
+	bob := userContext("bob")
+	searches, err := s.Searches(bob, model, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(searches) != 1 || !searches[0].Broken {
+		t.Errorf("broken searches = %+v", searches)
+	}
+	_, err = s.Apply(bob, model, store.ListRequest{View: view})
+	if !errors.Is(err, store.ErrInvalidQuery) || !strings.Contains(err.Error(), "broken: unknown field kind") {
+		t.Errorf("Apply of broken search = %v", err)
+	}
+
+	// updating the query repairs the search
+	if _, err := s.Update(ctx, id, &savedsearch.Search{Name: "named", Query: "name:x"}); err != nil {
+		t.Fatal(err)
+	}
+	if searches, err := s.Searches(bob, model, true); err != nil || len(searches) != 0 {
+		t.Errorf("broken searches after update = %+v, %v", searches, err)
+	}
+}
@@ -0,0 +1,166 @@
+package search
+
+import "strings"
+
+// PartKind is the part of a search term being typed.
+type PartKind string
+
+const (
+	// PartField is the column of a term, before the colon.
+	PartField PartKind = "field"
+	// PartKey is the key of a JSON column, after the dot of column.key.
+	PartKey PartKind = "key"
+	// PartOperator is an operator being typed before a value, such as >.
+	PartOperator PartKind = "operator"
+	// PartValue is a value, after the colon, an operator or a comma.
+	PartValue PartKind = "value"
+)
+
+// Partial is the term of a search string being typed at a cursor.
+type Partial struct {
+	Kind PartKind
+	// Field is the column of the term, with the key of a JSON column in Key.
+	Field string
+	Key   string
+	// Operator is the operator typed before the value.
+	Operator SearchSymbol
+	// Prefix is the text of the part before the cursor, without quotes.
+	Prefix string
+	// Start and End are the byte offsets of the part in the search string,
+	// replaced by a completion.
+	Start int
+	End   int
+	// Rest is the search string without the term, to scope completions.
+	Rest string
+}
+
+var operators = []string{">=", "<=", "!=", ">", "<"}
+
+// ParsePartial returns the part of the term of text at cursor, a byte
+// offset clamped to text. The term is read up to the cursor only, so text
+// may be incomplete or invalid there.
+func ParsePartial(text string, cursor int) Partial {
+	if cursor < 0 {
+		cursor = 0
+	}
+	if cursor > len(text) {
+		cursor = len(text)
+	}
+	start, end := termBounds(text, cursor)
+	p := Partial{Rest: strings.TrimSpace(text[:start] + " " + text[end:])}
+	term := text[start:cursor]
+	colon := unquotedIndex(term, ':')
+	if colon < 0 {
+		name := text[start:partEnd(text, start, end, ':')]
+		if dot := strings.Index(term, "."); dot >= 0 {
+			p.Kind, p.Field, p.Prefix = PartKey, term[:dot], term[dot+1:]
+			p.Start, p.End = start+dot+1, start+len(name)
+			return p
+		}
+		p.Kind, p.Prefix, p.Start, p.End = PartField, term, start, start+len(name)
+		return p
+	}
+	p.Field = term[:colon]
+	if column, key, ok := strings.Cut(p.Field, "."); ok {
+		p.Field, p.Key = column, key
+	}
+	// the value at the cursor follows the last comma of the term
+	valueStart := start + colon + 1
+	if comma := lastUnquotedIndex(term[colon+1:], ','); comma >= 0 {
+		valueStart += comma + 1
+	}
+	value := text[valueStart:cursor]
+	p.Kind = PartValue
+	if strings.Trim(value, "<>=!") == "" && value != "" {
+		p.Kind, p.Prefix, p.Start, p.End = PartOperator, value, valueStart, cursor
+		return p
+	}
+	for _, op := range operators {
+		if strings.HasPrefix(value, op) {
+			p.Operator = SearchSymbol(op)
+			valueStart += len(op)
+			value = value[len(op):]
+			break
+		}
+	}
+	p.Prefix = strings.TrimLeft(value, `'"`)
+	p.Start, p.End = valueStart, partEnd(text, valueStart, end, ',')
+	return p
+}
+
+// termBounds returns the bounds of the term of text at cursor, separated by
+// spaces outside of quotes.
+func termBounds(text string, cursor int) (int, int) {
+	start, quote := 0, byte(0)
+	for i := 0; i < cursor; i++ {
+		switch c := text[i]; {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case c == ' ':
+			start = i + 1
+		}
+	}
+	end := cursor
+	for ; end < len(text); end++ {
+		c := text[end]
+		if quote != 0 {
+			if c == quote {
+				quote = 0
+			}
+			continue
+		}
+		if c == '\'' || c == '"' {
+			quote = c
+		} else if c == ' ' {
+			break
+		}
+	}
+	return start, end
+}
+
+// partEnd returns the end of the part of a term starting at start: the
+// first sep outside of quotes, or the end of the term.
+func partEnd(text string, start int, end int, sep byte) int {
+	if i := unquotedIndex(text[start:end], sep); i >= 0 {
+		return start + i
+	}
+	return end
+}
+
+func unquotedIndex(s string, sep byte) int {
+	quote := byte(0)
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case c == sep:
+			return i
+		}
+	}
+	return -1
+}
+
+func lastUnquotedIndex(s string, sep byte) int {
+	last, quote := -1, byte(0)
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case c == sep:
+			last = i
+		}
+	}
+	return last
+}
@@ -0,0 +1,27 @@
+package search
+
+import "testing"
+
+func TestParsePartial(t *testing.T) {
+	tests := []struct {
+		text   string
+		cursor int
+		want   Partial
+	}{
+		{"", 0, Partial{Kind: PartField}},
+		{"kind:x na", 9, Partial{Kind: PartField, Prefix: "na", Start: 7, End: 9, Rest: "kind:x"}},
+		{"na kind:x", 1, Partial{Kind: PartField, Prefix: "n", Start: 0, End: 2, Rest: "kind:x"}},
+		{"tags.re", 7, Partial{Kind: PartKey, Field: "tags", Prefix: "re", Start: 5, End: 7}},
+		{"tags.region:e", 13, Partial{Kind: PartValue, Field: "tags", Key: "region", Prefix: "e", Start: 12, End: 13}},
+		{"value:>", 7, Partial{Kind: PartOperator, Field: "value", Prefix: ">", Start: 6, End: 7}},
+		{"value:>=3", 9, Partial{Kind: PartValue, Field: "value", Operator: ">=", Prefix: "3", Start: 8, End: 9}},
+		{"value:1,<", 9, Partial{Kind: PartOperator, Field: "value", Prefix: "<", Start: 8, End: 9}},
+		{"name:'a b", 9, Partial{Kind: PartValue, Field: "name", Prefix: "a b", Start: 5, End: 9}},
+		{"name:ab", 100, Partial{Kind: PartValue, Field: "name", Prefix: "ab", Start: 5, End: 7}},
+	}
+	for _, tt := range tests {
+		if got := ParsePartial(tt.text, tt.cursor); got != tt.want {
+			t.Errorf("ParsePartial(%q, %v) = %+v, want %+v", tt.text, tt.cursor, got, tt.want)
+		}
+	}
+}
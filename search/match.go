@@ -0,0 +1,345 @@
+package search
+
+import (
+	"bytes"
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cast"
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+var ErrNotEvaluable = errors.New("search condition cannot be evaluated in memory")
+
+var schemaCache = &sync.Map{}
+
+// ObjectValues returns the column values of obj keyed by column name, the
+// names used in search strings. obj is a gorm model, a pointer to one, or a
+// map[string]any such as the records returned by ListAnyObjects.
+func ObjectValues(obj any) (map[string]any, error) {
+	if m, ok := obj.(map[string]any); ok {
+		return m, nil
+	}
+	rv := reflect.Indirect(reflect.ValueOf(obj))
+	if rv.Kind() != reflect.Struct {
+		return nil, errors.Errorf("unsupported object type %T", obj)
+	}
+	s, err := schema.Parse(rv.Addr().Interface(), schemaCache, schema.NamingStrategy{})
+	if err != nil {
+		return nil, errors.Wrap(err, "parse model")
+	}
+	values := map[string]any{}
+	for _, field := range s.Fields {
+		if field.DBName == "" {
+			continue
+		}
+		values[field.DBName] = field.ReflectValueOf(context.Background(), rv).Interface()
+	}
+	return values, nil
+}
+
+// Match reports whether obj satisfies the search, with the same semantics as
+// SearchDB and WhereString: terms are combined with AND, the values of a term
+// with OR. Terms handled by handleFuncs cannot be evaluated in memory.
+func (m SearchData) Match(obj any, handleFuncs map[string]SearchDataHandleFunc) (bool, error) {
+	values, err := ObjectValues(obj)
+	if err != nil {
+		return false, err
+	}
+	return m.matchValues(values, handleFuncs)
+}
+
+func (m SearchData) matchValues(values map[string]any, handleFuncs map[string]SearchDataHandleFunc) (bool, error) {
+	for k, vals := range m {
+		if handleFuncs != nil {
+			if f, ok := handleFuncs[k]; ok && f != nil {
+				return false, errors.Wrap(ErrNotEvaluable, k)
+			}
+		}
+		v := normalizeMatchValue(lookupValue(values, k))
+		matched := false
+		conditions := 0
+		for _, val := range vals {
+			if val.Symbol == SearchSymbolNone {
+				continue
+			}
+			conditions++
+			if val.Symbol == SearchSymbolSearch {
+				sub, ok := val.Value.(SearchData)
+				if !ok {
+					continue
+				}
+				subValues, _ := v.(map[string]any)
+				if subValues == nil {
+					subValues = map[string]any{}
+				}
+				ok, err := sub.matchText(subValues)
+				if err != nil {
+					return false, err
+				}
+				if ok {
+					matched = true
+				}
+				continue
+			}
+			if matchValue(v, val) {
+				matched = true
+			}
+		}
+		if conditions > 0 && !matched {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// matchText matches the keys of a JSON column. Like the ->> operator used by
+// SearchDB, both sides are compared as text.
+func (m SearchData) matchText(values map[string]any) (bool, error) {
+	texts := map[string]any{}
+	for k, v := range values {
+		if v != nil {
+			texts[k] = textOf(v)
+		}
+	}
+	sub := SearchData{}
+	for name, vals := range m {
+		vals2 := make([]SearchValue, 0, len(vals))
+		for _, val := range vals {
+			val2 := SearchValue{Symbol: val.Symbol}
+			if val.Value != nil {
+				val2.Value = fmt.Sprintf("%v", val.Value)
+			}
+			if val.Value2 != nil {
+				val2.Value2 = fmt.Sprintf("%v", val.Value2)
+			}
+			vals2 = append(vals2, val2)
+		}
+		sub[name] = vals2
+	}
+	return sub.matchValues(texts, nil)
+}
+
+func lookupValue(values map[string]any, name string) any {
+	if v, ok := values[name]; ok {
+		return v
+	}
+	for k, v := range values {
+		if strings.EqualFold(k, name) {
+			return v
+		}
+	}
+	return nil
+}
+
+func textOf(v any) string {
+	switch vv := v.(type) {
+	case string:
+		return vv
+	case map[string]any, []any:
+		data, _ := json.Marshal(vv)
+		return string(data)
+	}
+	return fmt.Sprintf("%v", v)
+}
+
+func normalizeMatchValue(v any) any {
+	switch vv := v.(type) {
+	case nil:
+		return nil
+	case time.Time:
+		return vv
+	case gorm.DeletedAt:
+		if !vv.Valid {
+			return nil
+		}
+		return vv.Time
+	case string, bool, int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64:
+		return vv
+	case []byte:
+		return decodeJSON(vv)
+	case driver.Valuer:
+		dv, err := vv.Value()
+		if err != nil {
+			return nil
+		}
+		return normalizeMatchValue(dv)
+	}
+	rv := reflect.ValueOf(v)
+	if rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			return nil
+		}
+		return normalizeMatchValue(rv.Elem().Interface())
+	}
+	if rv.Type().ConvertibleTo(reflect.TypeOf(time.Time{})) {
+		return rv.Convert(reflect.TypeOf(time.Time{})).Interface()
+	}
+	switch rv.Kind() {
+	case reflect.String:
+		return rv.String()
+	case reflect.Bool:
+		return rv.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return rv.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return rv.Uint()
+	case reflect.Float32, reflect.Float64:
+		return rv.Float()
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprintf("%v", v)
+	}
+	return decodeJSON(data)
+}
+
+func decodeJSON(data []byte) any {
+	var out any
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&out); err != nil {
+		return string(data)
+	}
+	return out
+}
+
+func matchValue(v any, val SearchValue) bool {
+	switch val.Symbol {
+	case SearchSymbolRange:
+		if v == nil {
+			return false
+		}
+		if val.Value != nil {
+			if c, ok := compareValues(v, val.Value); !ok || c < 0 {
+				return false
+			}
+		}
+		if val.Value2 != nil {
+			if c, ok := compareValues(v, val.Value2); !ok || c > 0 {
+				return false
+			}
+		}
+		return true
+	case SearchSymbolEq:
+		c, ok := compareValues(v, val.Value)
+		return ok && c == 0
+	case SearchSymbolNot:
+		c, ok := compareValues(v, val.Value)
+		return ok && c != 0
+	case SearchSymbolGt:
+		c, ok := compareValues(v, val.Value)
+		return ok && c > 0
+	case SearchSymbolGte:
+		c, ok := compareValues(v, val.Value)
+		return ok && c >= 0
+	case SearchSymbolLt:
+		c, ok := compareValues(v, val.Value)
+		return ok && c < 0
+	case SearchSymbolLte:
+		c, ok := compareValues(v, val.Value)
+		return ok && c <= 0
+	}
+	return false
+}
+
+// compareValues compares an object value with a search value. Like SQL, a
+// comparison with NULL never matches.
+func compareValues(a any, b any) (int, bool) {
+	if a == nil || b == nil {
+		return 0, false
+	}
+	if ta, ok := a.(time.Time); ok {
+		tb, err := cast.ToTimeE(b)
+		if text, ok := b.(string); ok {
+			if t, ok := ParseRelativeTime(text); ok {
+				tb, err = t, nil
+			}
+		}
+		if err != nil {
+			return 0, false
+		}
+		switch {
+		case ta.Before(tb):
+			return -1, true
+		case ta.After(tb):
+			return 1, true
+		}
+		return 0, true
+	}
+	if isNumber(a) || isNumber(b) {
+		fa, errA := cast.ToFloat64E(numberOf(a))
+		fb, errB := cast.ToFloat64E(numberOf(b))
+		if errA == nil && errB == nil {
+			switch {
+			case fa < fb:
+				return -1, true
+			case fa > fb:
+				return 1, true
+			}
+			return 0, true
+		}
+	}
+	if ba, ok := a.(bool); ok {
+		bb, err := cast.ToBoolE(b)
+		if err != nil {
+			return 0, false
+		}
+		if ba == bb {
+			return 0, true
+		}
+		if !ba {
+			return -1, true
+		}
+		return 1, true
+	}
+	return strings.Compare(textOf(a), textOf(b)), true
+}
+
+func isNumber(v any) bool {
+	switch v.(type) {
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64, json.Number:
+		return true
+	}
+	return false
+}
+
+func numberOf(v any) any {
+	if n, ok := v.(json.Number); ok {
+		return n.String()
+	}
+	return v
+}
+
+// Compare orders two column values like ORDER BY: NULL sorts after every
+// other value and values that cannot be compared are equal.
+func Compare(a any, b any) int {
+	a = normalizeMatchValue(a)
+	b = normalizeMatchValue(b)
+	switch {
+	case a == nil && b == nil:
+		return 0
+	case a == nil:
+		return 1
+	case b == nil:
+		return -1
+	}
+	if c, ok := compareValues(a, b); ok {
+		return c
+	}
+	return 0
+}
+
+// NormalizeValue converts a column value to the plain value used to match
+// it: nil, a bool, number, string, time.Time, or decoded JSON.
+func NormalizeValue(v any) any {
+	return normalizeMatchValue(v)
+}
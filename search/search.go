@@ -3,15 +3,18 @@ package search
 import (
 	"fmt"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/pkg/errors"
 	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
 )
 
 var ErrInvalidSearchSyntax = errors.New("invalid search syntax")
+var ErrNoValidSearchConditions = errors.New("no valid search conditions")
 
 type SearchSymbol string
 
@@ -178,6 +181,51 @@ func splitSearchString(text string) []string {
 	return parts
 }
 
+// NormalizeSearchString returns text with its terms sorted and separated by
+// single spaces. Searches that differ only in the order of their terms
+// normalize to the same text.
+func NormalizeSearchString(text string) string {
+	parts := []string{}
+	for _, part := range splitSearchString(text) {
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, " ")
+}
+
+// MergeSearchStrings returns the terms of base and extra. The terms of extra
+// replace the terms of base on the same column.
+func MergeSearchStrings(base string, extra string) string {
+	extraParts := splitSearchString(extra)
+	names := map[string]bool{}
+	for _, part := range extraParts {
+		names[termName(part)] = true
+	}
+	parts := []string{}
+	for _, part := range splitSearchString(base) {
+		if part != "" && !names[termName(part)] {
+			parts = append(parts, part)
+		}
+	}
+	for _, part := range extraParts {
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// termName returns the column of a term of a search string, or the term
+// itself when it has none.
+func termName(part string) string {
+	if i := strings.Index(part, ":"); i > 0 && !strings.ContainsAny(part[:i], `'"`) {
+		return part[:i]
+	}
+	return part
+}
+
 func ParseSearchString2(text string) (SearchData, error) {
 	pattern1 := "^((?:[$]{0,1})[A-Za-z0-9_\\-\\p{Han}\\.]+)$"
 	re1 := regexp.MustCompile(pattern1)
@@ -363,6 +411,93 @@ func ParseSearchString2(text string) (SearchData, error) {
 	return search, nil
 }
 
+var relativeTimeRe = regexp.MustCompile(`^now(?:([+-])(\d+)([smhdw]))?$`)
+
+// ParseRelativeTime parses a time relative to now: now, now-1h or now+30m,
+// with the units s, m, h, d and w.
+func ParseRelativeTime(text string) (time.Time, bool) {
+	subs := relativeTimeRe.FindStringSubmatch(text)
+	if len(subs) != 4 {
+		return time.Time{}, false
+	}
+	now := time.Now()
+	if subs[1] == "" {
+		return now, true
+	}
+	n, err := strconv.ParseInt(subs[2], 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	d := time.Duration(n)
+	switch subs[3] {
+	case "s":
+		d = d * time.Second
+	case "m":
+		d = d * time.Minute
+	case "h":
+		d = d * time.Hour
+	case "d":
+		d = d * 24 * time.Hour
+	case "w":
+		d = d * 7 * 24 * time.Hour
+	}
+	if subs[1] == "-" {
+		d = -d
+	}
+	return now.Add(d), true
+}
+
+// ResolveTimes returns m with the values of the fields isTime reports written
+// relative to now, such as created_at:>now-1h, replaced by their time. The
+// parser keeps them as text, so that status:now is compared with a string.
+func (m SearchData) ResolveTimes(isTime func(name string) bool) SearchData {
+	if isTime == nil {
+		return m
+	}
+	out := SearchData{}
+	for name, values := range m {
+		if !isTime(name) {
+			out[name] = values
+			continue
+		}
+		values2 := make([]SearchValue, 0, len(values))
+		for _, v := range values {
+			if text, ok := v.Value.(string); ok {
+				if t, ok := ParseRelativeTime(text); ok {
+					v.Value = t
+				}
+			}
+			values2 = append(values2, v)
+		}
+		out[name] = values2
+	}
+	return out
+}
+
+// ModelTimes reports the time fields of the model of db, for ResolveTimes. It
+// returns nil when db has no model.
+func ModelTimes(db *gorm.DB) func(name string) bool {
+	if db.Statement.Model == nil {
+		return nil
+	}
+	if _, ok := db.Statement.Model.(map[string]any); ok {
+		return nil
+	}
+	stmt := &gorm.Statement{DB: db}
+	if err := stmt.Parse(db.Statement.Model); err != nil {
+		return nil
+	}
+	return SchemaTimes(stmt.Schema)
+}
+
+// SchemaTimes reports the time fields of s, for ResolveTimes.
+func SchemaTimes(s *schema.Schema) func(name string) bool {
+	return func(name string) bool {
+		f := s.LookUpField(name)
+		return f != nil && f.GORMDataType == schema.Time
+	}
+}
+
 func (m SearchData) SearchDB(db *gorm.DB, handleFuncs map[string]SearchDataHandleFunc) *gorm.DB {
 	for k, vals := range m {
 		if handleFuncs != nil {
@@ -509,7 +644,7 @@ func (m SearchData) WhereString(handleFuncs SearchDataHandleFuncMap) (string, []
 		}
 	}
 	if len(queries) == 0 {
-		return "", nil, errors.New("no valid search conditions")
+		return "", nil, ErrNoValidSearchConditions
 	}
 	return strings.Join(queries, " AND "), args, nil
 }
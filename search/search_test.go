@@ -0,0 +1,102 @@
+package search
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseSearchString(t *testing.T) {
+	s, err := ParseSearchString("name:'a b' value:>=3 kind:a,b tags.region:eu status:now")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		want SearchValue
+	}{
+		{"name", SearchValue{Symbol: SearchSymbolEq, Value: "a b"}},
+		{"value", SearchValue{Symbol: SearchSymbolGte, Value: int64(3)}},
+		{"kind", SearchValue{Symbol: SearchSymbolEq, Value: "a"}},
+		// relative times are text until they are resolved for time fields
+		{"status", SearchValue{Symbol: SearchSymbolEq, Value: "now"}},
+	}
+	for _, tt := range tests {
+		if got := s[tt.name]; len(got) == 0 || got[0] != tt.want {
+			t.Errorf("%v: got %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+	if len(s["kind"]) != 2 {
+		t.Errorf("kind: got %+v", s["kind"])
+	}
+	sub, ok := s["tags"][0].Value.(SearchData)
+	if !ok || s["tags"][0].Symbol != SearchSymbolSearch || sub["region"][0].Value != "eu" {
+		t.Errorf("tags: got %+v", s["tags"])
+	}
+	if _, err := ParseSearchString("name:'a' )"); err == nil {
+		t.Error("invalid search parsed")
+	}
+}
+
+func TestResolveTimes(t *testing.T) {
+	s, err := ParseSearchString("created:>now-1h status:now")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s = s.ResolveTimes(func(name string) bool { return name == "created" })
+	created, ok := s["created"][0].Value.(time.Time)
+	if !ok || time.Since(created) < time.Hour || time.Since(created) > time.Hour+time.Minute {
+		t.Errorf("created: got %+v", s["created"])
+	}
+	if s["status"][0].Value != "now" {
+		t.Errorf("status: got %+v", s["status"])
+	}
+
+	for _, text := range []string{"now", "now-30m", "now+1d", "now-2w"} {
+		if _, ok := ParseRelativeTime(text); !ok {
+			t.Errorf("ParseRelativeTime(%q) failed", text)
+		}
+	}
+	for _, text := range []string{"nowhere", "now-1y", "now-", "1h"} {
+		if _, ok := ParseRelativeTime(text); ok {
+			t.Errorf("ParseRelativeTime(%q) parsed", text)
+		}
+	}
+}
+
+func TestMatchRelativeTime(t *testing.T) {
+	values := map[string]any{"created": time.Now().Add(-2 * time.Hour), "status": "now"}
+	for _, tt := range []struct {
+		search string
+		want   bool
+	}{
+		{"created:<now-1h", true},
+		{"created:>now-1h", false},
+		{"status:now", true},
+	} {
+		s, err := ParseSearchString(tt.search)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, err := s.Match(values, nil); err != nil || got != tt.want {
+			t.Errorf("%q: got %v, %v; want %v", tt.search, got, err, tt.want)
+		}
+	}
+}
+
+func TestMergeSearchStrings(t *testing.T) {
+	tests := []struct {
+		base, extra, want string
+	}{
+		{"kind:a name:x", "kind:b", "name:x kind:b"},
+		{"kind:a", "", "kind:a"},
+		{"", "name:'a b'", "name:'a b'"},
+	}
+	for _, tt := range tests {
+		if got := MergeSearchStrings(tt.base, tt.extra); got != tt.want {
+			t.Errorf("MergeSearchStrings(%q, %q) = %q, want %q", tt.base, tt.extra, got, tt.want)
+		}
+	}
+	if NormalizeSearchString("b:1  a:2") != NormalizeSearchString("a:2 b:1") {
+		t.Error("normalized searches differ")
+	}
+}
@@ -0,0 +1,168 @@
+package store
+
+import (
+	"context"
+	"regexp"
+	"time"
+
+	"github.com/heypkg/store/audit"
+	"github.com/heypkg/store/search"
+	"github.com/pkg/errors"
+)
+
+// ListRequest selects a page of objects.
+type ListRequest struct {
+	// Search is a search string in the search language.
+	Search   string
+	Page     int
+	PageSize int
+	// OrderBy is a comma-separated list of columns, suffixed with - for
+	// descending order.
+	OrderBy string
+	Select  []string
+	// Preload lists associations chosen by the server, Include the ones
+	// requested by the client, which must be allowed for the model.
+	Preload string
+	Include string
+	// Deleted selects soft-deleted objects instead of live ones.
+	Deleted bool
+	// View is the id of a saved search whose terms, order and fields the
+	// request extends. It is resolved by the stores of a db with a
+	// savedsearch.Service.
+	View        string
+	HandleFuncs search.SearchDataHandleFuncMap
+	// Timeout bounds the call, see WithTimeout.
+	Timeout time.Duration
+}
+
+// GetRequest selects one object by column values, such as its id.
+type GetRequest struct {
+	Conditions map[string]any
+	Preload    string
+	Include    string
+	Deleted    bool
+	Timeout    time.Duration
+}
+
+type AggregateFunc string
+
+const (
+	AggregateCount AggregateFunc = "count"
+	AggregateSum   AggregateFunc = "sum"
+	AggregateAvg   AggregateFunc = "avg"
+	AggregateMin   AggregateFunc = "min"
+	AggregateMax   AggregateFunc = "max"
+)
+
+type Aggregation struct {
+	Name  string
+	Func  AggregateFunc
+	Field string
+}
+
+// AggregateRequest groups the objects matching Search by GroupBy and computes
+// the aggregations of every group.
+type AggregateRequest struct {
+	Search       string
+	GroupBy      []string
+	Aggregations []Aggregation
+	HandleFuncs  search.SearchDataHandleFuncMap
+	Timeout      time.Duration
+}
+
+// SuggestRequest asks for the completions of the search string Search at
+// the byte offset Cursor.
+type SuggestRequest struct {
+	Search string
+	Cursor int
+	// Limit is the maximum number of values suggested.
+	Limit       int
+	HandleFuncs search.SearchDataHandleFuncMap
+	Timeout     time.Duration
+}
+
+// Suggestion is a completion of a search string: a field, an operator or a
+// value. Count is the number of objects with a suggested value.
+type Suggestion struct {
+	Kind  search.PartKind `json:"kind"`
+	Text  string          `json:"text"`
+	Type  string          `json:"type,omitempty"`
+	Count int64           `json:"count,omitempty"`
+}
+
+// Suggestions are the completions of the part of a search string between
+// Start and End, which the text of a suggestion replaces.
+type Suggestions struct {
+	Start int          `json:"start"`
+	End   int          `json:"end"`
+	Items []Suggestion `json:"items"`
+}
+
+var fieldNameRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z0-9_]+)?$`)
+
+// ValidFieldName reports whether name is a column, or a key of a JSON column
+// written as column.key, that may be written in SQL.
+func ValidFieldName(name string) bool {
+	return fieldNameRe.MatchString(name)
+}
+
+// Validate checks the field names of the request: columns, or keys of JSON
+// columns written as column.key.
+func (r AggregateRequest) Validate() error {
+	for _, name := range r.GroupBy {
+		if !fieldNameRe.MatchString(name) {
+			return errors.Errorf("invalid field %v", name)
+		}
+	}
+	for _, a := range r.Aggregations {
+		switch a.Func {
+		case AggregateCount, AggregateSum, AggregateAvg, AggregateMin, AggregateMax:
+		default:
+			return errors.Errorf("invalid aggregation %v", a.Func)
+		}
+		if a.Field == "*" {
+			if a.Func != AggregateCount {
+				return errors.Errorf("invalid aggregation %v(*)", a.Func)
+			}
+			continue
+		}
+		if !fieldNameRe.MatchString(a.Field) {
+			return errors.Errorf("invalid field %v", a.Field)
+		}
+	}
+	return nil
+}
+
+// Store is a backend holding the objects of model T. The tenant, the policy
+// subject and the audit actor of a call are carried by ctx.
+type Store[T any] interface {
+	List(ctx context.Context, req ListRequest) ([]T, int64, error)
+	Count(ctx context.Context, req ListRequest) (int64, error)
+	Get(ctx context.Context, req GetRequest) (*T, error)
+	Create(ctx context.Context, obj *T) error
+	// Update saves all fields of obj, or only values when it is not empty.
+	Update(ctx context.Context, obj *T, values map[string]any) error
+	Delete(ctx context.Context, obj *T) error
+	Restore(ctx context.Context, obj *T) error
+	Aggregate(ctx context.Context, req AggregateRequest) ([]map[string]any, error)
+}
+
+// HistoryStore is implemented by stores that keep the audit history of their
+// objects.
+type HistoryStore[T any] interface {
+	History(ctx context.Context, obj *T, req ListRequest) ([]audit.Record, int64, error)
+	Revert(ctx context.Context, obj *T, version int) (*T, error)
+}
+
+// Suggester is implemented by stores that complete the search strings of
+// their lists with the fields of the model and the values of the objects.
+type Suggester interface {
+	Suggest(ctx context.Context, req SuggestRequest) (Suggestions, error)
+}
+
+// Scanner is implemented by stores that stream the objects of a list one at
+// a time instead of loading them at once. Page and PageSize are ignored and
+// associations are not loaded. Returning an error from fn stops the scan.
+type Scanner[T any] interface {
+	Scan(ctx context.Context, req ListRequest, fn func(obj *T) error) error
+}
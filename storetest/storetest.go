@@ -0,0 +1,342 @@
+// Package storetest checks that a store.Store backend behaves like the GORM
+// store. Backends run the suite from their tests:
+//
+//	func TestStore(t *testing.T) {
+//		storetest.Run(t, func(t *testing.T) store.Store[storetest.Device] {
+//			return memory.NewStore[storetest.Device]()
+//		})
+//	}
+package storetest
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/heypkg/store"
+	"github.com/heypkg/store/jsontype"
+	"github.com/heypkg/store/tenancy"
+	"github.com/spf13/cast"
+	"gorm.io/gorm"
+)
+
+// Device is the model stored by the suite. GORM backends migrate it before
+// returning the store.
+type Device struct {
+	ID      uint `gorm:"primarykey"`
+	Schema  string
+	Name    string
+	Kind    string
+	Value   float64
+	Tags    jsontype.Tags  `gorm:"type:jsonb;serializer:json"`
+	Deleted gorm.DeletedAt `gorm:"column:deleted"`
+}
+
+// Context returns a context for tenant with the default tenancy strategy.
+func Context(tenant string) context.Context {
+	return tenancy.WithScope(context.Background(), tenancy.NewScope(nil, tenant))
+}
+
+// Run runs the suite. newStore is called once per test and must return an
+// empty store.
+func Run(t *testing.T, newStore func(t *testing.T) store.Store[Device]) {
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, s store.Store[Device])
+	}{
+		{"CreateGet", testCreateGet},
+		{"Search", testSearch},
+		{"OrderPage", testOrderPage},
+		{"SoftDelete", testSoftDelete},
+		{"Tenancy", testTenancy},
+		{"DeleteTenancy", testDeleteTenancy},
+		{"Update", testUpdate},
+		{"Aggregate", testAggregate},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.fn(t, newStore(t))
+		})
+	}
+}
+
+func seed(t *testing.T, s store.Store[Device], ctx context.Context) []*Device {
+	t.Helper()
+	devices := []*Device{
+		{Name: "d1", Kind: "a", Value: 1, Tags: jsontype.Tags{"loc": "x"}},
+		{Name: "d2", Kind: "b", Value: 2, Tags: jsontype.Tags{"loc": "y"}},
+		{Name: "d3", Kind: "a", Value: 3, Tags: jsontype.Tags{"loc": "y"}},
+	}
+	for _, d := range devices {
+		if err := s.Create(ctx, d); err != nil {
+			t.Fatalf("create %v: %v", d.Name, err)
+		}
+	}
+	return devices
+}
+
+func statusOf(err error) int {
+	if err == nil {
+		return http.StatusOK
+	}
+	return store.ErrorOf(err).Status
+}
+
+func names(devices []Device) []string {
+	out := []string{}
+	for _, d := range devices {
+		out = append(out, d.Name)
+	}
+	return out
+}
+
+func equalNames(a []string, b ...string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func testCreateGet(t *testing.T, s store.Store[Device]) {
+	ctx := Context("t1")
+	d := &Device{Name: "d1", Kind: "a"}
+	if err := s.Create(ctx, d); err != nil {
+		t.Fatal(err)
+	}
+	if d.ID == 0 || d.Schema != "t1" {
+		t.Fatalf("created %+v, want an id and schema t1", d)
+	}
+	got, err := s.Get(ctx, store.GetRequest{Conditions: map[string]any{"id": d.ID}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Name != "d1" {
+		t.Fatalf("got %+v", got)
+	}
+	_, err = s.Get(ctx, store.GetRequest{Conditions: map[string]any{"id": d.ID + 100}})
+	if statusOf(err) != http.StatusNotFound {
+		t.Fatalf("get missing: %v, want 404", err)
+	}
+}
+
+func testSearch(t *testing.T, s store.Store[Device]) {
+	ctx := Context("t1")
+	seed(t, s, ctx)
+	tests := []struct {
+		q    string
+		want []string
+	}{
+		{"", []string{"d1", "d2", "d3"}},
+		{"kind:a", []string{"d1", "d3"}},
+		{"kind:a,b", []string{"d1", "d2", "d3"}},
+		{"kind:!=a", []string{"d2"}},
+		{"value:2..3", []string{"d2", "d3"}},
+		{"value:>1", []string{"d2", "d3"}},
+		{"value:<=1", []string{"d1"}},
+		{"kind:a value:>1", []string{"d3"}},
+		{"tags.loc:y", []string{"d2", "d3"}},
+		{"name:d9", []string{}},
+	}
+	for _, tt := range tests {
+		data, total, err := s.List(ctx, store.ListRequest{Search: tt.q, OrderBy: "name"})
+		if err != nil {
+			t.Fatalf("q=%v: %v", tt.q, err)
+		}
+		if got := names(data); !equalNames(got, tt.want...) || total != int64(len(tt.want)) {
+			t.Errorf("q=%v: got %v (%v), want %v", tt.q, got, total, tt.want)
+		}
+		count, err := s.Count(ctx, store.ListRequest{Search: tt.q})
+		if err != nil || count != int64(len(tt.want)) {
+			t.Errorf("count q=%v: got %v %v, want %v", tt.q, count, err, len(tt.want))
+		}
+	}
+	if _, _, err := s.List(ctx, store.ListRequest{Search: "kind:(("}); statusOf(err) != http.StatusBadRequest {
+		t.Errorf("invalid query: %v, want 400", err)
+	}
+}
+
+func testOrderPage(t *testing.T, s store.Store[Device]) {
+	ctx := Context("t1")
+	seed(t, s, ctx)
+	tests := []struct {
+		page int
+		want []string
+	}{
+		{1, []string{"d3", "d2"}},
+		{2, []string{"d1"}},
+		{9, []string{"d1"}},
+		{0, []string{"d3", "d2"}},
+	}
+	for _, tt := range tests {
+		data, total, err := s.List(ctx, store.ListRequest{OrderBy: "value-", Page: tt.page, PageSize: 2})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := names(data); !equalNames(got, tt.want...) || total != 3 {
+			t.Errorf("page %v: got %v (%v), want %v", tt.page, got, total, tt.want)
+		}
+	}
+	data, _, err := s.List(ctx, store.ListRequest{OrderBy: "kind,name-"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := names(data); !equalNames(got, "d3", "d1", "d2") {
+		t.Errorf("order kind,name-: got %v", got)
+	}
+}
+
+func testSoftDelete(t *testing.T, s store.Store[Device]) {
+	ctx := Context("t1")
+	devices := seed(t, s, ctx)
+	if err := s.Delete(ctx, devices[0]); err != nil {
+		t.Fatal(err)
+	}
+	if _, total, err := s.List(ctx, store.ListRequest{}); err != nil || total != 2 {
+		t.Fatalf("list after delete: %v %v, want 2", total, err)
+	}
+	data, total, err := s.List(ctx, store.ListRequest{Deleted: true})
+	if err != nil || total != 1 || data[0].Name != "d1" {
+		t.Fatalf("list deleted: %v %v %v", names(data), total, err)
+	}
+	id := map[string]any{"id": devices[0].ID}
+	if _, err := s.Get(ctx, store.GetRequest{Conditions: id}); statusOf(err) != http.StatusNotFound {
+		t.Fatalf("get deleted: %v, want 404", err)
+	}
+	if _, err := s.Get(ctx, store.GetRequest{Conditions: id, Deleted: true}); err != nil {
+		t.Fatalf("get deleted with Deleted: %v", err)
+	}
+	if err := s.Restore(ctx, devices[0]); err != nil {
+		t.Fatal(err)
+	}
+	if _, total, err := s.List(ctx, store.ListRequest{}); err != nil || total != 3 {
+		t.Fatalf("list after restore: %v %v, want 3", total, err)
+	}
+}
+
+func testTenancy(t *testing.T, s store.Store[Device]) {
+	ctx1 := Context("t1")
+	ctx2 := Context("t2")
+	devices := seed(t, s, ctx1)
+	other := &Device{Name: "o1", Kind: "a"}
+	if err := s.Create(ctx2, other); err != nil {
+		t.Fatal(err)
+	}
+	data, total, err := s.List(ctx2, store.ListRequest{})
+	if err != nil || total != 1 || data[0].Name != "o1" {
+		t.Fatalf("list t2: %v %v %v", names(data), total, err)
+	}
+	if _, err := s.Get(ctx2, store.GetRequest{Conditions: map[string]any{"id": devices[0].ID}}); statusOf(err) != http.StatusNotFound {
+		t.Fatalf("get across tenants: %v, want 404", err)
+	}
+	if _, _, err := s.List(ctx2, store.ListRequest{Search: "schema:t1"}); statusOf(err) != http.StatusBadRequest {
+		t.Fatalf("search on tenant column: %v, want 400", err)
+	}
+	d := devices[0]
+	if err := s.Update(ctx1, d, map[string]any{"schema": "t2", "name": "moved"}); err != nil {
+		t.Fatal(err)
+	}
+	got, err := s.Get(ctx1, store.GetRequest{Conditions: map[string]any{"id": d.ID}})
+	if err != nil || got.Schema != "t1" || got.Name != "moved" {
+		t.Fatalf("update tenant: %+v %v", got, err)
+	}
+}
+
+func testDeleteTenancy(t *testing.T, s store.Store[Device]) {
+	ctx1 := Context("t1")
+	ctx2 := Context("t2")
+	devices := seed(t, s, ctx1)
+	count := func(ctx context.Context, deleted bool, want int64) {
+		t.Helper()
+		if _, total, err := s.List(ctx, store.ListRequest{Deleted: deleted}); err != nil || total != want {
+			t.Fatalf("list deleted=%v: %v %v, want %v", deleted, total, err, want)
+		}
+	}
+	if err := s.Delete(ctx2, &Device{ID: devices[0].ID}); statusOf(err) != http.StatusNotFound {
+		t.Fatalf("delete across tenants: %v, want 404", err)
+	}
+	if err := s.Delete(ctx1, &Device{ID: devices[2].ID + 100}); statusOf(err) != http.StatusNotFound {
+		t.Fatalf("delete of a missing object: %v, want 404", err)
+	}
+	count(ctx1, false, 3)
+	if err := s.Delete(ctx1, devices[0]); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Delete(ctx1, &Device{ID: devices[0].ID}); statusOf(err) != http.StatusNotFound {
+		t.Fatalf("delete of a deleted object: %v, want 404", err)
+	}
+	if err := s.Restore(ctx2, &Device{ID: devices[0].ID}); statusOf(err) != http.StatusNotFound {
+		t.Fatalf("restore across tenants: %v, want 404", err)
+	}
+	count(ctx1, true, 1)
+	if err := s.Restore(ctx1, &Device{ID: devices[0].ID}); err != nil {
+		t.Fatal(err)
+	}
+	count(ctx1, false, 3)
+}
+
+func testUpdate(t *testing.T, s store.Store[Device]) {
+	ctx := Context("t1")
+	devices := seed(t, s, ctx)
+	d := devices[1]
+	if err := s.Update(ctx, d, map[string]any{"value": 10}); err != nil {
+		t.Fatal(err)
+	}
+	got, err := s.Get(ctx, store.GetRequest{Conditions: map[string]any{"id": d.ID}})
+	if err != nil || got.Value != 10 || got.Name != "d2" {
+		t.Fatalf("update values: %+v %v", got, err)
+	}
+	got.Name = "renamed"
+	if err := s.Update(ctx, got, nil); err != nil {
+		t.Fatal(err)
+	}
+	data, _, err := s.List(ctx, store.ListRequest{Search: "name:renamed"})
+	if err != nil || len(data) != 1 || data[0].Value != 10 {
+		t.Fatalf("update all fields: %v %v", data, err)
+	}
+}
+
+func testAggregate(t *testing.T, s store.Store[Device]) {
+	ctx := Context("t1")
+	seed(t, s, ctx)
+	rows, err := s.Aggregate(ctx, store.AggregateRequest{
+		GroupBy: []string{"kind"},
+		Aggregations: []store.Aggregation{
+			{Name: "count", Func: store.AggregateCount, Field: "*"},
+			{Name: "sum_value", Func: store.AggregateSum, Field: "value"},
+			{Name: "max_value", Func: store.AggregateMax, Field: "value"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []map[string]any{
+		{"kind": "a", "count": 2, "sum_value": 4, "max_value": 3},
+		{"kind": "b", "count": 1, "sum_value": 2, "max_value": 2},
+	}
+	if len(rows) != len(want) {
+		t.Fatalf("aggregate: got %v, want %v", rows, want)
+	}
+	for i, row := range rows {
+		for k, v := range want[i] {
+			if cast.ToString(row[k]) != cast.ToString(v) {
+				t.Errorf("aggregate row %v %v: got %v, want %v", i, k, row[k], v)
+			}
+		}
+	}
+	rows, err = s.Aggregate(ctx, store.AggregateRequest{
+		Search:       "kind:a",
+		GroupBy:      []string{"tags.loc"},
+		Aggregations: []store.Aggregation{{Name: "count", Func: store.AggregateCount, Field: "*"}},
+	})
+	if err != nil || len(rows) != 2 || cast.ToString(rows[0]["tags_loc"]) != "x" {
+		t.Fatalf("aggregate json key: %v %v", rows, err)
+	}
+	if _, err := s.Aggregate(ctx, store.AggregateRequest{GroupBy: []string{"kind;drop"}}); statusOf(err) != http.StatusBadRequest {
+		t.Fatalf("invalid field: %v, want 400", err)
+	}
+}
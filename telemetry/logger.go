@@ -0,0 +1,106 @@
+// Package telemetry logs the queries of the stores with slog and traces
+// them with OpenTelemetry.
+package telemetry
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/heypkg/store/tenancy"
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// Logger is a gorm logger writing to slog. Failed queries are logged at the
+// error level, slow queries at the warn level and other queries at the
+// debug level, each with the sql, the elapsed time, the rows and the tenant.
+type Logger struct {
+	Logger *slog.Logger
+	// LogLevel selects the queries that are logged: errors at logger.Error,
+	// slow queries too at logger.Warn, every query at logger.Info.
+	LogLevel logger.LogLevel
+	// SlowThreshold is the duration above which queries are slow. Zero
+	// disables slow query logs.
+	SlowThreshold time.Duration
+	// Redact leaves the arguments of queries out of logs, which show
+	// placeholders instead.
+	Redact bool
+	// IgnoreRecordNotFound does not log gorm.ErrRecordNotFound.
+	IgnoreRecordNotFound bool
+}
+
+var _ logger.Interface = (*Logger)(nil)
+var _ gorm.ParamsFilter = (*Logger)(nil)
+
+// NewLogger returns a logger of failed and slow queries, above 200ms, with
+// redacted arguments. A nil l is slog.Default().
+func NewLogger(l *slog.Logger) *Logger {
+	if l == nil {
+		l = slog.Default()
+	}
+	return &Logger{
+		Logger:               l,
+		LogLevel:             logger.Warn,
+		SlowThreshold:        200 * time.Millisecond,
+		Redact:               true,
+		IgnoreRecordNotFound: true,
+	}
+}
+
+func (l *Logger) LogMode(level logger.LogLevel) logger.Interface {
+	out := *l
+	out.LogLevel = level
+	return &out
+}
+
+func (l *Logger) Info(ctx context.Context, msg string, args ...any) {
+	if l.LogLevel >= logger.Info {
+		l.Logger.InfoContext(ctx, fmt.Sprintf(msg, args...))
+	}
+}
+
+func (l *Logger) Warn(ctx context.Context, msg string, args ...any) {
+	if l.LogLevel >= logger.Warn {
+		l.Logger.WarnContext(ctx, fmt.Sprintf(msg, args...))
+	}
+}
+
+func (l *Logger) Error(ctx context.Context, msg string, args ...any) {
+	if l.LogLevel >= logger.Error {
+		l.Logger.ErrorContext(ctx, fmt.Sprintf(msg, args...))
+	}
+}
+
+func (l *Logger) Trace(ctx context.Context, begin time.Time, fc func() (string, int64), err error) {
+	if l.LogLevel <= logger.Silent {
+		return
+	}
+	elapsed := time.Since(begin)
+	attrs := func() []slog.Attr {
+		sql, rows := fc()
+		attrs := []slog.Attr{slog.String("sql", sql), slog.Duration("elapsed", elapsed), slog.Int64("rows", rows)}
+		if tenant := tenancy.ScopeFromContext(ctx).Tenant; tenant != "" {
+			attrs = append(attrs, slog.String("tenant", tenant))
+		}
+		return attrs
+	}
+	switch {
+	case err != nil && l.LogLevel >= logger.Error && !(l.IgnoreRecordNotFound && errors.Is(err, gorm.ErrRecordNotFound)):
+		l.Logger.LogAttrs(ctx, slog.LevelError, "query failed", append(attrs(), slog.String("error", err.Error()))...)
+	case l.SlowThreshold > 0 && elapsed > l.SlowThreshold && l.LogLevel >= logger.Warn:
+		l.Logger.LogAttrs(ctx, slog.LevelWarn, "slow query", append(attrs(), slog.Duration("threshold", l.SlowThreshold))...)
+	case l.LogLevel >= logger.Info:
+		l.Logger.LogAttrs(ctx, slog.LevelDebug, "query", attrs()...)
+	}
+}
+
+// ParamsFilter drops the arguments of queries from logs when Redact is set.
+func (l *Logger) ParamsFilter(ctx context.Context, sql string, params ...any) (string, []any) {
+	if l.Redact {
+		return sql, nil
+	}
+	return sql, params
+}
@@ -0,0 +1,85 @@
+package telemetry_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/glebarez/sqlite"
+	"github.com/heypkg/store/storetest"
+	"github.com/heypkg/store/telemetry"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// logs returns the JSON records written to buf.
+func logs(t *testing.T, buf *bytes.Buffer) []map[string]any {
+	t.Helper()
+	var out []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var rec map[string]any
+		if err := json.Unmarshal([]byte(line), &rec); err != nil {
+			t.Fatal(err)
+		}
+		out = append(out, rec)
+	}
+	buf.Reset()
+	return out
+}
+
+func TestLogger(t *testing.T) {
+	var buf bytes.Buffer
+	l := telemetry.NewLogger(slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug})))
+	db, err := gorm.Open(sqlite.Open(t.TempDir()+"/store.db"), &gorm.Config{Logger: l})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&storetest.Device{}); err != nil {
+		t.Fatal(err)
+	}
+	ctx := storetest.Context("a")
+	query := func(l logger.Interface) {
+		var devices []storetest.Device
+		db.Session(&gorm.Session{Logger: l}).WithContext(ctx).Where("name = ?", "secret").Find(&devices)
+	}
+
+	buf.Reset()
+	query(l)
+	if recs := logs(t, &buf); len(recs) != 0 {
+		t.Errorf("fast query logged: %v", recs)
+	}
+
+	slow := *l
+	slow.SlowThreshold = time.Nanosecond
+	query(&slow)
+	recs := logs(t, &buf)
+	if len(recs) != 1 || recs[0]["level"] != "WARN" || recs[0]["msg"] != "slow query" {
+		t.Fatalf("slow query logs = %v", recs)
+	}
+	if sql := recs[0]["sql"].(string); strings.Contains(sql, "secret") || !strings.Contains(sql, "?") {
+		t.Errorf("redacted sql = %q", sql)
+	}
+	if recs[0]["tenant"] != "a" {
+		t.Errorf("tenant = %v, want a", recs[0]["tenant"])
+	}
+
+	all := l.LogMode(logger.Info).(*telemetry.Logger)
+	all.Redact = false
+	query(all)
+	recs = logs(t, &buf)
+	if len(recs) != 1 || recs[0]["level"] != "DEBUG" || !strings.Contains(recs[0]["sql"].(string), "secret") {
+		t.Errorf("query logs = %v", recs)
+	}
+
+	db.Session(&gorm.Session{Logger: l}).Raw("SELECT * FROM missing").Scan(&[]map[string]any{})
+	recs = logs(t, &buf)
+	if len(recs) != 1 || recs[0]["level"] != "ERROR" || recs[0]["error"] == nil {
+		t.Errorf("failed query logs = %v", recs)
+	}
+}
@@ -0,0 +1,39 @@
+package telemetry
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// InstrumentationName names the tracer of the stores.
+const InstrumentationName = "github.com/heypkg/store"
+
+// Attributes of store spans.
+const (
+	AttrModel  = attribute.Key("store.model")
+	AttrSchema = attribute.Key("store.schema")
+	AttrTerms  = attribute.Key("store.search.terms")
+	AttrRows   = attribute.Key("store.rows")
+	AttrTotal  = attribute.Key("store.total")
+	AttrSource = attribute.Key("store.source")
+	AttrSeries = attribute.Key("store.series")
+)
+
+// Start starts a span of the tracer provider registered with otel, which
+// does nothing until one is set.
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(InstrumentationName).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// End records err, if any, and ends span.
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
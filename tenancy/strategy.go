@@ -0,0 +1,111 @@
+package tenancy
+
+import (
+	"sync"
+
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+)
+
+// ColumnStrategy keeps all tenants in the same tables and filters rows by a
+// tenant column.
+type ColumnStrategy struct {
+	column     string
+	contextKey string
+}
+
+func NewColumnStrategy(column string, contextKey string) *ColumnStrategy {
+	return &ColumnStrategy{column: column, contextKey: contextKey}
+}
+
+func (s *ColumnStrategy) Resolve(v Values) (string, error) {
+	return resolveFromValues(v, s.contextKey, false)
+}
+
+func (s *ColumnStrategy) Run(db *gorm.DB, tenant string, fn func(db *gorm.DB) error) error {
+	return fn(db)
+}
+
+func (s *ColumnStrategy) Column() string {
+	return s.column
+}
+
+// SchemaStrategy keeps every tenant in its own Postgres schema and runs each
+// call in a transaction with the search path set to that schema.
+type SchemaStrategy struct {
+	prefix     string
+	contextKey string
+}
+
+func NewSchemaStrategy(prefix string, contextKey string) *SchemaStrategy {
+	return &SchemaStrategy{prefix: prefix, contextKey: contextKey}
+}
+
+func (s *SchemaStrategy) Resolve(v Values) (string, error) {
+	return resolveFromValues(v, s.contextKey, true)
+}
+
+func (s *SchemaStrategy) Run(db *gorm.DB, tenant string, fn func(db *gorm.DB) error) error {
+	if !tenantNameRe.MatchString(tenant) {
+		return errors.Wrap(ErrInvalidTenant, tenant)
+	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec(`SET LOCAL search_path TO "` + s.prefix + tenant + `", public`).Error; err != nil {
+			return errors.Wrap(err, "set search path")
+		}
+		return fn(tx)
+	})
+}
+
+func (s *SchemaStrategy) Column() string {
+	return ""
+}
+
+// DatabaseStrategy keeps every tenant in its own database. Connections are
+// opened on first use and kept for later requests.
+type DatabaseStrategy struct {
+	contextKey string
+	open       func(tenant string) (*gorm.DB, error)
+	dbs        sync.Map
+	mu         sync.Mutex
+}
+
+func NewDatabaseStrategy(contextKey string, open func(tenant string) (*gorm.DB, error)) *DatabaseStrategy {
+	return &DatabaseStrategy{contextKey: contextKey, open: open}
+}
+
+func (s *DatabaseStrategy) Resolve(v Values) (string, error) {
+	return resolveFromValues(v, s.contextKey, true)
+}
+
+func (s *DatabaseStrategy) Run(db *gorm.DB, tenant string, fn func(db *gorm.DB) error) error {
+	tenantDB, err := s.DB(tenant)
+	if err != nil {
+		return err
+	}
+	return fn(tenantDB.WithContext(db.Statement.Context))
+}
+
+func (s *DatabaseStrategy) Column() string {
+	return ""
+}
+
+func (s *DatabaseStrategy) DB(tenant string) (*gorm.DB, error) {
+	if !tenantNameRe.MatchString(tenant) {
+		return nil, errors.Wrap(ErrInvalidTenant, tenant)
+	}
+	if v, ok := s.dbs.Load(tenant); ok {
+		return v.(*gorm.DB), nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if v, ok := s.dbs.Load(tenant); ok {
+		return v.(*gorm.DB), nil
+	}
+	tenantDB, err := s.open(tenant)
+	if err != nil {
+		return nil, errors.Wrapf(err, "open database of tenant %v", tenant)
+	}
+	s.dbs.Store(tenant, tenantDB)
+	return tenantDB, nil
+}
@@ -0,0 +1,180 @@
+package tenancy
+
+import (
+	"context"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"github.com/heypkg/store/search"
+	"github.com/pkg/errors"
+	"github.com/spf13/cast"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrTenantRequired = errors.New("tenant required")
+	ErrInvalidTenant  = errors.New("invalid tenant")
+	ErrTenantOverride = errors.New("tenant column cannot be used in search")
+)
+
+// Values holds the values set on a request by middlewares. echo.Context
+// implements it; other frameworks use an adapter.
+type Values interface {
+	Get(key string) any
+}
+
+// Strategy isolates the data of one tenant from the others.
+type Strategy interface {
+	// Resolve returns the tenant of the request.
+	Resolve(v Values) (string, error)
+	// Run calls fn with a db bound to the tenant's connection, database or
+	// search path.
+	Run(db *gorm.DB, tenant string, fn func(db *gorm.DB) error) error
+	// Column returns the column holding the tenant, or "" when tenants are
+	// not separated by rows.
+	Column() string
+}
+
+const StrategyContextKey = "tenancy"
+
+var defaultStrategy Strategy = NewColumnStrategy("schema", "schema")
+
+func SetDefaultStrategy(s Strategy) {
+	defaultStrategy = s
+}
+
+func DefaultStrategy() Strategy {
+	return defaultStrategy
+}
+
+func StrategyFromValues(v Values) Strategy {
+	if s, ok := v.Get(StrategyContextKey).(Strategy); ok && s != nil {
+		return s
+	}
+	return defaultStrategy
+}
+
+// Scope is a strategy bound to a resolved tenant.
+type Scope struct {
+	Strategy Strategy
+	Tenant   string
+}
+
+func NewScope(s Strategy, tenant string) Scope {
+	if s == nil {
+		s = defaultStrategy
+	}
+	return Scope{Strategy: s, Tenant: tenant}
+}
+
+func ScopeFromValues(v Values) (Scope, error) {
+	s := StrategyFromValues(v)
+	tenant, err := s.Resolve(v)
+	if err != nil {
+		return Scope{}, err
+	}
+	return Scope{Strategy: s, Tenant: tenant}, nil
+}
+
+func (s Scope) Run(db *gorm.DB, fn func(db *gorm.DB) error) error {
+	return s.Strategy.Run(db, s.Tenant, fn)
+}
+
+// Apply restricts the statement to the tenant's rows. Models without the
+// tenant column are left untouched.
+func (s Scope) Apply(db *gorm.DB) *gorm.DB {
+	column := s.Strategy.Column()
+	if column == "" {
+		return db
+	}
+	if db.Statement.Model != nil {
+		stmt := &gorm.Statement{DB: db}
+		if err := stmt.Parse(db.Statement.Model); err == nil && stmt.Schema.LookUpField(column) == nil {
+			return db
+		}
+	}
+	return db.Where(db.Statement.Quote(column)+" = ?", s.Tenant)
+}
+
+// Where returns the row condition for raw queries, or "" when tenants are not
+// separated by rows.
+func (s Scope) Where() (string, []any) {
+	column := s.Strategy.Column()
+	if column == "" {
+		return "", nil
+	}
+	return column + " = ?", []any{s.Tenant}
+}
+
+// Check rejects search terms on the tenant column so that clients cannot
+// widen or change the tenant filter.
+func (s Scope) Check(data search.SearchData) error {
+	column := s.Strategy.Column()
+	if column == "" {
+		return nil
+	}
+	for name := range data {
+		name = strings.TrimPrefix(name, "$")
+		if strings.EqualFold(name, column) || strings.HasPrefix(strings.ToLower(name), strings.ToLower(column)+".") {
+			return errors.Wrap(ErrTenantOverride, name)
+		}
+	}
+	return nil
+}
+
+// Assign sets the tenant column of obj and removes it from values, so
+// writes cannot move an object to another tenant.
+func (s Scope) Assign(db *gorm.DB, obj any, values map[string]any) error {
+	column := s.Strategy.Column()
+	if column == "" {
+		return nil
+	}
+	for k := range values {
+		if strings.EqualFold(k, column) {
+			delete(values, k)
+		}
+	}
+	stmt := &gorm.Statement{DB: db}
+	if err := stmt.Parse(obj); err != nil {
+		return errors.Wrap(err, "parse model")
+	}
+	field := stmt.Schema.LookUpField(column)
+	if field == nil {
+		return nil
+	}
+	return field.Set(context.Background(), reflect.Indirect(reflect.ValueOf(obj)), s.Tenant)
+}
+
+var tenantNameRe = regexp.MustCompile(`^[A-Za-z0-9_]+$`)
+
+func resolveFromValues(v Values, key string, required bool) (string, error) {
+	tenant := cast.ToString(v.Get(key))
+	if tenant == "" {
+		if required {
+			return "", ErrTenantRequired
+		}
+		return "", nil
+	}
+	if required && !tenantNameRe.MatchString(tenant) {
+		return "", errors.Wrap(ErrInvalidTenant, tenant)
+	}
+	return tenant, nil
+}
+
+type scopeContextKey struct{}
+
+func WithScope(ctx context.Context, scope Scope) context.Context {
+	return context.WithValue(ctx, scopeContextKey{}, scope)
+}
+
+// ScopeFromContext returns the scope stored in ctx, or the default strategy
+// with an empty tenant.
+func ScopeFromContext(ctx context.Context) Scope {
+	if ctx != nil {
+		if v, ok := ctx.Value(scopeContextKey{}).(Scope); ok && v.Strategy != nil {
+			return v
+		}
+	}
+	return NewScope(nil, "")
+}
@@ -0,0 +1,80 @@
+package store
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	// DefaultTimeout bounds calls that do not set a timeout. Zero leaves
+	// them to the deadline of their context.
+	DefaultTimeout time.Duration
+	// MaxTimeout caps the timeouts of calls, including those asked for by
+	// clients. Zero does not cap them.
+	MaxTimeout time.Duration
+)
+
+// ParseTimeout reads a timeout query parameter: a duration such as 500ms or
+// a number of seconds. Invalid or negative values are zero.
+func ParseTimeout(text string) time.Duration {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return 0
+	}
+	d, err := time.ParseDuration(text)
+	if err != nil {
+		seconds, err := strconv.ParseFloat(text, 64)
+		if err != nil {
+			return 0
+		}
+		d = time.Duration(seconds * float64(time.Second))
+	}
+	if d < 0 {
+		return 0
+	}
+	return d
+}
+
+// WithTimeout returns the context of a store call with a deadline of
+// timeout, or DefaultTimeout when it is zero, capped by MaxTimeout. The
+// deadline of ctx is kept when it is earlier.
+func WithTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	if MaxTimeout > 0 && (timeout <= 0 || timeout > MaxTimeout) {
+		timeout = MaxTimeout
+	}
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// ContextError returns the error of a call that failed after ctx was done
+// as a timeout, or as cancelled when the caller went away. Errors of other
+// kinds than internal errors are returned as they are.
+func ContextError(ctx context.Context, err error) error {
+	if err == nil {
+		return nil
+	}
+	ctxErr := ctx.Err()
+	if ctxErr == nil {
+		if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+			return WrapError(err)
+		}
+		return err
+	}
+	var e *Error
+	if errors.As(err, &e) && !errors.Is(e, ErrInternal) {
+		return err
+	}
+	if errors.Is(ctxErr, context.DeadlineExceeded) {
+		return Timeout(err)
+	}
+	return NewError(ErrCanceled, "canceled", err)
+}
@@ -1,15 +1,34 @@
 package tsdb
 
 import (
-	gormdb "github.com/heypkg/store/gorm"
+	"github.com/heypkg/store"
+	"github.com/heypkg/store/echoadapter"
+	"github.com/heypkg/store/echohandler"
 	"github.com/labstack/echo/v4"
 	"gorm.io/gorm"
 )
 
+// TSObjectHandler panics with echohandler.ErrUnsupportedBackend when db is
+// not a supported backend.
 func TSObjectHandler[T any](db any) echo.MiddlewareFunc {
-	switch db2 := db.(type) {
-	case *gorm.DB:
-		return gormdb.TSObjectHandler[T](db2)
+	return echohandler.TSObjectHandler[T](db)
+}
+
+// HandleTSQueryCommandEcho runs the command of the request body for the
+// tenant of c. Queries stop when the client goes away or after the timeout
+// of the command or of the timeout query parameter.
+func HandleTSQueryCommandEcho(c echo.Context, db *gorm.DB) (TSResult, error) {
+	ctx, err := echoadapter.Context(c)
+	if err != nil {
+		return TSResult{}, echoadapter.HTTPError(err)
+	}
+	var cmd TSQueryCommand
+	if err := c.Bind(&cmd); err != nil {
+		return TSResult{}, echoadapter.HTTPError(err)
+	}
+	if cmd.Timeout <= 0 {
+		cmd.Timeout = store.ParseTimeout(c.QueryParam("timeout")).Seconds()
 	}
-	return nil
+	result, err := HandleTSQueryCommandContext(ctx, db, cmd)
+	return result, echoadapter.HTTPError(err)
 }
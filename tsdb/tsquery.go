@@ -1,14 +1,21 @@
 package tsdb
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"strings"
 	"time"
 
+	"github.com/heypkg/store"
+	"github.com/heypkg/store/metrics"
+	"github.com/heypkg/store/replica"
 	"github.com/heypkg/store/search"
+	"github.com/heypkg/store/telemetry"
+	"github.com/heypkg/store/tenancy"
 	"github.com/pkg/errors"
 	"github.com/spf13/cast"
+	"go.opentelemetry.io/otel/trace"
 	"gorm.io/gorm"
 )
 
@@ -45,6 +52,8 @@ type TSQueryCommand struct {
 	To       int64   `json:"To"`
 	Query    TSQuery `json:"Query"`
 	TimeZone string  `json:"TimeZone"`
+	// Timeout bounds the query, in seconds. See store.WithTimeout.
+	Timeout float64 `json:"Timeout,omitempty"`
 }
 type TSPointValue any
 type TSPoint map[string]TSPointValue
@@ -61,6 +70,16 @@ type TSResult struct {
 }
 
 func HandleTSQueryCommand(db *gorm.DB, cmd TSQueryCommand) (TSResult, error) {
+	return HandleTSQueryCommandContext(context.Background(), db, cmd)
+}
+
+// HandleTSQueryCommandContext runs cmd with the deadline of ctx and the
+// timeout of cmd. The tenant is cmd.Schema, or the tenant of ctx when it is
+// empty, and the tenancy strategy is the one of ctx. Invalid searches are
+// invalid queries.
+func HandleTSQueryCommandContext(ctx context.Context, db *gorm.DB, cmd TSQueryCommand) (TSResult, error) {
+	ctx, cancel := store.WithTimeout(ctx, time.Duration(cmd.Timeout*float64(time.Second)))
+	defer cancel()
 	result := TSResult{
 		From: cmd.From,
 		To:   cmd.To,
@@ -68,21 +87,93 @@ func HandleTSQueryCommand(db *gorm.DB, cmd TSQueryCommand) (TSResult, error) {
 	}
 	query := cmd.Query
 
-	searchString := fmt.Sprintf(`%v schema:"%v"`, query.SearchString, cmd.Schema)
-	search, err := search.ParseSearchString2(searchString)
+	scope := tenancy.ScopeFromContext(ctx)
+	if cmd.Schema != "" {
+		scope.Tenant = cmd.Schema
+	}
+	ctx, op := startQuery(ctx, query.Source, scope.Tenant)
+	var series []TSSeries
+	var err error
+	defer func() { op.end(err, series) }()
+	_, parseSpan := telemetry.Start(ctx, "store.parse")
+	search, err := search.ParseSearchString2(query.SearchString)
+	if err == nil {
+		parseSpan.SetAttributes(telemetry.AttrTerms.Int(len(search)))
+		err = scope.Check(search)
+	}
+	telemetry.End(parseSpan, err)
 	if err != nil {
+		err = store.InvalidQuery(err)
 		return result, err
 	}
-	query.Search = search
-	series, err := QueryTimeSeries(db, cmd.From, cmd.To, cmd.TimeZone, query)
+	// relative times are compared with the time column of the series
+	query.Search = search.ResolveTimes(func(name string) bool { return name == "time" })
+	err = replica.Run(ctx, db, func(db *gorm.DB) error {
+		return scope.Run(db.WithContext(ctx), func(db *gorm.DB) error {
+			var err error
+			where, whereArgs := scope.Where()
+			series, err = queryTimeSeries(db, cmd.From, cmd.To, cmd.TimeZone, query, where, whereArgs)
+			return err
+		})
+	})
 	if err != nil {
-		return result, err
+		return result, store.ContextError(ctx, err)
 	}
 	result.Data = series
 	return result, nil
 }
 
 func QueryTimeSeries(db *gorm.DB, from int64, to int64, tz string, query TSQuery) ([]TSSeries, error) {
+	return QueryTimeSeriesContext(context.Background(), db, from, to, tz, query)
+}
+
+// QueryTimeSeriesContext runs query with the deadline of ctx.
+func QueryTimeSeriesContext(ctx context.Context, db *gorm.DB, from int64, to int64, tz string, query TSQuery) ([]TSSeries, error) {
+	ctx, op := startQuery(ctx, query.Source, "")
+	var series []TSSeries
+	err := replica.Run(ctx, db, func(db *gorm.DB) error {
+		var err error
+		series, err = queryTimeSeries(db.WithContext(ctx), from, to, tz, query, "", nil)
+		return err
+	})
+	op.end(err, series)
+	return series, store.ContextError(ctx, err)
+}
+
+// queryOperation traces and measures a time series query.
+type queryOperation struct {
+	ctx    context.Context
+	span   trace.Span
+	source string
+	begin  time.Time
+}
+
+func startQuery(ctx context.Context, source string, schema string) (context.Context, *queryOperation) {
+	ctx, span := telemetry.Start(ctx, "tsdb.query",
+		telemetry.AttrSource.String(source),
+		telemetry.AttrSchema.String(schema),
+	)
+	return ctx, &queryOperation{ctx: ctx, span: span, source: source, begin: time.Now()}
+}
+
+func (q *queryOperation) end(err error, series []TSSeries) {
+	points := 0
+	for _, s := range series {
+		points += len(s.Points)
+	}
+	var storeErr error
+	if err != nil {
+		storeErr = store.ContextError(q.ctx, store.WrapError(err))
+	}
+	metrics.ObserveTSQuery(q.source, q.begin, points, storeErr)
+	q.span.SetAttributes(
+		telemetry.AttrRows.Int(points),
+		telemetry.AttrSeries.Int(len(series)),
+	)
+	telemetry.End(q.span, err)
+}
+
+func queryTimeSeries(db *gorm.DB, from int64, to int64, tz string, query TSQuery, tenantWhere string, tenantArgs []any) ([]TSSeries, error) {
 	if query.Source == "" {
 		return nil, errors.New("source is empty")
 	}
@@ -108,6 +199,7 @@ func QueryTimeSeries(db *gorm.DB, from int64, to int64, tz string, query TSQuery
 	var offsetClause []string
 	var limitClause []string
 	var whereClause []string
+	var whereArgs []any
 
 	for _, sel := range query.Select {
 		var expr string
@@ -175,6 +267,11 @@ func QueryTimeSeries(db *gorm.DB, from int64, to int64, tz string, query TSQuery
 	if query.Limit > 0 {
 		limitClause = append(limitClause, fmt.Sprintf("LIMIT %d", query.Limit))
 	}
+	if tenantWhere != "" {
+		whereClause = append(whereClause, tenantWhere)
+		whereArgs = append(whereArgs, tenantArgs...)
+	}
+
 	// Construct the WHERE clause of the SQL query based on the query parameters.
 	where := fmt.Sprintf("WHERE time >= to_timestamp(%d) AND time < to_timestamp(%d)", from, to+1)
 	if len(whereClause) > 0 {
@@ -209,7 +306,7 @@ func QueryTimeSeries(db *gorm.DB, from int64, to int64, tz string, query TSQuery
 	)
 
 	// Execute the SQL query and scan the result set into a slice of TSSeries structs.
-	rows, err := db.Debug().Raw(queryStr, searchArgs...).Rows()
+	rows, err := db.Raw(queryStr, append(whereArgs, searchArgs...)...).Rows()
 	if err != nil {
 		return nil, errors.Wrap(err, "query")
 	}
@@ -286,6 +383,9 @@ func QueryTimeSeries(db *gorm.DB, from int64, to int64, tz string, query TSQuery
 		}
 		series.Points = append(series.Points, point)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "scan rows")
+	}
 	for _, series := range seriesMap {
 		result = append(result, *series)
 	}
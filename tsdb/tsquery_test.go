@@ -0,0 +1,61 @@
+package tsdb_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/glebarez/sqlite"
+	"github.com/heypkg/store"
+	"github.com/heypkg/store/tenancy"
+	"github.com/heypkg/store/tsdb"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+var errStop = errors.New("stop")
+
+// strategy is a column strategy recording the tenants it runs for.
+type strategy struct {
+	*tenancy.ColumnStrategy
+	tenants []string
+}
+
+func (s *strategy) Run(db *gorm.DB, tenant string, fn func(db *gorm.DB) error) error {
+	s.tenants = append(s.tenants, tenant)
+	return errStop
+}
+
+func TestTSQueryScope(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(t.TempDir()+"/ts.db"), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &strategy{ColumnStrategy: tenancy.NewColumnStrategy("schema", "schema")}
+	ctx := tenancy.WithScope(context.Background(), tenancy.NewScope(s, "a"))
+	query := func(schema string, q string) error {
+		_, err := tsdb.HandleTSQueryCommandContext(ctx, db, tsdb.TSQueryCommand{
+			Schema: schema,
+			Query:  tsdb.TSQuery{Source: "points", SearchString: q},
+		})
+		return err
+	}
+
+	for _, q := range []string{"((", "schema:b"} {
+		if err := query("", q); !errors.Is(err, store.ErrInvalidQuery) {
+			t.Errorf("search %q: %v, want an invalid query", q, err)
+		}
+	}
+	if len(s.tenants) != 0 {
+		t.Fatalf("invalid searches ran: %v", s.tenants)
+	}
+	if err := query("", "name:x"); !errors.Is(err, errStop) {
+		t.Fatal(err)
+	}
+	if err := query("b", ""); !errors.Is(err, errStop) {
+		t.Fatal(err)
+	}
+	if len(s.tenants) != 2 || s.tenants[0] != "a" || s.tenants[1] != "b" {
+		t.Fatalf("tenants of the strategy of ctx: %v, want [a b]", s.tenants)
+	}
+}
@@ -2,6 +2,7 @@ package utils
 
 import (
 	"crypto"
+	"crypto/hmac"
 	"crypto/md5"
 	"crypto/rand"
 	"crypto/rsa"
@@ -42,6 +43,15 @@ func GetSignatureSha256(in io.Reader, privateKey *rsa.PrivateKey) (string, error
 	return sign, nil
 }
 
+// GetHMACSignatureSha256 returns the hex HMAC-SHA256 of in with key.
+func GetHMACSignatureSha256(in io.Reader, key []byte) (string, error) {
+	h := hmac.New(sha256.New, key)
+	if _, err := io.Copy(h, in); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
 func WriteFileToDir(dir string, filename string, in io.Reader) (int64, error) {
 	filename = filepath.Join(dir, filename)
 	fpath := filepath.Dir(filename)
@@ -0,0 +1,297 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/heypkg/store/feed"
+	"github.com/heypkg/store/jsontype"
+	"github.com/heypkg/store/search"
+	"github.com/heypkg/store/utils"
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// The headers of the webhook requests. The signature is "sha256=" and the
+// hex HMAC-SHA256, keyed with the secret of the subscription, of the
+// timestamp, a dot and the body.
+const (
+	HeaderEvent     = "X-Webhook-Event"
+	HeaderDelivery  = "X-Webhook-Delivery"
+	HeaderTimestamp = "X-Webhook-Timestamp"
+	HeaderSignature = "X-Webhook-Signature"
+)
+
+// maxResponse is the number of bytes of the responses kept in the attempts.
+const maxResponse = 1024
+
+// Send queues a delivery of e for each active subscription of its tenant
+// and model matching it. An event is queued once per subscription, so Send
+// can be retried.
+func (s *Service) Send(ctx context.Context, e feed.Event) error {
+	decode := s.model(e.Model)
+	if decode == nil {
+		return nil
+	}
+	var subs []Subscription
+	if err := s.db(ctx).Where(map[string]any{"schema": e.Tenant, "model": e.Model, "active": true}).
+		Find(&subs).Error; err != nil {
+		return errors.Wrap(err, "read webhooks")
+	}
+	if len(subs) == 0 {
+		return nil
+	}
+	obj, err := decode(e)
+	if err != nil {
+		return err
+	}
+	payload, err := json.Marshal(e)
+	if err != nil {
+		return errors.Wrap(err, "encode event")
+	}
+	deliveries := []Delivery{}
+	for _, sub := range subs {
+		ok, err := matches(sub, e, obj)
+		if err != nil {
+			s.error(errors.Wrapf(err, "match webhook %v", sub.ID))
+			continue
+		}
+		if ok {
+			deliveries = append(deliveries, Delivery{
+				SubscriptionID: sub.ID,
+				EventID:        e.ID,
+				Schema:         sub.Schema,
+				EventType:      e.Type,
+				Payload:        jsontype.NewJSONType(json.RawMessage(payload)),
+				Status:         StatusPending,
+				NextAttemptAt:  time.Now(),
+			})
+		}
+	}
+	if len(deliveries) == 0 {
+		return nil
+	}
+	return s.db(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(&deliveries).Error
+}
+
+func matches(sub Subscription, e feed.Event, obj any) (bool, error) {
+	if len(sub.Events) > 0 {
+		found := false
+		for _, typ := range sub.Events {
+			found = found || typ == e.Type
+		}
+		if !found {
+			return false, nil
+		}
+	}
+	if sub.Query == "" {
+		return true, nil
+	}
+	data, err := search.ParseSearchString(sub.Query)
+	if err != nil {
+		return false, err
+	}
+	return data.Match(obj, nil)
+}
+
+// Run delivers the pending deliveries every Interval until ctx is done.
+func (s *Service) Run(ctx context.Context) error {
+	interval := s.Interval
+	if interval <= 0 {
+		interval = DefaultInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if _, err := s.Deliver(ctx); err != nil && ctx.Err() == nil {
+			s.error(err)
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// Deliver posts the pending deliveries due now and returns the number that
+// succeeded.
+func (s *Service) Deliver(ctx context.Context) (int, error) {
+	size := s.BatchSize
+	if size <= 0 {
+		size = DefaultBatchSize
+	}
+	db := s.db(ctx)
+	var deliveries []Delivery
+	active := db.Model(&Subscription{}).Select("id").Where("active = ?", true)
+	if err := db.Where("status = ? AND next_attempt_at <= ?", StatusPending, time.Now()).
+		Where("subscription_id IN (?)", active).
+		Order("id").
+		Limit(size).
+		Find(&deliveries).Error; err != nil {
+		return 0, errors.Wrap(err, "read deliveries")
+	}
+	subs := map[uint]*Subscription{}
+	delivered := 0
+	for i := range deliveries {
+		d := &deliveries[i]
+		if ctx.Err() != nil {
+			return delivered, ctx.Err()
+		}
+		sub, ok := subs[d.SubscriptionID]
+		if !ok {
+			sub = &Subscription{}
+			if err := db.Take(sub, d.SubscriptionID).Error; err != nil {
+				if !errors.Is(err, gorm.ErrRecordNotFound) {
+					return delivered, errors.Wrap(err, "read webhook")
+				}
+				sub = nil
+			}
+			subs[d.SubscriptionID] = sub
+		}
+		if sub == nil || !sub.Active {
+			continue
+		}
+		attempt := s.post(ctx, sub, d)
+		if err := s.save(db, d, attempt); err != nil {
+			return delivered, err
+		}
+		if attempt.Error == "" {
+			delivered++
+		} else {
+			s.error(errors.Errorf("deliver %v to webhook %v: %v", d.ID, sub.ID, attempt.Error))
+		}
+	}
+	return delivered, nil
+}
+
+// post sends d to the endpoint of sub and returns the log of the request.
+func (s *Service) post(ctx context.Context, sub *Subscription, d *Delivery) *Attempt {
+	attempt := &Attempt{DeliveryID: d.ID, Time: time.Now()}
+	body := []byte(d.Payload.Data)
+	timestamp := strconv.FormatInt(attempt.Time.Unix(), 10)
+	signature, err := Sign(sub.Secret, timestamp, body)
+	if err != nil {
+		attempt.Error = err.Error()
+		return attempt
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, sub.URL, bytes.NewReader(body))
+	if err != nil {
+		attempt.Error = err.Error()
+		return attempt
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderEvent, string(d.EventType))
+	req.Header.Set(HeaderDelivery, strconv.FormatUint(uint64(d.ID), 10))
+	req.Header.Set(HeaderTimestamp, timestamp)
+	req.Header.Set(HeaderSignature, signature)
+	client := s.Client
+	if client == nil {
+		client = defaultClient
+	}
+	resp, err := client.Do(req)
+	attempt.Duration = time.Since(attempt.Time)
+	if err != nil {
+		attempt.Error = err.Error()
+		return attempt
+	}
+	defer resp.Body.Close()
+	response, _ := io.ReadAll(io.LimitReader(resp.Body, maxResponse))
+	io.Copy(io.Discard, resp.Body)
+	attempt.StatusCode = resp.StatusCode
+	attempt.Response = string(response)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		attempt.Error = fmt.Sprintf("webhook responded %v", resp.Status)
+	}
+	return attempt
+}
+
+// save logs attempt and updates d with its outcome.
+func (s *Service) save(db *gorm.DB, d *Delivery, attempt *Attempt) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(attempt).Error; err != nil {
+			return errors.Wrap(err, "log attempt")
+		}
+		values := map[string]any{"attempts": d.Attempts + 1, "last_error": attempt.Error}
+		if attempt.Error == "" {
+			values["status"] = StatusDelivered
+			values["delivered_at"] = attempt.Time
+		} else if s.MaxAttempts > 0 && d.Attempts+1 >= s.MaxAttempts {
+			values["status"] = StatusDead
+		} else {
+			values["next_attempt_at"] = time.Now().Add(s.backoff(d.Attempts + 1))
+		}
+		if err := tx.Model(&Delivery{}).Where("id = ?", d.ID).Updates(values).Error; err != nil {
+			return errors.Wrap(err, "update delivery")
+		}
+		return nil
+	})
+}
+
+// backoff returns the delay before the next attempt after attempts failed
+// ones.
+func (s *Service) backoff(attempts int) time.Duration {
+	min, max := s.MinBackoff, s.MaxBackoff
+	if min <= 0 {
+		min = DefaultMinBackoff
+	}
+	if max < min {
+		max = min
+	}
+	delay := min
+	for i := 1; i < attempts && delay < max; i++ {
+		delay *= 2
+	}
+	if delay > max {
+		delay = max
+	}
+	return delay
+}
+
+func (s *Service) error(err error) {
+	if s.OnError != nil {
+		s.OnError(err)
+	}
+}
+
+// Sign returns the signature header of body sent at timestamp.
+func Sign(secret string, timestamp string, body []byte) (string, error) {
+	in := io.MultiReader(strings.NewReader(timestamp+"."), bytes.NewReader(body))
+	sum, err := utils.GetHMACSignatureSha256(in, []byte(secret))
+	if err != nil {
+		return "", err
+	}
+	return "sha256=" + sum, nil
+}
+
+// Verify checks the signature of a webhook request received by an endpoint,
+// rejecting the requests signed more than tolerance ago when it is not zero.
+func Verify(secret string, header http.Header, body []byte, tolerance time.Duration) error {
+	timestamp := header.Get(HeaderTimestamp)
+	if tolerance > 0 {
+		sec, err := strconv.ParseInt(timestamp, 10, 64)
+		if err != nil {
+			return errors.New("invalid webhook timestamp")
+		}
+		if d := time.Since(time.Unix(sec, 0)); d > tolerance || d < -tolerance {
+			return errors.New("webhook timestamp out of tolerance")
+		}
+	}
+	expected, err := Sign(secret, timestamp, body)
+	if err != nil {
+		return err
+	}
+	if !hmac.Equal([]byte(expected), []byte(header.Get(HeaderSignature))) {
+		return errors.New("invalid webhook signature")
+	}
+	return nil
+}
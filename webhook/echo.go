@@ -0,0 +1,108 @@
+package webhook
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/heypkg/store"
+	"github.com/heypkg/store/echoadapter"
+	"github.com/labstack/echo/v4"
+)
+
+// Routes serves the webhook API of the tenant of the requests on g:
+//
+//	GET    /webhooks                          subscriptions
+//	POST   /webhooks                          subscribe
+//	DELETE /webhooks/:id                      unsubscribe
+//	GET    /webhooks/:id/deliveries?status=   delivery log
+//	POST   /webhooks/:id/replay?since=        replay the deliveries since an RFC 3339 time
+//	GET    /webhooks/dead-letters             dead deliveries
+//	GET    /webhooks/deliveries/:id/attempts  attempts of a delivery
+//	POST   /webhooks/deliveries/:id/replay    replay a delivery
+func (s *Service) Routes(g *echo.Group) {
+	g.GET("/webhooks", s.handle(func(c echo.Context, ctx context.Context) (int, any, error) {
+		subs, err := s.Subscriptions(ctx)
+		return http.StatusOK, subs, err
+	}))
+	g.POST("/webhooks", s.handle(func(c echo.Context, ctx context.Context) (int, any, error) {
+		sub := &Subscription{}
+		if err := c.Bind(sub); err != nil {
+			return 0, nil, err
+		}
+		return http.StatusCreated, sub, s.Subscribe(ctx, sub)
+	}))
+	g.DELETE("/webhooks/:id", s.handle(func(c echo.Context, ctx context.Context) (int, any, error) {
+		id, err := paramID(c)
+		if err != nil {
+			return 0, nil, err
+		}
+		return http.StatusNoContent, nil, s.Unsubscribe(ctx, id)
+	}))
+	g.GET("/webhooks/dead-letters", s.handle(func(c echo.Context, ctx context.Context) (int, any, error) {
+		deliveries, err := s.DeadLetters(ctx)
+		return http.StatusOK, deliveries, err
+	}))
+	g.GET("/webhooks/:id/deliveries", s.handle(func(c echo.Context, ctx context.Context) (int, any, error) {
+		id, err := paramID(c)
+		if err != nil {
+			return 0, nil, err
+		}
+		deliveries, err := s.Deliveries(ctx, id, Status(c.QueryParam("status")))
+		return http.StatusOK, deliveries, err
+	}))
+	g.POST("/webhooks/:id/replay", s.handle(func(c echo.Context, ctx context.Context) (int, any, error) {
+		id, err := paramID(c)
+		if err != nil {
+			return 0, nil, err
+		}
+		since, err := time.Parse(time.RFC3339, c.QueryParam("since"))
+		if err != nil {
+			return 0, nil, store.NewError(store.ErrInvalidQuery, "invalid since", err)
+		}
+		n, err := s.ReplaySince(ctx, id, since)
+		return http.StatusAccepted, map[string]int64{"Replayed": n}, err
+	}))
+	g.GET("/webhooks/deliveries/:id/attempts", s.handle(func(c echo.Context, ctx context.Context) (int, any, error) {
+		id, err := paramID(c)
+		if err != nil {
+			return 0, nil, err
+		}
+		attempts, err := s.Attempts(ctx, id)
+		return http.StatusOK, attempts, err
+	}))
+	g.POST("/webhooks/deliveries/:id/replay", s.handle(func(c echo.Context, ctx context.Context) (int, any, error) {
+		id, err := paramID(c)
+		if err != nil {
+			return 0, nil, err
+		}
+		d, err := s.Replay(ctx, id)
+		return http.StatusAccepted, d, err
+	}))
+}
+
+func (s *Service) handle(fn func(c echo.Context, ctx context.Context) (int, any, error)) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		ctx, err := echoadapter.Context(c)
+		if err != nil {
+			return echoadapter.HTTPError(err)
+		}
+		status, body, err := fn(c, ctx)
+		if err != nil {
+			return echoadapter.HTTPError(err)
+		}
+		if body == nil {
+			return c.NoContent(status)
+		}
+		return c.JSON(status, body)
+	}
+}
+
+func paramID(c echo.Context) (uint, error) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		return 0, store.NewError(store.ErrInvalidQuery, "invalid id", err)
+	}
+	return uint(id), nil
+}
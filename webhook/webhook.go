@@ -0,0 +1,341 @@
+// Package webhook calls the endpoints tenants subscribe to the changes of
+// their objects. A Service is a feed.Sink: it turns the events it receives
+// into deliveries, which it posts signed to the subscribed URLs, retrying
+// with a backoff until they succeed or are dead-lettered. Sending it the
+// events of an outbox.Dispatcher makes the deliveries follow the committed
+// writes only.
+package webhook
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/heypkg/store"
+	"github.com/heypkg/store/audit"
+	"github.com/heypkg/store/feed"
+	"github.com/heypkg/store/jsontype"
+	"github.com/heypkg/store/outbox"
+	"github.com/heypkg/store/search"
+	"github.com/heypkg/store/tenancy"
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+)
+
+const (
+	DefaultInterval    = time.Second
+	DefaultBatchSize   = 100
+	DefaultMinBackoff  = 5 * time.Second
+	DefaultMaxBackoff  = time.Hour
+	DefaultMaxAttempts = 10
+	DefaultTimeout     = 10 * time.Second
+)
+
+type Status string
+
+const (
+	StatusPending   Status = "pending"
+	StatusDelivered Status = "delivered"
+	// StatusDead is the status of the deliveries that failed MaxAttempts
+	// times, listed by DeadLetters until they are replayed.
+	StatusDead Status = "dead"
+)
+
+// Subscription is an endpoint of a tenant called with the events of a model.
+// Events restricts the event types, all when empty, and Query restricts the
+// objects with a search string, as the q parameter of lists.
+type Subscription struct {
+	ID        uint                               `gorm:"primarykey" json:"ID"`
+	Schema    string                             `gorm:"index" json:"Schema"`
+	URL       string                             `json:"URL"`
+	Model     string                             `gorm:"index" json:"Model"`
+	Events    jsontype.JSONSlice[feed.EventType] `json:"Events"`
+	Query     string                             `json:"Query"`
+	Secret    string                             `json:"Secret,omitempty"`
+	Active    bool                               `json:"Active"`
+	CreatedAt time.Time                          `json:"CreatedAt"`
+	UpdatedAt time.Time                          `json:"UpdatedAt"`
+}
+
+func (Subscription) TableName() string {
+	return "webhook_subscriptions"
+}
+
+// Delivery is an event to post to the endpoint of a subscription.
+type Delivery struct {
+	ID             uint                               `gorm:"primarykey" json:"ID"`
+	SubscriptionID uint                               `gorm:"uniqueIndex:idx_webhook_deliveries_event" json:"SubscriptionID"`
+	EventID        string                             `gorm:"uniqueIndex:idx_webhook_deliveries_event;size:32" json:"EventID"`
+	Schema         string                             `gorm:"index" json:"Schema"`
+	EventType      feed.EventType                     `json:"EventType"`
+	Payload        jsontype.JSONType[json.RawMessage] `json:"Payload"`
+	Status         Status                             `gorm:"index" json:"Status"`
+	Attempts       int                                `json:"Attempts"`
+	NextAttemptAt  time.Time                          `gorm:"index" json:"NextAttemptAt"`
+	LastError      string                             `json:"LastError"`
+	DeliveredAt    *time.Time                         `json:"DeliveredAt"`
+	CreatedAt      time.Time                          `json:"CreatedAt"`
+}
+
+func (Delivery) TableName() string {
+	return "webhook_deliveries"
+}
+
+// Attempt is the log of a request of a delivery.
+type Attempt struct {
+	ID         uint          `gorm:"primarykey" json:"ID"`
+	DeliveryID uint          `gorm:"index" json:"DeliveryID"`
+	Time       time.Time     `json:"Time"`
+	StatusCode int           `json:"StatusCode"`
+	Duration   time.Duration `json:"Duration"`
+	Error      string        `json:"Error"`
+	Response   string        `json:"Response"`
+}
+
+func (Attempt) TableName() string {
+	return "webhook_attempts"
+}
+
+// Service stores the subscriptions and delivers their events. Models are
+// registered with Register before they are subscribed to.
+type Service struct {
+	DB *gorm.DB
+	// Client posts the deliveries, NewClient(DefaultTimeout) by default.
+	Client *http.Client
+	// AllowPrivate lets the subscriptions call hosts that are not public,
+	// such as endpoints of the internal network. Client must then dial them.
+	AllowPrivate bool
+	// Interval is the delay between the polls of the pending deliveries.
+	Interval   time.Duration
+	BatchSize  int
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	// MaxAttempts is the number of failed attempts after which a delivery
+	// is dead.
+	MaxAttempts int
+	// OnError is called with the errors of the deliveries. They are dropped
+	// when it is nil.
+	OnError func(err error)
+
+	mu     sync.RWMutex
+	models map[string]func(e feed.Event) (any, error)
+}
+
+var _ feed.Sink = (*Service)(nil)
+
+// New migrates the tables of the webhooks in db and returns their service.
+func New(db *gorm.DB) (*Service, error) {
+	if err := db.AutoMigrate(&Subscription{}, &Delivery{}, &Attempt{}); err != nil {
+		return nil, errors.Wrap(err, "migrate webhooks")
+	}
+	return &Service{
+		DB:          db,
+		Client:      NewClient(DefaultTimeout),
+		Interval:    DefaultInterval,
+		BatchSize:   DefaultBatchSize,
+		MinBackoff:  DefaultMinBackoff,
+		MaxBackoff:  DefaultMaxBackoff,
+		MaxAttempts: DefaultMaxAttempts,
+		models:      map[string]func(e feed.Event) (any, error){},
+	}, nil
+}
+
+// Register lets the tenants subscribe to the events of model T.
+func Register[T any](s *Service) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.models[feed.Model[T]()] = func(e feed.Event) (any, error) {
+		switch v := e.Object.(type) {
+		case T:
+			return &v, nil
+		case *T:
+			return v, nil
+		case json.RawMessage:
+			var obj T
+			if err := json.Unmarshal(v, &obj); err != nil {
+				return nil, errors.Wrap(err, "decode object")
+			}
+			return &obj, nil
+		}
+		return nil, errors.Errorf("unexpected object %T", e.Object)
+	}
+}
+
+func (s *Service) model(name string) func(e feed.Event) (any, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.models[name]
+}
+
+// db returns the db of the webhook tables, whose writes are neither audited
+// nor recorded in the outbox.
+func (s *Service) db(ctx context.Context) *gorm.DB {
+	return audit.Skip(outbox.Skip(s.DB.WithContext(ctx))).Session(&gorm.Session{})
+}
+
+func tenantOf(ctx context.Context) string {
+	return tenancy.ScopeFromContext(ctx).Tenant
+}
+
+// inTenant is the condition of the rows of the tenant of ctx.
+func inTenant(ctx context.Context) map[string]any {
+	return map[string]any{"schema": tenantOf(ctx)}
+}
+
+// Subscribe adds sub for the tenant of ctx. Its secret is generated when it
+// is empty.
+func (s *Service) Subscribe(ctx context.Context, sub *Subscription) error {
+	if err := s.check(sub); err != nil {
+		return err
+	}
+	sub.ID = 0
+	sub.Schema = tenantOf(ctx)
+	sub.Active = true
+	if sub.Secret == "" {
+		sub.Secret = newSecret()
+	}
+	return store.WrapError(s.db(ctx).Create(sub).Error)
+}
+
+func (s *Service) check(sub *Subscription) error {
+	if err := checkURL(sub.URL, s.AllowPrivate); err != nil {
+		return store.NewError(store.ErrInvalidQuery, "invalid webhook url: "+err.Error(), err)
+	}
+	if s.model(sub.Model) == nil {
+		return store.NewError(store.ErrInvalidQuery, "unknown webhook model "+sub.Model, nil)
+	}
+	for _, typ := range sub.Events {
+		switch typ {
+		case feed.Created, feed.Updated, feed.Deleted, feed.Restored:
+		default:
+			return store.NewError(store.ErrInvalidQuery, "unknown webhook event "+string(typ), nil)
+		}
+	}
+	if _, err := search.ParseSearchString(sub.Query); err != nil {
+		return store.InvalidQuery(err)
+	}
+	return nil
+}
+
+// Subscriptions returns the subscriptions of the tenant of ctx, without
+// their secrets.
+func (s *Service) Subscriptions(ctx context.Context) ([]Subscription, error) {
+	var subs []Subscription
+	if err := s.db(ctx).Where(inTenant(ctx)).Order("id").Find(&subs).Error; err != nil {
+		return nil, store.WrapError(err)
+	}
+	for i := range subs {
+		subs[i].Secret = ""
+	}
+	return subs, nil
+}
+
+// Unsubscribe deletes the subscription id of the tenant of ctx with its
+// deliveries.
+func (s *Service) Unsubscribe(ctx context.Context, id uint) error {
+	return store.WrapError(s.db(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Where("id = ?", id).Where(inTenant(ctx)).Delete(&Subscription{})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return store.NotFound("webhook not found")
+		}
+		deliveries := tx.Model(&Delivery{}).Select("id").Where("subscription_id = ?", id)
+		if err := tx.Where("delivery_id IN (?)", deliveries).Delete(&Attempt{}).Error; err != nil {
+			return err
+		}
+		return tx.Where("subscription_id = ?", id).Delete(&Delivery{}).Error
+	}))
+}
+
+// Deliveries returns the deliveries of the subscription id of the tenant of
+// ctx, the latest first, with status when it is not empty.
+func (s *Service) Deliveries(ctx context.Context, id uint, status Status) ([]Delivery, error) {
+	tx := s.db(ctx).Where("subscription_id = ?", id).Where(inTenant(ctx))
+	if status != "" {
+		tx = tx.Where("status = ?", status)
+	}
+	var deliveries []Delivery
+	if err := tx.Order("id DESC").Find(&deliveries).Error; err != nil {
+		return nil, store.WrapError(err)
+	}
+	return deliveries, nil
+}
+
+// DeadLetters returns the dead deliveries of the tenant of ctx, the latest
+// first.
+func (s *Service) DeadLetters(ctx context.Context) ([]Delivery, error) {
+	var deliveries []Delivery
+	if err := s.db(ctx).Where(inTenant(ctx)).Where("status = ?", StatusDead).
+		Order("id DESC").
+		Find(&deliveries).Error; err != nil {
+		return nil, store.WrapError(err)
+	}
+	return deliveries, nil
+}
+
+// Attempts returns the log of the delivery id of the tenant of ctx.
+func (s *Service) Attempts(ctx context.Context, id uint) ([]Attempt, error) {
+	if _, err := s.delivery(ctx, id); err != nil {
+		return nil, err
+	}
+	var attempts []Attempt
+	if err := s.db(ctx).Where("delivery_id = ?", id).Order("id").Find(&attempts).Error; err != nil {
+		return nil, store.WrapError(err)
+	}
+	return attempts, nil
+}
+
+func (s *Service) delivery(ctx context.Context, id uint) (*Delivery, error) {
+	var d Delivery
+	if err := s.db(ctx).Where("id = ?", id).Where(inTenant(ctx)).Take(&d).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, store.NotFound("delivery not found")
+		}
+		return nil, store.WrapError(err)
+	}
+	return &d, nil
+}
+
+// Replay sends the delivery id of the tenant of ctx again, whatever its
+// status, with the next deliveries.
+func (s *Service) Replay(ctx context.Context, id uint) (*Delivery, error) {
+	d, err := s.delivery(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.db(ctx).Model(d).Updates(map[string]any{
+		"status":          StatusPending,
+		"attempts":        0,
+		"next_attempt_at": time.Now(),
+		"delivered_at":    nil,
+	}).Error; err != nil {
+		return nil, store.WrapError(err)
+	}
+	return d, nil
+}
+
+// ReplaySince sends again the deliveries of the subscription id of the
+// tenant of ctx created since t, and returns their number.
+func (s *Service) ReplaySince(ctx context.Context, id uint, t time.Time) (int64, error) {
+	result := s.db(ctx).Model(&Delivery{}).
+		Where("subscription_id = ? AND created_at >= ?", id, t).Where(inTenant(ctx)).
+		Updates(map[string]any{
+			"status":          StatusPending,
+			"attempts":        0,
+			"next_attempt_at": time.Now(),
+			"delivered_at":    nil,
+		})
+	return result.RowsAffected, store.WrapError(result.Error)
+}
+
+func newSecret() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}